@@ -9,9 +9,9 @@ require (
 	github.com/matryer/try v0.0.0-20161228173917-9ac251b645a2 // indirect
 	github.com/rpi-ws281x/rpi-ws281x-go v1.0.6
 	github.com/shreddedbacon/fake-powerwall v0.0.4
-	github.com/shreddedbacon/tesla v0.0.0-20200608013619-3fb9553b8fbd
 	github.com/smartystreets/goconvey v1.6.4 // indirect
 	github.com/tarm/serial v0.0.0-20180830185346-98f6abe2eb07
+	go.etcd.io/bbolt v1.3.5
 	golang.org/x/sys v0.0.0-20200625212154-ddb9806d33ae // indirect
 	gopkg.in/matryer/try.v1 v1.0.0-20150601225556-312d2599e12e
 	gopkg.in/robfig/cron.v2 v2.0.0-20150107220207-be2e0b0deed5
@@ -0,0 +1,180 @@
+package allocator
+
+import (
+	"math"
+	"sort"
+)
+
+// TaperMargin is how far below its allocation a charger has to be drawing before it is treated as tapering, a
+// tapering charger is only given this much more than it is drawing so the rest can go to the other chargers
+const TaperMargin = 2
+
+// TaperPolls is how many polls in a row a car has to draw well below the same offer, without its draw rising,
+// before it is treated as tapering, so a car that is still ramping up isn't mistaken for one that has tapered off
+const TaperPolls = 5
+
+// Charger is what the allocator needs to know about a single wall connector
+type Charger struct {
+	ID          string
+	Priority    int  // a weight, a charger with priority 2 gets twice the share of a charger with priority 1
+	MaxAmps     int  // the most this charger can be given
+	Drawing     bool // a car is plugged in and wants to charge
+	TaperedAmps int  // the most a car that has tapered off is given, 0 if it hasn't, see Taper
+}
+
+// Taper follows what a car draws against what it is offered, to tell a car that has tapered off, or is limited by
+// its own charger, from one that is still ramping up. The controller keeps one for each charger and updates it
+// each time the car reports what it is drawing
+type Taper struct {
+	offered int     // the offer the polls are counted against
+	last    float64 // what the car drew at the last poll
+	polls   int     // how many polls in a row the car has drawn well below the offer without its draw rising
+	amps    int     // the most the car is given once it has tapered off, 0 until it has
+}
+
+// Update records what the car drew against what it was offered, once it has tapered off it keeps its tapered amps
+// until it draws near them, when it may want more again
+func (t *Taper) Update(offered int, actualAmps float64) {
+	rising := actualAmps >= t.last+1
+	t.last = actualAmps
+	if offered <= 0 {
+		// paused or unplugged, so it starts again from whatever it is offered next
+		*t = Taper{}
+		return
+	}
+	if t.amps > 0 {
+		if actualAmps+1 >= float64(t.amps) {
+			*t = Taper{offered: offered, last: actualAmps}
+			return
+		}
+		// follow the car down as it tapers off further
+		if amps := taperedAmps(actualAmps); amps < t.amps {
+			t.amps = amps
+		}
+		return
+	}
+	if offered != t.offered {
+		t.offered = offered
+		t.polls = 0
+	}
+	if rising || actualAmps+TaperMargin >= float64(offered) {
+		t.polls = 0
+		return
+	}
+	t.polls++
+	if t.polls >= TaperPolls {
+		t.amps = taperedAmps(actualAmps)
+	}
+}
+
+// Amps returns the most the car should be given now that it has tapered off, or 0 if it hasn't
+func (t Taper) Amps() int {
+	return t.amps
+}
+
+// taperedAmps returns what a car that has tapered off is given, a little more than it is drawing
+func taperedAmps(actualAmps float64) int {
+	return int(math.Ceil(actualAmps)) + TaperMargin
+}
+
+// Allocation is the amps given to a single charger, Paused is set if the charger was drawing but there was not
+// enough to give it the minimum
+type Allocation struct {
+	ID     string
+	Amps   int
+	Paused bool
+}
+
+// Allocate splits the available amps between the chargers that are drawing, every charger that is given anything
+// gets at least minAmps, if there isn't enough for everyone the lowest priority chargers are paused
+// the returned allocations are in the same order as the chargers
+func Allocate(availableAmps, minAmps int, chargers []Charger) []Allocation {
+	allocations := make([]Allocation, len(chargers))
+	// index the chargers that want current, highest priority first
+	var active []int
+	for i, c := range chargers {
+		allocations[i].ID = c.ID
+		if c.Drawing && limit(c, minAmps) >= minAmps {
+			active = append(active, i)
+		}
+	}
+	sort.SliceStable(active, func(a, b int) bool {
+		ca, cb := chargers[active[a]], chargers[active[b]]
+		if weight(ca) != weight(cb) {
+			return weight(ca) > weight(cb)
+		}
+		return ca.ID < cb.ID
+	})
+
+	// pause the lowest priority chargers until everyone left can have the minimum
+	for len(active) > 0 && len(active)*minAmps > availableAmps {
+		allocations[active[len(active)-1]].Paused = true
+		active = active[:len(active)-1]
+	}
+	if len(active) == 0 {
+		return allocations
+	}
+
+	// everyone gets the minimum, then what is left is shared by weight without going over each charger's limit
+	remaining := availableAmps - len(active)*minAmps
+	for _, i := range active {
+		allocations[i].Amps = minAmps
+	}
+	uncapped := append([]int{}, active...)
+	for remaining > 0 && len(uncapped) > 0 {
+		totalWeight := 0
+		for _, i := range uncapped {
+			totalWeight += weight(chargers[i])
+		}
+		given := 0
+		next := uncapped[:0]
+		for _, i := range uncapped {
+			share := remaining * weight(chargers[i]) / totalWeight
+			room := limit(chargers[i], minAmps) - allocations[i].Amps
+			if share >= room {
+				share = room
+			} else {
+				next = append(next, i)
+			}
+			allocations[i].Amps += share
+			given += share
+		}
+		remaining -= given
+		uncapped = next
+		if given == 0 {
+			// the shares have rounded down to nothing, hand out what is left one amp at a time by priority
+			for _, i := range uncapped {
+				if remaining == 0 {
+					break
+				}
+				allocations[i].Amps++
+				remaining--
+			}
+			break
+		}
+	}
+	return allocations
+}
+
+// limit returns the most a charger should be given, which is its max amps unless the car has tapered off
+func limit(c Charger, minAmps int) int {
+	l := c.MaxAmps
+	if c.TaperedAmps > 0 {
+		tapered := c.TaperedAmps
+		if tapered < minAmps {
+			tapered = minAmps
+		}
+		if tapered < l {
+			l = tapered
+		}
+	}
+	return l
+}
+
+// weight returns the priority of the charger, anything below 1 is treated as 1
+func weight(c Charger) int {
+	if c.Priority < 1 {
+		return 1
+	}
+	return c.Priority
+}
@@ -0,0 +1,330 @@
+package allocator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name      string
+		available int
+		minAmps   int
+		chargers  []Charger
+		want      []Allocation
+	}{
+		{
+			name:      "nothing plugged in",
+			available: 32,
+			minAmps:   6,
+			chargers: []Charger{
+				{ID: "a", Priority: 1, MaxAmps: 32},
+				{ID: "b", Priority: 1, MaxAmps: 32},
+			},
+			want: []Allocation{{ID: "a"}, {ID: "b"}},
+		},
+		{
+			name:      "a single charger is given everything up to its max",
+			available: 24,
+			minAmps:   6,
+			chargers:  []Charger{{ID: "a", Priority: 1, MaxAmps: 32, Drawing: true}},
+			want:      []Allocation{{ID: "a", Amps: 24}},
+		},
+		{
+			name:      "idle and disabled chargers are left out",
+			available: 32,
+			minAmps:   6,
+			chargers: []Charger{
+				{ID: "a", Priority: 1, MaxAmps: 32, Drawing: true},
+				// disabled, or nothing plugged in, both leave the charger not drawing
+				{ID: "b", Priority: 5, MaxAmps: 32, Drawing: false},
+				{ID: "c", Priority: 1, MaxAmps: 32, Drawing: false, TaperedAmps: 16},
+			},
+			want: []Allocation{{ID: "a", Amps: 32}, {ID: "b"}, {ID: "c"}},
+		},
+		{
+			name:      "equal priorities share evenly",
+			available: 32,
+			minAmps:   6,
+			chargers: []Charger{
+				{ID: "a", Priority: 1, MaxAmps: 32, Drawing: true},
+				{ID: "b", Priority: 1, MaxAmps: 32, Drawing: true},
+			},
+			want: []Allocation{{ID: "a", Amps: 16}, {ID: "b", Amps: 16}},
+		},
+		{
+			name:      "priorities below 1 count as 1",
+			available: 32,
+			minAmps:   6,
+			chargers: []Charger{
+				{ID: "a", Priority: 0, MaxAmps: 32, Drawing: true},
+				{ID: "b", Priority: -3, MaxAmps: 32, Drawing: true},
+			},
+			want: []Allocation{{ID: "a", Amps: 16}, {ID: "b", Amps: 16}},
+		},
+		{
+			name:      "priority weights what is left after the minimum",
+			available: 30,
+			minAmps:   6,
+			chargers: []Charger{
+				{ID: "a", Priority: 1, MaxAmps: 32, Drawing: true},
+				{ID: "b", Priority: 2, MaxAmps: 32, Drawing: true},
+			},
+			// 18 left after the minimums, a gets 6 of it and b gets 12
+			want: []Allocation{{ID: "a", Amps: 12}, {ID: "b", Amps: 18}},
+		},
+		{
+			name:      "amps that round down go to the highest priority",
+			available: 20,
+			minAmps:   6,
+			chargers: []Charger{
+				{ID: "a", Priority: 1, MaxAmps: 32, Drawing: true},
+				{ID: "b", Priority: 1, MaxAmps: 32, Drawing: true},
+				{ID: "c", Priority: 1, MaxAmps: 32, Drawing: true},
+			},
+			want: []Allocation{{ID: "a", Amps: 7}, {ID: "b", Amps: 7}, {ID: "c", Amps: 6}},
+		},
+		{
+			name:      "the per charger max is a cap and the rest goes to the others",
+			available: 40,
+			minAmps:   6,
+			chargers: []Charger{
+				{ID: "a", Priority: 1, MaxAmps: 10, Drawing: true},
+				{ID: "b", Priority: 1, MaxAmps: 32, Drawing: true},
+			},
+			want: []Allocation{{ID: "a", Amps: 10}, {ID: "b", Amps: 30}},
+		},
+		{
+			name:      "the wiring max is never exceeded even when every charger could take more",
+			available: 40,
+			minAmps:   6,
+			chargers: []Charger{
+				{ID: "a", Priority: 3, MaxAmps: 32, Drawing: true},
+				{ID: "b", Priority: 1, MaxAmps: 32, Drawing: true},
+				{ID: "c", Priority: 1, MaxAmps: 32, Drawing: true},
+			},
+			// 22 left after the minimums, shared 3:1:1 rounds down to 13, 4 and 4, the spare amp goes to a
+			want: []Allocation{{ID: "a", Amps: 20}, {ID: "b", Amps: 10}, {ID: "c", Amps: 10}},
+		},
+		{
+			name:      "more available than every charger can take",
+			available: 80,
+			minAmps:   6,
+			chargers: []Charger{
+				{ID: "a", Priority: 1, MaxAmps: 32, Drawing: true},
+				{ID: "b", Priority: 1, MaxAmps: 16, Drawing: true},
+			},
+			want: []Allocation{{ID: "a", Amps: 32}, {ID: "b", Amps: 16}},
+		},
+		{
+			name:      "a charger whose max is below the minimum is left out",
+			available: 32,
+			minAmps:   6,
+			chargers: []Charger{
+				{ID: "a", Priority: 1, MaxAmps: 5, Drawing: true},
+				{ID: "b", Priority: 1, MaxAmps: 32, Drawing: true},
+			},
+			want: []Allocation{{ID: "a"}, {ID: "b", Amps: 32}},
+		},
+		{
+			name:      "the lowest priority is paused when the share drops below the minimum",
+			available: 10,
+			minAmps:   6,
+			chargers: []Charger{
+				{ID: "a", Priority: 1, MaxAmps: 32, Drawing: true},
+				{ID: "b", Priority: 2, MaxAmps: 32, Drawing: true},
+			},
+			want: []Allocation{{ID: "a", Paused: true}, {ID: "b", Amps: 10}},
+		},
+		{
+			name:      "equal priorities pause by ID",
+			available: 12,
+			minAmps:   6,
+			chargers: []Charger{
+				{ID: "c", Priority: 1, MaxAmps: 32, Drawing: true},
+				{ID: "a", Priority: 1, MaxAmps: 32, Drawing: true},
+				{ID: "b", Priority: 1, MaxAmps: 32, Drawing: true},
+			},
+			want: []Allocation{{ID: "c", Paused: true}, {ID: "a", Amps: 6}, {ID: "b", Amps: 6}},
+		},
+		{
+			name:      "everyone is paused below the minimum",
+			available: 5,
+			minAmps:   6,
+			chargers: []Charger{
+				{ID: "a", Priority: 1, MaxAmps: 32, Drawing: true},
+				{ID: "b", Priority: 1, MaxAmps: 32, Drawing: false},
+			},
+			want: []Allocation{{ID: "a", Paused: true}, {ID: "b"}},
+		},
+		{
+			name:      "a tapering car's unused amps go to the others",
+			available: 32,
+			minAmps:   6,
+			chargers: []Charger{
+				// tapered off at 8, so it is given 8 + the taper margin
+				{ID: "a", Priority: 1, MaxAmps: 32, Drawing: true, TaperedAmps: 8 + TaperMargin},
+				{ID: "b", Priority: 1, MaxAmps: 32, Drawing: true},
+			},
+			want: []Allocation{{ID: "a", Amps: 8 + TaperMargin}, {ID: "b", Amps: 32 - 8 - TaperMargin}},
+		},
+		{
+			name:      "a tapering car is still given the minimum",
+			available: 32,
+			minAmps:   6,
+			chargers: []Charger{
+				{ID: "a", Priority: 1, MaxAmps: 32, Drawing: true, TaperedAmps: 1 + TaperMargin},
+				{ID: "b", Priority: 1, MaxAmps: 32, Drawing: true},
+			},
+			want: []Allocation{{ID: "a", Amps: 6}, {ID: "b", Amps: 26}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Allocate(tt.available, tt.minAmps, tt.chargers)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Allocate(%d, %d) = %+v, want %+v", tt.available, tt.minAmps, got, tt.want)
+			}
+			total := 0
+			for i, a := range got {
+				total += a.Amps
+				if a.Amps > tt.chargers[i].MaxAmps {
+					t.Errorf("%s was given %dA, more than its max of %dA", a.ID, a.Amps, tt.chargers[i].MaxAmps)
+				}
+				if a.Amps != 0 && a.Amps < tt.minAmps {
+					t.Errorf("%s was given %dA, less than the minimum of %dA", a.ID, a.Amps, tt.minAmps)
+				}
+			}
+			if total > tt.available {
+				t.Errorf("gave out %dA of the %dA available", total, tt.available)
+			}
+		})
+	}
+}
+
+func TestTaper(t *testing.T) {
+	type poll struct {
+		offered int
+		actual  float64
+	}
+	// steady returns n polls of the car drawing actual from the same offer
+	steady := func(n, offered int, actual float64) []poll {
+		polls := make([]poll, n)
+		for i := range polls {
+			polls[i] = poll{offered, actual}
+		}
+		return polls
+	}
+	join := func(groups ...[]poll) []poll {
+		var polls []poll
+		for _, g := range groups {
+			polls = append(polls, g...)
+		}
+		return polls
+	}
+	tests := []struct {
+		name  string
+		polls []poll
+		want  int
+	}{
+		{
+			name:  "drawing what it is offered",
+			polls: steady(20, 32, 32),
+			want:  0,
+		},
+		{
+			name:  "drawing within the taper margin",
+			polls: steady(20, 16, 16-TaperMargin),
+			want:  0,
+		},
+		{
+			// the first poll is the car coming on, so its draw has risen
+			name:  "not below the offer for long enough",
+			polls: steady(TaperPolls, 32, 16),
+			want:  0,
+		},
+		{
+			name:  "below a steady offer for long enough",
+			polls: steady(TaperPolls+1, 32, 16),
+			want:  16 + TaperMargin,
+		},
+		{
+			name:  "the offer changes before it has been below it for long enough",
+			polls: join(steady(TaperPolls, 32, 16), steady(TaperPolls-1, 24, 16)),
+			want:  0,
+		},
+		{
+			name:  "the tapered amps are kept while it draws below them",
+			polls: join(steady(TaperPolls+1, 32, 16), steady(20, 16+TaperMargin, 16)),
+			want:  16 + TaperMargin,
+		},
+		{
+			name:  "the tapered amps follow it down",
+			polls: join(steady(TaperPolls+1, 32, 16), steady(1, 16+TaperMargin, 10)),
+			want:  10 + TaperMargin,
+		},
+		{
+			name:  "drawing near the tapered amps wants more",
+			polls: join(steady(TaperPolls+1, 32, 16), steady(1, 16+TaperMargin, 17.5)),
+			want:  0,
+		},
+		{
+			name:  "paused",
+			polls: join(steady(TaperPolls+1, 32, 16), steady(1, 0, 0)),
+			want:  0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var taper Taper
+			for _, p := range tt.polls {
+				taper.Update(p.offered, p.actual)
+			}
+			if got := taper.Amps(); got != tt.want {
+				t.Fatalf("tapered to %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// offers runs a car through the allocator and its taper, the car draws what it is offered up to what it can take,
+// and returns what it was offered at each poll
+func offers(polls int, canTake func(poll int) float64) []int {
+	var taper Taper
+	offered := make([]int, polls)
+	for i := range offered {
+		a := Allocate(32, 6, []Charger{{ID: "a", Priority: 1, MaxAmps: 32, Drawing: true, TaperedAmps: taper.Amps()}})
+		offered[i] = a[0].Amps
+		actual := canTake(i)
+		if actual > float64(offered[i]) {
+			actual = float64(offered[i])
+		}
+		taper.Update(offered[i], actual)
+	}
+	return offered
+}
+
+func TestTaperDoesNotOscillate(t *testing.T) {
+	// a car whose own charger takes 16A on a 32A circuit is offered 32 until it has drawn 16 for long enough, then
+	// 18 from then on, rather than going back to 32 as soon as it is drawing near 18
+	got := offers(50, func(int) float64 { return 16 })
+	for i, amps := range got {
+		want := 32
+		if i > TaperPolls {
+			want = 16 + TaperMargin
+		}
+		if amps != want {
+			t.Fatalf("offered %v, want 32 then %d from poll %d", got, 16+TaperMargin, TaperPolls+1)
+		}
+	}
+}
+
+func TestTaperRampingUp(t *testing.T) {
+	// a car that was just plugged in takes a while to ramp up to what it is offered, it isn't tapering
+	got := offers(50, func(poll int) float64 { return 6 + float64(poll)*1.5 })
+	for _, amps := range got {
+		if amps != 32 {
+			t.Fatalf("offered %v while the car was ramping up, want 32 throughout", got)
+		}
+	}
+}
@@ -1,49 +1,188 @@
 package controller
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"path/filepath"
 	"text/template"
 	"time"
 
-	"github.com/shreddedbacon/tesla"
+	"github.com/shreddedbacon/twcmanager/internal/secrets"
 	"github.com/shreddedbacon/twcmanager/internal/ui"
+	"github.com/shreddedbacon/twcmanager/internal/vehicle"
 )
 
+// teslaRefreshBefore is how long before a token expires that it is refreshed, tokens last 45 days
+const teslaRefreshBefore = 7 * 24 * time.Hour
+
+// vehicleAPIFunc returns the vehicle api acting for the account the access token belongs to
+type vehicleAPIFunc func(accessToken string) vehicle.API
+
 // TeslaAPIAuth Authenticates against the telsa API to retrieve a token.
 func (p *TWCPrimary) TeslaAPIAuth(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		tUser := r.FormValue("username")
 		tPass := r.FormValue("password")
 
-		tClient, err := tesla.NewClient(
-			&tesla.Auth{
-				ClientID:     "81527cff06843c8634fdc09e8ac0abefb46ac849f38fe1e431c2ef2106796384",
-				ClientSecret: "c7257eb71a564034f9419ee651c7d0e5f7aa6bfbd18bafb5c5c033b093bb2fa3",
-				Email:        tUser,
-				Password:     tPass,
-			})
+		token, refreshToken, err := vehicle.RequestToken(r.Context(), p.vehicleAPIURL, map[string]string{
+			"grant_type": "password",
+			"email":      tUser,
+			"password":   tPass,
+		})
 		if err != nil {
 			http.Redirect(w, r, "/accounts", http.StatusSeeOther)
 			log.Println(fmt.Sprintf("Error authenticating user: %v", err))
 			return
 		}
 		tAPI := &TeslaAPIUser{
-			Username: tUser,
-			Token:    tClient.Token,
+			Username:     tUser,
+			Token:        token,
+			RefreshToken: refreshToken,
 		}
 		// check if we already have the user, and just update the token
+		p.mu.Lock()
 		idx, ok := containsAPIUser(p.TeslaAPITokens, tAPI)
+		// a new slice, as copies of the primary share the old one
+		tokens := append([]*TeslaAPIUser{}, p.TeslaAPITokens...)
 		if ok {
-			p.TeslaAPITokens[idx] = tAPI
+			tokens[idx] = tAPI
 		} else {
-			p.TeslaAPITokens = append(p.TeslaAPITokens, tAPI)
+			tokens = append(tokens, tAPI)
 		}
+		p.TeslaAPITokens = tokens
+		p.mu.Unlock()
+		p.saveAccounts()
+	}
+	http.Redirect(w, r, "/accounts", http.StatusSeeOther)
+}
+
+// APIForgetTeslaAccount removes an account and its token
+func (p *TWCPrimary) APIForgetTeslaAccount(w http.ResponseWriter, r *http.Request) {
+	username := r.FormValue("username")
+	p.mu.Lock()
+	idx, ok := containsAPIUser(p.TeslaAPITokens, &TeslaAPIUser{Username: username})
+	if ok {
+		// a new slice, as copies of the primary share the old one
+		tokens := append([]*TeslaAPIUser{}, p.TeslaAPITokens[:idx]...)
+		p.TeslaAPITokens = append(tokens, p.TeslaAPITokens[idx+1:]...)
 	}
+	p.mu.Unlock()
+	if !ok {
+		httpError(w, fmt.Errorf(`{"error":"unknown account"}`))
+		return
+	}
+	p.saveAccounts()
 	http.Redirect(w, r, "/accounts", http.StatusSeeOther)
 }
 
+// openSecrets opens the store for the tesla api tokens and loads the accounts from it
+func (p *TWCPrimary) openSecrets() (*secrets.Store, error) {
+	dir := filepath.Dir(p.ConfigPath)
+	if p.SecretsPath == "" {
+		p.SecretsPath = filepath.Join(dir, "secrets.enc")
+	}
+	if p.SecretsKeyFile == "" {
+		p.SecretsKeyFile = filepath.Join(dir, "secrets.key")
+	}
+	key, err := secrets.LoadKey(getEnv("TWC_SECRETS_KEY", ""), p.SecretsKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	store, err := secrets.NewStore(p.SecretsPath, key)
+	if err != nil {
+		return nil, err
+	}
+	accounts := []*TeslaAPIUser{}
+	if err := store.Load(&accounts); err != nil {
+		return nil, err
+	}
+	p.TeslaAPITokens = accounts
+	return store, nil
+}
+
+// saveAccounts writes the accounts to the secrets, the lock must not be held
+func (p *TWCPrimary) saveAccounts() {
+	p.mu.RLock()
+	store := p.secrets
+	accounts := append([]*TeslaAPIUser{}, p.TeslaAPITokens...)
+	p.mu.RUnlock()
+	if store == nil {
+		return
+	}
+	if err := store.Save(accounts); err != nil {
+		log.Println(log2JSONString(LogData{
+			Type:    "ERROR",
+			Source:  "accounts",
+			Message: fmt.Sprintf("Unable to save the accounts: %v", err),
+		}))
+	}
+}
+
+// accountsCron refreshes the tokens that are about to expire, once an hour
+func (p *TWCPrimary) accountsCron(now int64) {
+	p.mu.Lock()
+	if (now - p.timeLastAccountsCheck) < 3600 {
+		p.mu.Unlock()
+		return
+	}
+	p.timeLastAccountsCheck = now
+	due := []*TeslaAPIUser{}
+	for _, account := range p.TeslaAPITokens {
+		if account.RefreshToken == "" || account.Token == nil {
+			continue
+		}
+		if time.Until(time.Unix(account.Token.Expires, 0)) < teslaRefreshBefore {
+			due = append(due, account)
+		}
+	}
+	p.mu.Unlock()
+	if len(due) == 0 {
+		return
+	}
+	for _, account := range due {
+		refreshed := *account
+		token, refreshToken, err := vehicle.RequestToken(context.Background(), p.vehicleAPIURL, map[string]string{
+			"grant_type":    "refresh_token",
+			"refresh_token": account.RefreshToken,
+		})
+		if err != nil {
+			refreshed.RefreshError = err.Error()
+			if p.debugLevel() >= 1 {
+				log.Println(log2JSONString(LogData{
+					Type:    "ERROR",
+					Source:  "accounts",
+					Message: fmt.Sprintf("Unable to refresh the token for %s: %v", account.Username, err),
+				}))
+			}
+		} else {
+			refreshed.Token = token
+			if refreshToken != "" {
+				refreshed.RefreshToken = refreshToken
+			}
+			refreshed.Refreshed = time.Now()
+			refreshed.RefreshError = ""
+			if p.debugLevel() >= 9 {
+				log.Println(log2JSONString(LogData{
+					Type:    "INFO",
+					Source:  "accounts",
+					Message: fmt.Sprintf("Refreshed the token for %s", account.Username),
+				}))
+			}
+		}
+		p.mu.Lock()
+		// the account may have been signed in again or forgotten while the token was being refreshed
+		if idx, ok := containsAPIUser(p.TeslaAPITokens, account); ok && p.TeslaAPITokens[idx] == account {
+			tokens := append([]*TeslaAPIUser{}, p.TeslaAPITokens...)
+			tokens[idx] = &refreshed
+			p.TeslaAPITokens = tokens
+		}
+		p.mu.Unlock()
+	}
+	p.saveAccounts()
+}
+
 func containsAPIUser(slice []*TeslaAPIUser, s *TeslaAPIUser) (int, bool) {
 	for idx, item := range slice {
 		if item.Username == s.Username {
@@ -65,7 +204,7 @@ func (p *TWCPrimary) GetTeslaAPIUsers(w http.ResponseWriter, r *http.Request) {
 	pageData := TeslaAPIPage{
 		BreadCrumbs: getBreadCrumbs("Accounts"),
 		PageName:    "Accounts",
-		PageData:    *p,
+		PageData:    p.snapshot(),
 	}
 	tpl1, _ := ui.Asset("templates/accounts.html")
 	tpl2, _ := ui.Asset("templates/home.html")
@@ -76,46 +215,37 @@ func (p *TWCPrimary) GetTeslaAPIUsers(w http.ResponseWriter, r *http.Request) {
 	tmpl.ExecuteTemplate(w, "base", pageData)
 }
 
-// TeslaAPIChargeByVIN check if the known tesla API users have the VIN that is being returned by the TWC,
-// return the user for next steps
+// TeslaAPIChargeByVIN finds the account with the VIN that is being returned by the TWC, wakes the car and starts
+// or stops it charging
 func (p *TWCPrimary) TeslaAPIChargeByVIN(VIN string, charge bool) error {
-	for _, tAPIUser := range p.TeslaAPITokens {
-		tClient, err := tesla.NewClientWithToken(&tesla.Auth{
-			ClientID:     "81527cff06843c8634fdc09e8ac0abefb46ac849f38fe1e431c2ef2106796384",
-			ClientSecret: "c7257eb71a564034f9419ee651c7d0e5f7aa6bfbd18bafb5c5c033b093bb2fa3",
-		}, tAPIUser.Token)
+	ctx := context.Background()
+	for _, tAPIUser := range p.snapshot().TeslaAPITokens {
+		api := p.newVehicleAPI(tAPIUser.Token.AccessToken)
+		v, ok, err := vehicle.Find(ctx, api, VIN)
 		if err != nil {
 			return fmt.Errorf("Error authenticating user: %v", err)
 		}
-		vehicles, err := tClient.Vehicles()
+		if !ok {
+			continue
+		}
+		v, err = api.Wake(ctx, v.ID)
 		if err != nil {
-			return fmt.Errorf("Error authenticating user: %v", err)
+			return fmt.Errorf("Error waking car: %v", err)
 		}
-		for _, v := range vehicles {
-			if v.Vin == VIN {
-				v, err := v.Wakeup()
-				if err != nil {
-					return fmt.Errorf("Error waking car: %v", err)
-				}
-				if v.State != "online" {
-					err = fmt.Errorf("Car not online yet: %v", err)
-					time.Sleep(5 * time.Second)
-				}
-				if err != nil {
-					return fmt.Errorf("Error waking car: %v", err)
-				}
-				if charge {
-					err := v.StartCharging()
-					if err != nil {
-						return fmt.Errorf("Error starting charge: %v", err)
-					}
-
-				} else {
-					err := v.StopCharging()
-					if err != nil {
-						return fmt.Errorf("Error stopping charge: %v", err)
-					}
-				}
+		if !v.Online() {
+			// give the car time to wake up before it is tried again
+			time.Sleep(wakeWait)
+			return fmt.Errorf("Error waking car: car not online yet")
+		}
+		if charge {
+			err := api.StartCharging(ctx, v.ID)
+			if err != nil {
+				return fmt.Errorf("Error starting charge: %v", err)
+			}
+		} else {
+			err := api.StopCharging(ctx, v.ID)
+			if err != nil {
+				return fmt.Errorf("Error stopping charge: %v", err)
 			}
 		}
 	}
@@ -124,24 +254,15 @@ func (p *TWCPrimary) TeslaAPIChargeByVIN(VIN string, charge bool) error {
 
 // ListTeslaAPIVehicles lists all the vehicles for all known user accounts
 func (p *TWCPrimary) ListTeslaAPIVehicles(w http.ResponseWriter, r *http.Request) {
-	for _, tAPIUser := range p.TeslaAPITokens {
-		tClient, err := tesla.NewClientWithToken(&tesla.Auth{
-			ClientID:     "81527cff06843c8634fdc09e8ac0abefb46ac849f38fe1e431c2ef2106796384",
-			ClientSecret: "c7257eb71a564034f9419ee651c7d0e5f7aa6bfbd18bafb5c5c033b093bb2fa3",
-		}, tAPIUser.Token)
-		if err != nil {
-			http.Redirect(w, r, "/vehicles", http.StatusSeeOther)
-			log.Println(fmt.Sprintf("Error authenticating user: %v", err))
-			return
-		}
-		vehicles, err := tClient.Vehicles()
+	for _, tAPIUser := range p.snapshot().TeslaAPITokens {
+		vehicles, err := p.newVehicleAPI(tAPIUser.Token.AccessToken).Vehicles(r.Context())
 		if err != nil {
 			http.Redirect(w, r, "/vehicles", http.StatusSeeOther)
 			log.Println(fmt.Sprintf("Error authenticating user: %v", err))
 			return
 		}
 		for _, v := range vehicles {
-			fmt.Println(fmt.Sprintf("%v, %v, %v, %v", v.ID, v.DisplayName, v.State, v.Vin))
+			fmt.Println(fmt.Sprintf("%v, %v, %v, %v", v.ID, v.DisplayName, v.State, v.VIN))
 		}
 	}
 	http.Redirect(w, r, "/vehicles", http.StatusSeeOther)
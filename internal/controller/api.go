@@ -19,12 +19,9 @@ func (p *TWCPrimary) APISetDebugLevel(w http.ResponseWriter, r *http.Request) {
 		httpError(w, fmt.Errorf("%v", err))
 		return
 	}
-	p.DebugLevel = int(debugLevel)
-	if len(p.knownTWCs) > 0 {
-		for _, twc := range p.knownTWCs {
-			twc.DebugLevel = int(debugLevel)
-		}
-	}
+	p.mu.Lock()
+	p.setDebugLevel(int(debugLevel))
+	p.mu.Unlock()
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, `{"DebugLevel": %v}`, vars["DebugLevel"])
 }
@@ -84,17 +81,7 @@ func (p *TWCPrimary) APISetMaxWattsHandler(w http.ResponseWriter, r *http.Reques
 			httpError(w, fmt.Errorf("%v", err))
 			return
 		}
-		phases := p.SupplyPhases
-		if phases != 1 && phases != 3 {
-			httpError(w, fmt.Errorf(`{"error":"not valid number of phases: %d"}`, phases))
-			return
-		}
-		intAmps = int(intWatts) / p.SupplyVoltage
-		if phases == 3 {
-			volts := p.SupplyVoltage * 3
-			intAmps = int(intWatts) / volts
-		}
-		err = p.SetMaxAmpsHandler(int(intAmps))
+		intAmps, err = p.SetMaxWatts(int(intWatts))
 		if err != nil {
 			httpError(w, fmt.Errorf("%v", err))
 			return
@@ -117,28 +104,10 @@ func (p *TWCPrimary) APIDisableTWC(w http.ResponseWriter, r *http.Request) {
 			httpError(w, fmt.Errorf("%v", err))
 			return
 		}
-		twc, ok := p.GetSecondary(bTWCID)
-		if ok {
-			if p.DebugLevel >= 9 {
-				log.Println(log2JSONString(LogData{
-					Type:     "DEBUG",
-					Source:   "api",
-					Receiver: fmt.Sprintf("%x", bTWCID),
-					Message:  "Disabling secondary twc",
-				}))
-			}
-			twc.AllowCharge = false
-			// _, err = p.sendChargeRate(twc.TWCID, []byte{0x00, 0x00}, byte(0x05))
-			// if err != nil {
-			// 	httpError(w, fmt.Errorf("%v", err))
-			// 	return
-			// }
-			_, err := p.sendStopCommand(twc.TWCID)
-			if err != nil {
-				httpError(w, fmt.Errorf("%v", err))
-				return
-			}
-			// @TODO: redistribute available amps over available TWCs
+		err = p.DisableTWC(bTWCID)
+		if err != nil {
+			httpError(w, fmt.Errorf("%v", err))
+			return
 		}
 	}
 	http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -153,49 +122,112 @@ func (p *TWCPrimary) APIEnableTWC(w http.ResponseWriter, r *http.Request) {
 			httpError(w, fmt.Errorf("%v", err))
 			return
 		}
-		twc, ok := p.GetSecondary(bTWCID)
-		if ok {
-			if p.DebugLevel >= 9 {
-				log.Println(log2JSONString(LogData{
-					Type:     "DEBUG",
-					Source:   "api",
-					Receiver: fmt.Sprintf("%x", bTWCID),
-					Message:  "Enabling secondary twc",
-				}))
-			}
-			twc.AllowCharge = true
-			// redistribute available amps over available TWCs
-			if p.knownTWCs != nil {
-				splitAmps := p.AvailableAmps / len(p.knownTWCs)
-				for _, twc := range p.knownTWCs {
-					if twc.AllowCharge {
-						p.numInitMsgsToSend = 10
-						// for i := 0; i < 6; i++ {
-						// 	_, _ = p.sendPrimaryLinkReady1()
-						// 	time.Sleep(100 * time.Millisecond)
-						// }
-						// for i := 0; i < 6; i++ {
-						// 	_, _ = p.sendPrimaryLinkReady2()
-						// 	time.Sleep(100 * time.Millisecond)
-						// }
-						_, err := p.sendChargeRate(twc.TWCID, Dec2Bytes(uint16(splitAmps*100)), byte(0x09))
-						if err != nil {
-							httpError(w, fmt.Errorf("%v", err))
-							return
-						}
-						_, err = p.sendStartCommand(twc.TWCID)
-						if err != nil {
-							httpError(w, fmt.Errorf("%v", err))
-							return
-						}
-					}
-				}
-			}
+		err = p.EnableTWC(bTWCID)
+		if err != nil {
+			httpError(w, fmt.Errorf("%v", err))
+			return
 		}
 	}
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// SetMaxWatts sets the max charge available to all TWCs from a number of watts, it returns the amps that works
+// out to
+func (p *TWCPrimary) SetMaxWatts(watts int) (int, error) {
+	p.mu.RLock()
+	phases := p.SupplyPhases
+	supplyVoltage := p.SupplyVoltage
+	p.mu.RUnlock()
+	if phases != 1 && phases != 3 {
+		return 0, fmt.Errorf(`{"error":"not valid number of phases: %d"}`, phases)
+	}
+	intAmps := watts / supplyVoltage
+	if phases == 3 {
+		volts := supplyVoltage * 3
+		intAmps = watts / volts
+	}
+	return intAmps, p.SetMaxAmpsHandler(intAmps)
+}
+
+// DisableTWC stops a specific TWC from charging and shares its amps with the others
+func (p *TWCPrimary) DisableTWC(twcID []byte) error {
+	p.mu.Lock()
+	twc, ok := p.GetSecondary(twcID)
+	if ok {
+		twc.AllowCharge = false
+	}
+	p.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if p.debugLevel() >= 9 {
+		log.Println(log2JSONString(LogData{
+			Type:     "DEBUG",
+			Source:   "api",
+			Receiver: fmt.Sprintf("%x", twcID),
+			Message:  "Disabling secondary twc",
+		}))
+	}
+	// _, err = p.sendChargeRate(twc.TWCID, []byte{0x00, 0x00}, byte(0x05))
+	// if err != nil {
+	// 	return err
+	// }
+	_, err := p.sendStopCommand(twcID)
+	if err != nil {
+		return err
+	}
+	// redistribute available amps over available TWCs
+	return p.balance()
+}
+
+// EnableTWC allows a specific TWC to charge again
+func (p *TWCPrimary) EnableTWC(twcID []byte) error {
+	p.mu.Lock()
+	twc, ok := p.GetSecondary(twcID)
+	allowed := [][]byte{}
+	if ok {
+		twc.AllowCharge = true
+		for _, twc := range p.knownTWCs {
+			if twc.AllowCharge {
+				p.numInitMsgsToSend = 10
+				allowed = append(allowed, twc.TWCID)
+			}
+		}
+	}
+	p.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if p.debugLevel() >= 9 {
+		log.Println(log2JSONString(LogData{
+			Type:     "DEBUG",
+			Source:   "api",
+			Receiver: fmt.Sprintf("%x", twcID),
+			Message:  "Enabling secondary twc",
+		}))
+	}
+	// redistribute available amps over available TWCs
+	err := p.balance()
+	if err != nil {
+		return err
+	}
+	for _, twcID := range allowed {
+		// for i := 0; i < 6; i++ {
+		// 	_, _ = p.sendPrimaryLinkReady1()
+		// 	time.Sleep(100 * time.Millisecond)
+		// }
+		// for i := 0; i < 6; i++ {
+		// 	_, _ = p.sendPrimaryLinkReady2()
+		// 	time.Sleep(100 * time.Millisecond)
+		// }
+		_, err = p.sendStartCommand(twcID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // APIGetStats just marshal the TWCs that we know about into json and return it
 func (p *TWCPrimary) APIGetStats(w http.ResponseWriter, r *http.Request) {
 	strB, err := json.Marshal(p.getStats())
@@ -0,0 +1,248 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/shreddedbacon/twcmanager/internal/allocator"
+)
+
+// SecondaryConfig holds the load balancing settings for a single secondary TWC
+type SecondaryConfig struct {
+	Priority    int        `yaml:"priority"`    // share of the available amps relative to the other TWCs, defaults to 1
+	MaxAmps     int        `yaml:"maxAmps"`     // the most this TWC will be given, 0 uses wiringMaxAmpsPerTWC
+	ChargeMode  string     `yaml:"chargeMode"`  // solar or always, a vehicle profile with a charge mode overrides this
+	Goal        ChargeGoal `yaml:"goal"`        // the energy any vehicle plugged in needs, a vehicle profile with a goal overrides this
+	AmpsControl string     `yaml:"ampsControl"` // twc or vehicle, a vehicle profile with amps control overrides this
+}
+
+// secondaryConfig returns the load balancing settings for a secondary, the lock must be held
+func (p *TWCPrimary) secondaryConfig(twcID []byte) SecondaryConfig {
+	cfg := p.Secondaries[fmt.Sprintf("%x", twcID)]
+	if cfg.Priority < 1 {
+		cfg.Priority = 1
+	}
+	if cfg.MaxAmps <= 0 || cfg.MaxAmps > p.WiringMaxAmpsPerTWC {
+		cfg.MaxAmps = p.WiringMaxAmpsPerTWC
+	}
+	if cfg.ChargeMode != ChargeModeAlways {
+		cfg.ChargeMode = ChargeModeSolar
+	}
+	if cfg.AmpsControl != AmpsControlVehicle {
+		cfg.AmpsControl = AmpsControlTWC
+	}
+	return cfg
+}
+
+// chargeMode returns the charge mode of the vehicle plugged into the secondary, falling back to the charge mode of
+// the secondary, a plan that is charging from the grid always charges, the lock must be held
+func (p *TWCPrimary) chargeMode(twc *TWCSecondary) string {
+	if twc.plan != nil && twc.plan.Charging() {
+		return ChargeModeAlways
+	}
+	profile, ok := p.vehicleProfile(twc)
+	if ok && profile.ChargeMode != "" {
+		return profile.ChargeMode
+	}
+	return p.secondaryConfig(twc.TWCID).ChargeMode
+}
+
+// SetSecondaryConfig saves the load balancing settings for a secondary and rebalances
+func (p *TWCPrimary) SetSecondaryConfig(twcID []byte, cfg SecondaryConfig) error {
+	if cfg.MaxAmps < 0 {
+		return fmt.Errorf("max amps must be 0 or more")
+	}
+	if cfg.ChargeMode != "" && cfg.ChargeMode != ChargeModeSolar && cfg.ChargeMode != ChargeModeAlways {
+		return fmt.Errorf("charge mode must be %s or %s", ChargeModeSolar, ChargeModeAlways)
+	}
+	if cfg.AmpsControl != "" && cfg.AmpsControl != AmpsControlTWC && cfg.AmpsControl != AmpsControlVehicle {
+		return fmt.Errorf("amps control must be %s or %s", AmpsControlTWC, AmpsControlVehicle)
+	}
+	if cfg.Goal.KWH > 0 && !cfg.Goal.valid() {
+		return fmt.Errorf("the goal time must be HH:MM")
+	}
+	p.mu.Lock()
+	if p.Secondaries == nil {
+		p.Secondaries = map[string]SecondaryConfig{}
+	}
+	p.Secondaries[fmt.Sprintf("%x", twcID)] = cfg
+	p.mu.Unlock()
+	err := p.writeConfig()
+	if err != nil {
+		return err
+	}
+	return p.balance()
+}
+
+// updateSecondaryConfig changes some of the load balancing settings for a secondary, keeping the rest
+func (p *TWCPrimary) updateSecondaryConfig(twcID []byte, update func(cfg *SecondaryConfig)) error {
+	p.mu.RLock()
+	cfg := p.Secondaries[fmt.Sprintf("%x", twcID)]
+	p.mu.RUnlock()
+	update(&cfg)
+	return p.SetSecondaryConfig(twcID, cfg)
+}
+
+// allocate works out how the available amps should be split between the secondaries, secondaries that always
+// charge are given what the wiring allows first, or what the tariff window allows from the grid while one is in
+// use, then everyone else shares the available amps out of whatever is left, the lock must be held
+func (p *TWCPrimary) allocate() []allocator.Allocation {
+	allocations := make([]allocator.Allocation, len(p.knownTWCs))
+	var always, solar []allocator.Charger
+	var alwaysIdx, solarIdx, targetReached []int
+	for i, twc := range p.knownTWCs {
+		cfg := p.secondaryConfig(twc.TWCID)
+		actualAmps := float64(Bytes2Dec2(twc.ReportedAmpsActual, false)) / 100
+		charger := allocator.Charger{
+			ID:       fmt.Sprintf("%x", twc.TWCID),
+			Priority: cfg.Priority,
+			MaxAmps:  cfg.MaxAmps,
+			// a paused secondary still wants current, it just isn't getting any
+			Drawing:     twc.AllowCharge && (actualAmps > 0 || twc.ReportedState == 1 || twc.PlugState == 1 || twc.paused),
+			TaperedAmps: twc.taper.Amps(),
+		}
+		if profile, ok := p.vehicleProfile(twc); ok {
+			if profile.MaxAmps > 0 && profile.MaxAmps < charger.MaxAmps {
+				charger.MaxAmps = profile.MaxAmps
+			}
+			if profile.Priority > 0 {
+				charger.Priority = profile.Priority
+			}
+			if charger.Drawing && p.dailyTargetReached(twc.profileVIN, profile) {
+				charger.Drawing = false
+				targetReached = append(targetReached, i)
+			}
+		}
+		if p.chargeMode(twc) == ChargeModeAlways {
+			always = append(always, charger)
+			alwaysIdx = append(alwaysIdx, i)
+		} else {
+			solar = append(solar, charger)
+			solarIdx = append(solarIdx, i)
+		}
+	}
+	alwaysAmps := p.WiringMaxAmpsAllTWC
+	if p.tariffWindow >= 0 && p.tariffWindow < len(p.Tariff.Windows) && p.Tariff.Windows[p.tariffWindow].MaxAmps < alwaysAmps {
+		alwaysAmps = p.Tariff.Windows[p.tariffWindow].MaxAmps
+	}
+	used := 0
+	for j, a := range allocator.Allocate(alwaysAmps, p.MinAmpsPerTWC, always) {
+		allocations[alwaysIdx[j]] = a
+		used += a.Amps
+	}
+	availableAmps := p.availableAmps()
+	if availableAmps > p.WiringMaxAmpsAllTWC-used {
+		availableAmps = p.WiringMaxAmpsAllTWC - used
+	}
+	for j, a := range allocator.Allocate(availableAmps, p.MinAmpsPerTWC, solar) {
+		allocations[solarIdx[j]] = a
+	}
+	// vehicles that have had their fill for the day are paused until tomorrow
+	for _, i := range targetReached {
+		allocations[i].Paused = true
+	}
+	return allocations
+}
+
+// allocatedAmps returns the amps the allocator would give a single secondary, and whether it would be paused,
+// the lock must be held
+func (p *TWCPrimary) allocatedAmps(twcID []byte) (int, bool) {
+	id := fmt.Sprintf("%x", twcID)
+	for _, a := range p.allocate() {
+		if a.ID == id {
+			return a.Amps, a.Paused
+		}
+	}
+	return 0, false
+}
+
+// balance splits the available amps between the secondaries and sends each one its new charge rate,
+// the lock must not be held
+func (p *TWCPrimary) balance() error {
+	type chargeRate struct {
+		twcID  []byte
+		amps   int
+		paused bool
+	}
+	p.mu.Lock()
+	if p.shuttingDown {
+		// Shutdown has left the secondaries at the safe rate
+		p.mu.Unlock()
+		return errShuttingDown
+	}
+	rates := []chargeRate{}
+	for i, a := range p.allocate() {
+		twc := p.knownTWCs[i]
+		if a.Amps == 0 && !a.Paused {
+			// nothing plugged in, or disabled, so nothing to tell it
+			continue
+		}
+		amps := a.Amps
+		if !a.Paused {
+			amps = p.twcChargeRate(twc, a.Amps)
+			if twc.vehicleAmps.active && !twc.paused && Bytes2Dec2(twc.AvailableAmps, true) == uint16(amps*100) {
+				// the vehicle is setting the amps, so the TWC is left alone
+				continue
+			}
+		}
+		twc.AvailableAmps = Dec2Bytes(uint16(amps * 100))
+		twc.paused = a.Paused
+		rates = append(rates, chargeRate{twcID: twc.TWCID, amps: amps, paused: a.Paused})
+	}
+	p.mu.Unlock()
+	for _, rate := range rates {
+		if rate.paused {
+			if p.debugLevel() >= 9 {
+				log.Println(log2JSONString(LogData{
+					Type:     "INFO",
+					Source:   "balance",
+					Receiver: fmt.Sprintf("%x", rate.twcID),
+					Message:  "Pausing secondary, there are not enough amps for the minimum or the vehicle has reached its daily target",
+				}))
+			}
+			_, err := p.sendChargeRate(rate.twcID, []byte{0x00, 0x00}, byte(0x05))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		_, err := p.sendChargeRate(rate.twcID, Dec2Bytes(uint16(rate.amps*100)), byte(0x09))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// APISecondarySettings sets the load balancing settings for a single secondary
+func (p *TWCPrimary) APISecondarySettings(w http.ResponseWriter, r *http.Request) {
+	twcid := r.FormValue("twcid")
+	bTWCID, err := TWCIDStr2Byte(twcid)
+	if err != nil {
+		httpError(w, fmt.Errorf("%v", err))
+		return
+	}
+	priority, err := strconv.Atoi(r.FormValue("priority"))
+	if err != nil || priority < 1 {
+		httpError(w, fmt.Errorf(`{"error":"priority must be a number of 1 or more"}`))
+		return
+	}
+	maxAmps, err := strconv.Atoi(r.FormValue("maxAmps"))
+	if err != nil || maxAmps < 0 {
+		httpError(w, fmt.Errorf(`{"error":"max amps must be a number of 0 or more"}`))
+		return
+	}
+	err = p.updateSecondaryConfig(bTWCID, func(cfg *SecondaryConfig) {
+		cfg.Priority = priority
+		cfg.MaxAmps = maxAmps
+		cfg.ChargeMode = r.FormValue("chargeMode")
+		cfg.AmpsControl = r.FormValue("ampsControl")
+	})
+	if err != nil {
+		httpError(w, fmt.Errorf("%v", err))
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/info/%x", bTWCID), http.StatusSeeOther)
+}
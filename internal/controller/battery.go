@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shreddedbacon/twcmanager/internal/energy"
+)
+
+// BatteryPolicy decides how the home battery is shared with the cars, it needs an energy source that reports the
+// battery
+type BatteryPolicy struct {
+	SolarMinSOC      float64 `yaml:"solarMinSOC"`      // only give the cars solar once the battery is above this percent, 0 to always
+	PreventDischarge bool    `yaml:"preventDischarge"` // take any battery discharge off the watts for the cars so they never draw from the battery
+	EveningStart     string  `yaml:"eveningStart"`     // HH:MM, when the battery may start charging the cars
+	EveningEnd       string  `yaml:"eveningEnd"`       // HH:MM, when it has to stop
+	EveningMinSOC    float64 `yaml:"eveningMinSOC"`    // the battery charges the cars in the evening until it is down to this percent
+	EveningWatts     int     `yaml:"eveningWatts"`     // the most the battery gives the cars in the evening, 0 turns the evening off
+}
+
+// evening returns true if the battery may charge the cars at the time with the charge it has
+func (b BatteryPolicy) evening(now time.Time, soc float64) bool {
+	if b.EveningWatts <= 0 || soc <= b.EveningMinSOC {
+		return false
+	}
+	return inWindow(now, b.EveningStart, b.EveningEnd)
+}
+
+// inWindow returns true if the local time of day is from start up to end, both HH:MM, a window that ends before it
+// starts runs past midnight, an invalid time means never
+func inWindow(now time.Time, start, end string) bool {
+	s, err := time.Parse("15:04", start)
+	if err != nil {
+		return false
+	}
+	e, err := time.Parse("15:04", end)
+	if err != nil {
+		return false
+	}
+	now = now.Local()
+	minute := now.Hour()*60 + now.Minute()
+	from := s.Hour()*60 + s.Minute()
+	to := e.Hour()*60 + e.Minute()
+	if from <= to {
+		return minute >= from && minute < to
+	}
+	return minute >= from || minute < to
+}
+
+// applyBatteryPolicy adjusts the watts available to the chargers for the battery, it returns the watts available
+// and how many of them come from the battery
+func (p *TWCPrimary) applyBatteryPolicy(d energy.Reading, availableWatts float64, now time.Time) (float64, float64) {
+	policy := p.BatteryPolicy
+	discharging := d.BatteryWatts
+	if discharging < 0 {
+		discharging = 0
+	}
+	fromBattery := float64(0)
+	reason := ""
+	// without the battery charge there's no telling whether the battery is full enough to share, so the limits that
+	// depend on it are skipped
+	switch {
+	case d.HasSOC && policy.evening(now, d.BatterySOC):
+		// the battery covers the chargers up to the evening limit, on top of any solar
+		availableWatts = availableWatts - discharging + float64(policy.EveningWatts)
+		fromBattery = float64(policy.EveningWatts)
+		reason = fmt.Sprintf("Battery at %.0f%%, letting it give the chargers up to %d watts", d.BatterySOC, policy.EveningWatts)
+	case policy.SolarMinSOC > 0 && d.HasSOC && d.BatterySOC < policy.SolarMinSOC:
+		// the battery is topped up first
+		availableWatts = 0
+		reason = fmt.Sprintf("Battery at %.0f%%, below %.0f%%, keeping the solar for the battery", d.BatterySOC, policy.SolarMinSOC)
+	case policy.PreventDischarge && discharging > 0:
+		availableWatts -= discharging
+		reason = fmt.Sprintf("Battery discharging %.0f watts, taking it off the watts for the chargers", discharging)
+	}
+	if availableWatts < 0 {
+		availableWatts = 0
+	}
+	if fromBattery > availableWatts {
+		fromBattery = availableWatts
+	}
+	if reason != "" && p.debugLevel() >= 12 {
+		log.Println(log2JSONString(LogData{
+			Type:    "DEBUG",
+			Source:  "battery",
+			Message: reason,
+		}))
+	}
+	return availableWatts, fromBattery
+}
+
+// APIBatteryPolicy saves the battery policy from the powerwall page
+func (p *TWCPrimary) APIBatteryPolicy(w http.ResponseWriter, r *http.Request) {
+	policy := BatteryPolicy{
+		PreventDischarge: r.FormValue("preventDischarge") == "on",
+		EveningStart:     r.FormValue("eveningStart"),
+		EveningEnd:       r.FormValue("eveningEnd"),
+	}
+	for _, t := range []string{policy.EveningStart, policy.EveningEnd} {
+		if _, err := time.Parse("15:04", t); t != "" && err != nil {
+			httpError(w, fmt.Errorf(`{"error":"evening times must be HH:MM"}`))
+			return
+		}
+	}
+	for _, field := range []struct {
+		name  string
+		value *float64
+	}{
+		{"solarMinSOC", &policy.SolarMinSOC},
+		{"eveningMinSOC", &policy.EveningMinSOC},
+	} {
+		v := r.FormValue(field.name)
+		if v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 100 {
+			httpError(w, fmt.Errorf(`{"error":"%s must be a percentage"}`, field.name))
+			return
+		}
+		*field.value = f
+	}
+	if v := r.FormValue("eveningWatts"); v != "" {
+		watts, err := strconv.Atoi(v)
+		if err != nil || watts < 0 {
+			httpError(w, fmt.Errorf(`{"error":"evening watts must be a number of 0 or more"}`))
+			return
+		}
+		policy.EveningWatts = watts
+	}
+	p.mu.Lock()
+	p.BatteryPolicy = policy
+	p.mu.Unlock()
+	err := p.writeConfig()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/powerwall", http.StatusSeeOther)
+}
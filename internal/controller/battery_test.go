@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shreddedbacon/twcmanager/internal/energy"
+)
+
+func TestApplyBatteryPolicy(t *testing.T) {
+	evening := time.Date(2020, 6, 1, 18, 0, 0, 0, time.Local)
+	midday := time.Date(2020, 6, 1, 12, 0, 0, 0, time.Local)
+	policy := BatteryPolicy{
+		SolarMinSOC:   90,
+		EveningStart:  "17:00",
+		EveningEnd:    "22:00",
+		EveningMinSOC: 50,
+		EveningWatts:  3000,
+	}
+	tests := []struct {
+		name        string
+		policy      BatteryPolicy
+		reading     energy.Reading
+		now         time.Time
+		available   float64
+		fromBattery float64
+	}{
+		{
+			name:      "battery below the solar minimum",
+			policy:    policy,
+			reading:   energy.Reading{BatterySOC: 80, HasSOC: true},
+			now:       midday,
+			available: 0,
+		},
+		{
+			name:      "battery above the solar minimum",
+			policy:    policy,
+			reading:   energy.Reading{BatterySOC: 95, HasSOC: true},
+			now:       midday,
+			available: 2000,
+		},
+		{
+			name:      "no battery charge reported",
+			policy:    policy,
+			reading:   energy.Reading{},
+			now:       midday,
+			available: 2000,
+		},
+		{
+			name:        "evening",
+			policy:      policy,
+			reading:     energy.Reading{BatterySOC: 60, HasSOC: true, BatteryWatts: 500},
+			now:         evening,
+			available:   4500,
+			fromBattery: 3000,
+		},
+		{
+			name:      "evening below the evening minimum",
+			policy:    policy,
+			reading:   energy.Reading{BatterySOC: 40, HasSOC: true},
+			now:       evening,
+			available: 0,
+		},
+		{
+			name:      "evening without the battery charge",
+			policy:    policy,
+			reading:   energy.Reading{BatteryWatts: 500},
+			now:       evening,
+			available: 2000,
+		},
+		{
+			name:      "prevent discharge without the battery charge",
+			policy:    BatteryPolicy{SolarMinSOC: 90, PreventDischarge: true},
+			reading:   energy.Reading{BatteryWatts: 500},
+			now:       midday,
+			available: 1500,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &TWCPrimary{BatteryPolicy: tt.policy}
+			available, fromBattery := p.applyBatteryPolicy(tt.reading, 2000, tt.now)
+			if available != tt.available || fromBattery != tt.fromBattery {
+				t.Fatalf("%v watts available with %v from the battery, want %v with %v", available, fromBattery, tt.available, tt.fromBattery)
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/shreddedbacon/twcmanager/internal/protocol"
+	"github.com/shreddedbacon/twcmanager/internal/transport"
+)
+
+// busPriority decides the order that queued messages are written to the bus, lower values are sent first
+type busPriority int
+
+const (
+	priorityHeartbeat busPriority = iota // linkready and heartbeats, the secondaries stop charging if these are late
+	priorityCommand                      // charge rate, start and stop commands
+	priorityPoll                         // polls for stats, vins and the like
+	numPriorities
+)
+
+// timings the bus scheduler works to
+const (
+	interFrameGap = 100 * time.Millisecond // minimum time between the end of one write and the start of the next
+	replyTimeout  = readTimeout            // how long to hold the bus for, and wait for, a reply to a request
+)
+
+// errReplyTimeout is returned when a secondary doesn't reply to a request in time
+var errReplyTimeout = errors.New("timed out waiting for reply")
+
+// errBusClosed is returned for any message that is still queued when the bus is closed
+var errBusClosed = errors.New("bus is closed")
+
+// busRequest is a message waiting to be written to the bus, and optionally the reply it is waiting for
+type busRequest struct {
+	msg      []byte
+	reply    protocol.Command // 0 if no reply is expected
+	from     []byte           // the secondary the reply is expected from
+	written  chan error       // receives the result of the write
+	replied  chan protocol.Frame
+	done     chan struct{} // closed when the reply arrives
+	timeSent int64
+}
+
+// busStats counts what has happened on the bus since the controller started
+type busStats struct {
+	framesSent     uint64
+	framesReceived uint64
+	checksumErrors uint64 // frames dropped because the checksum didn't match
+	lengthErrors   uint64 // frames dropped because they weren't one of the known lengths
+	ignoredBytes   uint64 // bytes received outside of a frame or in a frame that couldn't be decoded
+}
+
+// busScheduler owns the port, it is the only thing that reads from or writes to it
+// outbound messages are queued by priority and written one at a time with a gap between each, while a reader
+// passes every frame that arrives to the handler and to any request that is waiting for it
+type busScheduler struct {
+	port       transport.Transport
+	handler    func(protocol.Frame)
+	debugLevel func() int
+
+	mu      sync.Mutex
+	queued  sync.Cond
+	queues  [numPriorities][]*busRequest
+	waiting []*busRequest // requests that have been written and are waiting for a reply
+	closed  bool
+	stopped chan struct{} // closed once the writer has stopped
+	counts  busStats
+
+	frames   protocol.FrameReader // only used by the reader
+	received chan protocol.Frame  // frames waiting to be handled
+}
+
+func newBusScheduler(port transport.Transport, handler func(protocol.Frame), debugLevel func() int) *busScheduler {
+	b := &busScheduler{
+		port:       port,
+		handler:    handler,
+		debugLevel: debugLevel,
+		received:   make(chan protocol.Frame, 64),
+		stopped:    make(chan struct{}),
+	}
+	b.queued.L = &b.mu
+	return b
+}
+
+// Run starts the reader and the frame handler, then writes queued messages to the bus until it is closed
+func (b *busScheduler) Run() {
+	go b.readLoop()
+	go b.handleLoop()
+	b.writeLoop()
+	close(b.stopped)
+}
+
+// Close stops the bus once the message being written has been sent, anything still queued is dropped, then
+// closes the port
+func (b *busScheduler) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	b.queued.Broadcast()
+	<-b.stopped
+	return b.port.Close()
+}
+
+// enqueue adds a message to the queue for its priority and returns without waiting for it to be sent
+func (b *busScheduler) enqueue(msg []byte, priority busPriority, reply protocol.Command, from []byte) *busRequest {
+	r := &busRequest{
+		msg:     msg,
+		reply:   reply,
+		from:    from,
+		written: make(chan error, 1),
+		replied: make(chan protocol.Frame, 1),
+		done:    make(chan struct{}),
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		r.written <- errBusClosed
+		return r
+	}
+	b.queues[priority] = append(b.queues[priority], r)
+	b.queued.Signal()
+	return r
+}
+
+// post queues a message without waiting for it to be sent, if a reply is expected the bus is still held for it
+// and the reply only goes to the handler
+func (b *busScheduler) post(msg []byte, priority busPriority, reply protocol.Command, from []byte) {
+	b.enqueue(msg, priority, reply, from)
+}
+
+// send queues a message and waits for it to be written to the bus
+func (b *busScheduler) send(msg []byte, priority busPriority) (int64, error) {
+	r := b.enqueue(msg, priority, 0, nil)
+	err := <-r.written
+	return r.timeSent, err
+}
+
+// request queues a message and waits for the given reply from the secondary it was sent to
+func (b *busScheduler) request(msg []byte, priority busPriority, reply protocol.Command, from []byte) (protocol.Frame, error) {
+	r := b.enqueue(msg, priority, reply, from)
+	if err := <-r.written; err != nil {
+		return protocol.Frame{}, err
+	}
+	timer := time.NewTimer(replyTimeout)
+	defer timer.Stop()
+	select {
+	case f := <-r.replied:
+		return f, nil
+	case <-timer.C:
+		b.stopWaiting(r)
+		return protocol.Frame{}, fmt.Errorf("%w %s from %x", errReplyTimeout, reply, from)
+	}
+}
+
+// next blocks until a message is queued and returns the one with the highest priority, or nil once the bus
+// is closed
+func (b *busScheduler) next() *busRequest {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		if b.closed {
+			for i := range b.queues {
+				for _, r := range b.queues[i] {
+					r.written <- errBusClosed
+				}
+				b.queues[i] = nil
+			}
+			return nil
+		}
+		for i := range b.queues {
+			if len(b.queues[i]) > 0 {
+				r := b.queues[i][0]
+				b.queues[i] = b.queues[i][1:]
+				return r
+			}
+		}
+		b.queued.Wait()
+	}
+}
+
+// writeLoop writes queued messages one at a time, if a message expects a reply the bus is held until the reply
+// arrives or times out so the secondary isn't talked over
+func (b *busScheduler) writeLoop() {
+	var lastWrite time.Time
+	for {
+		r := b.next()
+		if r == nil {
+			return
+		}
+		if wait := interFrameGap - time.Since(lastWrite); wait > 0 {
+			time.Sleep(wait)
+		}
+		if r.reply != 0 {
+			// register before writing so a quick reply can't be missed
+			b.mu.Lock()
+			b.waiting = append(b.waiting, r)
+			b.mu.Unlock()
+		}
+		timeSent, err := SendMessage(b.debugLevel(), b.port, r.msg)
+		lastWrite = time.Now()
+		if err == nil {
+			b.mu.Lock()
+			b.counts.framesSent++
+			b.mu.Unlock()
+		}
+		r.timeSent = timeSent
+		r.written <- err
+		if err != nil || r.reply == 0 {
+			if err != nil {
+				b.stopWaiting(r)
+			}
+			continue
+		}
+		timer := time.NewTimer(replyTimeout)
+		select {
+		case <-r.done:
+		case <-timer.C:
+		}
+		timer.Stop()
+		lastWrite = time.Now()
+	}
+}
+
+// stats returns a copy of the bus counters
+func (b *busScheduler) stats() busStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.counts
+}
+
+// stopWaiting removes a request from the list of requests waiting for a reply
+func (b *busScheduler) stopWaiting(r *busRequest) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, w := range b.waiting {
+		if w == r {
+			b.waiting = append(b.waiting[:i], b.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// readLoop reads from the port for as long as it is open, and passes every complete frame on
+func (b *busScheduler) readLoop() {
+	defer close(b.received)
+	buf := make([]byte, 64)
+	for {
+		_ = b.port.SetReadDeadline(time.Now().Add(readTimeout))
+		n, err := b.port.Read(buf)
+		for _, body := range b.frames.Feed(buf[:n]) {
+			b.receive(body)
+		}
+		b.mu.Lock()
+		b.counts.ignoredBytes = b.frames.Ignored()
+		closed := b.closed
+		b.mu.Unlock()
+		if closed {
+			return
+		}
+		if err != nil && !isTimeout(err) {
+			if b.debugLevel() >= 1 {
+				log.Println(log2JSONString(LogData{
+					Type:    "ERROR",
+					Source:  "bus",
+					Message: fmt.Sprintf("Unable to read from the bus: %v", err),
+				}))
+			}
+			// don't spin if the port has gone away
+			time.Sleep(readTimeout)
+		}
+	}
+}
+
+// receive decodes a single frame, hands it to any request waiting for it and then queues it for the handler
+func (b *busScheduler) receive(body []byte) {
+	if b.debugLevel() >= 1 {
+		log.Println(log2JSONString(LogData{
+			Type:    "DEBUG",
+			Source:  "bus",
+			Message: fmt.Sprintf("Rx@: % X", body),
+		}))
+	}
+	msg, err := protocol.DecodeFrame(body)
+	if err != nil {
+		b.frames.Reject(body)
+		b.mu.Lock()
+		if errors.Is(err, protocol.ErrChecksum) {
+			b.counts.checksumErrors++
+		} else if errors.Is(err, protocol.ErrLength) {
+			b.counts.lengthErrors++
+		}
+		b.mu.Unlock()
+		if b.debugLevel() >= 2 {
+			log.Println(log2JSONString(LogData{
+				Type:    "DEBUG",
+				Source:  "bus",
+				Message: fmt.Sprintf("Ignoring message: %v", err),
+			}))
+		}
+		return
+	}
+	f, err := protocol.Decode(msg)
+	if err != nil {
+		if b.debugLevel() >= 2 {
+			log.Println(log2JSONString(LogData{
+				Type:    "DEBUG",
+				Source:  "bus",
+				Message: fmt.Sprintf("Unable to decode message: %v", err),
+			}))
+		}
+		return
+	}
+	b.mu.Lock()
+	b.counts.framesReceived++
+	for i, r := range b.waiting {
+		if r.reply == f.Command && bytes.Equal(r.from, f.Sender) {
+			b.waiting = append(b.waiting[:i], b.waiting[i+1:]...)
+			r.replied <- f
+			close(r.done)
+			break
+		}
+	}
+	b.mu.Unlock()
+	b.received <- f
+}
+
+// handleLoop runs the handler for each received frame, this is kept apart from the reader so a handler that
+// sends a message can't stop a reply from being read
+func (b *busScheduler) handleLoop() {
+	for f := range b.received {
+		b.handler(f)
+	}
+}
+
+// isTimeout returns true if the error is from a read deadline passing
+func isTimeout(err error) bool {
+	t, ok := err.(interface{ Timeout() bool })
+	return ok && t.Timeout()
+}
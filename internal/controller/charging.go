@@ -9,6 +9,13 @@ import (
 	"gopkg.in/matryer/try.v1"
 )
 
+// the waits when the vehicle api is used to start or stop charging, before trying again after a failure and for a
+// car that has been woken to come online
+var (
+	chargeRetryWait = 2 * time.Second
+	wakeWait        = 5 * time.Second
+)
+
 // APIStopCharging stop charging
 func (p *TWCPrimary) APIStopCharging(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
@@ -47,202 +54,246 @@ func (p *TWCPrimary) APIStartCharging(w http.ResponseWriter, r *http.Request) {
 
 // StopCharging attempts to stop charging the car
 func (p *TWCPrimary) StopCharging(TWCID []byte) error {
+	p.mu.Lock()
 	twc, ok := p.GetSecondary(TWCID)
-	if ok {
-		// if the twc is reporting that a car is plugged in, then attempt to stop charging it
-		if twc.PlugState == 1 || twc.PlugState == 3 {
-			if p.DebugLevel >= 9 {
+	// if the twc is reporting that a car is plugged in, then attempt to stop charging it
+	if !ok || (twc.PlugState != 1 && twc.PlugState != 3) {
+		p.mu.Unlock()
+		return nil
+	}
+	vin := fmt.Sprintf("%s%s%s", twc.VINStart, twc.VINMiddle, twc.VINEnd)
+	hasAccounts := len(p.TeslaAPITokens) > 0
+	if hasAccounts && len(vin) == 17 {
+		twc.AllowCharge = true
+		twc.ChargeState = false
+	} else if !hasAccounts {
+		twc.AllowCharge = false
+		twc.ChargeState = false
+	}
+	p.mu.Unlock()
+	if p.debugLevel() >= 9 {
+		log.Println(log2JSONString(LogData{
+			Type:     "DEBUG",
+			Source:   "charging",
+			Receiver: fmt.Sprintf("%x", TWCID),
+			Message:  "Received stop command for TWC",
+		}))
+	}
+	if hasAccounts {
+		if len(vin) == 17 {
+			if p.debugLevel() >= 9 {
 				log.Println(log2JSONString(LogData{
 					Type:     "DEBUG",
 					Source:   "charging",
 					Receiver: fmt.Sprintf("%x", TWCID),
-					Message:  "Received stop command for TWC",
+					Message:  fmt.Sprintf("Attempting to stop charging car via API, vin: %s ", vin),
 				}))
 			}
-			if len(p.TeslaAPITokens) > 0 {
-				vin := fmt.Sprintf("%s%s%s", twc.VINStart, twc.VINMiddle, twc.VINEnd)
-				if len(vin) == 17 {
-					twc.AllowCharge = true
-					twc.ChargeState = false
-					if p.DebugLevel >= 9 {
+			// try and stop charging 10 times before giving up
+			err := try.Do(func(attempt int) (bool, error) {
+				var err error
+				err = p.TeslaAPIChargeByVIN(vin, false)
+				if err != nil {
+					if p.debugLevel() >= 9 {
 						log.Println(log2JSONString(LogData{
-							Type:     "DEBUG",
+							Type:     "ERROR",
 							Source:   "charging",
 							Receiver: fmt.Sprintf("%x", TWCID),
-							Message:  fmt.Sprintf("Attempting to stop charging car via API, vin: %s ", vin),
+							Message:  fmt.Sprintf("Unable to stop charging vin %s, trying again: %v", vin, err),
 						}))
 					}
-					// try and stop charging 10 times before giving up
-					err := try.Do(func(attempt int) (bool, error) {
-						var err error
-						err = p.TeslaAPIChargeByVIN(vin, false)
-						if err != nil {
-							if p.DebugLevel >= 9 {
-								log.Println(log2JSONString(LogData{
-									Type:     "ERROR",
-									Source:   "charging",
-									Receiver: fmt.Sprintf("%x", TWCID),
-									Message:  fmt.Sprintf("Unable to stop charging vin %s, trying again: %v", vin, err),
-								}))
-							}
-							time.Sleep(2 * time.Second)
-						}
-						return attempt < 10, err
-					})
-					if err != nil {
-						return err
-					}
-					p.LEDCharging = false
-				}
-			} else {
-				if p.DebugLevel >= 9 {
-					log.Println(log2JSONString(LogData{
-						Type:     "DEBUG",
-						Source:   "charging",
-						Receiver: fmt.Sprintf("%x", TWCID),
-						Message:  "No accounts known by controller, proceeding to stop charging the brutal way",
-					}))
+					time.Sleep(chargeRetryWait)
 				}
-				twc.AllowCharge = false
-				twc.ChargeState = false
-				if p.DebugLevel >= 9 {
-					log.Println(log2JSONString(LogData{
-						Type:     "DEBUG",
-						Source:   "charging",
-						Receiver: fmt.Sprintf("%x", TWCID),
-						Message:  "Disabling secondary TWC",
-					}))
-				}
-				_, err := p.sendChargeRate(twc.TWCID, []byte{0x00, 0x00}, byte(0x05))
-				if err != nil {
-					return err
-				}
-				_, err = p.sendStopCommand(twc.TWCID)
-				if err != nil {
-					return err
-				}
-				p.LEDCharging = false
+				return attempt < 10, err
+			})
+			if err != nil {
+				return err
 			}
+			p.setLEDCharging(false)
+		}
+	} else {
+		if p.debugLevel() >= 9 {
+			log.Println(log2JSONString(LogData{
+				Type:     "DEBUG",
+				Source:   "charging",
+				Receiver: fmt.Sprintf("%x", TWCID),
+				Message:  "No accounts known by controller, proceeding to stop charging the brutal way",
+			}))
+		}
+		if p.debugLevel() >= 9 {
+			log.Println(log2JSONString(LogData{
+				Type:     "DEBUG",
+				Source:   "charging",
+				Receiver: fmt.Sprintf("%x", TWCID),
+				Message:  "Disabling secondary TWC",
+			}))
 		}
+		_, err := p.sendChargeRate(TWCID, []byte{0x00, 0x00}, byte(0x05))
+		if err != nil {
+			return err
+		}
+		_, err = p.sendStopCommand(TWCID)
+		if err != nil {
+			return err
+		}
+		p.setLEDCharging(false)
 	}
 	return nil
 }
 
 // StartCharging attempts to start charging the car
 func (p *TWCPrimary) StartCharging(TWCID []byte) error {
+	p.mu.Lock()
 	twc, ok := p.GetSecondary(TWCID)
-	if ok {
-		// if the twc is reporting that a car is plugged in, then attempt to start charging it
-		if twc.PlugState == 1 || twc.PlugState == 3 {
-			if p.DebugLevel >= 9 {
+	// if the twc is reporting that a car is plugged in, then attempt to start charging it
+	if !ok || (twc.PlugState != 1 && twc.PlugState != 3) {
+		p.mu.Unlock()
+		return nil
+	}
+	vin := fmt.Sprintf("%s%s%s", twc.VINStart, twc.VINMiddle, twc.VINEnd)
+	hasAccounts := len(p.TeslaAPITokens) > 0
+	if !hasAccounts || len(vin) == 17 {
+		twc.AllowCharge = true
+		twc.ChargeState = true
+	}
+	splitAmps, paused := p.allocatedAmps(TWCID)
+	if splitAmps == 0 && !paused {
+		// the car isn't drawing anything yet so the allocator doesn't count it, start it at the minimum until
+		// the next time the amps are balanced
+		splitAmps = p.MinAmpsPerTWC
+	}
+	// a vehicle that sets its own amps is started with the TWC at its maximum
+	twcAmps := splitAmps
+	if !paused {
+		twcAmps = p.twcChargeRate(twc, splitAmps)
+	}
+	p.mu.Unlock()
+	if p.debugLevel() >= 9 {
+		log.Println(log2JSONString(LogData{
+			Type:     "DEBUG",
+			Source:   "charging",
+			Receiver: fmt.Sprintf("%x", TWCID),
+			Message:  "Received start command for TWC",
+		}))
+	}
+	if hasAccounts {
+		if len(vin) == 17 {
+			if p.debugLevel() >= 9 {
 				log.Println(log2JSONString(LogData{
 					Type:     "DEBUG",
 					Source:   "charging",
 					Receiver: fmt.Sprintf("%x", TWCID),
-					Message:  "Received start command for TWC",
+					Message:  fmt.Sprintf("Attempting to start charging car via API, vin: %s ", vin),
 				}))
 			}
-			if len(p.TeslaAPITokens) > 0 {
-				vin := fmt.Sprintf("%s%s%s", twc.VINStart, twc.VINMiddle, twc.VINEnd)
-				if len(vin) == 17 {
-					if p.DebugLevel >= 9 {
+			// try and start charging 10 times before giving up
+			err := try.Do(func(attempt int) (bool, error) {
+				var err error
+				err = p.TeslaAPIChargeByVIN(vin, true)
+				if err != nil {
+					if p.debugLevel() >= 9 {
 						log.Println(log2JSONString(LogData{
-							Type:     "DEBUG",
+							Type:     "ERROR",
 							Source:   "charging",
 							Receiver: fmt.Sprintf("%x", TWCID),
-							Message:  fmt.Sprintf("Attempting to start charging car via API, vin: %s ", vin),
+							Message:  fmt.Sprintf("Unable to start charging vin %s, trying again: %v", vin, err),
 						}))
 					}
-					splitAmps := p.AvailableAmps / len(p.knownTWCs)
-					twc.AllowCharge = true
-					twc.ChargeState = true
-					// try and start charging 10 times before giving up
-					err := try.Do(func(attempt int) (bool, error) {
-						var err error
-						err = p.TeslaAPIChargeByVIN(vin, true)
-						if err != nil {
-							if p.DebugLevel >= 9 {
-								log.Println(log2JSONString(LogData{
-									Type:     "ERROR",
-									Source:   "charging",
-									Receiver: fmt.Sprintf("%x", TWCID),
-									Message:  fmt.Sprintf("Unable to start charging vin %s, trying again: %v", vin, err),
-								}))
-							}
-							time.Sleep(2 * time.Second)
-						}
-						return attempt < 10, err
-					})
-					if err != nil {
-						return err
-					}
-					_, err = p.sendChargeRate(twc.TWCID, Dec2Bytes(uint16(splitAmps*100)), byte(0x09))
-					if err != nil {
-						return err
-					}
-					p.LEDCharging = true
-				}
-			} else {
-				if p.DebugLevel >= 9 {
-					log.Println(log2JSONString(LogData{
-						Type:     "DEBUG",
-						Source:   "charging",
-						Receiver: fmt.Sprintf("%x", TWCID),
-						Message:  "No accounts known by controller, proceeding to start charging the brutal way",
-					}))
-				}
-				splitAmps := p.AvailableAmps / len(p.knownTWCs)
-				twc.AllowCharge = true
-				twc.ChargeState = true
-				if p.DebugLevel >= 9 {
-					log.Println(log2JSONString(LogData{
-						Type:     "DEBUG",
-						Source:   "charging",
-						Receiver: fmt.Sprintf("%x", TWCID),
-						Message:  "Enabling secondary TWC",
-					}))
+					time.Sleep(chargeRetryWait)
 				}
-				_, err := p.sendStartCommand(twc.TWCID)
-				if err != nil {
-					return err
-				}
-				_, err = p.sendChargeRate(twc.TWCID, Dec2Bytes(uint16(splitAmps*100)), byte(0x09))
-				if err != nil {
-					return err
-				}
-				p.LEDCharging = true
+				return attempt < 10, err
+			})
+			if err != nil {
+				return err
 			}
+			_, err = p.sendChargeRate(TWCID, Dec2Bytes(uint16(twcAmps*100)), byte(0x09))
+			if err != nil {
+				return err
+			}
+			p.setLEDCharging(true)
+		}
+	} else {
+		if p.debugLevel() >= 9 {
+			log.Println(log2JSONString(LogData{
+				Type:     "DEBUG",
+				Source:   "charging",
+				Receiver: fmt.Sprintf("%x", TWCID),
+				Message:  "No accounts known by controller, proceeding to start charging the brutal way",
+			}))
 		}
+		if p.debugLevel() >= 9 {
+			log.Println(log2JSONString(LogData{
+				Type:     "DEBUG",
+				Source:   "charging",
+				Receiver: fmt.Sprintf("%x", TWCID),
+				Message:  "Enabling secondary TWC",
+			}))
+		}
+		_, err := p.sendStartCommand(TWCID)
+		if err != nil {
+			return err
+		}
+		_, err = p.sendChargeRate(TWCID, Dec2Bytes(uint16(splitAmps*100)), byte(0x09))
+		if err != nil {
+			return err
+		}
+		p.setLEDCharging(true)
 	}
 	return nil
 }
 
+func (p *TWCPrimary) setLEDCharging(charging bool) {
+	p.mu.Lock()
+	p.LEDCharging = charging
+	p.mu.Unlock()
+}
+
 // @TODO: need an option to check if we should actually stop/start connected cars, to support scheduled charging/departure in the car
 
-// StopConnectedCars loop over all the twcs that have a known charge state and check if they need to be stopped
+// StopConnectedCars loop over all the twcs that have a known charge state and check if they need to be stopped,
+// vehicles with a profile that always charges are left alone
 func (p *TWCPrimary) StopConnectedCars() {
-	if p.knownTWCs != nil {
-		for _, twc := range p.knownTWCs {
-			if twc.ChargeState == true {
-				err := p.StopCharging(twc.TWCID)
-				if err != nil {
-					fmt.Println(fmt.Sprintf("error stopping charging: %v", err))
-				}
-			}
+	for _, twcID := range p.secondaryIDsByChargeState(true) {
+		if p.alwaysCharges(twcID) {
+			continue
+		}
+		err := p.StopCharging(twcID)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("error stopping charging: %v", err))
 		}
 	}
 }
 
 // StartConnectedCars loop over all the twcs that have a known charge state and check if they need to be started
 func (p *TWCPrimary) StartConnectedCars() {
-	if p.knownTWCs != nil {
-		for _, twc := range p.knownTWCs {
-			if twc.ChargeState == false {
-				err := p.StartCharging(twc.TWCID)
-				if err != nil {
-					fmt.Println(fmt.Sprintf("error starting charging: %v", err))
-				}
-			}
+	for _, twcID := range p.secondaryIDsByChargeState(false) {
+		err := p.StartCharging(twcID)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("error starting charging: %v", err))
 		}
 	}
 }
+
+// secondaryIDsByChargeState returns the IDs of the secondaries that are, or are not, charging
+func (p *TWCPrimary) secondaryIDsByChargeState(charging bool) [][]byte {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	ids := [][]byte{}
+	for _, twc := range p.knownTWCs {
+		if twc.ChargeState == charging {
+			ids = append(ids, twc.TWCID)
+		}
+	}
+	return ids
+}
+
+// alwaysCharges returns true if the secondary, or the profile of the vehicle plugged into it, always charges
+func (p *TWCPrimary) alwaysCharges(twcID []byte) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	twc, ok := p.GetSecondary(twcID)
+	if !ok {
+		return false
+	}
+	return p.chargeMode(twc) == ChargeModeAlways
+}
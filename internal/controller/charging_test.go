@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shreddedbacon/twcmanager/internal/simulator"
+	"github.com/shreddedbacon/twcmanager/internal/vehicle"
+)
+
+// startWithVehicleAPI runs the primary with the fake owner api and an account signed in to it, the car is plugged
+// in and its VIN known once it returns, the waits between tries are shortened until the test ends
+func startWithVehicleAPI(t *testing.T) (*TWCPrimary, *vehicle.Fake) {
+	retry, wake := chargeRetryWait, wakeWait
+	chargeRetryWait, wakeWait = time.Millisecond, time.Millisecond
+	t.Cleanup(func() {
+		chargeRetryWait, wakeWait = retry, wake
+	})
+	fake := vehicle.NewFake(testVIN)
+	if err := fake.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fake.Close() })
+	cfg := testConfig(t)
+	cfg.VehicleAPIURL = fake.URL
+	p, _ := startPrimary(t, cfg, simulator.Options{}, oneTWC)
+	waitFor(t, 15*time.Second, "the car to be plugged in", func() bool {
+		twc, _ := secondary(p, testTWC1)
+		return twc.PlugState == 1 || twc.PlugState == 3
+	})
+	p.mu.Lock()
+	p.TeslaAPITokens = []*TeslaAPIUser{{Username: "test@example.com", Token: &vehicle.Token{AccessToken: "fake-access-1"}}}
+	// the VIN takes a while to come over the bus, it is the same one the simulator sends
+	twc, _ := p.GetSecondary(testTWC1)
+	twc.VINStart, twc.VINMiddle, twc.VINEnd = testVIN[:7], testVIN[7:14], testVIN[14:]
+	p.mu.Unlock()
+	return p, fake
+}
+
+// commands returns how many times the fake's car has been sent the command
+func commands(fake *vehicle.Fake, name string) int {
+	v, _ := fake.Vehicle(testVIN)
+	n := 0
+	for _, command := range v.Commands {
+		if command == name {
+			n++
+		}
+	}
+	return n
+}
+
+// the retry waits are changed, so these tests don't run in parallel with the others
+func TestChargingThroughTheVehicleAPI(t *testing.T) {
+	p, fake := startWithVehicleAPI(t)
+	tests := []struct {
+		name      string
+		setup     func()
+		charge    bool
+		command   string
+		commands  int // the commands sent to the car
+		wantErr   bool
+		wantState string
+	}{
+		{
+			name:      "start after the car wakes up",
+			setup:     func() { fake.WakeAfter(3) },
+			charge:    true,
+			command:   "charge_start",
+			commands:  1,
+			wantState: "Charging",
+		},
+		{
+			name:      "stop after failing",
+			setup:     func() { fake.Fail(3) },
+			command:   "charge_stop",
+			commands:  4,
+			wantState: "Stopped",
+		},
+		{
+			name:      "start after failing",
+			setup:     func() { fake.Fail(9) },
+			charge:    true,
+			command:   "charge_start",
+			commands:  10,
+			wantState: "Charging",
+		},
+		{
+			name:      "stop a sleeping car after failing",
+			setup:     func() { fake.Sleep(testVIN); fake.WakeAfter(2); fake.Fail(2) },
+			command:   "charge_stop",
+			commands:  3,
+			wantState: "Stopped",
+		},
+		{
+			name:      "start gives up after 10 failures",
+			setup:     func() { fake.Fail(20) },
+			charge:    true,
+			command:   "charge_start",
+			commands:  10,
+			wantErr:   true,
+			wantState: "Stopped",
+		},
+		{
+			name:      "still starts once the car answers",
+			setup:     func() { fake.Fail(0) },
+			charge:    true,
+			command:   "charge_start",
+			commands:  1,
+			wantState: "Charging",
+		},
+		{
+			name:      "stop gives up after 10 failures",
+			setup:     func() { fake.Fail(20) },
+			command:   "charge_stop",
+			commands:  10,
+			wantErr:   true,
+			wantState: "Charging",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup()
+			before := commands(fake, tt.command)
+			var err error
+			if tt.charge {
+				err = p.StartCharging(testTWC1)
+			} else {
+				err = p.StopCharging(testTWC1)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("returned %v, want an error %v", err, tt.wantErr)
+			}
+			if sent := commands(fake, tt.command) - before; sent != tt.commands {
+				t.Fatalf("%s was sent %d times, want %d", tt.command, sent, tt.commands)
+			}
+			v, _ := fake.Vehicle(testVIN)
+			if v.ChargingState != tt.wantState {
+				t.Fatalf("the car is %s, want %s", v.ChargingState, tt.wantState)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shreddedbacon/twcmanager/internal/energy"
+	"github.com/shreddedbacon/twcmanager/internal/simulator"
+)
+
+// testRouter routes the pages and api the concurrency test hammers, the same way main does
+func testRouter(p *TWCPrimary) *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/maxamps", p.APISetMaxAmpsHandler).Methods("POST")
+	r.HandleFunc("/api/v1/disable", p.APIStopCharging).Methods("POST")
+	r.HandleFunc("/api/v1/enable", p.APIStartCharging).Methods("POST")
+	r.HandleFunc("/api/v1/secondary", p.APISecondarySettings).Methods("POST")
+	r.HandleFunc("/api/v1/stats", p.APIGetStats).Methods("GET")
+	r.HandleFunc("/api/v1/solarcontrol", p.APISolarControl).Methods("GET")
+	r.HandleFunc("/metrics", p.Metrics).Methods("GET")
+	r.HandleFunc("/", p.GetWallConnectors)
+	r.HandleFunc("/info/{twcid}", p.GetWallConnectorInfo)
+	r.HandleFunc("/powerwall", p.GetPowerwallSettings)
+	return r
+}
+
+// TestPrimaryConcurrently runs the bus, the crons, the web ui and the mqtt commands against each other so the race
+// detector has something to find
+func TestPrimaryConcurrently(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs for too long for -short")
+	}
+	t.Parallel()
+	cfg := testConfig(t)
+	cfg.EnablePowerwall = true
+	cfg.AutoStartStopInterval = true
+	cfg.EnergySource = EnergySourceConfig{
+		Type:   EnergySourceStatic,
+		Static: energy.Reading{SolarWatts: 6000, LoadWatts: 1000},
+	}
+	cfg.SolarControl.SampleInterval = 1
+	p, sim := startPrimary(t, cfg, simulator.Options{}, func(s *simulator.Simulator) {
+		oneTWC(s)
+		s.AddTWC(testTWC2, 3200)
+		_ = s.PlugIn(testTWC2, "5YJ3E7EB0KF000002")
+	})
+	for _, id := range [][]byte{testTWC1, testTWC2} {
+		waitFor(t, 15*time.Second, "the secondaries to link", func() bool { return linked(p, id) })
+	}
+
+	router := testRouter(p)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	// loop calls f until the test stops
+	loop := func(f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				f(i)
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+	}
+	loop(func(i int) {
+		p.RunCron()
+	})
+	loop(func(i int) {
+		// every call is a new sample, so the solar following runs each time
+		p.powerwallCron(time.Now().Unix() + int64(i))
+	})
+	get := func(path string) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s returned %d: %s", path, w.Code, w.Body)
+		}
+	}
+	post := func(path string, form url.Values) {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusSeeOther && w.Code != http.StatusOK {
+			t.Errorf("POST %s returned %d: %s", path, w.Code, w.Body)
+		}
+	}
+	loop(func(i int) {
+		for _, path := range []string{"/", "/info/1001", "/info/1002", "/api/v1/stats", "/api/v1/solarcontrol", "/metrics", "/powerwall"} {
+			get(path)
+		}
+	})
+	loop(func(i int) {
+		post("/api/v1/maxamps", url.Values{"availableAmps": {[]string{"10", "16", "24"}[i%3]}})
+		post("/api/v1/secondary", url.Values{"twcid": {"1002"}, "priority": {"2"}, "maxAmps": {"16"}})
+		if i%2 == 0 {
+			post("/api/v1/disable", url.Values{"twcid": {"1001"}})
+		} else {
+			post("/api/v1/enable", url.Values{"twcid": {"1001"}})
+		}
+	})
+	loop(func(i int) {
+		p.mqttPrimaryCommand("twcmanager/command/maxamps", []byte([]string{"12", "20"}[i%2]))
+		p.mqttPrimaryCommand("twcmanager/command/powerwall", []byte([]string{"off", "on"}[i%2]))
+		p.mqttSecondaryCommand("twcmanager/secondary/1002/command/charging", []byte([]string{"off", "on"}[i%2]))
+		p.mqttSecondaryCommand("twcmanager/secondary/1001/command/solar", []byte([]string{"on", "off"}[i%2]))
+	})
+	time.Sleep(5 * time.Second)
+	close(stop)
+	wg.Wait()
+
+	// the primary still works once it has all settled down
+	if err := p.SetPowerwallMode(false); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range [][]byte{testTWC1, testTWC2} {
+		err := p.updateSecondaryConfig(id, func(cfg *SecondaryConfig) {
+			cfg.ChargeMode = ChargeModeSolar
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.StartCharging(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.SetMaxAmpsHandler(20); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 10*time.Second, "the secondaries to share the new charge rate", func() bool {
+		return int(offered(sim, testTWC1))+int(offered(sim, testTWC2)) == 2000
+	})
+}
@@ -1,12 +1,12 @@
 package controller
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"log"
 	"time"
 
-	powerwall "github.com/shreddedbacon/fake-powerwall/api"
+	"github.com/shreddedbacon/twcmanager/internal/energy"
 )
 
 // RunCron runs the cron scrips
@@ -15,241 +15,215 @@ func (p *TWCPrimary) RunCron() {
 	p.vinCron(now)
 	p.twcStatusCron(now)
 	p.powerwallCron(now)
+	p.planCron(now)
+	p.sessionCron(now)
+	p.accountsCron(now)
+	p.vehicleAmpsCron(now)
 }
 
 func (p *TWCPrimary) twcStatusCron(now int64) {
-	if (now - p.timeLastSecondaryPoll) >= 5 {
-		if p.DebugLevel >= 12 {
-			log.Println(log2JSONString(LogData{
-				Type:    "DEBUG",
-				Source:  "cron",
-				Message: fmt.Sprintf("Running twcStatusCron %d, knownTWC count %d", now-p.timeLastSecondaryPoll, len(p.knownTWCs)),
-			}))
-		}
-		if len(p.knownTWCs) == 1 {
-			p.SetTWCStatusLED(0x00ff00)
-		} else if len(p.knownTWCs) == 0 {
-			p.SetTWCStatusLED(0xffff00)
-		}
-		p.timeLastSecondaryPoll = now
+	p.mu.Lock()
+	if (now - p.timeLastSecondaryPoll) < 5 {
+		p.mu.Unlock()
+		return
+	}
+	if p.debugLevel() >= 12 {
+		log.Println(log2JSONString(LogData{
+			Type:    "DEBUG",
+			Source:  "cron",
+			Message: fmt.Sprintf("Running twcStatusCron %d, knownTWC count %d", now-p.timeLastSecondaryPoll, len(p.knownTWCs)),
+		}))
+	}
+	knownTWCs := len(p.knownTWCs)
+	p.timeLastSecondaryPoll = now
+	p.mu.Unlock()
+	if knownTWCs == 1 {
+		p.SetTWCStatusLED(0x00ff00)
+	} else if knownTWCs == 0 {
+		p.SetTWCStatusLED(0xffff00)
 	}
 }
 
 // check if the full vin is collected and set the VIN LED status
 // this is meant for if the controller only talks to 1 twc
 func (p *TWCPrimary) vinCron(now int64) {
-	if (now - p.timeLastVINCron) >= 5 {
-		if p.DebugLevel >= 12 {
+	p.mu.Lock()
+	if (now - p.timeLastVINCron) < 5 {
+		p.mu.Unlock()
+		return
+	}
+	if p.debugLevel() >= 12 {
+		log.Println(log2JSONString(LogData{
+			Type:    "DEBUG",
+			Source:  "cron",
+			Message: fmt.Sprintf("Running vin %d, knownTWC count %d", now-p.timeLastSecondaryPoll, len(p.knownTWCs)),
+		}))
+	}
+	p.timeLastVINCron = now
+	if len(p.knownTWCs) != 1 {
+		p.mu.Unlock()
+		return
+	}
+	// count how many of the three parts of the vin have been received
+	vinParts := 0
+	for _, part := range []string{p.knownTWCs[0].VINStart, p.knownTWCs[0].VINMiddle, p.knownTWCs[0].VINEnd} {
+		if part != "" {
+			vinParts++
+		}
+	}
+	p.mu.Unlock()
+	switch vinParts {
+	case 0:
+		p.SetVINLED(0x000000)
+	case 1:
+		p.SetVINLED(0xff0000)
+	case 2:
+		p.SetVINLED(0xffff00)
+	case 3:
+		p.SetVINLED(0x00ff00)
+	}
+}
+
+// powerwallReading is what the energy source reported at the last check, and the watts that left for the chargers
+type powerwallReading struct {
+	ok             bool // false if the energy source couldn't be read
+	solarWatts     float64
+	loadWatts      float64
+	siteWatts      float64 // negative when exporting to the grid
+	batteryWatts   float64 // negative when charging the battery
+	batterySOC     float64
+	hasSOC         bool // false if the energy source doesn't report the battery charge
+	availableWatts float64
+}
+
+// this is where we check the usage from the solar/powerwall and set the available amperage on the wall connector
+func (p *TWCPrimary) powerwallCron(now int64) {
+	// take a copy of the settings so the lock isn't held while talking to the powerwall and the TWCs
+	cfg := p.snapshot()
+	// check the last time we checked the powerwall for its status
+	// the tariff windows are checked every time so the amps change as soon as a window starts or ends
+	window := cfg.Tariff.window(time.Unix(now, 0))
+	// the check is claimed before it is done, the crons run every second and a slow energy source would otherwise
+	// be read again by each cron that starts before it answers
+	p.mu.Lock()
+	lastCheck, lastWindow := p.timeLastPowerwallCheck, p.tariffWindow
+	due := (now-lastCheck) >= p.solarCheckInterval() || window != lastWindow
+	if due {
+		p.timeLastPowerwallCheck = now
+		p.tariffWindow = window
+	}
+	p.mu.Unlock()
+	if !due {
+		return
+	}
+	if window != lastWindow && p.debugLevel() >= 9 {
+		message := "Leaving the tariff windows"
+		if window >= 0 {
+			message = fmt.Sprintf("Entering the %s tariff window", cfg.Tariff.windowName(window))
+		}
+		log.Println(log2JSONString(LogData{
+			Type:    "INFO",
+			Source:  "cron",
+			Message: message,
+		}))
+	}
+	gridAmps := cfg.Tariff.gridAmps(window)
+	// if solar following is enabled and there is something to read the solar generation from
+	if cfg.EnablePowerwall && cfg.energy != nil {
+		// if the time checks out, then we do the thing
+		if p.debugLevel() >= 12 {
 			log.Println(log2JSONString(LogData{
 				Type:    "DEBUG",
 				Source:  "cron",
-				Message: fmt.Sprintf("Running vin %d, knownTWC count %d", now-p.timeLastSecondaryPoll, len(p.knownTWCs)),
+				Message: fmt.Sprintf("Running powerwallCron %d", now-lastCheck),
 			}))
 		}
-		if len(p.knownTWCs) == 1 {
-			if p.knownTWCs[0].VINStart == "" && p.knownTWCs[0].VINMiddle == "" && p.knownTWCs[0].VINEnd == "" {
-				p.SetVINLED(0x000000)
-			}
-
-			if p.knownTWCs[0].VINStart != "" && p.knownTWCs[0].VINMiddle == "" && p.knownTWCs[0].VINEnd == "" {
-				p.SetVINLED(0xff0000)
-			}
-			if p.knownTWCs[0].VINStart == "" && p.knownTWCs[0].VINMiddle != "" && p.knownTWCs[0].VINEnd == "" {
-				p.SetVINLED(0xff0000)
-			}
-			if p.knownTWCs[0].VINStart == "" && p.knownTWCs[0].VINMiddle == "" && p.knownTWCs[0].VINEnd != "" {
-				p.SetVINLED(0xff0000)
-			}
 
-			if p.knownTWCs[0].VINStart != "" && p.knownTWCs[0].VINMiddle != "" && p.knownTWCs[0].VINEnd == "" {
-				p.SetVINLED(0xffff00)
-			}
-			if p.knownTWCs[0].VINStart != "" && p.knownTWCs[0].VINMiddle == "" && p.knownTWCs[0].VINEnd != "" {
-				p.SetVINLED(0xffff00)
-			}
-			if p.knownTWCs[0].VINStart == "" && p.knownTWCs[0].VINMiddle != "" && p.knownTWCs[0].VINEnd != "" {
-				p.SetVINLED(0xffff00)
+		d, err := p.readEnergy(context.Background(), cfg.energy)
+		if err != nil {
+			// carry on with nothing from solar, the offset still applies
+			d = energy.Reading{}
+			if p.debugLevel() >= 1 {
+				log.Println(log2JSONString(LogData{
+					Type:    "ERROR",
+					Source:  "cron",
+					Message: fmt.Sprintf("Unable to read the %s energy source: %v", cfg.energySourceType(), err),
+				}))
 			}
+		}
 
-			if p.knownTWCs[0].VINStart != "" && p.knownTWCs[0].VINMiddle != "" && p.knownTWCs[0].VINEnd != "" {
-				p.SetVINLED(0x00ff00)
+		availableWatts, batteryWatts := float64(0), float64(0)
+		if err == nil {
+			availableWatts = cfg.availableSolarWatts(d, p.getStats())
+			availableWatts, batteryWatts = cfg.applyBatteryPolicy(d, availableWatts, time.Now())
+		}
+		solarGeneration := d.SolarWatts
+		currentLoad := int(d.LoadWatts)
+		p.mu.Lock()
+		// what the battery gives the chargers isn't solar
+		p.solarAmps = float64(wattsToAmps(cfg.SupplyPhases, cfg.SupplyVoltage, availableWatts-batteryWatts))
+		p.lastPowerwall = powerwallReading{
+			ok:             err == nil,
+			solarWatts:     solarGeneration,
+			loadWatts:      float64(currentLoad),
+			siteWatts:      d.GridWatts,
+			batteryWatts:   d.BatteryWatts,
+			batterySOC:     d.BatterySOC,
+			hasSOC:         d.HasSOC,
+			availableWatts: availableWatts,
+		}
+		p.mu.Unlock()
+		offsetAmps := wattsToAmps(cfg.SupplyPhases, cfg.SupplyVoltage, float64(cfg.PowerOffset))
+		p.followSolar(&cfg, now, availableWatts, offsetAmps, window)
+		if window != lastWindow {
+			// the window limits the secondaries that always charge too
+			_ = p.balance()
+		}
+	} else {
+		p.mu.Lock()
+		p.solarAmps = 0
+		p.mu.Unlock()
+		// if powerwall monitoring is disabled, then just check if the available amps are enough
+		// this mode is basically acting just like a normal wall connector if the available amps are higher than the minimum (default 6A)
+		availableAmps := cfg.AvailableAmps
+		if gridAmps >= 0 {
+			// the tariff windows decide the amps
+			availableAmps = gridAmps
+		}
+		// the window's amps only last until it ends, so the configured amps aren't changed, they apply whether or not
+		// the cars are started and stopped through the vehicle api, and below the minimum the chargers are paused
+		if err := p.setLimitAmps(gridAmps); err != nil {
+			if p.debugLevel() >= 12 {
+				log.Println(log2JSONString(LogData{
+					Type:    "ERROR",
+					Source:  "cron",
+					Message: fmt.Sprintf("Error setting the amperage to %d: %v", availableAmps, err),
+				}))
 			}
+			return
 		}
-		p.timeLastVINCron = now
-	}
-}
-
-// this is where we check the usage from the solar/powerwall and set the available amperage on the wall connector
-func (p *TWCPrimary) powerwallCron(now int64) {
-	// check the last time we checked the powerwall for its status
-	if (now - p.timeLastPowerwallCheck) >= int64(p.PowerwallCheckInterval*60) {
-		// if powerwall monitoring is enabled
-		if p.EnablePowerwall && p.Powerwall != "" {
-			// if the time checks out, then we do the thing
-			if p.DebugLevel >= 12 {
+		if availableAmps >= cfg.MinAmpsPerTWC {
+			if p.debugLevel() >= 12 {
 				log.Println(log2JSONString(LogData{
 					Type:    "DEBUG",
 					Source:  "cron",
-					Message: fmt.Sprintf("Running powerwallCron %d", now-p.timeLastPowerwallCheck),
+					Message: fmt.Sprintf("Setting the amperage to %d", availableAmps),
 				}))
 			}
-
-			d := &powerwall.MetersAggregates{}
-			pw := powerwall.FakePowerwall{
-				Inverter: p.Powerwall,
-			}
-			b, err := pw.Request("/api/meters/aggregates")
-			if err == nil {
-				json.Unmarshal(b, d)
-			}
-
-			// get the total watts the chargers are consuming first up
-			totalWatts := 0
-			for _, twc := range p.knownTWCs {
-				totalWatts = totalWatts + int(twc.StatsCurrentWatts)
-			}
-			currentLoad := 0
-			if d.Load != nil {
-				currentLoad = int(d.Load.InstantPower)
-			}
-			nonChargerLoad := int(currentLoad)
-			if int(currentLoad) > totalWatts {
-				nonChargerLoad = int(currentLoad) - totalWatts
-			}
-
-			solarGeneration := float64(0)
-			if d.Solar != nil {
-				solarGeneration = d.Solar.InstantPower
-			}
-			availableWatts := float64(0)
-			if int(solarGeneration) > nonChargerLoad {
-				availableWatts = float64(int(solarGeneration) - nonChargerLoad)
-			}
-
-			// need to invert the site power as it shows as negative when exporting to grid
-			// solarExport := d.Site.InstantPower * -1
-			intAmps := wattsToAmps(p.SupplyPhases, p.SupplyVoltage, availableWatts)
-			offsetAmps := wattsToAmps(p.SupplyPhases, p.SupplyVoltage, float64(p.PowerOffset))
-			// @TODO: this needs to be redone at some stage to calculate if the powerwall/solar power value has the charger usage in it too
-			// if intAmps >= p.MinAmpsPerTWC {
-			// 	// check if the power we are getting from solar is producing enough amps
-			// 	if p.DebugLevel >= 12 {
-			// 		log.Println(fmt.Sprintf("set the amperage to %d", intAmps))
-			// 	}
-			// 	err = p.SetMaxAmpsHandler(intAmps)
-			// 	if err != nil {
-			// 		if p.DebugLevel >= 12 {
-			// 			log.Println(fmt.Sprintf("error set the amperage to %d", intAmps))
-			// 		}
-			// 	}
-			// 	if p.AutoStartStopInterval {
-			// 		p.StartConnectedCars()
-			// 	}
-			// } else
-			if intAmps+offsetAmps >= p.MinAmpsPerTWC {
-				// check if the power we are getting from solar is producing enough amps, plus the amps we have as an offset
-				if p.DebugLevel == 12 {
-					log.Println(log2JSONString(LogData{
-						Type:    "DEBUG",
-						Source:  "cron",
-						Message: fmt.Sprintf("Setting the amperage to %d, including offset of %d", intAmps+offsetAmps, offsetAmps),
-					}))
-				}
-				if p.AutoStartStopInterval {
-					err = p.SetMaxAmpsHandler(intAmps + offsetAmps)
-					if err != nil {
-						if p.DebugLevel >= 12 {
-							log.Println(log2JSONString(LogData{
-								Type:    "ERROR",
-								Source:  "cron",
-								Message: fmt.Sprintf("Error setting the amperage to %d, including offset of %d", intAmps+offsetAmps, offsetAmps),
-							}))
-						}
-						return
-					}
-				}
-				if p.AutoStartStopInterval {
-					p.StartConnectedCars()
-				}
-			} else {
-				// otherwise fall back to just our offset amps
-				if offsetAmps >= p.MinAmpsPerTWC {
-					if p.DebugLevel >= 12 {
-						log.Println(log2JSONString(LogData{
-							Type:    "DEBUG",
-							Source:  "cron",
-							Message: fmt.Sprintf("Setting the amperage to %d with no solar generation", offsetAmps),
-						}))
-					}
-					if p.AutoStartStopInterval {
-						err = p.SetMaxAmpsHandler(offsetAmps)
-						if err != nil {
-							if p.DebugLevel >= 12 {
-								log.Println(log2JSONString(LogData{
-									Type:    "ERROR",
-									Source:  "cron",
-									Message: fmt.Sprintf("Error setting the amperage to %d with no solar generation", offsetAmps),
-								}))
-							}
-							return
-						}
-					}
-					if p.AutoStartStopInterval {
-						p.StartConnectedCars()
-					}
-				} else {
-					if p.DebugLevel >= 12 {
-						log.Println(log2JSONString(LogData{
-							Type:    "DEBUG",
-							Source:  "cron",
-							Message: fmt.Sprintf("Not enough amps to cover minimum; Offset: %d, Minimum:%d", offsetAmps, p.MinAmpsPerTWC),
-						}))
-					}
-					if p.AutoStartStopInterval {
-						p.StopConnectedCars()
-					}
-				}
+			if cfg.AutoStartStopInterval {
+				p.StartConnectedCars()
 			}
 		} else {
-			// if powerwall monitoring is disabled, then just check if the available amps are enough
-			// this mode is basically acting just like a normal wall connector if the available amps are higher than the minimum (default 6A)
-			if p.AvailableAmps >= p.MinAmpsPerTWC {
-				if p.DebugLevel >= 12 {
-					log.Println(log2JSONString(LogData{
-						Type:    "DEBUG",
-						Source:  "cron",
-						Message: fmt.Sprintf("Setting the amperage to %d", p.AvailableAmps),
-					}))
-				}
-				if p.AutoStartStopInterval {
-					err := p.SetMaxAmpsHandler(p.AvailableAmps)
-					if err != nil {
-						if p.DebugLevel >= 12 {
-							log.Println(log2JSONString(LogData{
-								Type:    "ERROR",
-								Source:  "cron",
-								Message: fmt.Sprintf("Error setting the amperage to %d", p.AvailableAmps),
-							}))
-						}
-						return
-					}
-				}
-				if p.AutoStartStopInterval {
-					p.StartConnectedCars()
-				}
-			} else {
-				if p.DebugLevel >= 12 {
-					log.Println(log2JSONString(LogData{
-						Type:    "DEBUG",
-						Source:  "cron",
-						Message: fmt.Sprintf("Not enough amps to cover minimum; Available: %d, Minimum:%d", p.AvailableAmps, p.MinAmpsPerTWC),
-					}))
-				}
-				if p.AutoStartStopInterval {
-					p.StopConnectedCars()
-				}
+			if p.debugLevel() >= 12 {
+				log.Println(log2JSONString(LogData{
+					Type:    "DEBUG",
+					Source:  "cron",
+					Message: fmt.Sprintf("Not enough amps to cover minimum; Available: %d, Minimum:%d", availableAmps, cfg.MinAmpsPerTWC),
+				}))
+			}
+			if cfg.AutoStartStopInterval {
+				p.StopConnectedCars()
 			}
 		}
-		p.timeLastPowerwallCheck = now
 	}
 }
@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shreddedbacon/twcmanager/internal/energy"
+)
+
+// energy source types
+const (
+	EnergySourcePowerwall = "powerwall" // the local api of a powerwall, or fake-powerwall, at the powerwall address
+	EnergySourceHTTP      = "http"      // any JSON endpoint, eg the local api of an Enphase or SMA inverter
+	EnergySourceMQTT      = "mqtt"      // topics on the broker from the mqtt settings
+	EnergySourceModbus    = "modbus"    // a sunspec inverter and meter, or any registers, over modbus tcp
+	EnergySourceStatic    = "static"    // fixed values from the config
+)
+
+// EnergySourceConfig chooses where the solar generation, house load, grid and battery power are read from when
+// following the solar
+type EnergySourceConfig struct {
+	Type     string              `yaml:"type"`     // powerwall, http, mqtt, modbus or static, defaults to powerwall
+	URL      string              `yaml:"url"`      // http, the JSON document to read
+	Headers  map[string]string   `yaml:"headers"`  // http, extra request headers such as Authorization
+	Insecure bool                `yaml:"insecure"` // http, don't verify the certificate
+	Paths    energy.Paths        `yaml:"paths"`    // http, where each value is in the document
+	Topics   energy.Topics       `yaml:"topics"`   // mqtt, where each value is published
+	MaxAge   int                 `yaml:"maxAge"`   // mqtt, seconds before a value is too old to use, defaults to 600
+	Modbus   energy.ModbusConfig `yaml:"modbus"`   // modbus, the device and where to read each value
+	Static   energy.Reading      `yaml:"static"`   // static, the values to report
+}
+
+// newEnergySource creates the energy source from the config, it returns nil if there is nothing to read from
+func (p *TWCPrimary) newEnergySource() (energy.Source, error) {
+	cfg := p.EnergySource
+	switch cfg.Type {
+	case "", EnergySourcePowerwall:
+		if p.Powerwall == "" {
+			return nil, nil
+		}
+		return energy.NewPowerwall(p.Powerwall), nil
+	case EnergySourceHTTP:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("the url is required for an http energy source")
+		}
+		return energy.NewHTTP(cfg.URL, cfg.Headers, cfg.Paths, cfg.Insecure), nil
+	case EnergySourceMQTT:
+		if p.mqtt == nil {
+			return nil, fmt.Errorf("the mqtt broker is required for an mqtt energy source")
+		}
+		maxAge := time.Duration(cfg.MaxAge) * time.Second
+		if maxAge <= 0 {
+			maxAge = 10 * time.Minute
+		}
+		source, err := energy.NewMQTT(p.mqtt, cfg.Topics, maxAge)
+		if err != nil {
+			return nil, err
+		}
+		return source, nil
+	case EnergySourceModbus:
+		source, err := energy.NewModbus(cfg.Modbus)
+		if err != nil {
+			return nil, err
+		}
+		return source, nil
+	case EnergySourceStatic:
+		return &energy.Static{Reading: cfg.Static}, nil
+	}
+	return nil, fmt.Errorf("unknown energy source type %q", cfg.Type)
+}
+
+// solar modes, how the watts left over for the chargers are worked out
+const (
+	SolarModeSurplus = "surplus" // the solar generation less the load that isn't the chargers, this is the default
+	SolarModeGrid    = "grid"    // the chargers are adjusted by how far the grid meter is from the grid setpoint
+)
+
+// availableSolarWatts works out the watts the chargers can use from a reading, twcs are copies of the secondaries
+// taken at the time of the reading
+func (p *TWCPrimary) availableSolarWatts(d energy.Reading, twcs []TWCSecondary) float64 {
+	if p.SolarMode == SolarModeGrid {
+		// what the chargers are drawing now, plus whatever would bring the grid to the setpoint, so anything the
+		// chargers draw that isn't covered by solar shows up on the meter and is taken back off next time
+		chargerAmps := float64(0)
+		for _, twc := range twcs {
+			if len(twc.ReportedAmpsActual) >= 2 {
+				chargerAmps += float64(Bytes2Dec2(twc.ReportedAmpsActual, false)) / 100
+			}
+		}
+		chargerWatts := chargerAmps * float64(ampsToWatts(p.SupplyPhases, p.SupplyVoltage, 1))
+		availableWatts := chargerWatts + float64(p.GridSetpoint) - d.GridWatts
+		if availableWatts < 0 {
+			return 0
+		}
+		return availableWatts
+	}
+	// get the total watts the chargers are consuming first up
+	totalWatts := 0
+	for _, twc := range twcs {
+		totalWatts = totalWatts + int(twc.StatsCurrentWatts)
+	}
+	currentLoad := int(d.LoadWatts)
+	nonChargerLoad := int(currentLoad)
+	if int(currentLoad) > totalWatts {
+		nonChargerLoad = int(currentLoad) - totalWatts
+	}
+	availableWatts := float64(0)
+	if int(d.SolarWatts) > nonChargerLoad {
+		availableWatts = float64(int(d.SolarWatts) - nonChargerLoad)
+	}
+	return availableWatts
+}
+
+// energySourceType returns the type of energy source in use
+func (p *TWCPrimary) energySourceType() string {
+	if p.EnergySource.Type == "" {
+		return EnergySourcePowerwall
+	}
+	return p.EnergySource.Type
+}
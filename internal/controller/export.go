@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/shreddedbacon/twcmanager/internal/history"
+)
+
+// ExportSession is a single charging session in an export
+type ExportSession struct {
+	ID          uint64    `json:"id"`
+	TWCID       string    `json:"twcID"`
+	VIN         string    `json:"vin"`
+	VehicleName string    `json:"vehicleName"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	KWH         uint32    `json:"kWh"`
+	Cost        float64   `json:"cost"`
+	// the energy delivered at each price, the rest of the energy was delivered before this was recorded and is
+	// charged at the price outside the windows
+	Tariffs []history.TariffKWH `json:"tariffs"`
+}
+
+// ExportTotal is the energy given to a single vehicle over all the sessions in an export
+type ExportTotal struct {
+	VIN         string  `json:"vin"`
+	VehicleName string  `json:"vehicleName"`
+	Sessions    int     `json:"sessions"`
+	KWH         uint32  `json:"kWh"`
+	Cost        float64 `json:"cost"`
+}
+
+// Export is the charging sessions between two times, with the totals for each vehicle
+type Export struct {
+	From        time.Time       `json:"from"`
+	To          time.Time       `json:"to"`
+	PricePerKWH float64         `json:"pricePerKWH"` // the price outside the tariff windows
+	Currency    string          `json:"currency"`
+	Sessions    []ExportSession `json:"sessions"`
+	Totals      []ExportTotal   `json:"totals"`
+}
+
+// exportSessions collects the sessions that started between the two times, only those for the given VIN if it
+// isn't empty
+func (p *TWCPrimary) exportSessions(from, to time.Time, vin string) (Export, error) {
+	if p.history == nil {
+		return Export{}, fmt.Errorf("session history is not available")
+	}
+	p.mu.RLock()
+	tariff := p.Tariff
+	p.mu.RUnlock()
+	sessions, err := p.history.Between(from, to)
+	if err != nil {
+		return Export{}, err
+	}
+	export := Export{
+		From:        from,
+		To:          to,
+		PricePerKWH: tariff.PricePerKWH,
+		Currency:    tariff.Currency,
+		Sessions:    []ExportSession{},
+		Totals:      []ExportTotal{},
+	}
+	totals := map[string]*ExportTotal{}
+	for _, s := range sessions {
+		if vin != "" && s.VIN != vin {
+			continue
+		}
+		session := ExportSession{
+			ID:          s.ID,
+			TWCID:       s.TWCID,
+			VIN:         s.VIN,
+			VehicleName: s.VehicleName,
+			Start:       s.Start,
+			End:         s.End,
+			KWH:         s.KWH,
+			Cost:        roundCost(s.Cost(tariff.PricePerKWH)),
+			Tariffs:     s.Tariffs,
+		}
+		if session.Tariffs == nil {
+			session.Tariffs = []history.TariffKWH{}
+		}
+		export.Sessions = append(export.Sessions, session)
+		total, ok := totals[s.VIN]
+		if !ok {
+			total = &ExportTotal{VIN: s.VIN}
+			totals[s.VIN] = total
+		}
+		if s.VehicleName != "" {
+			total.VehicleName = s.VehicleName
+		}
+		total.Sessions++
+		total.KWH += s.KWH
+		total.Cost += s.Cost(tariff.PricePerKWH)
+	}
+	for _, total := range totals {
+		total.Cost = roundCost(total.Cost)
+		export.Totals = append(export.Totals, *total)
+	}
+	sort.Slice(export.Totals, func(i, j int) bool {
+		return export.Totals[i].VIN < export.Totals[j].VIN
+	})
+	return export, nil
+}
+
+// roundCost rounds a cost to the nearest cent
+func roundCost(cost float64) float64 {
+	return math.Round(cost*100) / 100
+}
+
+// parseExportTime reads a time from the query, either a date or an RFC3339 time, a date given as the end of the
+// range includes the whole of that day
+func parseExportTime(v string, end bool) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", v, time.Local); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// APIExportSessions exports the charging sessions between two times as CSV or JSON, with the energy and cost for
+// each session and the totals for each vehicle
+func (p *TWCPrimary) APIExportSessions(w http.ResponseWriter, r *http.Request) {
+	var err error
+	query := r.URL.Query()
+	from := time.Time{}
+	to := time.Now()
+	if v := query.Get("from"); v != "" {
+		from, err = parseExportTime(v, false)
+		if err != nil {
+			httpError(w, fmt.Errorf("from must be a date (2006-01-02) or an RFC3339 time"))
+			return
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		to, err = parseExportTime(v, true)
+		if err != nil {
+			httpError(w, fmt.Errorf("to must be a date (2006-01-02) or an RFC3339 time"))
+			return
+		}
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		httpError(w, fmt.Errorf("format must be csv or json"))
+		return
+	}
+	export, err := p.exportSessions(from, to, query.Get("vin"))
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	filename := fmt.Sprintf("sessions-%s-%s.%s", from.Format("20060102"), to.Format("20060102"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	if format == "json" {
+		strB, err := json.Marshal(export)
+		if err != nil {
+			httpError(w, fmt.Errorf("%v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "%s", string(strB))
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.WriteHeader(http.StatusOK)
+	writeExportCSV(w, export)
+}
+
+// writeExportCSV writes the sessions, then a blank line and the totals for each vehicle
+func writeExportCSV(w http.ResponseWriter, export Export) {
+	c := csv.NewWriter(w)
+	c.Write([]string{"id", "twcID", "vin", "vehicleName", "start", "end", "kWh", "cost", "currency"})
+	for _, s := range export.Sessions {
+		end := ""
+		if !s.End.IsZero() {
+			end = s.End.Format(time.RFC3339)
+		}
+		c.Write([]string{
+			strconv.FormatUint(s.ID, 10),
+			s.TWCID,
+			s.VIN,
+			s.VehicleName,
+			s.Start.Format(time.RFC3339),
+			end,
+			strconv.FormatUint(uint64(s.KWH), 10),
+			strconv.FormatFloat(s.Cost, 'f', 2, 64),
+			export.Currency,
+		})
+	}
+	c.Write(nil)
+	c.Write([]string{"vin", "vehicleName", "sessions", "kWh", "cost", "currency"})
+	for _, t := range export.Totals {
+		c.Write([]string{
+			t.VIN,
+			t.VehicleName,
+			strconv.Itoa(t.Sessions),
+			strconv.FormatUint(uint64(t.KWH), 10),
+			strconv.FormatFloat(t.Cost, 'f', 2, 64),
+			export.Currency,
+		})
+	}
+	c.Flush()
+}
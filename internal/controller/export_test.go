@@ -0,0 +1,243 @@
+package controller
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shreddedbacon/twcmanager/internal/history"
+)
+
+// the vehicles in the exported sessions
+const (
+	exportVIN1 = "5YJ3E7EB0KF000001"
+	exportVIN2 = "5YJ3E7EB0KF000002"
+)
+
+// day returns a local time on a day in july 2020
+func day(d, hour, minute int) time.Time {
+	return time.Date(2020, 7, d, hour, minute, 0, 0, time.Local)
+}
+
+// exportPrimary returns a primary with a session store in a temporary directory holding the sessions
+func exportPrimary(t *testing.T, tariff Tariff, sessions []history.Session) *TWCPrimary {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	store, err := history.OpenStore(filepath.Join(dir, "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	for i := range sessions {
+		s := sessions[i]
+		if err := store.Save(&s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &TWCPrimary{mu: &sync.RWMutex{}, Tariff: tariff, history: store}
+}
+
+// exportSessionsFixture are july's sessions, and one either side of it
+var exportSessionsFixture = []history.Session{
+	{TWCID: "1001", VIN: exportVIN2, Start: day(0, 23, 59), End: day(1, 2, 0), KWH: 5},
+	{
+		TWCID: "1001", VIN: exportVIN1, VehicleName: "Model 3", Start: day(1, 10, 0), End: day(1, 14, 0), KWH: 10,
+		Tariffs: []history.TariffKWH{{Window: "", PricePerKWH: 0.30, KWH: 4}, {Window: "off-peak", PricePerKWH: 0.12, KWH: 6}},
+	},
+	// recorded before the tariff windows were kept
+	{TWCID: "1002", VIN: exportVIN2, Start: day(15, 18, 0), End: day(15, 20, 0), KWH: 7},
+	{
+		TWCID: "1001", VIN: exportVIN1, Start: day(31, 23, 30), End: day(32, 1, 0), KWH: 3,
+		Tariffs: []history.TariffKWH{{Window: "", PricePerKWH: 0.30, KWH: 3}},
+	},
+	{TWCID: "1001", VIN: exportVIN1, Start: day(32, 0, 0), End: day(32, 3, 0), KWH: 9},
+}
+
+func TestExportSessions(t *testing.T) {
+	p := exportPrimary(t, Tariff{PricePerKWH: 0.30, Currency: "AUD"}, exportSessionsFixture)
+	july, august := day(1, 0, 0), day(32, 0, 0)
+	tests := []struct {
+		name     string
+		from, to time.Time
+		vin      string
+		sessions []uint64 // the IDs of the sessions exported
+		costs    []float64
+		totals   []ExportTotal
+	}{
+		{
+			name:     "every vehicle",
+			from:     july,
+			to:       august,
+			sessions: []uint64{2, 3, 4},
+			costs:    []float64{1.92, 2.10, 0.90},
+			totals: []ExportTotal{
+				{VIN: exportVIN1, VehicleName: "Model 3", Sessions: 2, KWH: 13, Cost: 2.82},
+				{VIN: exportVIN2, Sessions: 1, KWH: 7, Cost: 2.10},
+			},
+		},
+		{
+			name:     "one vehicle",
+			from:     july,
+			to:       august,
+			vin:      exportVIN2,
+			sessions: []uint64{3},
+			costs:    []float64{2.10},
+			totals:   []ExportTotal{{VIN: exportVIN2, Sessions: 1, KWH: 7, Cost: 2.10}},
+		},
+		{
+			name:     "the end of the range isn't included",
+			from:     july,
+			to:       day(31, 23, 30),
+			vin:      exportVIN1,
+			sessions: []uint64{2},
+			costs:    []float64{1.92},
+			totals:   []ExportTotal{{VIN: exportVIN1, VehicleName: "Model 3", Sessions: 1, KWH: 10, Cost: 1.92}},
+		},
+		{
+			name:     "a vehicle without sessions",
+			from:     july,
+			to:       august,
+			vin:      "5YJ3E7EB0KF000003",
+			sessions: []uint64{},
+			costs:    []float64{},
+			totals:   []ExportTotal{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			export, err := p.exportSessions(tt.from, tt.to, tt.vin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids, costs := []uint64{}, []float64{}
+			for _, s := range export.Sessions {
+				ids = append(ids, s.ID)
+				costs = append(costs, s.Cost)
+			}
+			if !reflect.DeepEqual(ids, tt.sessions) || !reflect.DeepEqual(costs, tt.costs) {
+				t.Fatalf("exported sessions %v costing %v, want %v costing %v", ids, costs, tt.sessions, tt.costs)
+			}
+			if !reflect.DeepEqual(export.Totals, tt.totals) {
+				t.Fatalf("totals %+v, want %+v", export.Totals, tt.totals)
+			}
+			if export.PricePerKWH != 0.30 || export.Currency != "AUD" {
+				t.Fatalf("exported the price as %v %s, want 0.30 AUD", export.PricePerKWH, export.Currency)
+			}
+		})
+	}
+}
+
+func TestExportCostRounding(t *testing.T) {
+	// each session costs a third of a cent over 33 cents, the total is rounded from what they cost rather than
+	// adding up the rounded costs
+	sessions := []history.Session{
+		{VIN: exportVIN1, Start: day(1, 10, 0), End: day(1, 11, 0), KWH: 1},
+		{VIN: exportVIN1, Start: day(2, 10, 0), End: day(2, 11, 0), KWH: 1},
+		{VIN: exportVIN2, Start: day(3, 10, 0), End: day(3, 11, 0), KWH: 1, Tariffs: []history.TariffKWH{{PricePerKWH: 0.125, KWH: 1}}},
+	}
+	p := exportPrimary(t, Tariff{PricePerKWH: 0.3333}, sessions)
+	export, err := p.exportSessions(day(1, 0, 0), day(4, 0, 0), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []float64{0.33, 0.33, 0.13} {
+		if got := export.Sessions[i].Cost; got != want {
+			t.Fatalf("session %d costs %v, want %v", i+1, got, want)
+		}
+	}
+	if got := export.Totals[0].Cost; got != 0.67 {
+		t.Fatalf("the total costs %v, want 0.67", got)
+	}
+}
+
+func TestParseExportTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		end     bool
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "a date starts the range at midnight", value: "2020-07-01", want: day(1, 0, 0)},
+		{name: "a date ends the range with the whole day", value: "2020-07-31", end: true, want: day(32, 0, 0)},
+		{name: "a time", value: "2020-07-01T10:30:00Z", want: time.Date(2020, 7, 1, 10, 30, 0, 0, time.UTC)},
+		{name: "a time ends the range at the time", value: "2020-07-31T10:30:00Z", end: true, want: time.Date(2020, 7, 31, 10, 30, 0, 0, time.UTC)},
+		{name: "neither", value: "31/07/2020", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExportTime(tt.value, tt.end)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("returned %v, want an error %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Fatalf("parsed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIExportSessions(t *testing.T) {
+	p := exportPrimary(t, Tariff{PricePerKWH: 0.30, Currency: "AUD"}, exportSessionsFixture)
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		p.APIExportSessions(w, httptest.NewRequest(http.MethodGet, "/api/v1/sessions/export?"+query, nil))
+		return w
+	}
+
+	// the dates include the whole of the last day
+	w := get("from=2020-07-01&to=2020-07-31&format=csv")
+	if w.Code != http.StatusOK {
+		t.Fatalf("returned %d: %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="sessions-20200701-20200801.csv"` {
+		t.Fatalf("the download is %s", got)
+	}
+	r := csv.NewReader(strings.NewReader(w.Body.String()))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the header, the three sessions, then the header of the totals and one for each vehicle, the blank line
+	// between them is skipped
+	if len(records) != 7 {
+		t.Fatalf("read %d records, want 7: %q", len(records), records)
+	}
+	if got := records[1]; got[0] != "2" || got[2] != exportVIN1 || got[6] != "10" || got[7] != "1.92" || got[8] != "AUD" {
+		t.Fatalf("the first session is %q", got)
+	}
+	if got := records[5]; got[0] != exportVIN1 || got[2] != "2" || got[3] != "13" || got[4] != "2.82" {
+		t.Fatalf("the first total is %q", got)
+	}
+
+	w = get("from=2020-07-01&to=2020-07-31&vin=" + exportVIN2)
+	if w.Code != http.StatusOK {
+		t.Fatalf("returned %d: %s", w.Code, w.Body)
+	}
+	export := Export{}
+	if err := json.Unmarshal(w.Body.Bytes(), &export); err != nil {
+		t.Fatal(err)
+	}
+	if len(export.Sessions) != 1 || export.Sessions[0].VIN != exportVIN2 || len(export.Totals) != 1 {
+		t.Fatalf("exported %+v, want the one session of %s", export, exportVIN2)
+	}
+
+	for _, query := range []string{"from=yesterday", "to=31/07/2020", "format=xml"} {
+		if w := get(query); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s returned %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package controller
 
 import (
-	"bytes"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
@@ -13,40 +12,32 @@ import (
 	"text/template"
 	"time"
 
-	"github.com/tarm/serial"
+	"github.com/shreddedbacon/twcmanager/internal/protocol"
+	"github.com/shreddedbacon/twcmanager/internal/transport"
 )
 
-// SendMessage sends a message to the serial port
-func SendMessage(debugLevel int, port *serial.Port, msg []byte) (int64, error) {
-	// calculate checksum of message
-	checksum := 0
-	for b := 1; b < len(msg)-1; b++ {
-		checksum = checksum + int(msg[b])
-	}
-	// add the checksum
-	msg = append(msg, byte(checksum&0xFF))
+// how long to wait for a byte to arrive on the bus, or for a write to complete
+const (
+	readTimeout  = 750 * time.Millisecond
+	writeTimeout = 2 * time.Second
+)
 
-	// escape special chars
-	msg = bytes.Replace(bytes.Replace(msg, []byte{0xDB}, []byte{0xDB, 0xDD}, 1), []byte{0xC0}, []byte{0xDB, 0xDC}, 1)
+// SendMessage sends a message to the serial port, only the bus scheduler should call this
+func SendMessage(debugLevel int, port transport.Transport, msg []byte) (int64, error) {
+	// add the checksum, escape any special bytes and wrap the message with c0
+	frame := protocol.EncodeFrame(msg)
 
-	var debugBytes []byte
-	for _, debugB := range msg {
-		debubByte := []byte(fmt.Sprintf("%X ", debugB))
-		debugBytes = append(debugBytes, debubByte...)
-	}
 	if debugLevel >= 1 {
 		log.Println(log2JSONString(LogData{
 			Type:    "DEBUG",
 			Source:  "primary",
-			Message: fmt.Sprintf("Tx@: %s", debugBytes),
+			Message: fmt.Sprintf("Tx@: % X", frame[1:len(frame)-1]),
 		}))
 	}
 
-	// wrap the message with c0
-	msg = append([]byte{0xC0}, append(msg, 0xC0)...)
-
 	// actually send the message to the serial port
-	_, err := port.Write(msg)
+	_ = port.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := port.Write(frame)
 	if err != nil {
 		return time.Now().Unix(), err
 	}
@@ -80,12 +71,6 @@ func Bytes2Dec2(mySlice []byte, little bool) uint16 {
 	return data
 }
 
-func unescapeMessage(msg []byte, msgLen int) []byte {
-	unescapedMsg := msg[0:msgLen]
-	unescapedMsg = bytes.Replace(msg, []byte{0xDB, 0xDC}, []byte{0xC0}, 1)
-	return unescapedMsg[1 : len(unescapedMsg)-1]
-}
-
 func wattsToAmps(phases int, voltage int, watts float64) int {
 	intAmps := int(math.RoundToEven(watts)) / voltage
 	if phases == 3 {
@@ -149,11 +134,25 @@ var funcMap = template.FuncMap{
 	"BytesToUint16Divide": bytesToUint16Divide,
 	"IsFloatNegative":     isFloatNegative,
 	"RoundFloat":          roundFloat,
+	"FormatTime":          formatTime,
+	"Percent":             percent,
 	"Divide": func(a, b int) int {
 		return a / b
 	},
 }
 
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(time.RFC822)
+}
+
+// percent turns a fraction from 0 to 1 into a whole percentage
+func percent(f float64) float64 {
+	return math.RoundToEven(f * 100)
+}
+
 func getTime(t int64) string {
 	unixTimeUTC := time.Unix(t, 0)
 	unitTimeInRFC := unixTimeUTC.Format(time.RFC822)
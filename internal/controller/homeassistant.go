@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// haDevice is the device that the home assistant entities of a secondary belong to
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model,omitempty"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+	SerialNumber string   `json:"serial_number,omitempty"`
+}
+
+// haEntity is a single home assistant entity of a secondary, the config is merged with the settings common to every
+// entity before it is published
+type haEntity struct {
+	component string
+	object    string
+	config    map[string]interface{}
+}
+
+// haEntities returns the entities home assistant should create for a secondary
+func haEntities(cfg MQTTConfig, id string, wiringMaxAmps int) []haEntity {
+	sensor := func(object, name, value, unit, deviceClass, stateClass string) haEntity {
+		config := map[string]interface{}{
+			"name":           name,
+			"value_template": fmt.Sprintf("{{ value_json.%s }}", value),
+		}
+		if unit != "" {
+			config["unit_of_measurement"] = unit
+		}
+		if deviceClass != "" {
+			config["device_class"] = deviceClass
+		}
+		if stateClass != "" {
+			config["state_class"] = stateClass
+		}
+		return haEntity{component: "sensor", object: object, config: config}
+	}
+	entities := []haEntity{
+		sensor("power", "Power", "watts", "W", "power", "measurement"),
+		sensor("energy", "Energy", "lifetimeKWH", "kWh", "energy", "total_increasing"),
+	}
+	for phase := 1; phase <= 3; phase++ {
+		entities = append(entities,
+			sensor(fmt.Sprintf("phase%d_voltage", phase), fmt.Sprintf("Phase %d Voltage", phase),
+				fmt.Sprintf("phase%dVolts", phase), "V", "voltage", "measurement"),
+			sensor(fmt.Sprintf("phase%d_current", phase), fmt.Sprintf("Phase %d Current", phase),
+				fmt.Sprintf("phase%dAmps", phase), "A", "current", "measurement"),
+		)
+	}
+	plugState := sensor("plug_state", "Plug State", "plugState", "", "", "")
+	plugState.config["value_template"] = "{{ {0: 'Unplugged', 1: 'Charging', 3: 'Plugged in'}.get(value_json.plugState, value_json.plugState) }}"
+	plugState.config["icon"] = "mdi:ev-plug-type2"
+	chargeState := sensor("charge_state", "Charge State", "stateName", "", "", "")
+	chargeState.config["icon"] = "mdi:ev-station"
+	vin := sensor("vin", "VIN", "vin", "", "", "")
+	vin.config["icon"] = "mdi:car"
+	entities = append(entities, plugState, chargeState, vin,
+		haEntity{component: "number", object: "max_amps", config: map[string]interface{}{
+			"name":                "Max Amps",
+			"value_template":      "{{ value_json.maxAmps }}",
+			"command_topic":       cfg.topic("secondary", id, "command", "maxamps"),
+			"min":                 0,
+			"max":                 wiringMaxAmps,
+			"step":                1,
+			"mode":                "box",
+			"unit_of_measurement": "A",
+			"icon":                "mdi:current-ac",
+		}},
+		haEntity{component: "switch", object: "allow_charge", config: map[string]interface{}{
+			"name":           "Allow Charge",
+			"value_template": "{{ 'on' if value_json.allowCharge else 'off' }}",
+			"command_topic":  cfg.topic("secondary", id, "command", "enabled"),
+			"payload_on":     "on",
+			"payload_off":    "off",
+			"state_on":       "on",
+			"state_off":      "off",
+			"icon":           "mdi:ev-station",
+		}},
+		haEntity{component: "switch", object: "solar_mode", config: map[string]interface{}{
+			"name":           "Solar Mode",
+			"value_template": fmt.Sprintf("{{ 'on' if value_json.chargeMode == '%s' else 'off' }}", ChargeModeSolar),
+			"command_topic":  cfg.topic("secondary", id, "command", "solar"),
+			"payload_on":     "on",
+			"payload_off":    "off",
+			"state_on":       "on",
+			"state_off":      "off",
+			"icon":           "mdi:solar-power",
+		}},
+	)
+	return entities
+}
+
+// discoveryTopic returns the topic the discovery config of an entity is published to
+func (c MQTTConfig) discoveryTopic(id string, entity haEntity) string {
+	prefix := c.DiscoveryPrefix
+	if prefix == "" {
+		prefix = "homeassistant"
+	}
+	return strings.Join([]string{prefix, entity.component, "twc_" + id, entity.object, "config"}, "/")
+}
+
+// publishDiscovery publishes the home assistant discovery config for the linked secondaries that haven't had it
+// yet, or whose firmware, model, serial number or wiring limit has changed since
+func (p *TWCPrimary) publishDiscovery(twcs []TWCSecondary) {
+	type pending struct {
+		id     string
+		device haDevice
+	}
+	p.mu.Lock()
+	cfg := p.MQTT
+	wiringMaxAmps := p.WiringMaxAmpsPerTWC
+	var publish []pending
+	if cfg.HomeAssistant {
+		if p.discovered == nil {
+			p.discovered = map[string]string{}
+		}
+		for _, twc := range twcs {
+			if !twc.linked {
+				continue
+			}
+			id := fmt.Sprintf("%x", twc.TWCID)
+			device := haDevice{
+				Identifiers:  []string{"twc_" + id},
+				Name:         "Wall Connector " + id,
+				Manufacturer: "Tesla",
+				Model:        twc.Model,
+				SWVersion:    twc.FirmwareVersion,
+				SerialNumber: twc.SerialNumber,
+			}
+			b, _ := json.Marshal(device)
+			published := fmt.Sprintf("%s %d", b, wiringMaxAmps)
+			if p.discovered[id] == published {
+				continue
+			}
+			p.discovered[id] = published
+			publish = append(publish, pending{id: id, device: device})
+		}
+	}
+	p.mu.Unlock()
+	for _, twc := range publish {
+		for _, entity := range haEntities(cfg, twc.id, wiringMaxAmps) {
+			config := entity.config
+			config["unique_id"] = fmt.Sprintf("twc_%s_%s", twc.id, entity.object)
+			config["object_id"] = fmt.Sprintf("twc_%s_%s", twc.id, entity.object)
+			config["state_topic"] = cfg.topic("secondary", twc.id, "state")
+			config["availability_topic"] = cfg.topic("status")
+			config["device"] = twc.device
+			b, _ := json.Marshal(config)
+			p.mqttPublish(cfg.discoveryTopic(twc.id, entity), b)
+		}
+	}
+}
+
+// removeDiscovery clears the home assistant discovery config of a secondary so that its device is removed
+func (p *TWCPrimary) removeDiscovery(twcID []byte) {
+	id := fmt.Sprintf("%x", twcID)
+	p.mu.Lock()
+	cfg := p.MQTT
+	wiringMaxAmps := p.WiringMaxAmpsPerTWC
+	delete(p.discovered, id)
+	p.mu.Unlock()
+	if !cfg.HomeAssistant {
+		return
+	}
+	for _, entity := range haEntities(cfg, id, wiringMaxAmps) {
+		p.mqttPublish(cfg.discoveryTopic(id, entity), nil)
+	}
+}
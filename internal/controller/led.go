@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"context"
 	"time"
 )
 
@@ -46,16 +47,29 @@ func (ls *ledStrip) display(ledValues map[int]uint32) error {
 	return nil
 }
 
-// LEDLoop is the always running loop that controls the LEDs
-func (p *TWCPrimary) LEDLoop() {
+// LEDLoop is the loop that controls the LEDs, it runs until the context is cancelled
+func (p *TWCPrimary) LEDLoop(ctx context.Context) {
 	for {
-		time.Sleep(10 * time.Millisecond)
-		if p.LEDSOn == true {
-			if p.LEDCharging {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+		// copy the state so the lock isn't held while the strip renders
+		p.mu.RLock()
+		ledsOn := p.LEDSOn
+		ledCharging := p.LEDCharging
+		ledValues := map[int]uint32{}
+		for k, v := range p.LEDValues {
+			ledValues[k] = v
+		}
+		p.mu.RUnlock()
+		if ledsOn {
+			if ledCharging {
 				p.LEDController.wipe(uint32(0x00ff00))
 				p.LEDController.wipe(uint32(0x000000))
 			} else {
-				p.LEDController.display(p.LEDValues)
+				p.LEDController.display(ledValues)
 			}
 		} else {
 			p.LEDController.wipe(uint32(0x000000))
@@ -63,24 +77,30 @@ func (p *TWCPrimary) LEDLoop() {
 	}
 }
 
-// SetPlugStateLED Set the color of the plugstate led
+// SetPlugStateLED Set the color of the plugstate led, the lock must not be held
 func (p *TWCPrimary) SetPlugStateLED(color uint32) {
-	p.LEDValues[2] = color
+	p.setLED(2, color)
 }
 
-// SetVINLED Set the color of the VIN led
+// SetVINLED Set the color of the VIN led, the lock must not be held
 func (p *TWCPrimary) SetVINLED(color uint32) {
-	p.LEDValues[4] = color
+	p.setLED(4, color)
 }
 
-// SetTWCStatusLED Set the color of the TWC status led
+// SetTWCStatusLED Set the color of the TWC status led, the lock must not be held
 func (p *TWCPrimary) SetTWCStatusLED(color uint32) {
-	p.LEDValues[7] = color
+	p.setLED(7, color)
 }
 
-// SetLEDsOff turns all the LEDS off
+// SetLEDsOff turns all the LEDS off, the lock must not be held
 func (p *TWCPrimary) SetLEDsOff() {
 	for i := 0; i < 8; i++ {
-		p.LEDValues[i] = 0x000000
+		p.setLED(i, 0x000000)
 	}
 }
+
+func (p *TWCPrimary) setLED(i int, color uint32) {
+	p.mu.Lock()
+	p.LEDValues[i] = color
+	p.mu.Unlock()
+}
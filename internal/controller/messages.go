@@ -2,330 +2,416 @@ package controller
 
 import (
 	"bytes"
-	"encoding/hex"
 	"fmt"
 	"log"
-	"regexp"
 	"time"
+
+	"github.com/shreddedbacon/twcmanager/internal/history"
+	"github.com/shreddedbacon/twcmanager/internal/protocol"
 )
 
-// check if the message contains if the secondary TWC is ready to link
-func (p *TWCPrimary) isSecondaryReadyToLink(msg []byte, foundMsgMatch *bool) {
-	msgMatch, _ := regexp.MatchString(`^fde2(....)(..)(....)000000000000.+.+$`, fmt.Sprintf("%x", msg))
-	matches := regexp.MustCompile(`^fde2(....)(..)(....)000000000000.+.+$`).FindAllStringSubmatch(fmt.Sprintf("%x", msg), -1)
-	if msgMatch && *foundMsgMatch == false {
-		*foundMsgMatch = true
-		secondaryID, _ := hex.DecodeString(matches[0][1])
-		sign, _ := hex.DecodeString(matches[0][2])
-		data, _ := hex.DecodeString(matches[0][3])
-		maxAmps := []byte{data[0], data[1]}
-		if p.DebugLevel >= 9 {
+// handleFrame dispatches a received message to the receiver for its command, the receivers take the lock
+// themselves and release it before sending anything
+func (p *TWCPrimary) handleFrame(f protocol.Frame) {
+	switch f.Command {
+	case protocol.SecondaryLinkReady:
+		p.isSecondaryReadyToLink(f)
+	case protocol.SecondaryHeartbeat:
+		p.receiveSecondaryHeartbeatData(f)
+	case protocol.KWH:
+		p.receivePeriodicPollData(f)
+	case protocol.VINStart, protocol.VINMiddle, protocol.VINEnd:
+		p.receiveVIN(f)
+	case protocol.PlugState:
+		p.receivePlugState(f)
+	case protocol.FirmwareVersion:
+		p.receiveFirmwareVersion(f)
+	case protocol.SerialNumber, protocol.Model:
+		p.receiveSerialNumberOrModel(f)
+	case protocol.PrimaryLinkReady1, protocol.PrimaryLinkReadyAlt:
+		p.isPrimaryTWC(f)
+	default:
+		if p.debugLevel() >= 12 {
 			log.Println(log2JSONString(LogData{
 				Type:     "INFO",
 				Source:   "messaging",
-				Sender:   fmt.Sprintf("%x", secondaryID),
+				Sender:   fmt.Sprintf("%x", f.Sender),
 				Receiver: fmt.Sprintf("%x", p.ID),
-				Message:  fmt.Sprintf("Secondary TWC is ready to link, signed %x", sign),
+				Message:  fmt.Sprintf("Ignoring message with command %s", f.Command),
 			}))
 		}
-		spikeAmpsToCancel6ALimitB := []byte{}
-		if Bytes2Dec2(maxAmps, false) >= 8000 {
-			spikeAmpsToCancel6ALimitB = Dec2Bytes(2100)
-		} else {
-			spikeAmpsToCancel6ALimitB = Dec2Bytes(1600)
-		}
-		if p.DebugLevel >= 15 {
-			// @TODO: holder for future usage?
-			fmt.Println(spikeAmpsToCancel6ALimitB)
-		}
-		if bytes.Compare(secondaryID, p.ID) == 0 {
-			// secondary should resolve by changing twcid
-			p.numInitMsgsToSend = 10
-			// continue // @TODO: return instead?
-		}
-		secondaryTWC, ok := p.GetSecondary(secondaryID)
-		if !ok {
-			p.AddSecondary(secondaryTWC, secondaryID)
-		}
-		if secondaryTWC.ProtocolVersion == 1 && secondaryTWC.MinAmpsTWCSupports == 6 {
-			if len(msg) == 14 {
-				secondaryTWC.ProtocolVersion = 1
-				secondaryTWC.MinAmpsTWCSupports = 5
-			} else if len(msg) == 16 {
-				secondaryTWC.ProtocolVersion = 2
-				secondaryTWC.MinAmpsTWCSupports = 6
-			}
-			if p.DebugLevel >= 9 {
-				log.Println(log2JSONString(LogData{
-					Type:     "INFO",
-					Source:   "messaging",
-					Sender:   fmt.Sprintf("%x", secondaryID),
-					Receiver: fmt.Sprintf("%x", p.ID),
-					Message:  fmt.Sprintf("Secondary TWC protocolVersion to %d, minAmpsTWCSupports to %d", secondaryTWC.ProtocolVersion, secondaryTWC.MinAmpsTWCSupports),
-				}))
-			}
-		}
-		if secondaryTWC.wiringMaxAmps > int(Bytes2Dec2(maxAmps, false)/100) {
-			log.Println(log2JSONString(LogData{
-				Type:     "DANGER",
-				Source:   "messaging",
-				Sender:   fmt.Sprintf("%x", secondaryID),
-				Receiver: fmt.Sprintf("%x", p.ID),
-				Message:  fmt.Sprintf("DANGER!!: wiringMaxAmpsPerTWC is %d which is greater than the max %d amps your charger says it can handle.", secondaryTWC.wiringMaxAmps, maxAmps),
-			}))
-			secondaryTWC.wiringMaxAmps = int(Bytes2Dec2(maxAmps, false)/100) / 4
-		}
-		secondaryTWC.TimeLastRx = time.Now().UTC().Unix()
-		if !secondaryTWC.AllowCharge {
-			// If the TWC has been told to stop charging, set the reported state to something that the TWC would probably
-			// never send, so we know.
-			if p.DebugLevel >= 9 {
-				log.Println(log2JSONString(LogData{
-					Type:     "INFO",
-					Source:   "messaging",
-					Sender:   fmt.Sprintf("%x", secondaryID),
-					Receiver: fmt.Sprintf("%x", p.ID),
-					Message:  "Secondary TWC has been disabled, setting state and charge rates to 0",
-				}))
-			}
-			secondaryTWC.ReportedState = 99
-			secondaryTWC.ReportedAmpsActual = []byte{0x00, 0x00}
-			secondaryTWC.ReportedAmpsMax = []byte{0x00, 0x00}
-		}
-		p.timeLastTx, _ = secondaryTWC.sendPrimaryHeartbeat(p.port, p.ID)
 	}
 }
 
-func (p *TWCPrimary) receiveSecondaryHeartbeatData(msg []byte, foundMsgMatch *bool) {
-	msgMatch, _ := regexp.MatchString(`\Afde0(....)(....)(.............+?.+?)..$`, fmt.Sprintf("%x", msg))
-	matches := regexp.MustCompile(`\Afde0(....)(....)(.............+?.+?)..$`).FindAllStringSubmatch(fmt.Sprintf("%x", msg), -1)
-	if msgMatch && *foundMsgMatch == false {
-		*foundMsgMatch = true
-		secondaryID, _ := hex.DecodeString(matches[0][1])
-		primaryID, _ := hex.DecodeString(matches[0][2])
-		heartbeatData, _ := hex.DecodeString(matches[0][3])
-
-		secondaryTWC, ok := p.GetSecondary(secondaryID)
-		if ok {
-			if bytes.Compare(primaryID, p.ID) == 0 {
-				secondaryTWC.ReceiveSecondaryHeartbeat(heartbeatData)
-				reportedAmpsActual := []byte{heartbeatData[3], heartbeatData[4]}
-				if bytes.Compare(reportedAmpsActual, []byte{}) != 0 {
-					if Bytes2Dec2(reportedAmpsActual, false) > 0 {
-						secondaryTWC.ChargeState = true // set the TWC to be in the charging state
-					} else {
-						secondaryTWC.ChargeState = false // set the TWC to be in the not charging state
-					}
-				}
-			} else {
-				if p.DebugLevel >= 9 {
-					log.Println(log2JSONString(LogData{
-						Type:     "INFO",
-						Source:   "messaging",
-						Sender:   fmt.Sprintf("%x", secondaryID),
-						Receiver: fmt.Sprintf("%x", p.ID),
-						Message:  "Received heartbeat message from secondary TWC that we've not met before",
-					}))
-				}
-			}
-		}
+func (p *TWCPrimary) logMessageError(f protocol.Frame, err error) {
+	if p.debugLevel() >= 2 {
+		log.Println(log2JSONString(LogData{
+			Type:     "DEBUG",
+			Source:   "messaging",
+			Sender:   fmt.Sprintf("%x", f.Sender),
+			Receiver: fmt.Sprintf("%x", p.ID),
+			Message:  fmt.Sprintf("Unable to parse message: %v", err),
+		}))
 	}
 }
 
-func (p *TWCPrimary) receiveVinStart(msg []byte, foundMsgMatch *bool) {
-	msgMatch, _ := regexp.MatchString(`\Afdee(....)(..............)(.+?.+?)$`, fmt.Sprintf("%x", msg))
-	matches := regexp.MustCompile(`\Afdee(....)(..............)(.+?.+?)$`).FindAllStringSubmatch(fmt.Sprintf("%x", msg), -1)
-	if msgMatch && *foundMsgMatch == false {
-		*foundMsgMatch = true
-		secondaryID, _ := hex.DecodeString(matches[0][1])
-		vinStart, _ := hex.DecodeString(matches[0][2])
-		if p.DebugLevel >= 9 {
+// check if the message contains if the secondary TWC is ready to link
+func (p *TWCPrimary) isSecondaryReadyToLink(f protocol.Frame) {
+	linkReady, err := protocol.ParseLinkReady(f)
+	if err != nil {
+		p.logMessageError(f, err)
+		return
+	}
+	secondaryID := f.Sender
+	maxAmps := int(linkReady.MaxAmps / 100)
+	if p.debugLevel() >= 9 {
+		log.Println(log2JSONString(LogData{
+			Type:     "INFO",
+			Source:   "messaging",
+			Sender:   fmt.Sprintf("%x", secondaryID),
+			Receiver: fmt.Sprintf("%x", p.ID),
+			Message:  fmt.Sprintf("Secondary TWC is ready to link, signed %x", linkReady.Sign),
+		}))
+	}
+	spikeAmpsToCancel6ALimitB := []byte{}
+	if linkReady.MaxAmps >= 8000 {
+		spikeAmpsToCancel6ALimitB = Dec2Bytes(2100)
+	} else {
+		spikeAmpsToCancel6ALimitB = Dec2Bytes(1600)
+	}
+	if p.debugLevel() >= 15 {
+		// @TODO: holder for future usage?
+		fmt.Println(spikeAmpsToCancel6ALimitB)
+	}
+	p.mu.Lock()
+	if bytes.Compare(secondaryID, p.ID) == 0 {
+		// secondary should resolve by changing twcid
+		p.numInitMsgsToSend = 10
+		// continue // @TODO: return instead?
+	}
+	secondaryTWC, ok := p.GetSecondary(secondaryID)
+	if !ok {
+		p.AddSecondary(secondaryTWC, secondaryID)
+		secondaryTWC, _ = p.GetSecondary(secondaryID)
+	}
+	if secondaryTWC.ProtocolVersion == 1 && secondaryTWC.MinAmpsTWCSupports == 6 {
+		if f.ProtocolVersion == 1 {
+			secondaryTWC.ProtocolVersion = 1
+			secondaryTWC.MinAmpsTWCSupports = 5
+		} else {
+			secondaryTWC.ProtocolVersion = 2
+			secondaryTWC.MinAmpsTWCSupports = 6
+		}
+		if p.debugLevel() >= 9 {
 			log.Println(log2JSONString(LogData{
 				Type:     "INFO",
 				Source:   "messaging",
 				Sender:   fmt.Sprintf("%x", secondaryID),
 				Receiver: fmt.Sprintf("%x", p.ID),
-				Message:  fmt.Sprintf("Received from VIN Start %x from secondary TWC", vinStart),
+				Message:  fmt.Sprintf("Secondary TWC protocolVersion to %d, minAmpsTWCSupports to %d", secondaryTWC.ProtocolVersion, secondaryTWC.MinAmpsTWCSupports),
 			}))
 		}
-		secondaryTWC, ok := p.GetSecondary(secondaryID)
-		if ok {
-			if bytes.Compare(vinStart, []byte{0, 0, 0, 0, 0, 0, 0}) == 0 {
-				secondaryTWC.VINStart = ""
-			} else {
-				secondaryTWC.VINStart = fmt.Sprintf("%s", vinStart)
-			}
-		}
 	}
-}
-
-func (p *TWCPrimary) receiveVinMiddle(msg []byte, foundMsgMatch *bool) {
-	msgMatch, _ := regexp.MatchString(`\Afdef(....)(..............)(.+?.+?)$`, fmt.Sprintf("%x", msg))
-	matches := regexp.MustCompile(`\Afdef(....)(..............)(.+?.+?)$`).FindAllStringSubmatch(fmt.Sprintf("%x", msg), -1)
-	if msgMatch && *foundMsgMatch == false {
-		*foundMsgMatch = true
-		secondaryID, _ := hex.DecodeString(matches[0][1])
-		vinMiddle, _ := hex.DecodeString(matches[0][2])
-		if p.DebugLevel >= 9 {
+	if secondaryTWC.wiringMaxAmps > maxAmps {
+		log.Println(log2JSONString(LogData{
+			Type:     "DANGER",
+			Source:   "messaging",
+			Sender:   fmt.Sprintf("%x", secondaryID),
+			Receiver: fmt.Sprintf("%x", p.ID),
+			Message:  fmt.Sprintf("DANGER!!: wiringMaxAmpsPerTWC is %d which is greater than the max %d amps your charger says it can handle.", secondaryTWC.wiringMaxAmps, maxAmps),
+		}))
+		secondaryTWC.wiringMaxAmps = maxAmps / 4
+	}
+	secondaryTWC.TimeLastRx = time.Now().UTC().Unix()
+	if !secondaryTWC.AllowCharge {
+		// If the TWC has been told to stop charging, set the reported state to something that the TWC would probably
+		// never send, so we know.
+		if p.debugLevel() >= 9 {
 			log.Println(log2JSONString(LogData{
 				Type:     "INFO",
 				Source:   "messaging",
 				Sender:   fmt.Sprintf("%x", secondaryID),
 				Receiver: fmt.Sprintf("%x", p.ID),
-				Message:  fmt.Sprintf("Received from VIN Middle %x from secondary TWC", vinMiddle),
+				Message:  "Secondary TWC has been disabled, setting state and charge rates to 0",
 			}))
 		}
-		secondaryTWC, ok := p.GetSecondary(secondaryID)
-		if ok {
-			if bytes.Compare(vinMiddle, []byte{0, 0, 0, 0, 0, 0, 0}) == 0 {
-				secondaryTWC.VINMiddle = ""
-			} else {
-				secondaryTWC.VINMiddle = fmt.Sprintf("%s", vinMiddle)
-			}
-		}
+		secondaryTWC.ReportedState = 99
+		secondaryTWC.ReportedAmpsActual = []byte{0x00, 0x00}
+		secondaryTWC.ReportedAmpsMax = []byte{0x00, 0x00}
+	}
+	heartbeat := secondaryTWC.primaryHeartbeat(p.ID)
+	p.mu.Unlock()
+	if heartbeat != nil {
+		p.bus.post(heartbeat, priorityHeartbeat, protocol.SecondaryHeartbeat, secondaryID)
+		p.heartbeatSent(time.Now().UTC().Unix())
 	}
 }
 
-func (p *TWCPrimary) receiveVinEnd(msg []byte, foundMsgMatch *bool) {
-	msgMatch, _ := regexp.MatchString(`\Afdf1(....)(......)(.+?.+?)$`, fmt.Sprintf("%x", msg))
-	matches := regexp.MustCompile(`\Afdf1(....)(......)(.+?.+?)$`).FindAllStringSubmatch(fmt.Sprintf("%x", msg), -1)
-	if msgMatch && *foundMsgMatch == false {
-		*foundMsgMatch = true
-		secondaryID, _ := hex.DecodeString(matches[0][1])
-		vinEnd, _ := hex.DecodeString(matches[0][2])
-		if p.DebugLevel >= 9 {
+func (p *TWCPrimary) receiveSecondaryHeartbeatData(f protocol.Frame) {
+	heartbeatData, err := protocol.ParseSecondaryHeartbeat(f)
+	if err != nil {
+		p.logMessageError(f, err)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	secondaryTWC, ok := p.GetSecondary(f.Sender)
+	if !ok {
+		return
+	}
+	if bytes.Compare(f.Receiver, p.ID) != 0 {
+		if p.debugLevel() >= 9 {
 			log.Println(log2JSONString(LogData{
 				Type:     "INFO",
 				Source:   "messaging",
-				Sender:   fmt.Sprintf("%x", secondaryID),
+				Sender:   fmt.Sprintf("%x", f.Sender),
 				Receiver: fmt.Sprintf("%x", p.ID),
-				Message:  fmt.Sprintf("Received from VIN End %x from secondary TWC", vinEnd),
+				Message:  "Received heartbeat message from secondary TWC that we've not met before",
 			}))
 		}
-		secondaryTWC, ok := p.GetSecondary(secondaryID)
-		if ok {
-			//@TODO: if vin is 0, empty string it
-			if bytes.Compare(vinEnd, []byte{0, 0, 0}) == 0 {
-				secondaryTWC.VINEnd = ""
-			} else {
-				secondaryTWC.VINEnd = fmt.Sprintf("%s", vinEnd)
-			}
+		return
+	}
+	secondaryTWC.ReceiveSecondaryHeartbeat(heartbeatData)
+	secondaryTWC.taper.Update(secondaryTWC.offeredAmps(), float64(heartbeatData.AmpsActual)/100)
+	p.sampleSession(secondaryTWC)
+	if heartbeatData.AmpsActual > 0 {
+		secondaryTWC.ChargeState = true // set the TWC to be in the charging state
+	} else {
+		secondaryTWC.ChargeState = false // set the TWC to be in the not charging state
+	}
+}
+
+// receiveVIN handles the VIN start, middle and end messages
+func (p *TWCPrimary) receiveVIN(f protocol.Frame) {
+	vinPart, err := protocol.ParseVINPart(f)
+	if err != nil {
+		p.logMessageError(f, err)
+		return
+	}
+	partName := map[protocol.Command]string{
+		protocol.VINStart:  "Start",
+		protocol.VINMiddle: "Middle",
+		protocol.VINEnd:    "End",
+	}[f.Command]
+	if p.debugLevel() >= 9 {
+		log.Println(log2JSONString(LogData{
+			Type:     "INFO",
+			Source:   "messaging",
+			Sender:   fmt.Sprintf("%x", f.Sender),
+			Receiver: fmt.Sprintf("%x", p.ID),
+			Message:  fmt.Sprintf("Received from VIN %s %x from secondary TWC", partName, vinPart),
+		}))
+	}
+	p.mu.Lock()
+	secondaryTWC, ok := p.GetSecondary(f.Sender)
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+	switch f.Command {
+	case protocol.VINStart:
+		secondaryTWC.VINStart = vinPart
+	case protocol.VINMiddle:
+		secondaryTWC.VINMiddle = vinPart
+	case protocol.VINEnd:
+		secondaryTWC.VINEnd = vinPart
+	}
+	changed := p.applyVehicleProfile(secondaryTWC)
+	if secondaryTWC.session != nil && secondaryTWC.profileVIN != "" {
+		secondaryTWC.session.VIN = secondaryTWC.profileVIN
+		secondaryTWC.session.VehicleName = secondaryTWC.VehicleName
+	}
+	p.mu.Unlock()
+	if changed {
+		// the vehicle may have its own max amps and priority, so the amps need sharing out again
+		_ = p.balance()
+	}
+}
+
+func (p *TWCPrimary) receivePlugState(f protocol.Frame) {
+	plugState, err := protocol.ParsePlugState(f)
+	if err != nil {
+		p.logMessageError(f, err)
+		return
+	}
+	if p.debugLevel() >= 9 {
+		log.Println(log2JSONString(LogData{
+			Type:     "INFO",
+			Source:   "messaging",
+			Sender:   fmt.Sprintf("%x", f.Sender),
+			Receiver: fmt.Sprintf("%x", p.ID),
+			Message:  fmt.Sprintf("Received from Plug state %x from secondary TWC", plugState),
+		}))
+	}
+	// set some LED values
+	switch int(plugState) {
+	case 0:
+		p.SetPlugStateLED(0x000000) // set the LED to off to indicate that nothing is plugged in
+	case 1:
+		p.SetPlugStateLED(0x00ff00) // set the LED to green to indicate that a car is plugged in and charging
+	case 3:
+		p.SetPlugStateLED(0x0000ff) // set the LED to blue to indicate that a car is plugged in but not charging
+	}
+	p.mu.Lock()
+	secondaryTWC, ok := p.GetSecondary(f.Sender)
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+	prevPlugState := secondaryTWC.PlugState
+	secondaryTWC.PlugState = int(plugState)
+	if secondaryTWC.PlugState == 0 {
+		secondaryTWC.clearVehicle()
+		closed := p.closeSession(secondaryTWC)
+		p.mu.Unlock()
+		p.saveSessions(closed)
+		return
+	}
+	var opened *history.Session
+	if prevPlugState == 0 {
+		opened = p.openSession(secondaryTWC)
+	}
+	splitAmps, _ := p.allocatedAmps(f.Sender)
+	if prevPlugState == 0 {
+		// when a car is plugged in, charge at the minimum 6 amps (600 Watts) for a little bit while the system identifies the VIN and current powerwall state
+		// the powerwall monitoring will override this value if it needs to based on solar generation
+		// or it will stop charging entirely
+		splitAmps = p.MinAmpsPerTWC
+	} else if splitAmps > 0 {
+		splitAmps = p.twcChargeRate(secondaryTWC, splitAmps)
+		if secondaryTWC.vehicleAmps.active && Bytes2Dec2(secondaryTWC.AvailableAmps, true) == uint16(splitAmps*100) {
+			// the vehicle is setting the amps, so the TWC is left alone
+			p.mu.Unlock()
+			return
 		}
 	}
+	p.mu.Unlock()
+	p.saveOpenedSession(f.Sender, opened)
+	if splitAmps == 0 {
+		// paused, or not drawing anything yet
+		return
+	}
+	_, _ = p.sendChargeRate(f.Sender, Dec2Bytes(uint16(splitAmps*100)), byte(0x09))
 }
 
-func (p *TWCPrimary) receivePlugState(msg []byte, foundMsgMatch *bool) {
-	msgMatch, _ := regexp.MatchString(`\Afdb4(....)(..)(.+?.+?)$`, fmt.Sprintf("%x", msg))
-	matches := regexp.MustCompile(`\Afdb4(....)(..)(.+?.+?).$`).FindAllStringSubmatch(fmt.Sprintf("%x", msg), -1)
-	if msgMatch && *foundMsgMatch == false {
-		*foundMsgMatch = true
-		secondaryID, _ := hex.DecodeString(matches[0][1])
-		plugState, _ := hex.DecodeString(matches[0][2])
-		if p.DebugLevel >= 9 {
-			log.Println(log2JSONString(LogData{
-				Type:     "INFO",
-				Source:   "messaging",
-				Sender:   fmt.Sprintf("%x", secondaryID),
-				Receiver: fmt.Sprintf("%x", p.ID),
-				Message:  fmt.Sprintf("Received from Plug state %x from secondary TWC", plugState),
-			}))
+func (p *TWCPrimary) receivePeriodicPollData(f protocol.Frame) {
+	kwh, err := protocol.ParseKWH(f)
+	if err != nil {
+		p.logMessageError(f, err)
+		return
+	}
+	if p.debugLevel() >= 12 {
+		log.Println(log2JSONString(LogData{
+			Type:     "INFO",
+			Source:   "messaging",
+			Sender:   fmt.Sprintf("%x", f.Sender),
+			Receiver: fmt.Sprintf("%x", p.ID),
+			Message: fmt.Sprintf(" Secondary TWC unexpectedly reported kWh and voltage data: %d %d %d %d %d %d %d",
+				kwh.LifetimeKWH,
+				kwh.Volts[0],
+				kwh.Volts[1],
+				kwh.Volts[2],
+				kwh.Amps(0),
+				kwh.Amps(1),
+				kwh.Amps(2),
+			),
+		}))
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	secondaryTWC, ok := p.GetSecondary(f.Sender)
+	if ok {
+		if secondaryTWC.ReportedAmpsActual == nil {
+			secondaryTWC.ReportedAmpsActual = []byte{0x00, 0x00}
+		}
+		if Bytes2Dec2(secondaryTWC.ReportedAmpsActual, false) > 0 {
+			secondaryTWC.ChargeState = true // set the TWC to be in the charging state
+		} else {
+			secondaryTWC.ChargeState = false // set the TWC to be in the not charging state
 		}
-		// set some LED values
-		switch int(plugState[0]) {
-		case 0:
-			p.SetPlugStateLED(0x000000) // set the LED to off to indicate that nothing is plugged in
-		case 1:
-			p.SetPlugStateLED(0x00ff00) // set the LED to green to indicate that a car is plugged in and charging
-		case 3:
-			p.SetPlugStateLED(0x0000ff) // set the LED to blue to indicate that a car is plugged in but not charging
+		currentWatts := uint32(kwh.Volts[0] * (Bytes2Dec2(secondaryTWC.ReportedAmpsActual, false) / 100))
+		if kwh.Volts[1] != 0 && kwh.Volts[2] != 0 {
+			volts := kwh.Volts[0] + kwh.Volts[2] + kwh.Volts[2]
+			currentWatts = uint32(volts * (Bytes2Dec2(secondaryTWC.ReportedAmpsActual, false) / 100))
 		}
-		secondaryTWC, ok := p.GetSecondary(secondaryID)
-		if ok {
-			prevPlugState := secondaryTWC.PlugState
-			secondaryTWC.PlugState = int(plugState[0])
-			if secondaryTWC.PlugState != 0 {
-				splitAmps := p.AvailableAmps / len(p.knownTWCs)
-				if prevPlugState == 0 {
-					// when a car is plugged in, charge at the minimum 6 amps (600 Watts) for a little bit while the system identifies the VIN and current powerwall state
-					// the powerwall monitoring will override this value if it needs to based on solar generation
-					// or it will stop charging entirely
-					splitAmps = p.MinAmpsPerTWC
-				}
-				_, _ = p.sendChargeRate(secondaryTWC.TWCID, Dec2Bytes(uint16(splitAmps*100)), byte(0x09))
-			}
+		// update the stats on the secondary so we can display them :)
+		secondaryTWC.StatsCurrentWatts = currentWatts
+		secondaryTWC.StatsKWH = kwh.LifetimeKWH
+		secondaryTWC.kwhRead = true
+		p.recordEnergy(secondaryTWC, kwh.LifetimeKWH)
+		if secondaryTWC.session != nil {
+			p.meterSession(secondaryTWC.session, kwh.LifetimeKWH, time.Now())
 		}
+		secondaryTWC.StatsP1Volts = kwh.Volts[0]
+		secondaryTWC.StatsP2Volts = kwh.Volts[1]
+		secondaryTWC.StatsP3Volts = kwh.Volts[2]
+		secondaryTWC.StatsP1Amps = kwh.Amps(0)
+		secondaryTWC.StatsP2Amps = kwh.Amps(1)
+		secondaryTWC.StatsP3Amps = kwh.Amps(2)
 	}
 }
 
-func (p *TWCPrimary) receivePeriodicPollData(msg []byte, foundMsgMatch *bool) {
-	msgMatch, _ := regexp.MatchString(`\Afdeb(....)(........)(....)(....)(....)(.+?.+?)..$`, fmt.Sprintf("%x", msg))
-	matches := regexp.MustCompile(`\Afdeb(....)(........)(....)(....)(....)(..)(..)(..)(.+?.+?)..$`).FindAllStringSubmatch(fmt.Sprintf("%x", msg), -1)
-	if msgMatch && *foundMsgMatch == false {
-		*foundMsgMatch = true
-		secondaryID, _ := hex.DecodeString(matches[0][1])
-		data, _ := hex.DecodeString(matches[0][2])
-		p1, _ := hex.DecodeString(matches[0][3])
-		p2, _ := hex.DecodeString(matches[0][4])
-		p3, _ := hex.DecodeString(matches[0][5])
-		p1amps, _ := hex.DecodeString(matches[0][6])
-		p2amps, _ := hex.DecodeString(matches[0][7])
-		p3amps, _ := hex.DecodeString(matches[0][8])
-
-		if p.DebugLevel >= 12 {
-			log.Println(log2JSONString(LogData{
-				Type:     "INFO",
-				Source:   "messaging",
-				Sender:   fmt.Sprintf("%x", secondaryID),
-				Receiver: fmt.Sprintf("%x", p.ID),
-				Message: fmt.Sprintf(" Secondary TWC unexpectedly reported kWh and voltage data: %d %d %d %d %d %d %d",
-					Bytes2Dec4(data, false),
-					Bytes2Dec2(p1, false),
-					Bytes2Dec2(p2, false),
-					Bytes2Dec2(p3, false),
-					int(p1amps[0])/2,
-					int(p2amps[0])/2,
-					int(p3amps[0])/2,
-				),
-			}))
-		}
-		secondaryTWC, ok := p.GetSecondary(secondaryID)
-		if ok {
-			if secondaryTWC.ReportedAmpsActual == nil {
-				secondaryTWC.ReportedAmpsActual = []byte{0x00, 0x00}
-			}
-			if Bytes2Dec2(secondaryTWC.ReportedAmpsActual, false) > 0 {
-				secondaryTWC.ChargeState = true // set the TWC to be in the charging state
-			} else {
-				secondaryTWC.ChargeState = false // set the TWC to be in the not charging state
-			}
-			currentWatts := uint32(Bytes2Dec2(p1, false) * (Bytes2Dec2(secondaryTWC.ReportedAmpsActual, false) / 100))
-			if Bytes2Dec2(p2, false) != 0 && Bytes2Dec2(p3, false) != 0 {
-				volts := Bytes2Dec2(p1, false) + Bytes2Dec2(p3, false) + Bytes2Dec2(p3, false)
-				currentWatts = uint32(volts * (Bytes2Dec2(secondaryTWC.ReportedAmpsActual, false) / 100))
-			}
-			// update the stats on the secondary so we can display them :)
-			secondaryTWC.StatsCurrentWatts = currentWatts
-			secondaryTWC.StatsKWH = Bytes2Dec4(data, false)
-			secondaryTWC.StatsP1Volts = Bytes2Dec2(p1, false)
-			secondaryTWC.StatsP2Volts = Bytes2Dec2(p2, false)
-			secondaryTWC.StatsP3Volts = Bytes2Dec2(p3, false)
-			secondaryTWC.StatsP1Amps = int(p1amps[0]) / 2
-			secondaryTWC.StatsP2Amps = int(p2amps[0]) / 2
-			secondaryTWC.StatsP3Amps = int(p3amps[0]) / 2
-		}
+func (p *TWCPrimary) receiveFirmwareVersion(f protocol.Frame) {
+	version, err := protocol.ParseFirmwareVersion(f)
+	if err != nil {
+		p.logMessageError(f, err)
+		return
+	}
+	firmwareVersion := fmt.Sprintf("%d.%d.%d.%d", version[0], version[1], version[2], version[3])
+	if p.debugLevel() >= 9 {
+		log.Println(log2JSONString(LogData{
+			Type:     "INFO",
+			Source:   "messaging",
+			Sender:   fmt.Sprintf("%x", f.Sender),
+			Receiver: fmt.Sprintf("%x", p.ID),
+			Message:  fmt.Sprintf("Received firmware version %s from secondary TWC", firmwareVersion),
+		}))
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	secondaryTWC, ok := p.GetSecondary(f.Sender)
+	if ok {
+		secondaryTWC.FirmwareVersion = firmwareVersion
 	}
 }
 
-func (p *TWCPrimary) isPrimaryTWC(msg []byte, foundMsgMatch *bool) {
-	msgMatch, _ := regexp.MatchString(`\Afc(e1|e2)(....)(..)0000000000000000.+.+$`, fmt.Sprintf("%x", msg))
-	// Don't need to get any matches here, nothing to do with this data yet?
-	// matches := regexp.MustCompile(`\Afc(e1|e2)(....)(..)0000000000000000.+.+$`).FindAllStringSubmatch(fmt.Sprintf("%x", msg), -1)
-	if msgMatch && *foundMsgMatch == false {
-		*foundMsgMatch = true
+// receiveSerialNumberOrModel handles the serial number and model messages, which are both plain strings
+func (p *TWCPrimary) receiveSerialNumberOrModel(f protocol.Frame) {
+	value, err := protocol.ParseString(f)
+	if err != nil {
+		p.logMessageError(f, err)
+		return
+	}
+	name := "serial number"
+	if f.Command == protocol.Model {
+		name = "model"
+	}
+	if p.debugLevel() >= 9 {
 		log.Println(log2JSONString(LogData{
-			Type:    "ERROR",
-			Source:  "messaging",
-			Message: "ERR: TWC is set to Primary mode so it can't be controlled",
+			Type:     "INFO",
+			Source:   "messaging",
+			Sender:   fmt.Sprintf("%x", f.Sender),
+			Receiver: fmt.Sprintf("%x", p.ID),
+			Message:  fmt.Sprintf("Received %s %s from secondary TWC", name, value),
 		}))
 	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	secondaryTWC, ok := p.GetSecondary(f.Sender)
+	if ok {
+		if f.Command == protocol.Model {
+			secondaryTWC.Model = value
+		} else {
+			secondaryTWC.SerialNumber = value
+		}
+	}
+}
+
+func (p *TWCPrimary) isPrimaryTWC(f protocol.Frame) {
+	log.Println(log2JSONString(LogData{
+		Type:    "ERROR",
+		Source:  "messaging",
+		Sender:  fmt.Sprintf("%x", f.Sender),
+		Message: "ERR: TWC is set to Primary mode so it can't be controlled",
+	}))
 }
@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// metricsWriter writes metrics in the prometheus text exposition format
+type metricsWriter struct {
+	buf bytes.Buffer
+}
+
+// family starts a new metric, every sample of the metric must follow before the next family is started
+func (m *metricsWriter) family(name, metricType, help string) {
+	fmt.Fprintf(&m.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// sample writes a single value, labels are given as name and value pairs
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+	m.buf.WriteString(name)
+	if len(labels) > 0 {
+		m.buf.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.buf.WriteByte(',')
+			}
+			fmt.Fprintf(&m.buf, "%s=%s", labels[i], strconv.Quote(labels[i+1]))
+		}
+		m.buf.WriteByte('}')
+	}
+	m.buf.WriteByte(' ')
+	m.buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	m.buf.WriteByte('\n')
+}
+
+// Metrics exposes the state of the secondaries, the primary and the bus for prometheus to scrape
+func (p *TWCPrimary) Metrics(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC().Unix()
+	secondaries := p.getStats()
+	p.mu.RLock()
+	availableAmps := p.availableAmps()
+	powerwall := p.lastPowerwall
+	enablePowerwall := p.EnablePowerwall
+	p.mu.RUnlock()
+	bus := p.bus.stats()
+
+	m := &metricsWriter{}
+	secondaryGauge := func(name, help string, value func(twc TWCSecondary) float64) {
+		m.family(name, "gauge", help)
+		for _, twc := range secondaries {
+			m.sample(name, value(twc), "twc", fmt.Sprintf("%x", twc.TWCID))
+		}
+	}
+	amps := func(b []byte, little bool) float64 {
+		if len(b) < 2 {
+			return 0
+		}
+		return float64(Bytes2Dec2(b, little)) / 100
+	}
+	secondaryGauge("twc_secondary_amps_actual", "The amps the secondary reports the car is drawing.", func(twc TWCSecondary) float64 {
+		return amps(twc.ReportedAmpsActual, false)
+	})
+	secondaryGauge("twc_secondary_amps_max", "The amps the secondary reports it is allowing the car to draw.", func(twc TWCSecondary) float64 {
+		return amps(twc.ReportedAmpsMax, false)
+	})
+	secondaryGauge("twc_secondary_amps_offered", "The amps the primary has offered the secondary.", func(twc TWCSecondary) float64 {
+		return amps(twc.AvailableAmps, true)
+	})
+	m.family("twc_secondary_phase_volts", "gauge", "The volts on each phase reported by the secondary.")
+	for _, twc := range secondaries {
+		id := fmt.Sprintf("%x", twc.TWCID)
+		for phase, volts := range []uint16{twc.StatsP1Volts, twc.StatsP2Volts, twc.StatsP3Volts} {
+			m.sample("twc_secondary_phase_volts", float64(volts), "twc", id, "phase", strconv.Itoa(phase+1))
+		}
+	}
+	m.family("twc_secondary_phase_amps", "gauge", "The amps on each phase reported by the secondary.")
+	for _, twc := range secondaries {
+		id := fmt.Sprintf("%x", twc.TWCID)
+		for phase, a := range []int{twc.StatsP1Amps, twc.StatsP2Amps, twc.StatsP3Amps} {
+			m.sample("twc_secondary_phase_amps", float64(a), "twc", id, "phase", strconv.Itoa(phase+1))
+		}
+	}
+	m.family("twc_secondary_lifetime_kwh_total", "counter", "The energy the secondary has delivered over its lifetime.")
+	for _, twc := range secondaries {
+		m.sample("twc_secondary_lifetime_kwh_total", float64(twc.StatsKWH), "twc", fmt.Sprintf("%x", twc.TWCID))
+	}
+	secondaryGauge("twc_secondary_plug_state", "The plug state reported by the secondary, 0 unplugged, 1 charging, 3 plugged in.", func(twc TWCSecondary) float64 {
+		return float64(twc.PlugState)
+	})
+	secondaryGauge("twc_secondary_state", "The state reported in the secondary heartbeat.", func(twc TWCSecondary) float64 {
+		return float64(twc.ReportedState)
+	})
+	secondaryGauge("twc_secondary_last_rx_age_seconds", "The seconds since the secondary was last heard from.", func(twc TWCSecondary) float64 {
+		return float64(now - twc.TimeLastRx)
+	})
+
+	m.family("twc_available_amps", "gauge", "The amps available to share between the secondaries.")
+	m.sample("twc_available_amps", float64(availableAmps))
+	if enablePowerwall && powerwall.ok {
+		m.family("twc_powerwall_power_watts", "gauge", "The power reported by the powerwall at the last check.")
+		m.sample("twc_powerwall_power_watts", powerwall.solarWatts, "meter", "solar")
+		m.sample("twc_powerwall_power_watts", powerwall.loadWatts, "meter", "load")
+		m.sample("twc_powerwall_power_watts", powerwall.siteWatts, "meter", "site")
+		m.sample("twc_powerwall_power_watts", powerwall.batteryWatts, "meter", "battery")
+		if powerwall.hasSOC {
+			m.family("twc_powerwall_battery_percent", "gauge", "The battery charge reported at the last check.")
+			m.sample("twc_powerwall_battery_percent", powerwall.batterySOC)
+		}
+		m.family("twc_powerwall_available_watts", "gauge", "The watts of solar left for the chargers at the last check.")
+		m.sample("twc_powerwall_available_watts", powerwall.availableWatts)
+	}
+
+	m.family("twc_bus_frames_sent_total", "counter", "Frames written to the bus.")
+	m.sample("twc_bus_frames_sent_total", float64(bus.framesSent))
+	m.family("twc_bus_frames_received_total", "counter", "Frames received from the bus.")
+	m.sample("twc_bus_frames_received_total", float64(bus.framesReceived))
+	m.family("twc_bus_checksum_errors_total", "counter", "Frames dropped because the checksum didn't match.")
+	m.sample("twc_bus_checksum_errors_total", float64(bus.checksumErrors))
+	m.family("twc_bus_length_errors_total", "counter", "Frames dropped because they had an unexpected length.")
+	m.sample("twc_bus_length_errors_total", float64(bus.lengthErrors))
+	m.family("twc_bus_ignored_bytes_total", "counter", "Bytes received outside of a frame or in a frame that couldn't be decoded.")
+	m.sample("twc_bus_ignored_bytes_total", float64(bus.ignoredBytes))
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	w.Write(m.buf.Bytes())
+}
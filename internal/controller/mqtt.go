@@ -0,0 +1,404 @@
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shreddedbacon/twcmanager/internal/mqtt"
+)
+
+// MQTTConfig holds the settings for publishing the state to, and taking commands from, an MQTT broker
+type MQTTConfig struct {
+	Broker          string `yaml:"broker"` // tcp://host:1883 or ssl://host:8883, leave empty to turn off mqtt
+	Username        string `yaml:"username"`
+	Password        string `yaml:"password"`
+	ClientID        string `yaml:"clientID"`        // defaults to twc-controller
+	TopicPrefix     string `yaml:"topicPrefix"`     // defaults to twc
+	CACert          string `yaml:"caCert"`          // a PEM file of the CA to trust for ssl:// brokers, empty uses the system CAs
+	ClientCert      string `yaml:"clientCert"`      // a PEM file of the client certificate, for brokers that require one
+	ClientKey       string `yaml:"clientKey"`       // a PEM file of the client certificate key
+	Insecure        bool   `yaml:"insecure"`        // don't verify the certificate of the broker
+	PublishInterval int    `yaml:"publishInterval"` // seconds between publishing the state, defaults to 10
+	HomeAssistant   bool   `yaml:"homeAssistant"`   // publish home assistant discovery config for each linked TWC
+	DiscoveryPrefix string `yaml:"discoveryPrefix"` // the home assistant discovery prefix, defaults to homeassistant
+}
+
+// mqttPrimaryState is the state of the primary that is published to mqtt
+type mqttPrimaryState struct {
+	AvailableAmps       int     `json:"availableAmps"`
+	MinAmpsPerTWC       int     `json:"minAmpsPerTWC"`
+	WiringMaxAmpsAllTWC int     `json:"wiringMaxAmpsAllTWC"`
+	EnablePowerwall     bool    `json:"enablePowerwall"`
+	SolarAmps           float64 `json:"solarAmps"`
+	SolarWatts          float64 `json:"solarWatts"`
+	LoadWatts           float64 `json:"loadWatts"`
+	SiteWatts           float64 `json:"siteWatts"`
+	BatteryWatts        float64 `json:"batteryWatts"`
+	BatterySOC          float64 `json:"batterySOC"`
+	AvailableWatts      float64 `json:"availableWatts"`
+}
+
+// mqttSecondaryState is the state of a single secondary that is published to mqtt
+type mqttSecondaryState struct {
+	ID           string  `json:"id"`
+	VIN          string  `json:"vin"`
+	VehicleName  string  `json:"vehicleName"`
+	PlugState    int     `json:"plugState"`
+	State        int     `json:"state"`
+	StateName    string  `json:"stateName"`
+	Charging     bool    `json:"charging"`
+	AllowCharge  bool    `json:"allowCharge"`
+	ChargeMode   string  `json:"chargeMode"`
+	MaxAmps      int     `json:"maxAmps"`
+	AmpsActual   float64 `json:"ampsActual"`
+	AmpsMax      float64 `json:"ampsMax"`
+	AmpsOffered  float64 `json:"ampsOffered"`
+	Watts        uint32  `json:"watts"`
+	LifetimeKWH  uint32  `json:"lifetimeKWH"`
+	Phase1Volts  uint16  `json:"phase1Volts"`
+	Phase2Volts  uint16  `json:"phase2Volts"`
+	Phase3Volts  uint16  `json:"phase3Volts"`
+	Phase1Amps   int     `json:"phase1Amps"`
+	Phase2Amps   int     `json:"phase2Amps"`
+	Phase3Amps   int     `json:"phase3Amps"`
+	LastRx       int64   `json:"lastRx"`
+	Firmware     string  `json:"firmwareVersion"`
+	SerialNumber string  `json:"serialNumber"`
+	Model        string  `json:"model"`
+}
+
+// newMQTTSecondaryState converts a copy of a secondary into the state that is published, the lock must be held
+func (p *TWCPrimary) newMQTTSecondaryState(twc TWCSecondary) mqttSecondaryState {
+	amps := func(b []byte, little bool) float64 {
+		if len(b) < 2 {
+			return 0
+		}
+		return float64(Bytes2Dec2(b, little)) / 100
+	}
+	return mqttSecondaryState{
+		ID:           fmt.Sprintf("%x", twc.TWCID),
+		VIN:          twc.vin(),
+		VehicleName:  twc.VehicleName,
+		PlugState:    twc.PlugState,
+		State:        twc.ReportedState,
+		StateName:    getState(twc.ReportedState),
+		Charging:     twc.ChargeState,
+		AllowCharge:  twc.AllowCharge,
+		ChargeMode:   p.chargeMode(&twc),
+		MaxAmps:      p.secondaryConfig(twc.TWCID).MaxAmps,
+		AmpsActual:   amps(twc.ReportedAmpsActual, false),
+		AmpsMax:      amps(twc.ReportedAmpsMax, false),
+		AmpsOffered:  amps(twc.AvailableAmps, true),
+		Watts:        twc.StatsCurrentWatts,
+		LifetimeKWH:  twc.StatsKWH,
+		Phase1Volts:  twc.StatsP1Volts,
+		Phase2Volts:  twc.StatsP2Volts,
+		Phase3Volts:  twc.StatsP3Volts,
+		Phase1Amps:   twc.StatsP1Amps,
+		Phase2Amps:   twc.StatsP2Amps,
+		Phase3Amps:   twc.StatsP3Amps,
+		LastRx:       twc.TimeLastRx,
+		Firmware:     twc.FirmwareVersion,
+		SerialNumber: twc.SerialNumber,
+		Model:        twc.Model,
+	}
+}
+
+// topic returns the full name of a topic under the configured prefix
+func (c MQTTConfig) topic(parts ...string) string {
+	prefix := c.TopicPrefix
+	if prefix == "" {
+		prefix = "twc"
+	}
+	return strings.Join(append([]string{prefix}, parts...), "/")
+}
+
+// tlsConfig loads the certificates for an ssl:// broker
+func (c MQTTConfig) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: c.Insecure}
+	if c.CACert != "" {
+		pem, err := ioutil.ReadFile(c.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the mqtt ca certificate: %v", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CACert)
+		}
+	}
+	if c.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the mqtt client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// newMQTTClient creates the mqtt client from the config, it returns nil if mqtt is turned off
+func (p *TWCPrimary) newMQTTClient() (*mqtt.Client, error) {
+	cfg := p.MQTT
+	if cfg.Broker == "" {
+		return nil, nil
+	}
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientID := cfg.ClientID
+	if clientID == "" {
+		clientID = "twc-controller"
+	}
+	client, err := mqtt.NewClient(mqtt.Options{
+		Broker:    cfg.Broker,
+		ClientID:  clientID,
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+		TLSConfig: tlsConfig,
+		Will: &mqtt.Message{
+			Topic:   cfg.topic("status"),
+			Payload: []byte("offline"),
+			Retain:  true,
+		},
+		OnConnect: p.mqttConnected,
+		Logf: func(format string, args ...interface{}) {
+			if p.debugLevel() >= 1 {
+				log.Println(log2JSONString(LogData{
+					Type:    "INFO",
+					Source:  "mqtt",
+					Message: fmt.Sprintf(format, args...),
+				}))
+			}
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	client.Subscribe(cfg.topic("command", "+"), p.mqttPrimaryCommand)
+	client.Subscribe(cfg.topic("secondary", "+", "command", "+"), p.mqttSecondaryCommand)
+	return client, nil
+}
+
+// MQTTLoop keeps the connection to the broker and publishes the state every publish interval until the context
+// is cancelled, it does nothing if mqtt is turned off
+func (p *TWCPrimary) MQTTLoop(ctx context.Context) {
+	if p.mqtt == nil {
+		return
+	}
+	p.mu.RLock()
+	cfg := p.MQTT
+	p.mu.RUnlock()
+	interval := time.Duration(cfg.PublishInterval) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	// the client gets its own context so it can say it is going offline before it disconnects
+	clientCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.mqtt.Run(clientCtx)
+		close(done)
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			_ = p.mqtt.Publish(cfg.topic("status"), []byte("offline"), true)
+			cancel()
+			<-done
+			return
+		case <-time.After(interval):
+			p.publishState()
+		}
+	}
+}
+
+// mqttConnected is called every time the client connects to the broker
+func (p *TWCPrimary) mqttConnected() {
+	p.mu.Lock()
+	cfg := p.MQTT
+	// the broker may have lost the retained discovery config, so send it again
+	p.discovered = nil
+	p.mu.Unlock()
+	_ = p.mqtt.Publish(cfg.topic("status"), []byte("online"), true)
+	p.publishState()
+}
+
+// publishState publishes the state of the primary and every secondary to retained topics
+func (p *TWCPrimary) publishState() {
+	if p.mqtt == nil || !p.mqtt.Connected() {
+		return
+	}
+	twcs := p.getStats()
+	p.mu.RLock()
+	cfg := p.MQTT
+	state := mqttPrimaryState{
+		AvailableAmps:       p.availableAmps(),
+		MinAmpsPerTWC:       p.MinAmpsPerTWC,
+		WiringMaxAmpsAllTWC: p.WiringMaxAmpsAllTWC,
+		EnablePowerwall:     p.EnablePowerwall,
+		SolarAmps:           p.solarAmps,
+		SolarWatts:          p.lastPowerwall.solarWatts,
+		LoadWatts:           p.lastPowerwall.loadWatts,
+		SiteWatts:           p.lastPowerwall.siteWatts,
+		BatteryWatts:        p.lastPowerwall.batteryWatts,
+		BatterySOC:          p.lastPowerwall.batterySOC,
+		AvailableWatts:      p.lastPowerwall.availableWatts,
+	}
+	secondaries := make([]mqttSecondaryState, 0, len(twcs))
+	for _, twc := range twcs {
+		secondaries = append(secondaries, p.newMQTTSecondaryState(twc))
+	}
+	p.mu.RUnlock()
+	b, _ := json.Marshal(state)
+	p.mqttPublish(cfg.topic("primary", "state"), b)
+	for _, secondary := range secondaries {
+		b, _ := json.Marshal(secondary)
+		p.mqttPublish(cfg.topic("secondary", secondary.ID, "state"), b)
+	}
+	p.publishDiscovery(twcs)
+}
+
+// mqttForgetSecondary clears the retained state and discovery config of a secondary that has been removed
+func (p *TWCPrimary) mqttForgetSecondary(twcID []byte) {
+	if p.mqtt == nil || !p.mqtt.Connected() {
+		return
+	}
+	p.mu.RLock()
+	cfg := p.MQTT
+	p.mu.RUnlock()
+	p.mqttPublish(cfg.topic("secondary", fmt.Sprintf("%x", twcID), "state"), nil)
+	p.removeDiscovery(twcID)
+}
+
+// mqttPublish publishes a retained message, logging rather than returning any error
+func (p *TWCPrimary) mqttPublish(topic string, payload []byte) {
+	if err := p.mqtt.Publish(topic, payload, true); err != nil && p.debugLevel() >= 1 {
+		log.Println(log2JSONString(LogData{
+			Type:    "ERROR",
+			Source:  "mqtt",
+			Message: fmt.Sprintf("Unable to publish to %s: %v", topic, err),
+		}))
+	}
+}
+
+// mqttPrimaryCommand handles the commands for the primary, <prefix>/command/<command>
+func (p *TWCPrimary) mqttPrimaryCommand(topic string, payload []byte) {
+	command := topic[strings.LastIndex(topic, "/")+1:]
+	value := strings.TrimSpace(string(payload))
+	var err error
+	switch command {
+	case "maxamps":
+		var amps float64
+		amps, err = strconv.ParseFloat(value, 64)
+		if err == nil {
+			err = p.SetMaxAmpsHandler(int(amps))
+		}
+	case "maxwatts":
+		var watts float64
+		watts, err = strconv.ParseFloat(value, 64)
+		if err == nil {
+			_, err = p.SetMaxWatts(int(watts))
+		}
+	case "powerwall":
+		var enable bool
+		enable, err = parseSwitch(value)
+		if err == nil {
+			err = p.SetPowerwallMode(enable)
+		}
+	default:
+		err = fmt.Errorf("unknown command")
+	}
+	p.mqttCommandDone(topic, value, err)
+}
+
+// mqttSecondaryCommand handles the commands for a single secondary, <prefix>/secondary/<twcid>/command/<command>
+func (p *TWCPrimary) mqttSecondaryCommand(topic string, payload []byte) {
+	parts := strings.Split(topic, "/")
+	command := parts[len(parts)-1]
+	value := strings.TrimSpace(string(payload))
+	twcID, err := TWCIDStr2Byte(parts[len(parts)-3])
+	if err != nil {
+		p.mqttCommandDone(topic, value, err)
+		return
+	}
+	switch command {
+	case "charging":
+		var start bool
+		start, err = parseSwitch(value)
+		if err == nil && start {
+			err = p.StartCharging(twcID)
+		} else if err == nil {
+			err = p.StopCharging(twcID)
+		}
+	case "enabled":
+		var enable bool
+		enable, err = parseSwitch(value)
+		if err == nil && enable {
+			err = p.EnableTWC(twcID)
+		} else if err == nil {
+			err = p.DisableTWC(twcID)
+		}
+	case "maxamps":
+		var amps float64
+		amps, err = strconv.ParseFloat(value, 64)
+		if err == nil {
+			err = p.updateSecondaryConfig(twcID, func(cfg *SecondaryConfig) {
+				cfg.MaxAmps = int(amps)
+			})
+		}
+	case "solar":
+		var solar bool
+		solar, err = parseSwitch(value)
+		if err == nil {
+			err = p.updateSecondaryConfig(twcID, func(cfg *SecondaryConfig) {
+				cfg.ChargeMode = ChargeModeAlways
+				if solar {
+					cfg.ChargeMode = ChargeModeSolar
+				}
+			})
+		}
+	default:
+		err = fmt.Errorf("unknown command")
+	}
+	p.mqttCommandDone(topic, value, err)
+}
+
+// mqttCommandDone logs the result of a command and publishes the new state so it shows up straight away
+func (p *TWCPrimary) mqttCommandDone(topic, value string, err error) {
+	if err != nil {
+		log.Println(log2JSONString(LogData{
+			Type:    "ERROR",
+			Source:  "mqtt",
+			Message: fmt.Sprintf("Unable to run command %s %q: %v", topic, value, err),
+		}))
+		return
+	}
+	if p.debugLevel() >= 9 {
+		log.Println(log2JSONString(LogData{
+			Type:    "INFO",
+			Source:  "mqtt",
+			Message: fmt.Sprintf("Ran command %s %q", topic, value),
+		}))
+	}
+	p.publishState()
+}
+
+// parseSwitch reads an on or off value from a command
+func parseSwitch(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on", "true", "1", "start", "enable":
+		return true, nil
+	case "off", "false", "0", "stop", "disable":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected on or off")
+}
@@ -0,0 +1,320 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// planSlot is how finely the time until the departure is split up when choosing when to charge from the grid
+const planSlot = 15 * time.Minute
+
+// plan states
+const (
+	PlanWaiting  = "waiting"  // charging on solar until a cheap slot comes up
+	PlanCharging = "charging" // charging from the grid in a planned slot
+	PlanAtRisk   = "at risk"  // charging at full rate as there isn't enough time left otherwise
+	PlanDone     = "done"     // the energy has been delivered
+)
+
+// ChargeGoal is the energy a vehicle needs by a time of day
+type ChargeGoal struct {
+	KWH uint32 `yaml:"kWh" json:"kWh"` // 0 turns the planner off
+	By  string `yaml:"by" json:"by"`   // HH:MM, the time the vehicle leaves
+}
+
+// PlanWindow is a time when the plan charges from the grid
+type PlanWindow struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	PricePerKWH float64   `json:"pricePerKWH"`
+}
+
+// ChargePlan is how the goal of the vehicle plugged into a secondary is going to be met, and how far along it is
+type ChargePlan struct {
+	TWCID        string       `json:"twcID"`
+	VIN          string       `json:"vin,omitempty"`
+	Goal         ChargeGoal   `json:"goal"`
+	Departure    time.Time    `json:"departure"`
+	DeliveredKWH uint32       `json:"deliveredKWH"`
+	State        string       `json:"state"`
+	Windows      []PlanWindow `json:"windows"` // when the plan charges from the grid, the first may have started
+	startKWH     uint32       // the lifetime kWh of the secondary when the plan started
+}
+
+// Charging returns true if the plan is charging from the grid now
+func (c ChargePlan) Charging() bool {
+	return c.State == PlanCharging || c.State == PlanAtRisk
+}
+
+// RemainingKWH returns the energy still to be delivered
+func (c ChargePlan) RemainingKWH() uint32 {
+	if c.DeliveredKWH >= c.Goal.KWH {
+		return 0
+	}
+	return c.Goal.KWH - c.DeliveredKWH
+}
+
+// valid returns true if the goal can be planned for
+func (g ChargeGoal) valid() bool {
+	if g.KWH == 0 {
+		return false
+	}
+	_, err := time.Parse("15:04", g.By)
+	return err == nil
+}
+
+// next returns the next time after now that the vehicle leaves
+func (g ChargeGoal) next(now time.Time) time.Time {
+	by, _ := time.Parse("15:04", g.By)
+	now = now.Local()
+	departure := time.Date(now.Year(), now.Month(), now.Day(), by.Hour(), by.Minute(), 0, 0, time.Local)
+	if !departure.After(now) {
+		departure = departure.AddDate(0, 0, 1)
+	}
+	return departure
+}
+
+// chargeGoal returns the goal of the vehicle plugged into the secondary, falling back to the goal of the secondary,
+// the lock must be held
+func (p *TWCPrimary) chargeGoal(twc *TWCSecondary) ChargeGoal {
+	profile, ok := p.vehicleProfile(twc)
+	if ok && profile.Goal.KWH > 0 {
+		return profile.Goal
+	}
+	return p.Secondaries[fmt.Sprintf("%x", twc.TWCID)].Goal
+}
+
+// planMaxAmps returns the most the secondary can charge at, the lock must be held
+func (p *TWCPrimary) planMaxAmps(twc *TWCSecondary) int {
+	maxAmps := p.secondaryConfig(twc.TWCID).MaxAmps
+	if profile, ok := p.vehicleProfile(twc); ok && profile.MaxAmps > 0 && profile.MaxAmps < maxAmps {
+		maxAmps = profile.MaxAmps
+	}
+	return maxAmps
+}
+
+// updatePlan works out the plan for the secondary, it returns true if the plan has started or stopped charging from
+// the grid, the lock must be held
+func (p *TWCPrimary) updatePlan(twc *TWCSecondary, now time.Time) bool {
+	wasCharging := twc.plan != nil && twc.plan.Charging()
+	goal := p.chargeGoal(twc)
+	if !goal.valid() || twc.PlugState == 0 || !twc.kwhRead {
+		// nothing to plan for, or the lifetime kWh hasn't been read yet
+		twc.plan = nil
+		return wasCharging
+	}
+	plan := twc.plan
+	if plan == nil || plan.Goal != goal || plan.VIN != twc.profileVIN || !now.Before(plan.Departure) {
+		// a new goal, a different vehicle, or the vehicle didn't leave, so start again from what has been delivered
+		plan = &ChargePlan{
+			TWCID:     fmt.Sprintf("%x", twc.TWCID),
+			VIN:       twc.profileVIN,
+			Goal:      goal,
+			Departure: goal.next(now),
+			startKWH:  twc.lastKWH,
+		}
+	} else {
+		// the plan is shared with copies of the secondary, so change a copy of it
+		copied := *plan
+		plan = &copied
+	}
+	if twc.lastKWH > plan.startKWH {
+		plan.DeliveredKWH = twc.lastKWH - plan.startKWH
+	}
+	plan.Windows = nil
+	plan.State = PlanDone
+	if remaining := plan.RemainingKWH(); remaining > 0 {
+		watts := ampsToWatts(p.SupplyPhases, p.SupplyVoltage, p.planMaxAmps(twc))
+		// one more slot than is needed in case the car charges slower than the wiring allows
+		needed := int(math.Ceil(float64(remaining)*1000/(float64(watts)*planSlot.Hours()))) + 1
+		plan.State, plan.Windows = p.Tariff.planSlots(now, plan.Departure, needed)
+	}
+	twc.plan = plan
+	charging := plan.Charging()
+	if charging != wasCharging && p.debugLevel() >= 9 {
+		log.Println(log2JSONString(LogData{
+			Type:     "INFO",
+			Source:   "planner",
+			Receiver: plan.TWCID,
+			Message: fmt.Sprintf("Plan is %s, %d of %d kWh delivered, leaving at %s", plan.State, plan.DeliveredKWH,
+				plan.Goal.KWH, plan.Departure.Format("15:04")),
+		}))
+	}
+	return charging != wasCharging
+}
+
+// planSlots chooses the cheapest slots from now until the departure to charge from the grid in, the latest of the
+// slots with the same price are chosen first so the solar has a chance to do it, it returns the state of the plan
+// and the chosen slots joined into windows
+func (t Tariff) planSlots(now, departure time.Time, needed int) (string, []PlanWindow) {
+	type slot struct {
+		start, end time.Time
+		price      float64
+	}
+	slots := []slot{}
+	for start := now; start.Before(departure); {
+		// line the slots up on the quarter hours so they match the tariff windows
+		end := start.Truncate(planSlot).Add(planSlot)
+		if end.After(departure) {
+			end = departure
+		}
+		slots = append(slots, slot{start: start, end: end, price: t.tariffNow(start).PricePerKWH})
+		start = end
+	}
+	state := PlanWaiting
+	chosen := make([]bool, len(slots))
+	if needed >= len(slots) {
+		state = PlanAtRisk
+		for i := range chosen {
+			chosen[i] = true
+		}
+	} else {
+		order := make([]int, len(slots))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			if slots[order[a]].price != slots[order[b]].price {
+				return slots[order[a]].price < slots[order[b]].price
+			}
+			return order[a] > order[b]
+		})
+		for _, i := range order[:needed] {
+			chosen[i] = true
+		}
+		if len(chosen) > 0 && chosen[0] {
+			state = PlanCharging
+		}
+	}
+	windows := []PlanWindow{}
+	for i, s := range slots {
+		if !chosen[i] {
+			continue
+		}
+		if n := len(windows); n > 0 && windows[n-1].End.Equal(s.start) && windows[n-1].PricePerKWH == s.price {
+			windows[n-1].End = s.end
+			continue
+		}
+		windows = append(windows, PlanWindow{Start: s.start, End: s.end, PricePerKWH: s.price})
+	}
+	return state, windows
+}
+
+// planCron updates the plan of each secondary, starting the cars that need to charge from the grid
+func (p *TWCPrimary) planCron(now int64) {
+	p.mu.Lock()
+	if (now - p.timeLastPlan) < 30 {
+		p.mu.Unlock()
+		return
+	}
+	p.timeLastPlan = now
+	changed := false
+	start := [][]byte{}
+	for _, twc := range p.knownTWCs {
+		if !p.updatePlan(twc, time.Unix(now, 0)) {
+			continue
+		}
+		changed = true
+		if twc.plan != nil && twc.plan.Charging() && !twc.ChargeState {
+			start = append(start, twc.TWCID)
+		}
+	}
+	p.mu.Unlock()
+	for _, twcID := range start {
+		err := p.StartCharging(twcID)
+		if err != nil && p.debugLevel() >= 1 {
+			log.Println(log2JSONString(LogData{
+				Type:     "ERROR",
+				Source:   "planner",
+				Receiver: fmt.Sprintf("%x", twcID),
+				Message:  fmt.Sprintf("Unable to start charging for the plan: %v", err),
+			}))
+		}
+	}
+	if changed {
+		// the cars charging from the grid are given what the wiring allows, the rest share the available amps
+		_ = p.balance()
+	}
+}
+
+// chargePlans returns a copy of the plan of each secondary that has one
+func (p *TWCPrimary) chargePlans() []ChargePlan {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	plans := []ChargePlan{}
+	for _, twc := range p.knownTWCs {
+		if twc.plan != nil {
+			plans = append(plans, *twc.plan)
+		}
+	}
+	return plans
+}
+
+// APIPlans returns the plan of each secondary that has one
+func (p *TWCPrimary) APIPlans(w http.ResponseWriter, r *http.Request) {
+	b, err := json.Marshal(p.chargePlans())
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", b)
+}
+
+// APIPlanGoal sets the goal of a secondary, or of a vehicle if a VIN is given, a kWh of 0 clears it
+func (p *TWCPrimary) APIPlanGoal(w http.ResponseWriter, r *http.Request) {
+	goal := ChargeGoal{By: r.FormValue("by")}
+	if v := r.FormValue("kWh"); v != "" {
+		kwh, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			httpError(w, fmt.Errorf(`{"error":"kWh must be a number of 0 or more"}`))
+			return
+		}
+		goal.KWH = uint32(kwh)
+	}
+	if goal.KWH > 0 && !goal.valid() {
+		httpError(w, fmt.Errorf(`{"error":"by must be HH:MM"}`))
+		return
+	}
+	if vin := r.FormValue("vin"); vin != "" {
+		if len(vin) != 17 {
+			httpError(w, fmt.Errorf(`{"error":"the vin must be 17 characters"}`))
+			return
+		}
+		p.mu.Lock()
+		if p.Vehicles == nil {
+			p.Vehicles = map[string]VehicleProfile{}
+		}
+		profile := p.Vehicles[vin]
+		profile.Goal = goal
+		p.Vehicles[vin] = profile
+		p.mu.Unlock()
+		err := p.writeConfig()
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, `{"vin":"%s","kWh":%d,"by":"%s"}`, vin, goal.KWH, goal.By)
+		return
+	}
+	bTWCID, err := TWCIDStr2Byte(r.FormValue("twcid"))
+	if err != nil {
+		httpError(w, fmt.Errorf("%v", err))
+		return
+	}
+	err = p.updateSecondaryConfig(bTWCID, func(cfg *SecondaryConfig) {
+		cfg.Goal = goal
+	})
+	if err != nil {
+		httpError(w, fmt.Errorf("%v", err))
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/info/%x", bTWCID), http.StatusSeeOther)
+}
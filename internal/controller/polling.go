@@ -4,33 +4,31 @@ import (
 	"fmt"
 	"log"
 	"time"
+
+	"github.com/shreddedbacon/twcmanager/internal/protocol"
 )
 
 // PollSecondaryKWH polls the secondary TWCs for their usage statistics
 func (p *TWCPrimary) PollSecondaryKWH() (int64, error) {
-	for _, twc := range p.knownTWCs {
-		if p.DebugLevel >= 15 {
+	for _, twcID := range p.secondaryIDs() {
+		if p.debugLevel() >= 15 {
 			log.Println(log2JSONString(LogData{
 				Type:     "INFO",
 				Source:   "polling",
 				Sender:   fmt.Sprintf("%x", p.ID),
-				Receiver: fmt.Sprintf("%x", twc.TWCID),
+				Receiver: fmt.Sprintf("%x", twcID),
 				Message:  "Poll secondary for stats",
 			}))
 		}
-		// msg := append(append(append([]byte{0xFB, 0xEB}, p.ID...), twc.TWCID...), []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}...)
-		msg := append(append([]byte{0xFB, 0xEB}, p.ID...), twc.TWCID...)
-		padBytes(&msg)
-		_, _ = SendMessage(p.DebugLevel, p.port, msg)
-		p.ReadMessage()
-		time.Sleep(250 * time.Millisecond)
+		msg := protocol.NewPoll(protocol.PollKWH, p.ID, twcID).Encode(2)
+		_, _ = p.bus.request(msg, priorityPoll, protocol.KWH, twcID)
 	}
 	return time.Now().UTC().Unix(), nil
 }
 
 // PollFirmwareVersion polls the secondary TWCs for their usage statistics
 func (p *TWCPrimary) PollFirmwareVersion() (int64, error) {
-	if p.DebugLevel >= 15 {
+	if p.debugLevel() >= 15 {
 		log.Println(log2JSONString(LogData{
 			Type:    "INFO",
 			Source:  "polling",
@@ -38,18 +36,16 @@ func (p *TWCPrimary) PollFirmwareVersion() (int64, error) {
 			Message: "Poll for firmware version",
 		}))
 	}
-	for _, twc := range p.knownTWCs {
-		// msg := append(append(append([]byte{0xFB, 0x1B}, p.ID...), twc.TWCID...), []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}...)
-		msg := append(append([]byte{0xFB, 0x1B}, p.ID...), twc.TWCID...)
-		padBytes(&msg)
-		_, _ = SendMessage(p.DebugLevel, p.port, msg)
+	for _, twcID := range p.secondaryIDs() {
+		msg := protocol.NewPoll(protocol.PollFirmwareVersion, p.ID, twcID).Encode(2)
+		_, _ = p.bus.request(msg, priorityPoll, protocol.FirmwareVersion, twcID)
 	}
 	return time.Now().UTC().Unix(), nil
 }
 
 // PollSerialNumber polls the secondary TWCs for their usage statistics
 func (p *TWCPrimary) PollSerialNumber() (int64, error) {
-	if p.DebugLevel >= 15 {
+	if p.debugLevel() >= 15 {
 		log.Println(log2JSONString(LogData{
 			Type:    "INFO",
 			Source:  "polling",
@@ -57,18 +53,16 @@ func (p *TWCPrimary) PollSerialNumber() (int64, error) {
 			Message: "Poll for serial number",
 		}))
 	}
-	for _, twc := range p.knownTWCs {
-		// msg := append(append(append([]byte{0xFB, 0x19}, p.ID...), twc.TWCID...), []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}...)
-		msg := append(append([]byte{0xFB, 0x19}, p.ID...), twc.TWCID...)
-		padBytes(&msg)
-		_, _ = SendMessage(p.DebugLevel, p.port, msg)
+	for _, twcID := range p.secondaryIDs() {
+		msg := protocol.NewPoll(protocol.PollSerialNumber, p.ID, twcID).Encode(2)
+		_, _ = p.bus.request(msg, priorityPoll, protocol.SerialNumber, twcID)
 	}
 	return time.Now().UTC().Unix(), nil
 }
 
 // PollModel polls the secondary TWCs for their usage statistics
 func (p *TWCPrimary) PollModel() (int64, error) {
-	if p.DebugLevel >= 15 {
+	if p.debugLevel() >= 15 {
 		log.Println(log2JSONString(LogData{
 			Type:    "INFO",
 			Source:  "polling",
@@ -76,107 +70,142 @@ func (p *TWCPrimary) PollModel() (int64, error) {
 			Message: "Poll model number",
 		}))
 	}
-	for _, twc := range p.knownTWCs {
-		// msg := append(append(append([]byte{0xFB, 0x1A}, p.ID...), twc.TWCID...), []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}...)
-		msg := append(append([]byte{0xFB, 0x1A}, p.ID...), twc.TWCID...)
-		padBytes(&msg)
-		_, _ = SendMessage(p.DebugLevel, p.port, msg)
+	for _, twcID := range p.secondaryIDs() {
+		msg := protocol.NewPoll(protocol.PollModel, p.ID, twcID).Encode(2)
+		_, _ = p.bus.request(msg, priorityPoll, protocol.Model, twcID)
 	}
 	return time.Now().UTC().Unix(), nil
 }
 
 // PollVINStart polls the secondary TWCs for the current VIN
 func (p *TWCPrimary) PollVINStart() (int64, error) {
-	for _, twc := range p.knownTWCs {
-		if twc.ReportedState != 0 {
-			if p.DebugLevel >= 15 {
-				log.Println(log2JSONString(LogData{
-					Type:     "INFO",
-					Source:   "polling",
-					Sender:   fmt.Sprintf("%x", p.ID),
-					Receiver: fmt.Sprintf("%x", twc.TWCID),
-					Message:  "Poll Secondary for VIN start",
-				}))
-			}
-			// msg := append(append(append([]byte{0xFB, 0xEE}, p.ID...), twc.TWCID...), []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}...)
-			msg := append(append([]byte{0xFB, 0xEE}, p.ID...), twc.TWCID...)
-			padBytes(&msg)
-			_, _ = SendMessage(p.DebugLevel, p.port, msg)
-			p.ReadMessage()
-			time.Sleep(250 * time.Millisecond)
+	for _, twcID := range p.activeSecondaryIDs() {
+		if p.debugLevel() >= 15 {
+			log.Println(log2JSONString(LogData{
+				Type:     "INFO",
+				Source:   "polling",
+				Sender:   fmt.Sprintf("%x", p.ID),
+				Receiver: fmt.Sprintf("%x", twcID),
+				Message:  "Poll Secondary for VIN start",
+			}))
 		}
+		msg := protocol.NewPoll(protocol.PollVINStart, p.ID, twcID).Encode(2)
+		_, _ = p.bus.request(msg, priorityPoll, protocol.VINStart, twcID)
 	}
 	return time.Now().UTC().Unix(), nil
 }
 
 // PollVINMiddle polls the secondary TWCs for the current VIN
 func (p *TWCPrimary) PollVINMiddle() (int64, error) {
-	for _, twc := range p.knownTWCs {
-		if twc.ReportedState != 0 {
-			if p.DebugLevel >= 15 {
-				log.Println(log2JSONString(LogData{
-					Type:     "INFO",
-					Source:   "polling",
-					Sender:   fmt.Sprintf("%x", p.ID),
-					Receiver: fmt.Sprintf("%x", twc.TWCID),
-					Message:  "Poll Secondary for VIN middle",
-				}))
-			}
-			// msg := append(append(append([]byte{0xFB, 0xEF}, p.ID...), twc.TWCID...), []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}...)
-			msg := append(append([]byte{0xFB, 0xEF}, p.ID...), twc.TWCID...)
-			padBytes(&msg)
-			_, _ = SendMessage(p.DebugLevel, p.port, msg)
-			p.ReadMessage()
-			time.Sleep(250 * time.Millisecond)
+	for _, twcID := range p.activeSecondaryIDs() {
+		if p.debugLevel() >= 15 {
+			log.Println(log2JSONString(LogData{
+				Type:     "INFO",
+				Source:   "polling",
+				Sender:   fmt.Sprintf("%x", p.ID),
+				Receiver: fmt.Sprintf("%x", twcID),
+				Message:  "Poll Secondary for VIN middle",
+			}))
 		}
+		msg := protocol.NewPoll(protocol.PollVINMiddle, p.ID, twcID).Encode(2)
+		_, _ = p.bus.request(msg, priorityPoll, protocol.VINMiddle, twcID)
 	}
 	return time.Now().UTC().Unix(), nil
 }
 
 // PollVINEnd polls the secondary TWCs for the current VIN
 func (p *TWCPrimary) PollVINEnd() (int64, error) {
-	for _, twc := range p.knownTWCs {
-		if twc.ReportedState != 0 {
-			if p.DebugLevel >= 15 {
-				log.Println(log2JSONString(LogData{
-					Type:     "INFO",
-					Source:   "polling",
-					Sender:   fmt.Sprintf("%x", p.ID),
-					Receiver: fmt.Sprintf("%x", twc.TWCID),
-					Message:  "Poll Secondary for VIN end",
-				}))
-			}
-			// msg := append(append(append([]byte{0xFB, 0xF1}, p.ID...), twc.TWCID...), []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}...)
-			msg := append(append([]byte{0xFB, 0xF1}, p.ID...), twc.TWCID...)
-			padBytes(&msg)
-			_, _ = SendMessage(p.DebugLevel, p.port, msg)
-			p.ReadMessage()
-			time.Sleep(250 * time.Millisecond)
+	for _, twcID := range p.activeSecondaryIDs() {
+		if p.debugLevel() >= 15 {
+			log.Println(log2JSONString(LogData{
+				Type:     "INFO",
+				Source:   "polling",
+				Sender:   fmt.Sprintf("%x", p.ID),
+				Receiver: fmt.Sprintf("%x", twcID),
+				Message:  "Poll Secondary for VIN end",
+			}))
 		}
+		msg := protocol.NewPoll(protocol.PollVINEnd, p.ID, twcID).Encode(2)
+		_, _ = p.bus.request(msg, priorityPoll, protocol.VINEnd, twcID)
 	}
 	return time.Now().UTC().Unix(), nil
 }
 
 // PollPlugState polls the secondary TWCs for their plug state
 func (p *TWCPrimary) PollPlugState() (int64, error) {
+	for _, twcID := range p.activeSecondaryIDs() {
+		if p.debugLevel() >= 15 {
+			log.Println(log2JSONString(LogData{
+				Type:     "INFO",
+				Source:   "polling",
+				Sender:   fmt.Sprintf("%x", p.ID),
+				Receiver: fmt.Sprintf("%x", twcID),
+				Message:  "Poll Secondary for plug state",
+			}))
+		}
+		msg := protocol.NewPoll(protocol.PollPlugState, p.ID, twcID).Encode(2)
+		_, _ = p.bus.request(msg, priorityPoll, protocol.PlugState, twcID)
+	}
+	return time.Now().UTC().Unix(), nil
+}
+
+// pollReplies maps each poll to the reply the secondary sends back
+var pollReplies = map[protocol.Command]protocol.Command{
+	protocol.PollKWH:             protocol.KWH,
+	protocol.PollVINStart:        protocol.VINStart,
+	protocol.PollVINMiddle:       protocol.VINMiddle,
+	protocol.PollVINEnd:          protocol.VINEnd,
+	protocol.PollPlugState:       protocol.PlugState,
+	protocol.PollFirmwareVersion: protocol.FirmwareVersion,
+	protocol.PollSerialNumber:    protocol.SerialNumber,
+	protocol.PollModel:           protocol.Model,
+}
+
+// pollSecondaries queues a poll to every known secondary TWC, the replies are handled as they arrive so this
+// doesn't hold up the heartbeats
+func (p *TWCPrimary) pollSecondaries(cmd protocol.Command, message string) {
+	for _, twcID := range p.secondaryIDs() {
+		if p.debugLevel() >= 15 {
+			log.Println(log2JSONString(LogData{
+				Type:     "INFO",
+				Source:   "polling",
+				Sender:   fmt.Sprintf("%x", p.ID),
+				Receiver: fmt.Sprintf("%x", twcID),
+				Message:  message,
+			}))
+		}
+		msg := protocol.NewPoll(cmd, p.ID, twcID).Encode(2)
+		p.bus.post(msg, priorityPoll, pollReplies[cmd], twcID)
+	}
+}
+
+// activeSecondaryIDs returns the IDs of the secondaries that have reported a state other than ready
+func (p *TWCPrimary) activeSecondaryIDs() [][]byte {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	ids := [][]byte{}
 	for _, twc := range p.knownTWCs {
 		if twc.ReportedState != 0 {
-			if p.DebugLevel >= 15 {
-				log.Println(log2JSONString(LogData{
-					Type:     "INFO",
-					Source:   "polling",
-					Sender:   fmt.Sprintf("%x", p.ID),
-					Receiver: fmt.Sprintf("%x", twc.TWCID),
-					Message:  "Poll Secondary for plug state",
-				}))
-			}
-			// msg := append(append(append([]byte{0xFB, 0xB4}, p.ID...), twc.TWCID...), []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}...)
-			msg := append(append([]byte{0xFB, 0xB4}, p.ID...), twc.TWCID...)
-			padBytes(&msg)
-			_, _ = SendMessage(p.DebugLevel, p.port, msg)
-			p.ReadMessage()
-			time.Sleep(250 * time.Millisecond)
+			ids = append(ids, twc.TWCID)
 		}
 	}
-	return time.Now().UTC().Unix(), nil
+	return ids
+}
+
+// pollSecondaryInfo requests the firmware version, serial number and model from a single secondary TWC,
+// this only needs doing once as they don't change
+func (p *TWCPrimary) pollSecondaryInfo(twcID []byte) {
+	if p.debugLevel() >= 9 {
+		log.Println(log2JSONString(LogData{
+			Type:     "INFO",
+			Source:   "polling",
+			Sender:   fmt.Sprintf("%x", p.ID),
+			Receiver: fmt.Sprintf("%x", twcID),
+			Message:  "Poll secondary for firmware version, serial number and model",
+		}))
+	}
+	for _, cmd := range []protocol.Command{protocol.PollFirmwareVersion, protocol.PollSerialNumber, protocol.PollModel} {
+		msg := protocol.NewPoll(cmd, p.ID, twcID).Encode(2)
+		p.bus.post(msg, priorityPoll, pollReplies[cmd], twcID)
+	}
 }
@@ -1,23 +1,28 @@
 package controller
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
 	"text/template"
+	"time"
 
-	powerwall "github.com/shreddedbacon/fake-powerwall/api"
+	"github.com/shreddedbacon/twcmanager/internal/energy"
 	"github.com/shreddedbacon/twcmanager/internal/ui"
 )
 
 // PowerwallSettingsPage .
 type PowerwallSettingsPage struct {
-	PageName             string
-	BreadCrumbs          []BreadCrumb
-	PageData             TWCPrimary
-	PowerwallInfo        powerwall.MetersAggregates
-	PowerwallBatteryInfo powerwall.SystemSOE
+	PageName         string
+	BreadCrumbs      []BreadCrumb
+	PageData         TWCPrimary
+	EnergySourceType string
+	Energy           energy.Reading
+	EnergyError      string
+	SolarDecisions   []SolarDecision // the latest first
+	Tariff           TariffNow
 }
 
 // GetPowerwallSettings .
@@ -28,53 +33,41 @@ func (p *TWCPrimary) GetPowerwallSettings(w http.ResponseWriter, r *http.Request
 	tpl := append(tpl1, tpl2...)
 	tpl = append(tpl, tpl3...)
 	tmpl, _ := template.New("").Funcs(funcMap).Parse(string(tpl))
-	bp := &powerwall.SystemSOE{
-		Percentage: 0.0,
-	}
-	d := &powerwall.MetersAggregates{
-		Site: &powerwall.AggregateData{
-			InstantPower: 0,
-		},
-		Load: &powerwall.AggregateData{
-			InstantPower: 0,
-		},
-		Solar: &powerwall.AggregateData{
-			InstantPower: 0,
-		},
-		Battery: &powerwall.AggregateData{
-			InstantPower: 0,
-		},
+	primary := p.snapshot()
+	pageData := PowerwallSettingsPage{
+		BreadCrumbs:      getBreadCrumbs("Powerwall"),
+		PageName:         "Powerwall",
+		PageData:         primary,
+		EnergySourceType: primary.energySourceType(),
+		SolarDecisions:   p.solarDecisions(),
+		Tariff:           primary.Tariff.tariffNow(time.Now()),
 	}
-	if p.EnablePowerwall && p.Powerwall != "" {
-		pw := powerwall.FakePowerwall{
-			Inverter: p.Powerwall,
-		}
-		b, err := pw.Request("/api/meters/aggregates")
-		if err == nil {
-			json.Unmarshal(b, d)
-		}
-		bcb, err := pw.Request("/api/system_status/soe")
-		if err == nil {
-			json.Unmarshal(bcb, bp)
+	if primary.EnablePowerwall && primary.energy != nil {
+		reading, err := p.readEnergy(r.Context(), primary.energy)
+		if err != nil {
+			pageData.EnergyError = err.Error()
 		}
-	}
-	pageData := PowerwallSettingsPage{
-		BreadCrumbs:          getBreadCrumbs("Powerwall"),
-		PageName:             "Powerwall",
-		PageData:             *p,
-		PowerwallInfo:        *d,
-		PowerwallBatteryInfo: *bp,
+		pageData.Energy = reading
 	}
 	tmpl.ExecuteTemplate(w, "base", pageData)
 }
 
-// GetPowerwallSiteUsage get the usage meter value from the Powerwall.
+// GetPowerwallSiteUsage get the usage meter value from the Powerwall, or the reading from any other energy source.
 func (p *TWCPrimary) GetPowerwallSiteUsage(w http.ResponseWriter, r *http.Request) {
-	if p.EnablePowerwall && p.Powerwall != "" {
-		pw := powerwall.FakePowerwall{
-			Inverter: p.Powerwall,
+	primary := p.snapshot()
+	if primary.EnablePowerwall && primary.energy != nil {
+		var b []byte
+		var err error
+		if pw, ok := primary.energy.(*energy.Powerwall); ok {
+			// the raw meter aggregates, as this has always returned
+			b, err = pw.Aggregates(r.Context())
+		} else {
+			var reading energy.Reading
+			reading, err = p.readEnergy(r.Context(), primary.energy)
+			if err == nil {
+				b, err = json.Marshal(reading)
+			}
 		}
-		b, err := pw.Request("/api/meters/aggregates")
 		if err != nil {
 			httpError(w, err)
 			return
@@ -85,6 +78,21 @@ func (p *TWCPrimary) GetPowerwallSiteUsage(w http.ResponseWriter, r *http.Reques
 	httpError(w, fmt.Errorf("not configured"))
 }
 
+// readEnergy reads the energy source, giving up if it takes too long
+func (p *TWCPrimary) readEnergy(ctx context.Context, source energy.Source) (energy.Reading, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	return source.Read(ctx)
+}
+
+// SetPowerwallMode turns following the solar generation reported by the powerwall on or off
+func (p *TWCPrimary) SetPowerwallMode(enable bool) error {
+	p.mu.Lock()
+	p.EnablePowerwall = enable
+	p.mu.Unlock()
+	return p.writeConfig()
+}
+
 // APIPowerwallSettings returns the settings for the TWC controller
 func (p *TWCPrimary) APIPowerwallSettings(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
@@ -94,6 +102,8 @@ func (p *TWCPrimary) APIPowerwallSettings(w http.ResponseWriter, r *http.Request
 		powerOffset := r.FormValue("powerOffset")
 		powerOffsetAmps := r.FormValue("powerOffsetAmps")
 		powerwallCheckInterval := r.FormValue("powerwallCheckInterval")
+		solarMode := r.FormValue("solarMode")
+		gridSetpoint := r.FormValue("gridSetpoint")
 		err := r.ParseForm()
 		if err != nil {
 			httpError(w, fmt.Errorf("%v", err))
@@ -110,8 +120,10 @@ func (p *TWCPrimary) APIPowerwallSettings(w http.ResponseWriter, r *http.Request
 				httpError(w, fmt.Errorf(`{"error":"powerwall check interval is not a number: %v"}`, err))
 				return
 			}
+			p.mu.Lock()
 			p.PowerOffset = po
 			p.PowerwallCheckInterval = pwci
+			p.mu.Unlock()
 		}
 		if powerOffsetAmps != "" {
 			poa, err := strconv.Atoi(powerOffsetAmps)
@@ -119,11 +131,34 @@ func (p *TWCPrimary) APIPowerwallSettings(w http.ResponseWriter, r *http.Request
 				httpError(w, fmt.Errorf(`{"error":"power offset (amps) is not a number: %v"}`, err))
 				return
 			}
+			p.mu.Lock()
 			p.PowerOffset = ampsToWatts(p.SupplyPhases, p.SupplyVoltage, poa)
+			p.mu.Unlock()
 
 		}
-		if powerwall != "" {
+		if solarMode != "" && solarMode != SolarModeSurplus && solarMode != SolarModeGrid {
+			httpError(w, fmt.Errorf(`{"error":"solar mode must be %s or %s"}`, SolarModeSurplus, SolarModeGrid))
+			return
+		}
+		if gridSetpoint != "" {
+			gs, err := strconv.Atoi(gridSetpoint)
+			if err != nil {
+				httpError(w, fmt.Errorf(`{"error":"grid setpoint (watts) is not a number: %v"}`, err))
+				return
+			}
+			p.mu.Lock()
+			p.GridSetpoint = gs
+			p.mu.Unlock()
+		}
+		p.mu.Lock()
+		if solarMode != "" {
+			p.SolarMode = solarMode
+		}
+		if powerwall != "" && powerwall != p.Powerwall {
 			p.Powerwall = powerwall
+			if p.energySourceType() == EnergySourcePowerwall {
+				p.energy = energy.NewPowerwall(powerwall)
+			}
 		}
 		if autoStartStopInterval == "on" {
 			p.AutoStartStopInterval = true
@@ -135,6 +170,7 @@ func (p *TWCPrimary) APIPowerwallSettings(w http.ResponseWriter, r *http.Request
 		} else {
 			p.EnablePowerwall = false
 		}
+		p.mu.Unlock()
 		err = p.writeConfig()
 		if err != nil {
 			httpError(w, err)
@@ -143,7 +179,8 @@ func (p *TWCPrimary) APIPowerwallSettings(w http.ResponseWriter, r *http.Request
 		http.Redirect(w, r, "/powerwall", http.StatusSeeOther)
 		return
 	} else if r.Method == http.MethodGet {
-		strB := fmt.Sprintf(`{"powerwall": "%s", "enablePowerwall": %v}`, p.Powerwall, p.EnablePowerwall)
+		primary := p.snapshot()
+		strB := fmt.Sprintf(`{"powerwall": "%s", "enablePowerwall": %v}`, primary.Powerwall, primary.EnablePowerwall)
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintf(w, "%s", strB)
 		return
@@ -2,17 +2,27 @@ package controller
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/shreddedbacon/tesla"
-	"github.com/tarm/serial"
+	"github.com/shreddedbacon/twcmanager/internal/energy"
+	"github.com/shreddedbacon/twcmanager/internal/history"
+	"github.com/shreddedbacon/twcmanager/internal/mqtt"
+	"github.com/shreddedbacon/twcmanager/internal/protocol"
+	"github.com/shreddedbacon/twcmanager/internal/secrets"
+	"github.com/shreddedbacon/twcmanager/internal/transport"
+	"github.com/shreddedbacon/twcmanager/internal/vehicle"
 	"gopkg.in/yaml.v2"
 
 	ws2811 "github.com/rpi-ws281x/rpi-ws281x-go"
@@ -22,47 +32,84 @@ var spikeAmpsToCancel6ALimit = 16
 
 // TWCPrimary is the primary structure of the TWC controller
 type TWCPrimary struct {
-	port                   *serial.Port    `yaml:"-"`
-	ID                     []byte          `yaml:"-"` // []byte{0x77, 0x77}
-	sign                   []byte          `yaml:"-"` // []byte{0x77}
-	WiringMaxAmpsAllTWC    int             `yaml:"wiringMaxAmpsAllTWC"`
-	WiringMaxAmpsPerTWC    int             `yaml:"wiringMaxAmpsPerTWC"`
-	MinAmpsPerTWC          int             `yaml:"minAmpsPerTWC"` // When tracking Solar/Powerwall usage, this is the minimum value to allow charging at (12A = 2880W)
-	SupplyVoltage          int             `yaml:"supplyVoltage"` // Voltage of a single phase, used to convert watts to amps
-	SupplyPhases           int             `yaml:"supplyPhases"`  // Voltage of a single phase, used to convert watts to amps
-	knownTWCs              []*TWCSecondary // slice of all the TWCs that this primary knows about
-	DebugLevel             int             `yaml:"debugLevel"`
-	timeLastTx             int64           `yaml:"-"`
-	numInitMsgsToSend      int             `yaml:"-"`
-	SerialConfig           SerialConfig    `yaml:"serial"`
-	ConfigPath             string          `yaml:"-"`
-	AvailableAmps          int             `yaml:"availableAmps"`
-	Powerwall              string          `yaml:"powerwall"`
-	EnablePowerwall        bool            `yaml:"enablePowerwall"`
-	AutoStartStopInterval  bool            `yaml:"autoStartStopInterval"`
-	PowerOffset            int             `yaml:"powerOffset"`
-	PowerwallCheckInterval int             `yaml:"powerwallCheckInterval"`
-	TeslaAPITokens         []*TeslaAPIUser `yaml:"-"` // slice of all known tesla api tokens
-	timeLastVINCron        int64           `yaml:"-"`
-	timeLastStatePoll      int64           `yaml:"-"`
-	timeLastSecondaryPoll  int64           `yaml:"-"`
-	timeLastPowerwallCheck int64           `yaml:"-"`
-	twcNextHeartbeatID     int             `yaml:"-"`
-	LEDSOn                 bool            `yaml:"ledEnable"`
-	LEDController          *ledStrip       `yaml:"-"`
-	LEDValues              map[int]uint32  `yaml:"-"`
-	LEDCharging            bool            `yaml:"-"`
+	mu                     *sync.RWMutex              // guards knownTWCs, the secondaries and everything else that changes at runtime
+	bus                    *busScheduler              // the only thing that reads from or writes to the port
+	ID                     []byte                     `yaml:"-"` // []byte{0x77, 0x77}
+	sign                   []byte                     `yaml:"-"` // []byte{0x77}
+	WiringMaxAmpsAllTWC    int                        `yaml:"wiringMaxAmpsAllTWC"`
+	WiringMaxAmpsPerTWC    int                        `yaml:"wiringMaxAmpsPerTWC"`
+	MinAmpsPerTWC          int                        `yaml:"minAmpsPerTWC"` // When tracking Solar/Powerwall usage, this is the minimum value to allow charging at (12A = 2880W)
+	SupplyVoltage          int                        `yaml:"supplyVoltage"` // Voltage of a single phase, used to convert watts to amps
+	SupplyPhases           int                        `yaml:"supplyPhases"`  // Voltage of a single phase, used to convert watts to amps
+	knownTWCs              []*TWCSecondary            // slice of all the TWCs that this primary knows about
+	DebugLevel             int32                      `yaml:"debugLevel"` // use debugLevel() to read, it is changed at runtime
+	timeLastTx             int64                      `yaml:"-"`
+	numInitMsgsToSend      int                        `yaml:"-"`
+	SerialConfig           SerialConfig               `yaml:"serial"`
+	ConfigPath             string                     `yaml:"-"`
+	AvailableAmps          int                        `yaml:"availableAmps"`
+	Powerwall              string                     `yaml:"powerwall"`
+	EnablePowerwall        bool                       `yaml:"enablePowerwall"`
+	AutoStartStopInterval  bool                       `yaml:"autoStartStopInterval"`
+	PowerOffset            int                        `yaml:"powerOffset"`
+	PowerwallCheckInterval int                        `yaml:"powerwallCheckInterval"`
+	EnergySource           EnergySourceConfig         `yaml:"energySource"` // where the solar generation is read from, defaults to the powerwall
+	SolarMode              string                     `yaml:"solarMode"`    // surplus or grid, how the watts left over for the chargers are worked out
+	GridSetpoint           int                        `yaml:"gridSetpoint"` // the watts to hold the grid at in grid mode, positive importing, negative exporting
+	BatteryPolicy          BatteryPolicy              `yaml:"batteryPolicy"`
+	SolarControl           SolarControl               `yaml:"solarControl"`
+	energy                 energy.Source              // nil if there is nothing to read from
+	ShutdownAmps           int                        `yaml:"shutdownAmps"` // charge rate left on the secondaries when the controller stops, 0 stops charging
+	Secondaries            map[string]SecondaryConfig `yaml:"secondaries"`  // load balancing settings keyed by the secondary ID
+	Vehicles               map[string]VehicleProfile  `yaml:"vehicles"`     // charging profiles keyed by VIN
+	vehicleEnergy          map[string]*dailyEnergy    // the energy given to each vehicle today, keyed by VIN
+	HistoryPath            string                     `yaml:"historyPath"` // where the charging sessions are stored, defaults to history.db beside the config
+	Tariff                 Tariff                     `yaml:"tariff"`      // the price of energy, and when the cars can charge from the grid
+	tariffWindow           int                        // the tariff window at the last check, -1 outside the windows
+	limitAmps              int                        // the amps the tariff windows or the solar following allow until the next check
+	limited                bool                       // false when availableAmps applies rather than limitAmps
+	shuttingDown           bool                       // set by Shutdown, nothing but the safe rates is sent to the secondaries after it
+	MQTT                   MQTTConfig                 `yaml:"mqtt"`
+	mqtt                   *mqtt.Client               // nil if mqtt is turned off
+	discovered             map[string]string          // what was last published to home assistant, keyed by the secondary ID
+	history                *history.Store             // nil if the store couldn't be opened
+	solarAmps              float64                    // the amps solar could supply to the chargers at the last energy source check
+	lastPowerwall          powerwallReading           // what the energy source reported at the last check
+	solar                  solarState                 // the smoothing and decisions of the solar following
+	TeslaAPITokens         []*TeslaAPIUser            `yaml:"-"`              // slice of all known tesla api tokens, replaced rather than changed
+	SecretsPath            string                     `yaml:"secretsPath"`    // where the tesla api tokens are stored, defaults to secrets.enc beside the config
+	SecretsKeyFile         string                     `yaml:"secretsKeyFile"` // the key for the secrets if TWC_SECRETS_KEY isn't set, defaults to secrets.key beside the config
+	secrets                *secrets.Store             // nil if the secrets can't be stored
+	VehicleAPIURL          string                     `yaml:"vehicleAPIURL"` // the tesla owner api, or fake://<vin>,<vin> for a fake, empty for the real one
+	vehicleAPIURL          string                     // where the vehicle api is once any fake is started
+	VehicleAmpsInterval    int                        `yaml:"vehicleAmpsInterval"` // the least seconds between raising the charging amps of a vehicle, defaults to 60, they are lowered at most every 10 seconds
+	newVehicleAPI          vehicleAPIFunc
+	timeLastAccountsCheck  int64          `yaml:"-"`
+	timeLastVINCron        int64          `yaml:"-"`
+	timeLastStatePoll      int64          `yaml:"-"`
+	timeLastSecondaryPoll  int64          `yaml:"-"`
+	timeLastPowerwallCheck int64          `yaml:"-"`
+	timeLastSessionSave    int64          `yaml:"-"`
+	timeLastPlan           int64          `yaml:"-"`
+	twcNextHeartbeatID     int            `yaml:"-"`
+	LEDSOn                 bool           `yaml:"ledEnable"`
+	LEDController          *ledStrip      `yaml:"-"`
+	LEDValues              map[int]uint32 `yaml:"-"`
+	LEDCharging            bool           `yaml:"-"`
 }
 
 // TeslaAPIUser holds the API user
 type TeslaAPIUser struct {
-	Username string
-	Token    *tesla.Token
+	Username     string
+	Token        *vehicle.Token
+	RefreshToken string    // used to get a new token before this one expires
+	Refreshed    time.Time // when the token was last refreshed, zero if it hasn't been
+	RefreshError string    // why the last refresh failed, empty if it worked
 }
 
 // SerialConfig contains the serial port configuration
 type SerialConfig struct {
-	DevicePath string `yaml:"port"`
+	DevicePath string `yaml:"port"` // a serial device path, or tcp://host:port for an RS485 to ethernet bridge
 	BaudRate   int    `yaml:"baudRate"`
 }
 
@@ -83,14 +130,17 @@ func getEnv(key, fallback string) string {
 }
 
 // NewPrimary creates a new primary TWC controller.
-func NewPrimary(primary TWCPrimary, port *serial.Port) (*TWCPrimary, error) {
-	// func NewPrimary(newPrimaryID []byte, wiringMaxAmpsAllTWC int, wiringMaxAmpsPerTWC int, port *serial.Port, DebugLevel int, sign []byte, configPath string) (*TWCPrimary, error) {
+func NewPrimary(primary TWCPrimary, port transport.Transport) (*TWCPrimary, error) {
+	// func NewPrimary(newPrimaryID []byte, wiringMaxAmpsAllTWC int, wiringMaxAmpsPerTWC int, port transport.Transport, DebugLevel int, sign []byte, configPath string) (*TWCPrimary, error) {
 	if primary.SupplyPhases != 1 && primary.SupplyPhases != 3 {
 		return nil, fmt.Errorf("supply phases should be 1 or 2")
 	}
 	if primary.SupplyVoltage < 100 || primary.SupplyVoltage > 260 {
 		return nil, fmt.Errorf("supply voltage should be between 100 or 260")
 	}
+	if err := primary.Tariff.validate(); err != nil {
+		return nil, err
+	}
 
 	// LED Controller setup
 	opt := ws2811.DefaultOptions
@@ -119,7 +169,71 @@ func NewPrimary(primary TWCPrimary, port *serial.Port) (*TWCPrimary, error) {
 	primary.timeLastStatePoll = time.Now().UTC().Unix()
 	primary.timeLastSecondaryPoll = time.Now().UTC().Unix()
 	primary.timeLastPowerwallCheck = time.Now().UTC().Unix()
-	primary.port = port
+	primary.timeLastSessionSave = time.Now().UTC().Unix()
+	primary.tariffWindow = -1
+	primary.mu = &sync.RWMutex{}
+	primary.vehicleEnergy = map[string]*dailyEnergy{}
+	if primary.HistoryPath == "" {
+		primary.HistoryPath = filepath.Join(filepath.Dir(primary.ConfigPath), "history.db")
+	}
+	primary.history, err = history.OpenStore(primary.HistoryPath)
+	if err != nil {
+		// the controller is still useful without the history, so carry on without it
+		log.Println(log2JSONString(LogData{
+			Type:    "ERROR",
+			Source:  "sessions",
+			Message: fmt.Sprintf("Unable to open the session history at %s: %v", primary.HistoryPath, err),
+		}))
+	}
+	var fake *vehicle.Fake
+	primary.vehicleAPIURL, fake, err = vehicle.Open(primary.VehicleAPIURL)
+	if err != nil {
+		// carry on with the real api
+		log.Println(log2JSONString(LogData{
+			Type:    "ERROR",
+			Source:  "accounts",
+			Message: fmt.Sprintf("Unable to start the vehicle api at %s: %v", primary.VehicleAPIURL, err),
+		}))
+	} else if fake != nil && primary.debugLevel() >= 1 {
+		log.Println(log2JSONString(LogData{
+			Type:    "INFO",
+			Source:  "accounts",
+			Message: fmt.Sprintf("Using a fake vehicle api at %s", fake.URL),
+		}))
+	}
+	vehicleAPIURL := primary.vehicleAPIURL
+	primary.newVehicleAPI = func(accessToken string) vehicle.API {
+		return vehicle.NewTesla(vehicleAPIURL, accessToken)
+	}
+	primary.secrets, err = primary.openSecrets()
+	if err != nil {
+		// the accounts still work until the controller restarts
+		log.Println(log2JSONString(LogData{
+			Type:    "ERROR",
+			Source:  "accounts",
+			Message: fmt.Sprintf("Unable to open the secrets at %s: %v", primary.SecretsPath, err),
+		}))
+	}
+	primary.mqtt, err = primary.newMQTTClient()
+	if err != nil {
+		// carry on without mqtt, the web ui is still there to fix the settings
+		log.Println(log2JSONString(LogData{
+			Type:    "ERROR",
+			Source:  "mqtt",
+			Message: fmt.Sprintf("Unable to set up mqtt: %v", err),
+		}))
+	}
+	primary.energy, err = primary.newEnergySource()
+	if err != nil {
+		// solar following is turned off until the energy source is fixed
+		log.Println(log2JSONString(LogData{
+			Type:    "ERROR",
+			Source:  "energy",
+			Message: fmt.Sprintf("Unable to set up the energy source: %v", err),
+		}))
+	}
+	primary.bus = newBusScheduler(port, primary.handleFrame, primary.debugLevel)
+	go primary.bus.Run()
 	primary.LEDController = ls
 	primary.LEDCharging = false
 	primary.LEDValues = map[int]uint32{
@@ -136,8 +250,50 @@ func NewPrimary(primary TWCPrimary, port *serial.Port) (*TWCPrimary, error) {
 	return &primary, nil
 }
 
+// debugLevel returns the current debug level, it is safe to call with or without the lock held
+func (p *TWCPrimary) debugLevel() int {
+	return int(atomic.LoadInt32(&p.DebugLevel))
+}
+
+// setDebugLevel changes the debug level of the primary and all the secondaries, the lock must be held
+func (p *TWCPrimary) setDebugLevel(debugLevel int) {
+	atomic.StoreInt32(&p.DebugLevel, int32(debugLevel))
+	for _, twc := range p.knownTWCs {
+		twc.DebugLevel = debugLevel
+	}
+}
+
+// snapshot returns a copy of the primary that is safe to read without the lock, used when rendering pages
+func (p *TWCPrimary) snapshot() TWCPrimary {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	primary := *p
+	primary.knownTWCs = nil
+	primary.TeslaAPITokens = append([]*TeslaAPIUser{}, p.TeslaAPITokens...)
+	primary.LEDValues = map[int]uint32{}
+	for k, v := range p.LEDValues {
+		primary.LEDValues[k] = v
+	}
+	primary.Secondaries = map[string]SecondaryConfig{}
+	for k, v := range p.Secondaries {
+		primary.Secondaries[k] = v
+	}
+	primary.Vehicles = map[string]VehicleProfile{}
+	for k, v := range p.Vehicles {
+		primary.Vehicles[k] = v
+	}
+	primary.vehicleEnergy = nil
+	primary.solar.decisions = nil
+	primary.discovered = nil
+	primary.history = nil
+	primary.secrets = nil
+	primary.mqtt = nil
+	return primary
+}
+
+// writeConfig saves the configuration, the lock must not be held
 func (p *TWCPrimary) writeConfig() error {
-	d, _ := yaml.Marshal(p)
+	d, _ := yaml.Marshal(p.snapshot())
 	err := ioutil.WriteFile(p.ConfigPath, d, 0644)
 	if err != nil {
 		return fmt.Errorf(`{"error":"unable to write config file: %v"}`, err)
@@ -146,7 +302,7 @@ func (p *TWCPrimary) writeConfig() error {
 }
 
 func (p *TWCPrimary) sendPrimaryLinkReady1() (int64, error) {
-	if p.DebugLevel >= 9 {
+	if p.debugLevel() >= 9 {
 		log.Println(log2JSONString(LogData{
 			Type:    "INFO",
 			Source:  "primary",
@@ -154,17 +310,12 @@ func (p *TWCPrimary) sendPrimaryLinkReady1() (int64, error) {
 			Message: "Sending primary linkready1",
 		}))
 	}
-	msg := append(
-		append(
-			[]byte{0xFC, 0xE1},
-			p.ID...),
-		p.sign...)
-	padBytes(&msg)
-	return SendMessage(p.DebugLevel, p.port, msg)
+	msg := protocol.NewPrimaryLinkReady(protocol.PrimaryLinkReady1, p.ID, p.sign[0]).Encode(2)
+	return p.bus.send(msg, priorityHeartbeat)
 }
 
 func (p *TWCPrimary) sendPrimaryLinkReady2() (int64, error) {
-	if p.DebugLevel >= 9 {
+	if p.debugLevel() >= 9 {
 		log.Println(log2JSONString(LogData{
 			Type:    "INFO",
 			Source:  "primary",
@@ -172,18 +323,28 @@ func (p *TWCPrimary) sendPrimaryLinkReady2() (int64, error) {
 			Message: "Sending primary linkready2",
 		}))
 	}
-	msg := append(
-		append(
-			[]byte{0xFB, 0xE2},
-			p.ID...),
-		p.sign...)
-	padBytes(&msg)
-	return SendMessage(p.DebugLevel, p.port, msg)
+	msg := protocol.NewPrimaryLinkReady(protocol.PrimaryLinkReady2, p.ID, p.sign[0]).Encode(2)
+	return p.bus.send(msg, priorityHeartbeat)
+}
+
+// heartbeatSent records when the last linkready or heartbeat was sent, so the next heartbeat isn't sent too soon
+func (p *TWCPrimary) heartbeatSent(timeLastTx int64) {
+	p.mu.Lock()
+	p.timeLastTx = timeLastTx
+	p.mu.Unlock()
 }
 
-// sendChargeRate sends the desiredcharge rate to the receiver
+// sendChargeRate sends the desiredcharge rate to the receiver, and remembers it as the amps offered to the
+// secondary, the lock must not be held
 func (p *TWCPrimary) sendChargeRate(secondaryID []byte, chargeRate []byte, cmd byte) (int64, error) {
-	if p.DebugLevel >= 9 {
+	return p.queueChargeRate(secondaryID, chargeRate, cmd, false)
+}
+
+// queueChargeRate sends the charge rate to the receiver, once the controller is shutting down only the safe rates
+// sent by Shutdown go through, the check and the queueing are done under the lock so a charge rate worked out
+// before the shutdown can't be written after the safe rate
+func (p *TWCPrimary) queueChargeRate(secondaryID []byte, chargeRate []byte, cmd byte, shutdown bool) (int64, error) {
+	if p.debugLevel() >= 9 {
 		// displaying the chargerate we need to divide the given value by 100
 		cr := float64(Bytes2Dec2(chargeRate, true) / 100)
 		log.Println(log2JSONString(LogData{
@@ -194,20 +355,27 @@ func (p *TWCPrimary) sendChargeRate(secondaryID []byte, chargeRate []byte, cmd b
 			Message:  fmt.Sprintf("Sending charge rate %05.2fA to secondary", cr),
 		}))
 	}
-	msg := append(
-		append(
-			append(
-				[]byte{0xFB, 0xE0},
-				p.ID...),
-			secondaryID...),
-		[]byte{cmd, chargeRate[1], chargeRate[0]}...)
-	padBytes(&msg)
-	return SendMessage(p.DebugLevel, p.port, msg)
+	msg := protocol.NewPrimaryHeartbeat(p.ID, secondaryID, protocol.PrimaryHeartbeatData{
+		Command: cmd,
+		Amps:    Bytes2Dec2(chargeRate, true),
+	}).Encode(2)
+	p.mu.Lock()
+	if p.shuttingDown && !shutdown {
+		p.mu.Unlock()
+		return 0, errShuttingDown
+	}
+	if twc, ok := p.GetSecondary(secondaryID); ok {
+		twc.AvailableAmps = append([]byte{}, chargeRate...)
+	}
+	r := p.bus.enqueue(msg, priorityCommand, 0, nil)
+	p.mu.Unlock()
+	err := <-r.written
+	return r.timeSent, err
 }
 
 // sendStopCommand sends the desiredcharge rate to the receiver
 func (p *TWCPrimary) sendStopCommand(secondaryID []byte) (int64, error) {
-	if p.DebugLevel >= 9 {
+	if p.debugLevel() >= 9 {
 		log.Println(log2JSONString(LogData{
 			Type:     "INFO",
 			Source:   "primary",
@@ -216,18 +384,13 @@ func (p *TWCPrimary) sendStopCommand(secondaryID []byte) (int64, error) {
 			Message:  "Sending stop command to secondary",
 		}))
 	}
-	msg := append(
-		append(
-			[]byte{0xFC, 0xB2},
-			p.ID...),
-		secondaryID...)
-	padBytes(&msg)
-	return SendMessage(p.DebugLevel, p.port, msg)
+	msg := protocol.NewPoll(protocol.StopCharging, p.ID, secondaryID).Encode(2)
+	return p.bus.send(msg, priorityCommand)
 }
 
 // sendStartCommand sends the desiredcharge rate to the receiver
 func (p *TWCPrimary) sendStartCommand(secondaryID []byte) (int64, error) {
-	if p.DebugLevel >= 9 {
+	if p.debugLevel() >= 9 {
 		log.Println(log2JSONString(LogData{
 			Type:     "INFO",
 			Source:   "primary",
@@ -236,16 +399,15 @@ func (p *TWCPrimary) sendStartCommand(secondaryID []byte) (int64, error) {
 			Message:  "Sending start command to secondary",
 		}))
 	}
-	msg := append(
-		append(
-			[]byte{0xFC, 0xB1},
-			p.ID...),
-		secondaryID...)
-	padBytes(&msg)
-	return SendMessage(p.DebugLevel, p.port, msg)
+	if p.isShuttingDown() {
+		// the cars were stopped or left at the safe rate by Shutdown
+		return 0, errShuttingDown
+	}
+	msg := protocol.NewPoll(protocol.StartCharging, p.ID, secondaryID).Encode(2)
+	return p.bus.send(msg, priorityCommand)
 }
 
-// HasTWC checks if the primary has a TWC already
+// HasTWC checks if the primary has a TWC already, the lock must be held
 func (p *TWCPrimary) HasTWC(id []byte) (int, bool) {
 	for i, item := range p.knownTWCs {
 		if bytes.Compare(item.TWCID, id) == 0 {
@@ -255,11 +417,11 @@ func (p *TWCPrimary) HasTWC(id []byte) (int, bool) {
 	return 0, false
 }
 
-// AddSecondary adds a secondary TWC to the primary.
+// AddSecondary adds a secondary TWC to the primary, the lock must be held
 func (p *TWCPrimary) AddSecondary(secondaryTWC *TWCSecondary, secondaryID []byte) {
 	_, ok := p.HasTWC(secondaryID)
 	if !ok {
-		if p.DebugLevel >= 12 {
+		if p.debugLevel() >= 12 {
 			log.Println(log2JSONString(LogData{
 				Type:     "INFO",
 				Source:   "primary",
@@ -268,16 +430,16 @@ func (p *TWCPrimary) AddSecondary(secondaryTWC *TWCSecondary, secondaryID []byte
 				Message:  "Secondary TWC is a new TWC",
 			}))
 		}
-		secondaryTWC, _ = NewTWCSecondary(secondaryID, p.WiringMaxAmpsPerTWC, p.port, p.WiringMaxAmpsAllTWC, p.DebugLevel)
+		secondaryTWC, _ = NewTWCSecondary(secondaryID, p.WiringMaxAmpsPerTWC, p.WiringMaxAmpsAllTWC, p.debugLevel())
 		p.knownTWCs = append(p.knownTWCs, secondaryTWC)
 	}
 }
 
-// GetSecondary returns a secondary TWC if one is already connected.
+// GetSecondary returns a secondary TWC if one is already connected, the lock must be held while using it
 func (p *TWCPrimary) GetSecondary(secondaryID []byte) (*TWCSecondary, bool) {
 	idx, ok := p.HasTWC(secondaryID)
 	if ok {
-		if p.DebugLevel >= 12 {
+		if p.debugLevel() >= 12 {
 			log.Println(log2JSONString(LogData{
 				Type:     "INFO",
 				Source:   "primary",
@@ -291,7 +453,7 @@ func (p *TWCPrimary) GetSecondary(secondaryID []byte) (*TWCSecondary, bool) {
 	return &TWCSecondary{}, false
 }
 
-// RemoveSecondary removes a secondary TWC.
+// RemoveSecondary removes a secondary TWC, the lock must be held
 func (p *TWCPrimary) RemoveSecondary(i int) {
 	p.knownTWCs[i] = p.knownTWCs[len(p.knownTWCs)-1]
 	p.knownTWCs = p.knownTWCs[:len(p.knownTWCs)-1]
@@ -314,8 +476,8 @@ func (p *TWCPrimary) PreStart() {
 	time.Sleep(2 * time.Second)
 }
 
-// Run runs the primary controller.
-func (p *TWCPrimary) Run() {
+// Run runs the primary controller until the context is cancelled.
+func (p *TWCPrimary) Run(ctx context.Context) {
 	var idxSecondaryToSendNextHeartbeat int
 
 	var vinSCount = 0
@@ -323,153 +485,125 @@ func (p *TWCPrimary) Run() {
 	var vinECount = 0
 	var kwhCount = 0
 	var plugCount = 0
+	// the polls are counted in seconds, the loop goes around far more often when there isn't a heartbeat to send
+	var lastPollCount int64
 
 	for {
-		time.Sleep(25 * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(25 * time.Millisecond):
+		}
 		now := time.Now().UTC().Unix()
 
-		if p.numInitMsgsToSend > 5 {
-			p.timeLastTx, _ = p.sendPrimaryLinkReady1()
-			time.Sleep(100 * time.Millisecond)
+		p.mu.Lock()
+		numInitMsgsToSend := p.numInitMsgsToSend
+		if numInitMsgsToSend > 0 {
 			p.numInitMsgsToSend--
+		}
+		p.mu.Unlock()
+		if numInitMsgsToSend > 5 {
+			timeLastTx, _ := p.sendPrimaryLinkReady1()
+			p.heartbeatSent(timeLastTx)
+			time.Sleep(100 * time.Millisecond)
 			continue
-		} else if p.numInitMsgsToSend > 0 {
-			p.timeLastTx, _ = p.sendPrimaryLinkReady2()
+		} else if numInitMsgsToSend > 0 {
+			timeLastTx, _ := p.sendPrimaryLinkReady2()
+			p.heartbeatSent(timeLastTx)
 			time.Sleep(100 * time.Millisecond)
-			p.numInitMsgsToSend = p.numInitMsgsToSend - 1
 			continue
 		} else {
 			// @TODO: remove this after testing that it works in cron.go
 			// After finishing the 5 startup linkready1 and linkready2
-			if (now - p.timeLastTx) > 0 {
-				if len(p.knownTWCs) > 0 {
-					secondaryTWC := p.knownTWCs[idxSecondaryToSendNextHeartbeat]
-					if (now - secondaryTWC.TimeLastRx) >= 26 {
-						if p.DebugLevel >= 12 {
-							log.Println(log2JSONString(LogData{
-								Type:     "INFO",
-								Source:   "primary",
-								Sender:   fmt.Sprintf("%x", p.ID),
-								Receiver: fmt.Sprintf("%x", secondaryTWC.TWCID),
-								Message:  "Have not heard from secondary TWC for 26 seconds, removing.",
-							}))
-						}
-						p.RemoveSecondary(idxSecondaryToSendNextHeartbeat)
-					} else {
-						if p.DebugLevel >= 12 {
-							log.Println(log2JSONString(LogData{
-								Type:     "INFO",
-								Source:   "primary",
-								Sender:   fmt.Sprintf("%x", p.ID),
-								Receiver: fmt.Sprintf("%x", secondaryTWC.TWCID),
-								Message:  "Sending heartbeat to secondary TWC",
-							}))
-						}
-						p.timeLastTx, _ = secondaryTWC.sendPrimaryHeartbeat(p.port, p.ID)
-						time.Sleep(200 * time.Millisecond)
+			var heartbeat, heartbeatTo []byte
+			var closed *history.Session
+			var removed []byte
+			p.mu.Lock()
+			if (now-p.timeLastTx) > 0 && len(p.knownTWCs) > 0 {
+				if idxSecondaryToSendNextHeartbeat >= len(p.knownTWCs) {
+					idxSecondaryToSendNextHeartbeat = 0
+				}
+				secondaryTWC := p.knownTWCs[idxSecondaryToSendNextHeartbeat]
+				if (now - secondaryTWC.TimeLastRx) >= 26 {
+					if p.debugLevel() >= 12 {
+						log.Println(log2JSONString(LogData{
+							Type:     "INFO",
+							Source:   "primary",
+							Sender:   fmt.Sprintf("%x", p.ID),
+							Receiver: fmt.Sprintf("%x", secondaryTWC.TWCID),
+							Message:  "Have not heard from secondary TWC for 26 seconds, removing.",
+						}))
 					}
-					idxSecondaryToSendNextHeartbeat++
-					if idxSecondaryToSendNextHeartbeat >= len(p.knownTWCs) {
-						idxSecondaryToSendNextHeartbeat = 0
+					closed = p.closeSession(secondaryTWC)
+					removed = secondaryTWC.TWCID
+					p.RemoveSecondary(idxSecondaryToSendNextHeartbeat)
+				} else {
+					if p.debugLevel() >= 12 {
+						log.Println(log2JSONString(LogData{
+							Type:     "INFO",
+							Source:   "primary",
+							Sender:   fmt.Sprintf("%x", p.ID),
+							Receiver: fmt.Sprintf("%x", secondaryTWC.TWCID),
+							Message:  "Sending heartbeat to secondary TWC",
+						}))
 					}
+					heartbeat = secondaryTWC.primaryHeartbeat(p.ID)
+					heartbeatTo = secondaryTWC.TWCID
+				}
+				idxSecondaryToSendNextHeartbeat++
+				if idxSecondaryToSendNextHeartbeat >= len(p.knownTWCs) {
+					idxSecondaryToSendNextHeartbeat = 0
 				}
 			}
+			p.mu.Unlock()
+			p.saveSessions(closed)
+			if removed != nil {
+				p.mqttForgetSecondary(removed)
+			}
+			if heartbeat != nil {
+				// the reply is handled as it arrives, waiting for it just paces the loop
+				_, _ = p.bus.request(heartbeat, priorityHeartbeat, protocol.SecondaryHeartbeat, heartbeatTo)
+				p.heartbeatSent(time.Now().UTC().Unix())
+			}
 		}
-		p.ReadMessage()
 
-		if vinSCount == 9 {
-			for _, twc := range p.knownTWCs {
-				if p.DebugLevel >= 15 {
-					log.Println(log2JSONString(LogData{
-						Type:     "INFO",
-						Source:   "polling",
-						Sender:   fmt.Sprintf("%x", p.ID),
-						Receiver: fmt.Sprintf("%x", twc.TWCID),
-						Message:  "Poll Secondary for VIN start",
-					}))
-				}
-				msg := append(append([]byte{0xFB, 0xEE}, p.ID...), twc.TWCID...)
-				padBytes(&msg)
-				_, _ = SendMessage(p.DebugLevel, p.port, msg)
-				time.Sleep(200 * time.Millisecond)
-				p.ReadMessage()
+		// ask newly linked secondaries what they are, this only needs to happen once
+		var unpolled [][]byte
+		p.mu.Lock()
+		for _, twc := range p.knownTWCs {
+			if twc.linked && !twc.infoPolled {
+				twc.infoPolled = true
+				unpolled = append(unpolled, twc.TWCID)
 			}
+		}
+		p.mu.Unlock()
+		for _, twcID := range unpolled {
+			p.pollSecondaryInfo(twcID)
+		}
+
+		if now == lastPollCount {
+			continue
+		}
+		lastPollCount = now
+
+		if vinSCount == 9 {
+			p.pollSecondaries(protocol.PollVINStart, "Poll Secondary for VIN start")
 			vinSCount = 0
 		}
 		if vinMCount == 10 {
-			for _, twc := range p.knownTWCs {
-				if p.DebugLevel >= 15 {
-					log.Println(log2JSONString(LogData{
-						Type:     "INFO",
-						Source:   "polling",
-						Sender:   fmt.Sprintf("%x", p.ID),
-						Receiver: fmt.Sprintf("%x", twc.TWCID),
-						Message:  "Poll Secondary for VIN middle",
-					}))
-				}
-				msg := append(append([]byte{0xFB, 0xEF}, p.ID...), twc.TWCID...)
-				padBytes(&msg)
-				_, _ = SendMessage(p.DebugLevel, p.port, msg)
-				time.Sleep(200 * time.Millisecond)
-				p.ReadMessage()
-			}
+			p.pollSecondaries(protocol.PollVINMiddle, "Poll Secondary for VIN middle")
 			vinMCount = 0
 		}
 		if vinECount == 11 {
-			for _, twc := range p.knownTWCs {
-				if p.DebugLevel >= 15 {
-					log.Println(log2JSONString(LogData{
-						Type:     "INFO",
-						Source:   "polling",
-						Sender:   fmt.Sprintf("%x", p.ID),
-						Receiver: fmt.Sprintf("%x", twc.TWCID),
-						Message:  "Poll Secondary for VIN end",
-					}))
-				}
-				msg := append(append([]byte{0xFB, 0xF1}, p.ID...), twc.TWCID...)
-				padBytes(&msg)
-				_, _ = SendMessage(p.DebugLevel, p.port, msg)
-				time.Sleep(200 * time.Millisecond)
-				p.ReadMessage()
-			}
+			p.pollSecondaries(protocol.PollVINEnd, "Poll Secondary for VIN end")
 			vinECount = 0
 		}
 		if kwhCount == 12 {
-			for _, twc := range p.knownTWCs {
-				if p.DebugLevel >= 15 {
-					log.Println(log2JSONString(LogData{
-						Type:     "INFO",
-						Source:   "polling",
-						Sender:   fmt.Sprintf("%x", p.ID),
-						Receiver: fmt.Sprintf("%x", twc.TWCID),
-						Message:  "Poll secondary for stats",
-					}))
-				}
-				msg := append(append([]byte{0xFB, 0xEB}, p.ID...), twc.TWCID...)
-				padBytes(&msg)
-				_, _ = SendMessage(p.DebugLevel, p.port, msg)
-				time.Sleep(200 * time.Millisecond)
-				p.ReadMessage()
-			}
+			p.pollSecondaries(protocol.PollKWH, "Poll secondary for stats")
 			kwhCount = 0
 		}
 		if plugCount == 5 {
-			for _, twc := range p.knownTWCs {
-				if p.DebugLevel >= 15 {
-					log.Println(log2JSONString(LogData{
-						Type:     "INFO",
-						Source:   "polling",
-						Sender:   fmt.Sprintf("%x", p.ID),
-						Receiver: fmt.Sprintf("%x", twc.TWCID),
-						Message:  "Poll Secondary for plug state",
-					}))
-				}
-				msg := append(append([]byte{0xFB, 0xB4}, p.ID...), twc.TWCID...)
-				padBytes(&msg)
-				_, _ = SendMessage(p.DebugLevel, p.port, msg)
-				time.Sleep(200 * time.Millisecond)
-				p.ReadMessage()
-			}
+			p.pollSecondaries(protocol.PollPlugState, "Poll Secondary for plug state")
 			plugCount = 0
 		}
 
@@ -481,159 +615,100 @@ func (p *TWCPrimary) Run() {
 	}
 }
 
-// ReadMessage reads message from serial port
-func (p *TWCPrimary) ReadMessage() {
-	var ignoredData []byte
-	var lastTWCResponseMsg []byte
-	var msgRxCount = 0
-	var msg []byte
-	var msgLen int
-	// get the message
-	for {
-		// this section is where we read in the bytes that may be on the serial line until we have our data
-		var dataLen int
-		var data []byte
-		dataLen = 1
-		buf := make([]byte, dataLen)
-		n, _ := p.port.Read(buf[:])
-		data = buf[:n]
-		if len(data) == 0 {
-			break
-		}
-		if msgLen == 0 && data[0] != 0xC0 {
-			if p.DebugLevel >= 12 {
-				log.Println(log2JSONString(LogData{
-					Type:    "INFO",
-					Source:  "primary",
-					Sender:  fmt.Sprintf("%x", p.ID),
-					Message: "Ignoring data if length is 0 or first byte is not C0",
-				}))
-			}
-			ignoredData = append(ignoredData, data...)
-		} else if msgLen > 0 && msgLen < 15 && data[0] == 0xC0 {
-			if p.DebugLevel >= 12 {
-				log.Println(log2JSONString(LogData{
-					Type:    "INFO",
-					Source:  "primary",
-					Sender:  fmt.Sprintf("%x", p.ID),
-					Message: "Found end of message before full message received",
-				}))
-				// found end of message before full message received
-			}
-			msg = data
-			msgLen = 1
-		}
-		if msgLen == 0 {
-			msg = []byte{}
-		}
-		msg = append(msg, data...)
-		msgLen++
-		if msgLen >= 16 && data[0] == 0xC0 {
-			break
-		}
-	}
+// errShuttingDown is returned for any charge rate or start command sent once Shutdown has begun
+var errShuttingDown = errors.New("controller is shutting down")
 
-	if msgLen >= 16 {
-		msg = unescapeMessage(msg, msgLen)
-		msgLen = 0
-		msgRxCount++
-		if bytes.Compare(lastTWCResponseMsg, []byte{}) == 0 &&
-			bytes.Compare(msg[0:2], []byte{0xFB, 0xE0}) == 0 && bytes.Compare(msg[0:2], []byte{0xFD, 0xE0}) == 0 &&
-			bytes.Compare(msg[0:2], []byte{0xFC, 0xE1}) == 0 && bytes.Compare(msg[0:2], []byte{0xFB, 0xE2}) == 0 &&
-			bytes.Compare(msg[0:2], []byte{0xFD, 0xE2}) == 0 && bytes.Compare(msg[0:2], []byte{0xFB, 0xEB}) == 0 &&
-			bytes.Compare(msg[0:2], []byte{0xFD, 0xEB}) == 0 && bytes.Compare(msg[0:2], []byte{0xFD, 0xE0}) == 0 {
-			lastTWCResponseMsg = msg
-		}
-		var debugBytes []byte
-		for _, dByte := range msg {
-			debugByte := []byte(fmt.Sprintf("%0X ", dByte))
-			debugBytes = append(debugBytes, debugByte...)
+// isShuttingDown returns true once Shutdown has begun
+func (p *TWCPrimary) isShuttingDown() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.shuttingDown
+}
+
+// Shutdown leaves every secondary at the shutdown charge rate, or stops them charging, turns off the LEDs and
+// saves the config and any open sessions before closing the bus. Run and LEDLoop must have returned before this is called
+func (p *TWCPrimary) Shutdown(ctx context.Context) error {
+	// the crons and the frame handler can still be running, so nothing else is sent to the secondaries from here
+	p.mu.Lock()
+	p.shuttingDown = true
+	p.mu.Unlock()
+	cfg := p.snapshot()
+	shutdownAmps := cfg.ShutdownAmps
+	if shutdownAmps > cfg.WiringMaxAmpsPerTWC {
+		shutdownAmps = cfg.WiringMaxAmpsPerTWC
+	}
+	for _, twcID := range p.secondaryIDs() {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-		if p.DebugLevel >= 1 {
-			if p.DebugLevel > 1 {
-				log.Println(log2JSONString(LogData{
-					Type:    "DEBUG",
-					Source:  "primary",
-					Message: fmt.Sprintf("Rx@: (%0X) %s", ignoredData, debugBytes),
-				}))
-			} else {
-				log.Println(log2JSONString(LogData{
-					Type:    "DEBUG",
-					Source:  "primary",
-					Message: fmt.Sprintf("Rx@: %s", debugBytes),
-				}))
+		if shutdownAmps > 0 {
+			_, err := p.queueChargeRate(twcID, Dec2Bytes(uint16(shutdownAmps*100)), byte(0x09), true)
+			if err != nil {
+				return err
 			}
+			continue
 		}
-		ignoredData = []byte{}
-		if len(msg) != 14 && len(msg) != 16 && len(msg) != 20 {
-			// ignoring message of unexpected length
-			var debugBytes []byte
-			for _, debugB := range msg {
-				debubByte := []byte(fmt.Sprintf("%X ", debugB))
-				debugBytes = append(debugBytes, debubByte...)
-			}
-			if p.DebugLevel >= 2 {
-				log.Println(log2JSONString(LogData{
-					Type:    "DEBUG",
-					Source:  "primary",
-					Sender:  fmt.Sprintf("%x", p.ID),
-					Message: fmt.Sprintf("Ignoring message of unexpected length, msg: %s", debugBytes),
-				}))
-			}
-			return
+		_, err := p.queueChargeRate(twcID, []byte{0x00, 0x00}, byte(0x05), true)
+		if err != nil {
+			return err
 		}
-		checksumExpected := msg[len(msg)-1]
-		checksum := 0
-		for b := 1; b < len(msg)-1; b++ {
-			checksum = checksum + int(msg[b])
+		_, err = p.sendStopCommand(twcID)
+		if err != nil {
+			return err
 		}
-		if byte(checksum&0xFF) != checksumExpected {
-			// checksum does not match
-			var debugBytes []byte
-			for _, debugB := range msg {
-				debubByte := []byte(fmt.Sprintf("%X ", debugB))
-				debugBytes = append(debugBytes, debubByte...)
-			}
-			if p.DebugLevel >= 2 {
-				log.Println(log2JSONString(LogData{
-					Type:    "DEBUG",
-					Source:  "primary",
-					Sender:  fmt.Sprintf("%x", p.ID),
-					Message: fmt.Sprintf("Checksum does not match %x %x expected: %x; msg: %s", checksum&0xFF, byte(checksum&0xFF), checksumExpected, debugBytes),
-				}))
-			}
-			return
+	}
+	if err := p.LEDController.wipe(uint32(0x000000)); err != nil {
+		return err
+	}
+	p.LEDController.ws.Fini()
+	if err := p.writeConfig(); err != nil {
+		return err
+	}
+	if p.history != nil {
+		// sessions that are still open are closed when the store is next opened
+		p.mu.RLock()
+		sessions := p.openSessions()
+		p.mu.RUnlock()
+		p.saveSessions(sessions...)
+		if err := p.history.Close(); err != nil {
+			return err
 		}
-		foundMsgMatch := false
-		p.isSecondaryReadyToLink(msg, &foundMsgMatch)
-		p.receiveSecondaryHeartbeatData(msg, &foundMsgMatch)
-		p.receivePeriodicPollData(msg, &foundMsgMatch)
-		p.receiveVinStart(msg, &foundMsgMatch)
-		p.receiveVinMiddle(msg, &foundMsgMatch)
-		p.receiveVinEnd(msg, &foundMsgMatch)
-		p.receivePlugState(msg, &foundMsgMatch)
-		p.isPrimaryTWC(msg, &foundMsgMatch)
 	}
+	return p.bus.Close()
 }
 
-// helper to generate the states for all connected TWCs
+// helper to generate the states for all connected TWCs, the copies are taken under the lock so each one is consistent
 func (p *TWCPrimary) getStats() []TWCSecondary {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	allSecondaries := []TWCSecondary{}
 	for _, twc := range p.knownTWCs {
-		allSecondaries = append(allSecondaries, *twc)
+		allSecondaries = append(allSecondaries, twc.snapshot())
 	}
 	return allSecondaries
 }
 
 func (p *TWCPrimary) getTWCStats(twcid []byte) TWCSecondary {
-	twc := &TWCSecondary{}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	twc, ok := p.GetSecondary(twcid)
 	if ok {
-		return *twc
+		return twc.snapshot()
 	}
 	return *twc
 }
 
+// secondaryIDs returns the IDs of all the known secondaries, so they can be polled without holding the lock
+func (p *TWCPrimary) secondaryIDs() [][]byte {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	ids := [][]byte{}
+	for _, twc := range p.knownTWCs {
+		ids = append(ids, twc.TWCID)
+	}
+	return ids
+}
+
 // CustomMessage send a msg directly to the serial port, will pad it to the required length and add any padding
 // Be careful
 func (p *TWCPrimary) CustomMessage(w http.ResponseWriter, r *http.Request) {
@@ -641,38 +716,47 @@ func (p *TWCPrimary) CustomMessage(w http.ResponseWriter, r *http.Request) {
 	msg := vars["msg"]
 	data, _ := hex.DecodeString(msg)
 	padBytes(&data)
-	_, _ = SendMessage(p.DebugLevel, p.port, data)
+	_, _ = p.bus.send(data, priorityCommand)
 	fmt.Fprintln(w, "")
 }
 
 // SetMaxAmpsHandler is the actual function that sets the maximum amps that all wall connectors can use
 func (p *TWCPrimary) SetMaxAmpsHandler(intAmps int) error {
-	totalAmps := intAmps * 100 // multiply by 100 to get use in the byte value
-	if totalAmps > (p.WiringMaxAmpsAllTWC * 100) {
+	p.mu.Lock()
+	if intAmps > p.WiringMaxAmpsAllTWC {
 		// if the given amps is more than the number of available amps, then set total amps to max available
-		totalAmps = p.WiringMaxAmpsAllTWC * 100
+		intAmps = p.WiringMaxAmpsAllTWC
 	}
-	if totalAmps == 0 {
-		p.AvailableAmps = 0
-	} else {
-		p.AvailableAmps = totalAmps / 100
+	if intAmps < 0 {
+		intAmps = 0
 	}
+	p.AvailableAmps = intAmps
+	p.limited = false
+	p.mu.Unlock()
 	err := p.writeConfig()
 	if err != nil {
 		return err
 	}
-	if p.knownTWCs != nil {
-		splitAmps := totalAmps / len(p.knownTWCs)
-		for _, twc := range p.knownTWCs {
-			// set the twc to have the number of amps available to it to use in the heartbeat
-			twc.AvailableAmps = Dec2Bytes(uint16(splitAmps))
-			if twc.AllowCharge {
-				_, err := p.sendChargeRate(twc.TWCID, Dec2Bytes(uint16(splitAmps)), byte(0x09))
-				if err != nil {
-					return err
-				}
-			}
-		}
+	return p.balance()
+}
+
+// setLimitAmps shares the amps the tariff windows or the solar following allow between the wall connectors until
+// the next check, unlike SetMaxAmpsHandler the configured available amps are left alone, below 0 goes back to them
+func (p *TWCPrimary) setLimitAmps(intAmps int) error {
+	p.mu.Lock()
+	if intAmps > p.WiringMaxAmpsAllTWC {
+		intAmps = p.WiringMaxAmpsAllTWC
 	}
-	return nil
+	p.limitAmps = intAmps
+	p.limited = intAmps >= 0
+	p.mu.Unlock()
+	return p.balance()
+}
+
+// availableAmps returns the amps shared between the wall connectors, the lock must be held
+func (p *TWCPrimary) availableAmps() int {
+	if p.limited {
+		return p.limitAmps
+	}
+	return p.AvailableAmps
 }
@@ -0,0 +1,272 @@
+package controller
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shreddedbacon/twcmanager/internal/allocator"
+	"github.com/shreddedbacon/twcmanager/internal/simulator"
+	"github.com/shreddedbacon/twcmanager/internal/transport"
+)
+
+// the simulated TWCs the tests talk to
+var (
+	testTWC1 = []byte{0x10, 0x01}
+	testTWC2 = []byte{0x10, 0x02}
+)
+
+// testVIN is the VIN of the car plugged into the first simulated TWC
+const testVIN = "5YJ3E7EB0KF000001"
+
+// testConfig returns the settings the tests run the primary with, the config and history are kept in a
+// directory that is removed when the test ends
+func testConfig(t *testing.T) TWCPrimary {
+	dir, err := ioutil.TempDir("", "twcmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return TWCPrimary{
+		ID:                  []byte{0x77, 0x77},
+		WiringMaxAmpsAllTWC: 32,
+		WiringMaxAmpsPerTWC: 32,
+		MinAmpsPerTWC:       6,
+		SupplyVoltage:       240,
+		SupplyPhases:        1,
+		AvailableAmps:       32,
+		ConfigPath:          filepath.Join(dir, "config.yml"),
+	}
+}
+
+// startPrimary runs the primary against a simulator on the other end of a pipe, setup adds the simulated TWCs
+// before either starts, everything is stopped when the test ends
+func startPrimary(t *testing.T, cfg TWCPrimary, opts simulator.Options, setup func(s *simulator.Simulator)) (*TWCPrimary, *simulator.Simulator) {
+	primaryEnd, simEnd := transport.Pipe()
+	sim := simulator.New(simEnd, opts)
+	setup(sim)
+	p, err := NewPrimary(cfg, primaryEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	go sim.Run(stop)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		close(stop)
+		_ = p.bus.Close()
+		if p.history != nil {
+			_ = p.history.Close()
+		}
+	})
+	return p, sim
+}
+
+// oneTWC adds a single simulated TWC with a car plugged in
+func oneTWC(s *simulator.Simulator) {
+	s.AddTWC(testTWC1, 3200)
+	_ = s.PlugIn(testTWC1, testVIN)
+}
+
+// waitFor fails the test if cond isn't true before the timeout
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out after %v waiting for %s", timeout, what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// secondary returns a copy of the secondary the primary knows as id
+func secondary(p *TWCPrimary, id []byte) (TWCSecondary, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	twc, ok := p.GetSecondary(id)
+	if !ok {
+		return TWCSecondary{}, false
+	}
+	return twc.snapshot(), true
+}
+
+// linked returns true once the primary has heard the secondary reply to a heartbeat
+func linked(p *TWCPrimary, id []byte) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	twc, ok := p.GetSecondary(id)
+	return ok && twc.linked
+}
+
+// offered returns the amps the simulated TWC was last offered by the primary
+func offered(sim *simulator.Simulator, id []byte) uint16 {
+	state, _ := sim.State(id)
+	return state.OfferedAmps
+}
+
+func TestPrimaryLinksWithSecondaries(t *testing.T) {
+	t.Parallel()
+	p, sim := startPrimary(t, testConfig(t), simulator.Options{}, func(s *simulator.Simulator) {
+		oneTWC(s)
+		s.AddTWC(testTWC2, 3200)
+	})
+	for _, id := range [][]byte{testTWC1, testTWC2} {
+		waitFor(t, 15*time.Second, "the secondaries to link", func() bool { return linked(p, id) })
+		if state, _ := sim.State(id); !state.Linked {
+			t.Fatalf("the simulator hasn't heard a heartbeat for %x", id)
+		}
+	}
+	waitFor(t, 15*time.Second, "the secondary info to be read", func() bool {
+		twc, _ := secondary(p, testTWC1)
+		return twc.FirmwareVersion != "" && twc.SerialNumber != "" && twc.Model != ""
+	})
+}
+
+func TestPrimaryChangesTheChargeRate(t *testing.T) {
+	t.Parallel()
+	p, sim := startPrimary(t, testConfig(t), simulator.Options{}, oneTWC)
+	waitFor(t, 15*time.Second, "the secondary to link", func() bool { return linked(p, testTWC1) })
+	// a car that is plugged in starts at the minimum, then the plug state polls move it to its share
+	waitFor(t, 15*time.Second, "the car to be offered the available amps", func() bool { return offered(sim, testTWC1) == 3200 })
+	for _, amps := range []int{16, 10, 24} {
+		if err := p.SetMaxAmpsHandler(amps); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, 5*time.Second, "the new charge rate", func() bool { return offered(sim, testTWC1) == uint16(amps*100) })
+		waitFor(t, 5*time.Second, "the car to draw the new charge rate", func() bool {
+			twc, _ := secondary(p, testTWC1)
+			return Bytes2Dec2(twc.ReportedAmpsActual, false) == uint16(amps*100)
+		})
+	}
+	// below the minimum the secondary is paused
+	if err := p.SetMaxAmpsHandler(4); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "the secondary to be paused", func() bool { return offered(sim, testTWC1) == 0 })
+}
+
+func TestPrimaryKeepsTheShutdownRate(t *testing.T) {
+	t.Parallel()
+	p, sim := startPrimary(t, testConfig(t), simulator.Options{}, oneTWC)
+	waitFor(t, 15*time.Second, "the secondary to link", func() bool { return linked(p, testTWC1) })
+	waitFor(t, 15*time.Second, "the car to be offered the available amps", func() bool { return offered(sim, testTWC1) == 3200 })
+	// what Shutdown does before it sends the safe rate
+	p.mu.Lock()
+	p.shuttingDown = true
+	p.mu.Unlock()
+	if _, err := p.queueChargeRate(testTWC1, Dec2Bytes(1000), byte(0x09), true); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "the safe rate", func() bool { return offered(sim, testTWC1) == 1000 })
+	// a cron or frame that was already running when the controller was asked to stop
+	if err := p.balance(); err != errShuttingDown {
+		t.Fatalf("balance returned %v once shutting down", err)
+	}
+	if _, err := p.sendChargeRate(testTWC1, Dec2Bytes(2400), byte(0x09)); err != errShuttingDown {
+		t.Fatalf("sendChargeRate returned %v once shutting down", err)
+	}
+	if _, err := p.sendStartCommand(testTWC1); err != errShuttingDown {
+		t.Fatalf("sendStartCommand returned %v once shutting down", err)
+	}
+	_ = p.SetMaxAmpsHandler(24)
+	time.Sleep(2 * time.Second)
+	if got := offered(sim, testTWC1); got != 1000 {
+		t.Fatalf("the secondary was offered %d after the safe rate", got)
+	}
+	if twc, _ := secondary(p, testTWC1); Bytes2Dec2(twc.AvailableAmps, true) != 1000 {
+		t.Fatalf("the primary remembers %v as offered after the safe rate", twc.AvailableAmps)
+	}
+}
+
+func TestPrimaryFollowsThePlug(t *testing.T) {
+	t.Parallel()
+	p, sim := startPrimary(t, testConfig(t), simulator.Options{}, func(s *simulator.Simulator) {
+		s.AddTWC(testTWC1, 3200)
+	})
+	plugState := func() int {
+		twc, _ := secondary(p, testTWC1)
+		return twc.PlugState
+	}
+	waitFor(t, 15*time.Second, "the secondary to link", func() bool { return linked(p, testTWC1) })
+	if got := plugState(); got != 0 {
+		t.Fatalf("the plug state is %d with nothing plugged in", got)
+	}
+	_ = sim.PlugIn(testTWC1, testVIN)
+	waitFor(t, 10*time.Second, "the car to be plugged in", func() bool { return plugState() != 0 })
+	waitFor(t, 10*time.Second, "the car to start charging", func() bool {
+		state, _ := sim.State(testTWC1)
+		return state.Charging
+	})
+	_ = sim.Unplug(testTWC1)
+	waitFor(t, 10*time.Second, "the car to be unplugged", func() bool { return plugState() == 0 })
+	_ = sim.PlugIn(testTWC1, testVIN)
+	waitFor(t, 10*time.Second, "the car to be plugged in again", func() bool { return plugState() != 0 })
+}
+
+func TestPrimaryReadsTheVIN(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the bus for too long for -short")
+	}
+	t.Parallel()
+	p, sim := startPrimary(t, testConfig(t), simulator.Options{}, oneTWC)
+	vin := func() string {
+		twc, _ := secondary(p, testTWC1)
+		return twc.vin()
+	}
+	waitFor(t, 45*time.Second, "the VIN to be read", func() bool { return vin() == testVIN })
+	// the VIN is forgotten when the car is unplugged, and the next car's is read
+	_ = sim.Unplug(testTWC1)
+	waitFor(t, 10*time.Second, "the VIN to be forgotten", func() bool { return vin() == "" })
+	_ = sim.PlugIn(testTWC1, "5YJ3E7EB0KF000002")
+	waitFor(t, 45*time.Second, "the next VIN to be read", func() bool { return vin() == "5YJ3E7EB0KF000002" })
+}
+
+func TestPrimaryRemovesSilentSecondaries(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the bus for too long for -short")
+	}
+	t.Parallel()
+	p, _ := startPrimary(t, testConfig(t), simulator.Options{SilentAfter: 5 * time.Second}, oneTWC)
+	waitFor(t, 5*time.Second, "the secondary to link", func() bool { return linked(p, testTWC1) })
+	silent := time.Now()
+	known := func() bool {
+		_, ok := secondary(p, testTWC1)
+		return ok
+	}
+	waitFor(t, 45*time.Second, "the secondary to be removed", func() bool { return !known() })
+	// the simulator stops 5 seconds after it starts, and the primary waits 26 seconds for it
+	if waited := time.Since(silent); waited < 20*time.Second {
+		t.Fatalf("the secondary was removed after %v, before it had been silent for 26 seconds", waited)
+	}
+}
+
+func TestPrimaryOffersATaperedCarLess(t *testing.T) {
+	t.Parallel()
+	p, sim := startPrimary(t, testConfig(t), simulator.Options{}, func(s *simulator.Simulator) {
+		oneTWC(s)
+		// the car's own charger only takes 16 amps
+		_ = s.SetCarMaxAmps(testTWC1, 1600)
+	})
+	waitFor(t, 15*time.Second, "the secondary to link", func() bool { return linked(p, testTWC1) })
+	tapered := uint16((16 + allocator.TaperMargin) * 100)
+	waitFor(t, 30*time.Second, "the car to be offered a little more than it takes", func() bool { return offered(sim, testTWC1) == tapered })
+	// the offer stays there rather than going back to the available amps while the car draws below it
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if amps := offered(sim, testTWC1); amps != tapered {
+			t.Fatalf("the tapered car was offered %d, want %d", amps, tapered)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
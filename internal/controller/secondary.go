@@ -6,13 +6,13 @@ import (
 	"log"
 	"time"
 
-	"github.com/tarm/serial"
+	"github.com/shreddedbacon/twcmanager/internal/allocator"
+	"github.com/shreddedbacon/twcmanager/internal/history"
+	"github.com/shreddedbacon/twcmanager/internal/protocol"
 )
 
 // TWCSecondary .
 type TWCSecondary struct {
-	port *serial.Port
-
 	TWCID      []byte `json:"ID"`
 	MaxAmps    int    `json:"maxAmps"`
 	DebugLevel int    `json:"debugLevel"`
@@ -50,14 +50,29 @@ type TWCSecondary struct {
 	VINMiddle          string `json:"vinMiddle"`
 	VINEnd             string `json:"vinEnd"`
 	PlugState          int    `json:"plugState"`
+	FirmwareVersion    string `json:"firmwareVersion"`
+	SerialNumber       string `json:"serialNumber"`
+	Model              string `json:"model"`       // the part number of the TWC
+	VehicleName        string `json:"vehicleName"` // from the profile of the vehicle that is plugged in
+
+	linked     bool             // set once the secondary has replied to a heartbeat
+	infoPolled bool             // set once the firmware version, serial number and model have been requested
+	paused     bool             // set while there aren't enough amps to give this secondary the minimum
+	profileVIN string           // the VIN of the vehicle whose profile has been applied
+	lastKWH    uint32           // the lifetime kWh at the last reading, used to work out the energy given to each vehicle
+	kwhRead    bool             // set once the lifetime kWh has been read
+	session    *history.Session // the charging session in progress, nil if nothing is plugged in
+	plan       *ChargePlan      // the plan for the goal of the vehicle plugged in, replaced rather than changed
+
+	vehicleAmps vehicleAmps     // setting the charging amps through the vehicle api
+	taper       allocator.Taper // whether the car has tapered off, updated with each heartbeat
 }
 
 // NewTWCSecondary creates a new secondary TWC.
-func NewTWCSecondary(newSecondaryID []byte, maxAmps int, port *serial.Port, wiringMaxAmpsPerTWC int, debugLevel int) (*TWCSecondary, error) {
+func NewTWCSecondary(newSecondaryID []byte, maxAmps int, wiringMaxAmpsPerTWC int, debugLevel int) (*TWCSecondary, error) {
 	now := time.Now().UTC().Unix()
 	return &TWCSecondary{
 		TimeLastRx:         now,
-		port:               port,
 		TWCID:              newSecondaryID,
 		MaxAmps:            maxAmps,
 		ProtocolVersion:    1,
@@ -75,9 +90,10 @@ func NewTWCSecondary(newSecondaryID []byte, maxAmps int, port *serial.Port, wiri
 }
 
 // ReceiveSecondaryHeartbeat parses the received heartbeat from the secondary TWC
-func (t *TWCSecondary) ReceiveSecondaryHeartbeat(heartbeatData []byte) {
+func (t *TWCSecondary) ReceiveSecondaryHeartbeat(heartbeatData protocol.SecondaryHeartbeatData) {
 	now := time.Now().UTC().Unix()
 	t.TimeLastRx = now
+	t.linked = true
 	if t.DebugLevel >= 9 {
 		log.Println(log2JSONString(LogData{
 			Type:     "INFO",
@@ -87,9 +103,9 @@ func (t *TWCSecondary) ReceiveSecondaryHeartbeat(heartbeatData []byte) {
 		}))
 	}
 
-	t.ReportedAmpsMax = []byte{heartbeatData[1], heartbeatData[2]}
-	t.ReportedAmpsActual = []byte{heartbeatData[3], heartbeatData[4]}
-	t.ReportedState = int(heartbeatData[0])
+	t.ReportedAmpsMax = []byte{byte(heartbeatData.AmpsMax >> 8), byte(heartbeatData.AmpsMax)}
+	t.ReportedAmpsActual = []byte{byte(heartbeatData.AmpsActual >> 8), byte(heartbeatData.AmpsActual)}
+	t.ReportedState = int(heartbeatData.State)
 
 	lastOffered := uint16(0)
 	if bytes.Compare(t.LastAmpsOffered, []byte{}) != 0 {
@@ -133,28 +149,53 @@ func (t *TWCSecondary) ReceiveSecondaryHeartbeat(heartbeatData []byte) {
 
 }
 
-func (t *TWCSecondary) sendPrimaryHeartbeat(port *serial.Port, primaryID []byte) (int64, error) {
-	if t.AllowCharge {
-		if t.DebugLevel >= 9 {
-			log.Println(log2JSONString(LogData{
-				Type:     "INFO",
-				Source:   "secondary",
-				Sender:   fmt.Sprintf("%x", primaryID),
-				Receiver: fmt.Sprintf("%x", t.TWCID),
-				Message:  "Sending hearbeat to secondary TWC",
-			}))
-		}
-		msg := append(append(append([]byte{0xFB, 0xE0}, primaryID...), t.TWCID...), t.primaryHeartbeatData...)
-		// send heartbeat with the available amperage to this twc
-		// msg := append(
-		// 	append(
-		// 		append(
-		// 			[]byte{0xFB, 0xE0},
-		// 			primaryID...),
-		// 		t.TWCID...),
-		// 	[]byte{0x05, t.AvailableAmps[1], t.AvailableAmps[0]}...)
-		// padBytes(&msg)
-		return SendMessage(t.DebugLevel, port, msg)
+// offeredAmps returns the amps the car was last offered, when the vehicle is setting the amps the TWC is left at
+// its maximum, so what the car was set to is what it was offered
+func (t *TWCSecondary) offeredAmps() int {
+	if t.paused {
+		return 0
+	}
+	if t.vehicleAmps.active && t.vehicleAmps.vin == t.vin() {
+		return t.vehicleAmps.amps
 	}
-	return time.Now().UTC().Unix(), nil
+	return int(Bytes2Dec2(t.AvailableAmps, true) / 100)
+}
+
+// primaryHeartbeat builds the next heartbeat to send to the secondary, or returns nil if the secondary has been disabled
+func (t *TWCSecondary) primaryHeartbeat(primaryID []byte) []byte {
+	if !t.AllowCharge {
+		return nil
+	}
+	if t.DebugLevel >= 9 {
+		log.Println(log2JSONString(LogData{
+			Type:     "INFO",
+			Source:   "secondary",
+			Sender:   fmt.Sprintf("%x", primaryID),
+			Receiver: fmt.Sprintf("%x", t.TWCID),
+			Message:  "Sending hearbeat to secondary TWC",
+		}))
+	}
+	return protocol.Frame{
+		Command:  protocol.PrimaryHeartbeat,
+		Sender:   primaryID,
+		Receiver: t.TWCID,
+		Payload:  t.primaryHeartbeatData,
+	}.Encode(2)
+	// send heartbeat with the available amperage to this twc
+	// msg := protocol.NewPrimaryHeartbeat(primaryID, t.TWCID, protocol.PrimaryHeartbeatData{
+	// 	Command: 0x05,
+	// 	Amps:    Bytes2Dec2(t.AvailableAmps, true),
+	// }).Encode(2)
+}
+
+// snapshot returns a copy of the secondary that doesn't share any byte slices with it
+func (t *TWCSecondary) snapshot() TWCSecondary {
+	twc := *t
+	twc.TWCID = append([]byte{}, t.TWCID...)
+	twc.AvailableAmps = append([]byte{}, t.AvailableAmps...)
+	twc.ReportedAmpsMax = append([]byte{}, t.ReportedAmpsMax...)
+	twc.ReportedAmpsActual = append([]byte{}, t.ReportedAmpsActual...)
+	twc.LastAmpsOffered = append([]byte{}, t.LastAmpsOffered...)
+	twc.session = nil
+	return twc
 }
@@ -0,0 +1,250 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/shreddedbacon/twcmanager/internal/history"
+	"github.com/shreddedbacon/twcmanager/internal/ui"
+)
+
+// how many sessions are shown on each page of the history by default, and at most
+const (
+	sessionsPerPage    = 20
+	maxSessionsPerPage = 100
+)
+
+// HistoryPage .
+type HistoryPage struct {
+	PageName    string
+	BreadCrumbs []BreadCrumb
+	Sessions    []history.Session
+	Page        int
+	PrevPage    int // 0 if there is no previous page
+	NextPage    int // 0 if there is no next page
+}
+
+// openSession starts recording a session when a car is plugged in, it returns a copy of the session so it can be
+// saved by saveOpenedSession once the lock has been released, the lock must be held
+func (p *TWCPrimary) openSession(twc *TWCSecondary) *history.Session {
+	if p.history == nil || twc.session != nil {
+		return nil
+	}
+	now := time.Now()
+	session := &history.Session{
+		TWCID:       fmt.Sprintf("%x", twc.TWCID),
+		VIN:         twc.vin(),
+		VehicleName: twc.VehicleName,
+		Start:       now,
+		Updated:     now,
+	}
+	if twc.StatsKWH > 0 {
+		p.meterSession(session, twc.StatsKWH, now)
+	}
+	twc.session = session
+	opened := *session
+	return &opened
+}
+
+// meterSession records the lifetime kWh in the session, with the tariff window it was read in, the lock must be held
+func (p *TWCPrimary) meterSession(session *history.Session, lifetimeKWH uint32, now time.Time) {
+	tariff := p.Tariff.tariffNow(now)
+	session.Meter(lifetimeKWH, tariff.Window, tariff.PricePerKWH)
+}
+
+// saveOpenedSession saves a session returned by openSession, then gives the session being recorded the ID it was
+// saved with, or stops recording it if it couldn't be saved, the lock must not be held
+func (p *TWCPrimary) saveOpenedSession(twcID []byte, opened *history.Session) {
+	if opened == nil {
+		return
+	}
+	err := p.history.Save(opened)
+	p.mu.Lock()
+	if twc, ok := p.GetSecondary(twcID); ok && twc.session != nil && twc.session.ID == 0 && twc.session.Start.Equal(opened.Start) {
+		if err != nil {
+			twc.session = nil
+		} else {
+			twc.session.ID = opened.ID
+		}
+	}
+	p.mu.Unlock()
+	if err != nil {
+		p.logSessionError(err)
+	}
+}
+
+// closeSession stops recording the session on the secondary and returns it so it can be saved once the lock has
+// been released, the lock must be held
+func (p *TWCPrimary) closeSession(twc *TWCSecondary) *history.Session {
+	if twc.session == nil {
+		return nil
+	}
+	session := *twc.session
+	session.End = time.Now()
+	twc.session = nil
+	return &session
+}
+
+// openSessions returns a copy of every session that is still being recorded, the lock must be held
+func (p *TWCPrimary) openSessions() []*history.Session {
+	sessions := []*history.Session{}
+	for _, twc := range p.knownTWCs {
+		if twc.session != nil {
+			session := *twc.session
+			sessions = append(sessions, &session)
+		}
+	}
+	return sessions
+}
+
+// saveSessions writes the sessions to the store, the lock must not be held
+func (p *TWCPrimary) saveSessions(sessions ...*history.Session) {
+	if p.history == nil {
+		return
+	}
+	for _, session := range sessions {
+		if session == nil || session.ID == 0 {
+			// a session without an ID is still being opened, and saveOpenedSession saves it
+			continue
+		}
+		if err := p.history.Save(session); err != nil {
+			p.logSessionError(err)
+		}
+	}
+}
+
+// sampleSession records the current the car is drawing, and how much of it was covered by solar, the lock must be held
+func (p *TWCPrimary) sampleSession(twc *TWCSecondary) {
+	if twc.session == nil {
+		return
+	}
+	amps := float64(Bytes2Dec2(twc.ReportedAmpsActual, false)) / 100
+	// share the solar between the cars that are charging by how much they are drawing
+	totalAmps := float64(0)
+	for _, t := range p.knownTWCs {
+		totalAmps += float64(Bytes2Dec2(t.ReportedAmpsActual, false)) / 100
+	}
+	solarAmps := float64(0)
+	if totalAmps > 0 {
+		solarAmps = amps * p.solarAmps / totalAmps
+	}
+	twc.session.Sample(amps, solarAmps)
+}
+
+func (p *TWCPrimary) logSessionError(err error) {
+	log.Println(log2JSONString(LogData{
+		Type:    "ERROR",
+		Source:  "sessions",
+		Message: fmt.Sprintf("Unable to save charging session: %v", err),
+	}))
+}
+
+// sessionCron saves the sessions that are still open so they aren't lost if the controller stops unexpectedly
+func (p *TWCPrimary) sessionCron(now int64) {
+	p.mu.Lock()
+	if (now - p.timeLastSessionSave) < 60 {
+		p.mu.Unlock()
+		return
+	}
+	p.timeLastSessionSave = now
+	sessions := p.openSessions()
+	p.mu.Unlock()
+	p.saveSessions(sessions...)
+}
+
+// listSessions returns the given page of sessions, newest first, and the total number of sessions
+func (p *TWCPrimary) listSessions(page, perPage int) ([]history.Session, int, error) {
+	if p.history == nil {
+		return nil, 0, fmt.Errorf("session history is not available")
+	}
+	return p.history.List((page-1)*perPage, perPage)
+}
+
+// APIGetSessions returns a page of charging sessions, newest first
+func (p *TWCPrimary) APIGetSessions(w http.ResponseWriter, r *http.Request) {
+	page, perPage, err := pagination(r)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	sessions, total, err := p.listSessions(page, perPage)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	strB, err := json.Marshal(struct {
+		Page     int               `json:"page"`
+		PerPage  int               `json:"perPage"`
+		Total    int               `json:"total"`
+		Sessions []history.Session `json:"sessions"`
+	}{
+		Page:     page,
+		PerPage:  perPage,
+		Total:    total,
+		Sessions: sessions,
+	})
+	if err != nil {
+		httpError(w, fmt.Errorf("%v", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s", string(strB))
+}
+
+// GetSessionHistory .
+func (p *TWCPrimary) GetSessionHistory(w http.ResponseWriter, r *http.Request) {
+	page, perPage, err := pagination(r)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	sessions, total, err := p.listSessions(page, perPage)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	pageData := HistoryPage{
+		BreadCrumbs: getBreadCrumbs("History"),
+		PageName:    "History",
+		Sessions:    sessions,
+		Page:        page,
+	}
+	if page > 1 {
+		pageData.PrevPage = page - 1
+	}
+	if page*perPage < total {
+		pageData.NextPage = page + 1
+	}
+	tpl1, _ := ui.Asset("templates/history.html")
+	tpl2, _ := ui.Asset("templates/home.html")
+	tpl3, _ := ui.Asset("templates/base.html")
+	tpl := append(tpl1, tpl2...)
+	tpl = append(tpl, tpl3...)
+	tmpl, _ := template.New("").Funcs(funcMap).Parse(string(tpl))
+	tmpl.ExecuteTemplate(w, "base", pageData)
+}
+
+// pagination reads the page and perPage query parameters, pages start at 1
+func pagination(r *http.Request) (int, int, error) {
+	page := 1
+	perPage := sessionsPerPage
+	var err error
+	if v := r.URL.Query().Get("page"); v != "" {
+		page, err = strconv.Atoi(v)
+		if err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("page must be a number of 1 or more")
+		}
+	}
+	if v := r.URL.Query().Get("perPage"); v != "" {
+		perPage, err = strconv.Atoi(v)
+		if err != nil || perPage < 1 || perPage > maxSessionsPerPage {
+			return 0, 0, fmt.Errorf("perPage must be a number from 1 to %d", maxSessionsPerPage)
+		}
+	}
+	return page, perPage, nil
+}
@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shreddedbacon/twcmanager/internal/simulator"
+)
+
+func TestSessionsAreRecorded(t *testing.T) {
+	t.Parallel()
+	p, sim := startPrimary(t, testConfig(t), simulator.Options{}, func(s *simulator.Simulator) {
+		s.AddTWC(testTWC1, 3200)
+	})
+	if p.history == nil {
+		t.Fatal("the history wasn't opened")
+	}
+	waitFor(t, 15*time.Second, "the secondary to link", func() bool { return linked(p, testTWC1) })
+	_ = sim.PlugIn(testTWC1, testVIN)
+	waitFor(t, 10*time.Second, "the session to be saved", func() bool {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		twc, ok := p.GetSecondary(testTWC1)
+		return ok && twc.session != nil && twc.session.ID != 0
+	})
+	// saving the open sessions updates the one already saved rather than adding another
+	p.sessionCron(time.Now().Unix() + 60)
+	_ = sim.Unplug(testTWC1)
+	waitFor(t, 10*time.Second, "the session to be closed", func() bool {
+		sessions, _, err := p.listSessions(1, 10)
+		return err == nil && len(sessions) > 0 && !sessions[0].End.IsZero()
+	})
+	sessions, total, err := p.listSessions(1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 {
+		t.Fatalf("there are %d sessions, want the one: %+v", total, sessions)
+	}
+	if sessions[0].TWCID != "1001" {
+		t.Fatalf("the session is for %s, want 1001", sessions[0].TWCID)
+	}
+}
@@ -57,13 +57,11 @@ func (p *TWCPrimary) APISettings(w http.ResponseWriter, r *http.Request) {
 				httpError(w, fmt.Errorf(`{"error":"debug level is not a number: %v"}`, err))
 				return
 			}
+			p.mu.Lock()
 			p.WiringMaxAmpsAllTWC = wmaat
 			p.WiringMaxAmpsPerTWC = wmapt
 			p.MinAmpsPerTWC = mapt
-			p.DebugLevel = dl
-			for _, twc := range p.knownTWCs {
-				twc.DebugLevel = dl
-			}
+			p.setDebugLevel(dl)
 			if sv >= 100 && sv <= 260 {
 				// only set the supply voltage is between 100 and 260 is defined
 				p.SupplyVoltage = sv
@@ -72,6 +70,7 @@ func (p *TWCPrimary) APISettings(w http.ResponseWriter, r *http.Request) {
 				// only set the supply phase if 1 or 3 is defined
 				p.SupplyPhases = sp
 			}
+			p.mu.Unlock()
 		}
 		if baudRate != "" && devicePath != "" {
 			br, err := strconv.Atoi(baudRate)
@@ -79,14 +78,18 @@ func (p *TWCPrimary) APISettings(w http.ResponseWriter, r *http.Request) {
 				httpError(w, fmt.Errorf(`{"error":"baud rate is not a number: %v"}`, err))
 				return
 			}
+			p.mu.Lock()
 			p.SerialConfig.BaudRate = br
 			p.SerialConfig.DevicePath = devicePath
+			p.mu.Unlock()
 		}
+		p.mu.Lock()
 		if enableLed == "on" {
 			p.LEDSOn = true
 		} else {
 			p.LEDSOn = false
 		}
+		p.mu.Unlock()
 		err = p.writeConfig()
 		if err != nil {
 			httpError(w, err)
@@ -95,7 +98,8 @@ func (p *TWCPrimary) APISettings(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/settings", http.StatusSeeOther)
 		return
 	} else if r.Method == http.MethodGet {
-		strB := fmt.Sprintf(`{"wiringMaxAmpsAllTWC": %d, "wiringMaxAmpsPerTWC": %d, "debugLevel": %d}`, p.WiringMaxAmpsAllTWC, p.WiringMaxAmpsPerTWC, p.DebugLevel)
+		primary := p.snapshot()
+		strB := fmt.Sprintf(`{"wiringMaxAmpsAllTWC": %d, "wiringMaxAmpsPerTWC": %d, "debugLevel": %d}`, primary.WiringMaxAmpsAllTWC, primary.WiringMaxAmpsPerTWC, primary.DebugLevel)
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintf(w, "%s", strB)
 		return
@@ -108,7 +112,7 @@ func (p *TWCPrimary) GetPrimarySettings(w http.ResponseWriter, r *http.Request)
 	pageData := SettingsPage{
 		BreadCrumbs: getBreadCrumbs("Settings"),
 		PageName:    "Settings",
-		PageData:    *p,
+		PageData:    p.snapshot(),
 	}
 	tpl1, _ := ui.Asset("templates/settings.html")
 	tpl2, _ := ui.Asset("templates/home.html")
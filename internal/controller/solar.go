@@ -0,0 +1,273 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxSolarDecisions is how many of the latest solar following decisions are kept to show on the powerwall page
+const maxSolarDecisions = 20
+
+// solar following actions
+const (
+	solarActionStart = "start" // the cars are started
+	solarActionStop  = "stop"  // the cars are stopped
+	solarActionSet   = "set"   // the cars keep charging at a new rate
+	solarActionHold  = "hold"  // the cars keep charging at the same rate
+	solarActionOff   = "off"   // the cars stay stopped
+)
+
+// SolarControl smooths the solar following so that passing clouds don't keep starting and stopping the cars
+type SolarControl struct {
+	SampleInterval int     `yaml:"sampleInterval" json:"sampleInterval"` // seconds between readings, 0 reads every powerwallCheckInterval minutes
+	Smoothing      float64 `yaml:"smoothing" json:"smoothing"`           // the weight given to each new reading, between 0 and 1, 0 or 1 turns smoothing off
+	StartAmps      int     `yaml:"startAmps" json:"startAmps"`           // the amps needed to start the cars, 0 uses minAmpsPerTWC
+	StopAmps       int     `yaml:"stopAmps" json:"stopAmps"`             // the amps the cars are stopped below, 0 uses minAmpsPerTWC
+	MinOnSeconds   int     `yaml:"minOnSeconds" json:"minOnSeconds"`     // the cars charge for at least this long once started
+	MinOffSeconds  int     `yaml:"minOffSeconds" json:"minOffSeconds"`   // the cars stay stopped for at least this long once stopped
+	MaxStepAmps    int     `yaml:"maxStepAmps" json:"maxStepAmps"`       // the most the amps change by in one reading, 0 for no limit
+}
+
+// SolarDecision is what the solar following decided after a reading, and why
+type SolarDecision struct {
+	Time          time.Time `json:"time"`
+	Watts         float64   `json:"watts"`         // the watts available to the chargers at the reading
+	SmoothedWatts float64   `json:"smoothedWatts"` // the watts after smoothing
+	Amps          int       `json:"amps"`          // the amps the cars were set to, 0 when stopped
+	Action        string    `json:"action"`
+	Reason        string    `json:"reason"`
+}
+
+// solarState is what the solar following has worked out so far
+type solarState struct {
+	smoothedWatts float64
+	samples       int
+	charging      bool
+	changed       int64 // when the cars were last started or stopped, 0 before they have been
+	decisions     []SolarDecision
+}
+
+// solarCheckInterval returns the seconds between readings of the energy source
+func (p *TWCPrimary) solarCheckInterval() int64 {
+	if p.EnablePowerwall && p.SolarControl.SampleInterval > 0 {
+		return int64(p.SolarControl.SampleInterval)
+	}
+	return int64(p.PowerwallCheckInterval * 60)
+}
+
+// followSolar smooths a reading of the watts available to the chargers and decides whether to start, stop or
+// adjust the cars, topping them up from the grid in the tariff window, cfg is a snapshot of the primary taken at the
+// reading, the lock must not be held
+func (p *TWCPrimary) followSolar(cfg *TWCPrimary, now int64, watts float64, offsetAmps int, window int) {
+	ctl := cfg.SolarControl
+	p.mu.Lock()
+	state := &p.solar
+	if ctl.Smoothing <= 0 || ctl.Smoothing >= 1 || state.samples == 0 {
+		state.smoothedWatts = watts
+	} else {
+		state.smoothedWatts = ctl.Smoothing*watts + (1-ctl.Smoothing)*state.smoothedWatts
+	}
+	state.samples++
+	smoothed := state.smoothedWatts
+	charging, changed := state.charging, state.changed
+	currentAmps := p.availableAmps()
+	p.mu.Unlock()
+
+	startAmps := ctl.StartAmps
+	if startAmps <= 0 {
+		startAmps = cfg.MinAmpsPerTWC
+	}
+	stopAmps := ctl.StopAmps
+	if stopAmps <= 0 {
+		stopAmps = cfg.MinAmpsPerTWC
+	}
+	targetAmps := wattsToAmps(cfg.SupplyPhases, cfg.SupplyVoltage, smoothed) + offsetAmps
+	available := fmt.Sprintf("%dA", targetAmps)
+	if gridAmps := cfg.Tariff.gridAmps(window); gridAmps > targetAmps {
+		targetAmps = gridAmps
+		available = fmt.Sprintf("%dA from the grid", targetAmps)
+		if window >= 0 {
+			available = fmt.Sprintf("%dA from the grid in the %s tariff window", targetAmps, cfg.Tariff.windowName(window))
+		}
+	}
+	since := now - changed
+	decision := SolarDecision{
+		Time:          time.Unix(now, 0),
+		Watts:         watts,
+		SmoothedWatts: smoothed,
+	}
+	switch {
+	case charging && targetAmps < stopAmps && changed != 0 && since < int64(ctl.MinOnSeconds):
+		decision.Action = solarActionHold
+		decision.Amps = cfg.MinAmpsPerTWC
+		decision.Reason = fmt.Sprintf("%s is below the stop threshold of %dA, keeping the cars on at the minimum for another %ds",
+			available, stopAmps, int64(ctl.MinOnSeconds)-since)
+	case charging && targetAmps < stopAmps:
+		decision.Action = solarActionStop
+		decision.Reason = fmt.Sprintf("%s is below the stop threshold of %dA", available, stopAmps)
+	case !charging && targetAmps < startAmps:
+		decision.Action = solarActionOff
+		decision.Reason = fmt.Sprintf("%s is below the start threshold of %dA", available, startAmps)
+	case !charging && changed != 0 && since < int64(ctl.MinOffSeconds):
+		decision.Action = solarActionOff
+		decision.Reason = fmt.Sprintf("%s is above the start threshold of %dA, keeping the cars off for another %ds",
+			available, startAmps, int64(ctl.MinOffSeconds)-since)
+	default:
+		amps := targetAmps
+		if charging && ctl.MaxStepAmps > 0 {
+			if amps > currentAmps+ctl.MaxStepAmps {
+				amps = currentAmps + ctl.MaxStepAmps
+			} else if amps < currentAmps-ctl.MaxStepAmps {
+				amps = currentAmps - ctl.MaxStepAmps
+			}
+		}
+		if amps < cfg.MinAmpsPerTWC {
+			// between the stop threshold and the minimum the cars stay on at the minimum
+			amps = cfg.MinAmpsPerTWC
+		}
+		decision.Amps = amps
+		switch {
+		case !charging:
+			decision.Action = solarActionStart
+			decision.Reason = fmt.Sprintf("%s is above the start threshold of %dA", available, startAmps)
+		case amps != currentAmps:
+			decision.Action = solarActionSet
+			decision.Reason = fmt.Sprintf("%s available", available)
+			if amps != targetAmps {
+				decision.Reason = fmt.Sprintf("%s available, limited to %dA by the minimum or the maximum step", available, amps)
+			}
+		default:
+			decision.Action = solarActionHold
+			decision.Reason = fmt.Sprintf("%s available", available)
+		}
+	}
+
+	p.mu.Lock()
+	switch decision.Action {
+	case solarActionStart:
+		p.solar.charging = true
+		p.solar.changed = now
+	case solarActionStop:
+		p.solar.charging = false
+		p.solar.changed = now
+	}
+	// only keep the decisions that changed something, or were made for a different reason
+	record := true
+	if n := len(p.solar.decisions); n > 0 {
+		last := p.solar.decisions[n-1]
+		record = last.Action != decision.Action || last.Amps != decision.Amps || last.Reason != decision.Reason
+	}
+	if record {
+		p.solar.decisions = append(p.solar.decisions, decision)
+		if len(p.solar.decisions) > maxSolarDecisions {
+			p.solar.decisions = p.solar.decisions[len(p.solar.decisions)-maxSolarDecisions:]
+		}
+	}
+	p.mu.Unlock()
+	if record && p.debugLevel() >= 9 {
+		log.Println(log2JSONString(LogData{
+			Type:    "INFO",
+			Source:  "solar",
+			Message: fmt.Sprintf("%s at %dA, %s (%.0fW, smoothed %.0fW)", decision.Action, decision.Amps, decision.Reason, watts, smoothed),
+		}))
+	}
+
+	if !cfg.AutoStartStopInterval {
+		return
+	}
+	switch decision.Action {
+	case solarActionStop, solarActionOff:
+		p.StopConnectedCars()
+	default:
+		if decision.Amps != currentAmps || decision.Action == solarActionStart {
+			// the amps change with every sample, so they're a limit until the next one rather than saved
+			err := p.setLimitAmps(decision.Amps)
+			if err != nil {
+				if p.debugLevel() >= 12 {
+					log.Println(log2JSONString(LogData{
+						Type:    "ERROR",
+						Source:  "solar",
+						Message: fmt.Sprintf("Error setting the amperage to %d: %v", decision.Amps, err),
+					}))
+				}
+				return
+			}
+		}
+		p.StartConnectedCars()
+	}
+}
+
+// solarDecisions returns a copy of the latest solar following decisions, newest first
+func (p *TWCPrimary) solarDecisions() []SolarDecision {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	decisions := make([]SolarDecision, 0, len(p.solar.decisions))
+	for i := len(p.solar.decisions) - 1; i >= 0; i-- {
+		decisions = append(decisions, p.solar.decisions[i])
+	}
+	return decisions
+}
+
+// APISolarControl returns the solar following settings and the latest decisions, or saves the settings
+func (p *TWCPrimary) APISolarControl(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		p.mu.RLock()
+		ctl := p.SolarControl
+		p.mu.RUnlock()
+		b, err := json.Marshal(struct {
+			Settings  SolarControl    `json:"settings"`
+			Decisions []SolarDecision `json:"decisions"`
+		}{ctl, p.solarDecisions()})
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "%s", b)
+		return
+	}
+	ctl := SolarControl{}
+	for _, field := range []struct {
+		name  string
+		value *int
+	}{
+		{"sampleInterval", &ctl.SampleInterval},
+		{"startAmps", &ctl.StartAmps},
+		{"stopAmps", &ctl.StopAmps},
+		{"minOnSeconds", &ctl.MinOnSeconds},
+		{"minOffSeconds", &ctl.MinOffSeconds},
+		{"maxStepAmps", &ctl.MaxStepAmps},
+	} {
+		v := r.FormValue(field.name)
+		if v == "" {
+			continue
+		}
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 {
+			httpError(w, fmt.Errorf(`{"error":"%s must be a number of 0 or more"}`, field.name))
+			return
+		}
+		*field.value = i
+	}
+	if v := r.FormValue("smoothing"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			httpError(w, fmt.Errorf(`{"error":"smoothing must be between 0 and 1"}`))
+			return
+		}
+		ctl.Smoothing = f
+	}
+	p.mu.Lock()
+	p.SolarControl = ctl
+	p.mu.Unlock()
+	err := p.writeConfig()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/powerwall", http.StatusSeeOther)
+}
@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"context"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shreddedbacon/twcmanager/internal/energy"
+	"github.com/shreddedbacon/twcmanager/internal/simulator"
+	"gopkg.in/yaml.v2"
+)
+
+// slowSource is an energy source that takes a while to answer, and counts how often it is read
+type slowSource struct {
+	reading energy.Reading
+	reads   int32
+}
+
+func (s *slowSource) Read(ctx context.Context) (energy.Reading, error) {
+	atomic.AddInt32(&s.reads, 1)
+	time.Sleep(200 * time.Millisecond)
+	return s.reading, nil
+}
+
+func TestSolarFollowing(t *testing.T) {
+	t.Parallel()
+	cfg := testConfig(t)
+	cfg.EnablePowerwall = true
+	cfg.AutoStartStopInterval = true
+	cfg.SolarControl.SampleInterval = 10
+	p, _ := startPrimary(t, cfg, simulator.Options{}, oneTWC)
+	waitFor(t, 15*time.Second, "the secondary to link", func() bool { return linked(p, testTWC1) })
+	source := &slowSource{reading: energy.Reading{SolarWatts: 4800}}
+	p.mu.Lock()
+	p.energy = source
+	p.mu.Unlock()
+	if err := p.writeConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the crons that start while the source is still being read don't read it again, they're run a while from now
+	// so the primary's own crons have nothing to do
+	now := time.Now().Unix() + 600
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.powerwallCron(now)
+		}()
+	}
+	wg.Wait()
+	if reads := atomic.LoadInt32(&source.reads); reads != 1 {
+		t.Fatalf("the energy source was read %d times, want once", reads)
+	}
+	decisions := p.solarDecisions()
+	if len(decisions) != 1 || decisions[0].Action != solarActionStart {
+		t.Fatalf("the solar following decided %+v, want the cars started", decisions)
+	}
+
+	// the amps solar allows last until the next sample, the configured amps aren't changed
+	p.mu.RLock()
+	available, configured := p.availableAmps(), p.AvailableAmps
+	p.mu.RUnlock()
+	if available != decisions[0].Amps || configured != 32 {
+		t.Fatalf("sharing %dA with %dA configured, want %dA with 32A", available, configured, decisions[0].Amps)
+	}
+	b, err := ioutil.ReadFile(p.ConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved TWCPrimary
+	if err := yaml.Unmarshal(b, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.AvailableAmps != 32 {
+		t.Fatalf("%dA was saved, want the configured 32A", saved.AvailableAmps)
+	}
+}
@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+)
+
+// tariff window days
+const (
+	TariffDaysAll      = ""         // every day
+	TariffDaysWeekdays = "weekdays" // monday to friday, except the holidays
+	TariffDaysWeekends = "weekends" // saturday, sunday and the holidays
+)
+
+// Tariff is the price of energy, used to work out what each charging session cost, and when the cars can charge
+// from the grid
+type Tariff struct {
+	PricePerKWH float64        `yaml:"pricePerKWH"` // the price outside the windows
+	Currency    string         `yaml:"currency"`
+	MaxAmps     int            `yaml:"maxAmps"`  // the amps the cars can charge at from the grid outside the windows
+	Windows     []TariffWindow `yaml:"windows"`  // the first window that covers the time is used
+	Holidays    []string       `yaml:"holidays"` // dates, 2006-01-02, that use the weekend windows
+}
+
+// TariffWindow is a time of day with its own price, and the amps the cars can charge at from the grid
+type TariffWindow struct {
+	Name        string  `yaml:"name"`
+	Days        string  `yaml:"days"`  // weekdays, weekends, or empty for every day
+	Start       string  `yaml:"start"` // HH:MM
+	End         string  `yaml:"end"`   // HH:MM, a window that ends before it starts runs past midnight
+	PricePerKWH float64 `yaml:"pricePerKWH"`
+	MaxAmps     int     `yaml:"maxAmps"` // the amps the cars can charge at from the grid, solar following can give them more
+}
+
+// validate checks the windows and holidays can be read
+func (t Tariff) validate() error {
+	for i, w := range t.Windows {
+		switch w.Days {
+		case TariffDaysAll, TariffDaysWeekdays, TariffDaysWeekends:
+		default:
+			return fmt.Errorf("tariff window %d: days should be weekdays, weekends or empty for every day", i+1)
+		}
+		for _, v := range []string{w.Start, w.End} {
+			if _, err := time.Parse("15:04", v); err != nil {
+				return fmt.Errorf("tariff window %d: start and end should be HH:MM", i+1)
+			}
+		}
+		if w.MaxAmps < 0 {
+			return fmt.Errorf("tariff window %d: max amps can't be negative", i+1)
+		}
+	}
+	if t.MaxAmps < 0 {
+		return fmt.Errorf("tariff max amps can't be negative")
+	}
+	for _, v := range t.Holidays {
+		if _, err := time.Parse("2006-01-02", v); err != nil {
+			return fmt.Errorf("tariff holiday %q should be a date, 2006-01-02", v)
+		}
+	}
+	return nil
+}
+
+// weekend returns true if the local day is a saturday, sunday or one of the holidays
+func (t Tariff) weekend(now time.Time) bool {
+	now = now.Local()
+	if now.Weekday() == time.Saturday || now.Weekday() == time.Sunday {
+		return true
+	}
+	today := now.Format("2006-01-02")
+	for _, v := range t.Holidays {
+		if v == today {
+			return true
+		}
+	}
+	return false
+}
+
+// window returns the index of the window that covers the time, or -1 if none do
+func (t Tariff) window(now time.Time) int {
+	weekend := t.weekend(now)
+	for i, w := range t.Windows {
+		if (w.Days == TariffDaysWeekdays && weekend) || (w.Days == TariffDaysWeekends && !weekend) {
+			continue
+		}
+		if inWindow(now, w.Start, w.End) {
+			return i
+		}
+	}
+	return -1
+}
+
+// gridAmps returns the amps the cars can charge at from the grid in a window, or outside them if the index is -1,
+// it returns -1 if there are no windows so the available amps are used as they are
+func (t Tariff) gridAmps(i int) int {
+	if len(t.Windows) == 0 {
+		return -1
+	}
+	if i < 0 || i >= len(t.Windows) {
+		return t.MaxAmps
+	}
+	return t.Windows[i].MaxAmps
+}
+
+// windowName returns a name for a window to use in logs and the web ui
+func (t Tariff) windowName(i int) string {
+	if i < 0 || i >= len(t.Windows) {
+		return ""
+	}
+	if t.Windows[i].Name != "" {
+		return t.Windows[i].Name
+	}
+	return fmt.Sprintf("%s-%s", t.Windows[i].Start, t.Windows[i].End)
+}
+
+// TariffNow is the window in use at a time, for the web ui
+type TariffNow struct {
+	Window      string // empty outside the windows
+	PricePerKWH float64
+	Currency    string
+	MaxAmps     int // -1 if there are no windows
+}
+
+// tariffNow returns the window in use at the time
+func (t Tariff) tariffNow(now time.Time) TariffNow {
+	i := t.window(now)
+	current := TariffNow{
+		Window:      t.windowName(i),
+		PricePerKWH: t.PricePerKWH,
+		Currency:    t.Currency,
+		MaxAmps:     t.gridAmps(i),
+	}
+	if i >= 0 {
+		current.PricePerKWH = t.Windows[i].PricePerKWH
+	}
+	return current
+}
@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shreddedbacon/twcmanager/internal/simulator"
+)
+
+func TestTariffWindowPausesTheChargers(t *testing.T) {
+	t.Parallel()
+	cfg := testConfig(t)
+	// the cars aren't started and stopped through the vehicle api, so only the TWCs can apply the windows
+	cfg.AutoStartStopInterval = false
+	p, sim := startPrimary(t, cfg, simulator.Options{}, oneTWC)
+	waitFor(t, 15*time.Second, "the car to be offered the available amps", func() bool { return offered(sim, testTWC1) == 3200 })
+
+	// a peak window with nothing from the grid starts
+	now := time.Now()
+	p.mu.Lock()
+	p.Tariff = Tariff{
+		MaxAmps: 32,
+		Windows: []TariffWindow{{
+			Name:  "peak",
+			Start: now.Add(-time.Hour).Format("15:04"),
+			End:   now.Add(time.Hour).Format("15:04"),
+		}},
+	}
+	p.mu.Unlock()
+	// the crons are run by main, so the check is made here
+	p.powerwallCron(now.Unix())
+	waitFor(t, 5*time.Second, "the secondary to be paused", func() bool { return offered(sim, testTWC1) == 0 })
+	waitFor(t, 5*time.Second, "the car to stop drawing", func() bool {
+		state, _ := sim.State(testTWC1)
+		return state.ActualAmps == 0
+	})
+	if twc, _ := secondary(p, testTWC1); !twc.paused {
+		t.Fatal("the primary doesn't know the secondary is paused")
+	}
+	p.mu.RLock()
+	available := p.AvailableAmps
+	p.mu.RUnlock()
+	if available != 32 {
+		t.Fatalf("the window changed the configured amps to %d", available)
+	}
+
+	// once the window ends the car is offered the amps outside it again
+	p.mu.Lock()
+	p.Tariff.Windows[0].Start = now.Add(-2 * time.Hour).Format("15:04")
+	p.Tariff.Windows[0].End = now.Add(-time.Hour).Format("15:04")
+	p.mu.Unlock()
+	p.powerwallCron(now.Unix() + 1)
+	waitFor(t, 5*time.Second, "the car to be offered the amps outside the window", func() bool { return offered(sim, testTWC1) == 3200 })
+}
+
+func TestTariffWindowLimitsAlwaysCharging(t *testing.T) {
+	t.Parallel()
+	cfg := testConfig(t)
+	cfg.Secondaries = map[string]SecondaryConfig{"1001": {ChargeMode: ChargeModeAlways}}
+	now := time.Now()
+	// nothing from the grid outside the window, which doesn't start for an hour
+	cfg.Tariff = Tariff{Windows: []TariffWindow{{
+		Name:    "peak",
+		Start:   now.Add(time.Hour).Format("15:04"),
+		End:     now.Add(2 * time.Hour).Format("15:04"),
+		MaxAmps: 10,
+	}}}
+	p, sim := startPrimary(t, cfg, simulator.Options{}, oneTWC)
+	p.powerwallCron(now.Unix())
+	// outside the windows a secondary that always charges is only limited by the wiring
+	waitFor(t, 15*time.Second, "the car to be offered what the wiring allows", func() bool { return offered(sim, testTWC1) == 3200 })
+
+	p.mu.Lock()
+	p.Tariff.Windows[0].Start = now.Add(-time.Hour).Format("15:04")
+	p.mu.Unlock()
+	p.powerwallCron(now.Unix() + 1)
+	waitFor(t, 5*time.Second, "the car to be offered the window's amps", func() bool { return offered(sim, testTWC1) == 1000 })
+}
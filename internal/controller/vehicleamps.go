@@ -0,0 +1,263 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/shreddedbacon/twcmanager/internal/vehicle"
+)
+
+// amps control modes for a secondary or a vehicle profile
+const (
+	AmpsControlTWC     = "twc"     // the charge rate is sent to the TWC, this is the default
+	AmpsControlVehicle = "vehicle" // the charging amps are set on the car through the vehicle api, the TWC stays at its maximum
+)
+
+// vehicleAmpsRecheck is how long to wait before looking through the accounts again for a car none of them own
+const vehicleAmpsRecheck = 10 * 60
+
+// vehicleAmpsRetry is how long to wait before trying the vehicle api again once it has failed
+const vehicleAmpsRetry = 5 * 60
+
+// vehicleAmpsLowerInterval is the least time between lowering the charging amps of a vehicle, it is shorter than the
+// interval for raising them as the amps are lowered to keep within the wiring
+const vehicleAmpsLowerInterval = 10
+
+// vehicleAmpsDeadband is the least change to the charging amps of a vehicle that is worth sending it a command for
+const vehicleAmpsDeadband = 2
+
+// vehicleAmps is what is known about setting the charging amps of the vehicle plugged into a secondary through the
+// vehicle api
+type vehicleAmps struct {
+	vin           string // the vehicle this is about
+	username      string // the account that owns the vehicle, empty if none do
+	id            int64  // the id of the vehicle on the account
+	timeChecked   int64  // when the accounts were last looked through for the vehicle
+	active        bool   // the vehicle is setting the amps and the TWC is left at its maximum
+	inflight      bool   // the amps are being set right now
+	wanted        int    // what the allocator wants the vehicle to draw
+	amps          int    // what the vehicle was last set to
+	timeLastSet   int64  // when the amps were last set
+	timeLastError int64  // when the vehicle api last failed, the TWC is sent the charge rate until the retry
+}
+
+// ampsControl returns how the charging amps of the vehicle plugged into the secondary are set, the profile of the
+// vehicle overrides the secondary, the lock must be held
+func (p *TWCPrimary) ampsControl(twc *TWCSecondary) string {
+	profile, ok := p.vehicleProfile(twc)
+	if ok && profile.AmpsControl != "" {
+		return profile.AmpsControl
+	}
+	return p.secondaryConfig(twc.TWCID).AmpsControl
+}
+
+// vehicleAmpsInterval returns the least time between raising the charging amps of a vehicle
+func (p *TWCPrimary) vehicleAmpsInterval() int64 {
+	if p.VehicleAmpsInterval <= 0 {
+		return 60
+	}
+	return int64(p.VehicleAmpsInterval)
+}
+
+// due returns true if the vehicle should be sent what the allocator wants, it only is once the change is more than
+// the deadband, and not until the interval has passed since the amps were last raised, or a shorter one if they
+// are being lowered, so the car isn't sent a command every check
+func (state vehicleAmps) due(now, interval int64) bool {
+	if !state.active {
+		return true
+	}
+	change := state.wanted - state.amps
+	if change > -vehicleAmpsDeadband && change < vehicleAmpsDeadband {
+		return false
+	}
+	since := now - state.timeLastSet
+	if change > 0 {
+		return since >= interval
+	}
+	return since >= vehicleAmpsLowerInterval
+}
+
+// twcChargeRate returns the charge rate to send the secondary for the amps it has been given, a secondary whose
+// vehicle sets the amps stays at its maximum, the lock must be held
+func (p *TWCPrimary) twcChargeRate(twc *TWCSecondary, amps int) int {
+	if twc.vehicleAmps.vin != twc.vin() || p.ampsControl(twc) != AmpsControlVehicle {
+		return amps
+	}
+	twc.vehicleAmps.wanted = amps
+	if !twc.vehicleAmps.active {
+		return amps
+	}
+	return p.planMaxAmps(twc)
+}
+
+// vehicleAmpsCron finds the accounts that own the vehicles that set their own amps, and sets the charging amps
+// on them, falling back to the TWC if the vehicle api fails
+func (p *TWCPrimary) vehicleAmpsCron(now int64) {
+	type job struct {
+		twcID    []byte
+		vin      string
+		state    vehicleAmps
+		lookup   bool
+		setAmps  bool
+		tAPIUser *TeslaAPIUser
+	}
+	p.mu.Lock()
+	interval := p.vehicleAmpsInterval()
+	rebalance := false
+	jobs := []job{}
+	for _, twc := range p.knownTWCs {
+		vin := twc.vin()
+		if vin == "" || p.ampsControl(twc) != AmpsControlVehicle || twc.PlugState == 0 {
+			if twc.vehicleAmps.active {
+				// the mode was changed, so the TWC goes back to being sent the charge rate
+				twc.vehicleAmps.active = false
+				rebalance = true
+			}
+			continue
+		}
+		state := &twc.vehicleAmps
+		if state.vin != vin {
+			*state = vehicleAmps{vin: vin}
+		}
+		if state.inflight {
+			continue
+		}
+		j := job{twcID: twc.TWCID, vin: vin}
+		if state.username == "" {
+			if state.timeChecked != 0 && now-state.timeChecked < vehicleAmpsRecheck {
+				continue
+			}
+			state.timeChecked = now
+			j.lookup = true
+		} else {
+			if state.wanted < p.MinAmpsPerTWC || !state.due(now, interval) {
+				continue
+			}
+			if Bytes2Dec2(twc.ReportedAmpsActual, false) == 0 {
+				// the car is only awake to take the amps once it is charging
+				continue
+			}
+			if state.timeLastError != 0 && now-state.timeLastError < vehicleAmpsRetry {
+				continue
+			}
+			for _, tAPIUser := range p.TeslaAPITokens {
+				if tAPIUser.Username == state.username {
+					j.tAPIUser = tAPIUser
+				}
+			}
+			if j.tAPIUser == nil {
+				// the account was forgotten
+				*state = vehicleAmps{vin: vin, timeChecked: now}
+				continue
+			}
+			j.setAmps = true
+		}
+		state.inflight = true
+		j.state = *state
+		jobs = append(jobs, j)
+	}
+	accounts := append([]*TeslaAPIUser{}, p.TeslaAPITokens...)
+	p.mu.Unlock()
+	for _, j := range jobs {
+		state := j.state
+		state.inflight = false
+		if j.lookup {
+			state.username, state.id = p.findVehicleAccount(accounts, j.vin)
+		}
+		if j.setAmps {
+			err := p.newVehicleAPI(j.tAPIUser.Token.AccessToken).SetChargingAmps(context.Background(), state.id, state.wanted)
+			if err != nil {
+				if p.debugLevel() >= 1 {
+					log.Println(log2JSONString(LogData{
+						Type:     "ERROR",
+						Source:   "vehicleamps",
+						Receiver: fmt.Sprintf("%x", j.twcID),
+						Message:  fmt.Sprintf("Unable to set the charging amps of %s, sending them to the TWC instead: %v", j.vin, err),
+					}))
+				}
+				rebalance = rebalance || state.active
+				state.active = false
+				state.timeLastError = now
+			} else {
+				if p.debugLevel() >= 9 {
+					log.Println(log2JSONString(LogData{
+						Type:     "INFO",
+						Source:   "vehicleamps",
+						Receiver: fmt.Sprintf("%x", j.twcID),
+						Message:  fmt.Sprintf("Set the charging amps of %s to %dA", j.vin, state.wanted),
+					}))
+				}
+				rebalance = rebalance || !state.active
+				state.active = true
+				state.amps = state.wanted
+				state.timeLastSet = now
+				state.timeLastError = 0
+			}
+		}
+		p.mu.Lock()
+		if twc, ok := p.GetSecondary(j.twcID); ok && twc.vehicleAmps.vin == j.vin {
+			// keep what the allocator wanted while the api was being called
+			state.wanted = twc.vehicleAmps.wanted
+			twc.vehicleAmps = state
+		}
+		p.mu.Unlock()
+	}
+	if rebalance {
+		// move the TWC to or from its maximum
+		_ = p.balance()
+	}
+}
+
+// findVehicleAccount looks through the accounts for the one that owns the vehicle, it returns an empty username
+// if none do
+func (p *TWCPrimary) findVehicleAccount(accounts []*TeslaAPIUser, vin string) (string, int64) {
+	for _, tAPIUser := range accounts {
+		if tAPIUser.Token == nil {
+			continue
+		}
+		v, ok, err := vehicle.Find(context.Background(), p.newVehicleAPI(tAPIUser.Token.AccessToken), vin)
+		if err != nil {
+			if p.debugLevel() >= 1 {
+				log.Println(log2JSONString(LogData{
+					Type:    "ERROR",
+					Source:  "vehicleamps",
+					Message: fmt.Sprintf("Unable to list the vehicles of %s: %v", tAPIUser.Username, err),
+				}))
+			}
+			continue
+		}
+		if ok {
+			if p.debugLevel() >= 9 {
+				log.Println(log2JSONString(LogData{
+					Type:    "INFO",
+					Source:  "vehicleamps",
+					Message: fmt.Sprintf("%s is owned by %s, its charging amps will be set through the vehicle api", vin, tAPIUser.Username),
+				}))
+			}
+			return tAPIUser.Username, v.ID
+		}
+	}
+	return "", 0
+}
+
+// vehicleAmpsStatus describes how the charging amps of the vehicle plugged into the secondary are being set, it is
+// empty if the TWC sets them, the lock must be held
+func (p *TWCPrimary) vehicleAmpsStatus(twc *TWCSecondary) string {
+	state := twc.vehicleAmps
+	switch {
+	case p.ampsControl(twc) != AmpsControlVehicle:
+		return ""
+	case twc.PlugState == 0 || twc.vin() == "":
+		return "Waiting for a vehicle to be plugged in and its VIN to be read"
+	case state.vin != twc.vin() || state.timeChecked == 0:
+		return "Looking for the account that owns the vehicle"
+	case state.username == "":
+		return "None of the accounts own the vehicle, the charge rate is sent to the TWC"
+	case state.active:
+		return fmt.Sprintf("Set to %dA through the vehicle api of %s, the TWC is left at its maximum", state.amps, state.username)
+	case state.timeLastError != 0:
+		return "The vehicle api failed, the charge rate is sent to the TWC until it is tried again"
+	}
+	return "Waiting to set the amps through the vehicle api"
+}
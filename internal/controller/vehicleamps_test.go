@@ -0,0 +1,60 @@
+package controller
+
+import "testing"
+
+func TestVehicleAmpsDue(t *testing.T) {
+	const now, interval = 1000, 60
+	tests := []struct {
+		name  string
+		state vehicleAmps
+		want  bool
+	}{
+		{
+			name:  "not set through the vehicle api yet",
+			state: vehicleAmps{wanted: 16},
+			want:  true,
+		},
+		{
+			name:  "unchanged",
+			state: vehicleAmps{active: true, wanted: 16, amps: 16},
+			want:  false,
+		},
+		{
+			name:  "raised within the deadband",
+			state: vehicleAmps{active: true, wanted: 17, amps: 16},
+			want:  false,
+		},
+		{
+			name:  "lowered within the deadband",
+			state: vehicleAmps{active: true, wanted: 15, amps: 16},
+			want:  false,
+		},
+		{
+			name:  "raised before the interval",
+			state: vehicleAmps{active: true, wanted: 32, amps: 16, timeLastSet: now - interval + 1},
+			want:  false,
+		},
+		{
+			name:  "raised after the interval",
+			state: vehicleAmps{active: true, wanted: 32, amps: 16, timeLastSet: now - interval},
+			want:  true,
+		},
+		{
+			name:  "lowered before the lower interval",
+			state: vehicleAmps{active: true, wanted: 10, amps: 16, timeLastSet: now - vehicleAmpsLowerInterval + 1},
+			want:  false,
+		},
+		{
+			name:  "lowered after the lower interval",
+			state: vehicleAmps{active: true, wanted: 10, amps: 16, timeLastSet: now - vehicleAmpsLowerInterval},
+			want:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.state.due(now, interval); got != tt.want {
+				t.Fatalf("due = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/shreddedbacon/twcmanager/internal/allocator"
+)
+
+// charge modes for a secondary or a vehicle profile
+const (
+	ChargeModeSolar  = "solar"  // only charge with the available amps, this is the default
+	ChargeModeAlways = "always" // charge even when there isn't enough solar, limited only by the wiring
+)
+
+// VehicleProfile holds the charging settings for a single vehicle, keyed by VIN in the config
+type VehicleProfile struct {
+	Name           string     `yaml:"name"`
+	MaxAmps        int        `yaml:"maxAmps"`        // 0 uses the max amps of the TWC the vehicle is plugged into
+	Priority       int        `yaml:"priority"`       // 0 uses the priority of the TWC the vehicle is plugged into
+	ChargeMode     string     `yaml:"chargeMode"`     // solar or always, empty uses the charge mode of the TWC
+	DailyKWHTarget uint32     `yaml:"dailyKWHTarget"` // stop charging once this many kWh have been delivered today, 0 for no limit
+	Goal           ChargeGoal `yaml:"goal"`           // the energy the vehicle needs by the time it leaves
+	AmpsControl    string     `yaml:"ampsControl"`    // twc or vehicle, empty uses the amps control of the TWC
+}
+
+// dailyEnergy is the energy delivered to a vehicle on a given day
+type dailyEnergy struct {
+	day string
+	kwh uint32
+}
+
+// vin returns the full VIN of the vehicle plugged into the secondary, or an empty string if it isn't known yet
+func (t *TWCSecondary) vin() string {
+	vin := t.VINStart + t.VINMiddle + t.VINEnd
+	if len(vin) != 17 {
+		return ""
+	}
+	return vin
+}
+
+// applyVehicleProfile looks up the profile for the vehicle plugged into the secondary once its VIN is known,
+// it returns true if the vehicle has changed, the lock must be held
+func (p *TWCPrimary) applyVehicleProfile(twc *TWCSecondary) bool {
+	vin := twc.vin()
+	if vin == twc.profileVIN {
+		return false
+	}
+	twc.profileVIN = vin
+	twc.VehicleName = ""
+	profile, ok := p.Vehicles[vin]
+	if !ok {
+		return true
+	}
+	twc.VehicleName = profile.Name
+	if p.debugLevel() >= 9 {
+		log.Println(log2JSONString(LogData{
+			Type:     "INFO",
+			Source:   "vehicles",
+			Receiver: fmt.Sprintf("%x", twc.TWCID),
+			Message:  fmt.Sprintf("Applying the profile for %s (%s)", profile.Name, vin),
+		}))
+	}
+	return true
+}
+
+// clearVehicle forgets the vehicle plugged into the secondary when it is unplugged
+func (t *TWCSecondary) clearVehicle() {
+	t.VINStart = ""
+	t.VINMiddle = ""
+	t.VINEnd = ""
+	t.profileVIN = ""
+	t.VehicleName = ""
+	t.vehicleAmps = vehicleAmps{}
+	t.taper = allocator.Taper{}
+}
+
+// vehicleProfile returns the profile for the vehicle plugged into the secondary, the lock must be held
+func (p *TWCPrimary) vehicleProfile(twc *TWCSecondary) (VehicleProfile, bool) {
+	if twc.profileVIN == "" {
+		return VehicleProfile{}, false
+	}
+	profile, ok := p.Vehicles[twc.profileVIN]
+	return profile, ok
+}
+
+// recordEnergy adds any energy delivered since the last reading to the vehicle that is plugged in, the lifetime
+// kWh comes from the TWC so the difference between readings is what the vehicle has been given, the lock must
+// be held
+func (p *TWCPrimary) recordEnergy(twc *TWCSecondary, lifetimeKWH uint32) {
+	lastKWH := twc.lastKWH
+	twc.lastKWH = lifetimeKWH
+	if twc.profileVIN == "" || lastKWH == 0 || lifetimeKWH <= lastKWH {
+		return
+	}
+	today := time.Now().Format("2006-01-02")
+	energy, ok := p.vehicleEnergy[twc.profileVIN]
+	if !ok || energy.day != today {
+		energy = &dailyEnergy{day: today}
+		p.vehicleEnergy[twc.profileVIN] = energy
+	}
+	energy.kwh += lifetimeKWH - lastKWH
+}
+
+// dailyTargetReached returns true if the vehicle has been given its daily kWh target today, the lock must be held
+func (p *TWCPrimary) dailyTargetReached(vin string, profile VehicleProfile) bool {
+	if profile.DailyKWHTarget == 0 {
+		return false
+	}
+	energy, ok := p.vehicleEnergy[vin]
+	if !ok || energy.day != time.Now().Format("2006-01-02") {
+		return false
+	}
+	return energy.kwh >= profile.DailyKWHTarget
+}
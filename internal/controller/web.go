@@ -9,14 +9,18 @@ import (
 	"github.com/shreddedbacon/twcmanager/internal/ui"
 )
 
+// getBreadCrumbs returns a copy of the menu with the named page marked active, pages are rendered concurrently
+// so the shared menu must not be modified
 func getBreadCrumbs(name string) []BreadCrumb {
+	breadcrumbs := make([]BreadCrumb, len(breadcrumbMenu))
 	for i, bc := range breadcrumbMenu {
-		breadcrumbMenu[i].Active = ""
+		breadcrumbs[i] = bc
+		breadcrumbs[i].Active = ""
 		if bc.Name == name {
-			breadcrumbMenu[i].Active = "active"
+			breadcrumbs[i].Active = "active"
 		}
 	}
-	return breadcrumbMenu
+	return breadcrumbs
 }
 
 var breadcrumbMenu = []BreadCrumb{
@@ -32,6 +36,12 @@ var breadcrumbMenu = []BreadCrumb{
 	// 	Path:    "/",
 	// 	Active:  "",
 	// },
+	{
+		Name:    "History",
+		NavName: "History",
+		Path:    "/history",
+		Active:  "",
+	},
 	{
 		Name:    "Powerwall",
 		NavName: "Powerwall",
@@ -69,10 +79,15 @@ type SettingsPage struct {
 
 // TWCInfoPage .
 type TWCInfoPage struct {
-	PageName    string
-	BreadCrumbs []BreadCrumb
-	StatsData   TWCSecondary
-	PrimaryData TWCPrimary
+	PageName        string
+	BreadCrumbs     []BreadCrumb
+	StatsData       TWCSecondary
+	PrimaryData     TWCPrimary
+	SecondaryConfig SecondaryConfig
+	Goal            ChargeGoal  // the goal in use, from the vehicle profile or the secondary
+	ProfileGoal     bool        // the goal is from the profile of the vehicle plugged in
+	Plan            *ChargePlan // nil if there isn't a goal or nothing is plugged in
+	VehicleAmps     string      // how the vehicle api is setting the amps, empty if the TWC sets them
 }
 
 // BreadCrumb .
@@ -90,7 +105,7 @@ func (p *TWCPrimary) GetWallConnectors(w http.ResponseWriter, r *http.Request) {
 		BreadCrumbs: getBreadCrumbs("Wall Connectors"),
 		PageName:    "Wall Connectors",
 		StatsData:   p.getStats(),
-		PrimaryData: *p,
+		PrimaryData: p.snapshot(),
 	}
 	tpl1, _ := ui.Asset("templates/stats.html")
 	tpl2, _ := ui.Asset("templates/home.html")
@@ -114,8 +129,20 @@ func (p *TWCPrimary) GetWallConnectorInfo(w http.ResponseWriter, r *http.Request
 		BreadCrumbs: getBreadCrumbs("Wall Connectors"),
 		PageName:    "Wall Connector",
 		StatsData:   p.getTWCStats(bTWCID),
-		PrimaryData: *p,
+		PrimaryData: p.snapshot(),
+	}
+	p.mu.RLock()
+	pageData.SecondaryConfig = p.secondaryConfig(bTWCID)
+	if twc, ok := p.GetSecondary(bTWCID); ok {
+		pageData.Goal = p.chargeGoal(twc)
+		pageData.ProfileGoal = pageData.Goal != pageData.SecondaryConfig.Goal
+		if twc.plan != nil {
+			plan := *twc.plan
+			pageData.Plan = &plan
+		}
+		pageData.VehicleAmps = p.vehicleAmpsStatus(twc)
 	}
+	p.mu.RUnlock()
 	tpl1, _ := ui.Asset("templates/wcinfo.html")
 	tpl2, _ := ui.Asset("templates/home.html")
 	tpl3, _ := ui.Asset("templates/base.html")
@@ -0,0 +1,79 @@
+package energy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// HTTP is a source that reads a JSON document from a URL, it suits inverters with a local API such as Enphase,
+// SMA and Fronius
+type HTTP struct {
+	URL     string
+	Headers map[string]string // eg an Authorization header for the inverter
+	Paths   Paths
+	client  *http.Client
+}
+
+// NewHTTP creates a source that reads the values at the paths from the JSON document at the URL
+func NewHTTP(url string, headers map[string]string, paths Paths, insecure bool) *HTTP {
+	return &HTTP{
+		URL:     url,
+		Headers: headers,
+		Paths:   paths,
+		client:  newHTTPClient(insecure),
+	}
+}
+
+// Read fetches the document and picks the values out of it
+func (h *HTTP) Read(ctx context.Context) (Reading, error) {
+	b, err := get(ctx, h.client, h.URL, h.Headers)
+	if err != nil {
+		return Reading{}, err
+	}
+	v, err := decode(b)
+	if err != nil {
+		return Reading{}, fmt.Errorf("unable to decode the response from %s: %v", h.URL, err)
+	}
+	return readPaths(v, h.Paths)
+}
+
+// newHTTPClient returns a client for talking to devices on the local network, which often have self signed
+// certificates
+func newHTTPClient(insecure bool) *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+		},
+	}
+}
+
+// get performs a GET request and returns the body, anything other than a 200 is an error
+func get(ctx context.Context, client *http.Client, url string, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return b, nil
+}
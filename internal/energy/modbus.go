@@ -0,0 +1,285 @@
+package energy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/shreddedbacon/twcmanager/internal/modbus"
+)
+
+// sunspec markers, a device starts with "SunS" followed by a chain of models that ends with the end marker
+const (
+	sunspecMarker1      = 0x5375 // "Su"
+	sunspecMarker2      = 0x6e53 // "nS"
+	sunspecEnd          = 0xffff
+	sunspecScaleUnknown = -32768
+	maxModelsPerDevice  = 64
+)
+
+// sunspec register offsets, from the start of the model where its id is
+const (
+	// inverter models 101 single phase, 102 split phase and 103 three phase
+	inverterW   = 14
+	inverterWSF = 15
+	// meter models 201 single phase, 202 split phase, 203 wye and 204 delta, positive when importing
+	meterW   = 18
+	meterWSF = 22
+	// storage model 124
+	storageModel      = 124
+	storageChaState   = 8
+	storageChaStateSF = 22
+)
+
+// the addresses sunspec devices usually start at
+var sunspecBaseAddresses = []uint16{40000, 0, 50000}
+
+// Register is where a value is read from when it isn't read using sunspec, eg from a CT meter
+type Register struct {
+	UnitID   byte    `yaml:"unitID"`
+	Address  uint16  `yaml:"address"`
+	Input    bool    `yaml:"input"`              // an input register rather than a holding register
+	Type     string  `yaml:"type"`               // int16, uint16, int32, uint32 or float32, defaults to int16
+	Swap     bool    `yaml:"swap"`               // the low word comes first for 32 bit types
+	Scale    float64 `yaml:"scale"`              // multiplies the value, defaults to 1, use a negative scale to flip the sign
+	ScaleReg *uint16 `yaml:"scaleReg,omitempty"` // a sunspec scale factor register, applied as a power of ten
+}
+
+// Registers override where each value is read from, a value without a register is read using sunspec if the
+// device supports it
+type Registers struct {
+	Solar      *Register `yaml:"solar,omitempty"`
+	Load       *Register `yaml:"load,omitempty"` // if not set it is worked out from the solar, grid and battery
+	Grid       *Register `yaml:"grid,omitempty"`
+	Battery    *Register `yaml:"battery,omitempty"`
+	BatterySOC *Register `yaml:"batterySOC,omitempty"`
+}
+
+// ModbusConfig is the connection to an inverter or meter over Modbus TCP
+type ModbusConfig struct {
+	Address        string    `yaml:"address"`               // host:port, the port defaults to 502
+	InverterUnitID byte      `yaml:"inverterUnitID"`        // the unit with the sunspec inverter model, 0 if there isn't one
+	MeterUnitID    byte      `yaml:"meterUnitID"`           // the unit with the sunspec meter model at the grid connection, 0 if there isn't one
+	BaseAddress    *uint16   `yaml:"baseAddress,omitempty"` // where the sunspec models start, tries 40000, 0 and 50000 if not set
+	Registers      Registers `yaml:"registers"`
+}
+
+// Modbus is a source that reads a SunSpec inverter and meter, or any registers, over Modbus TCP
+type Modbus struct {
+	cfg    ModbusConfig
+	client *modbus.Client
+	mu     sync.Mutex
+	models map[byte]map[uint16]uint16 // the address of each model, keyed by unit id then model id
+}
+
+// NewModbus creates a source that reads the device at the address in the config
+func NewModbus(cfg ModbusConfig) (*Modbus, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("the modbus address is required")
+	}
+	address := cfg.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "502")
+	}
+	regs := cfg.Registers
+	if cfg.InverterUnitID == 0 && regs.Solar == nil {
+		return nil, fmt.Errorf("either the inverter unit id or a solar register is required")
+	}
+	return &Modbus{
+		cfg:    cfg,
+		client: modbus.NewClient(address, 5*time.Second),
+		models: map[byte]map[uint16]uint16{},
+	}, nil
+}
+
+// Read reads each value from its register, or from the sunspec models
+func (m *Modbus) Read(ctx context.Context) (Reading, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := Reading{Time: time.Now()}
+	regs := m.cfg.Registers
+	var err error
+	switch {
+	case regs.Solar != nil:
+		r.SolarWatts, err = m.readRegister(ctx, regs.Solar)
+	default:
+		// a value that isn't implemented usually means there's nothing to report, eg at night
+		r.SolarWatts, _, err = m.readModelValue(ctx, m.cfg.InverterUnitID, []uint16{101, 102, 103}, inverterW, inverterWSF, true)
+	}
+	if err != nil {
+		return Reading{}, fmt.Errorf("unable to read the solar: %v", err)
+	}
+	switch {
+	case regs.Grid != nil:
+		r.GridWatts, err = m.readRegister(ctx, regs.Grid)
+	case m.cfg.MeterUnitID != 0:
+		r.GridWatts, _, err = m.readModelValue(ctx, m.cfg.MeterUnitID, []uint16{201, 202, 203, 204}, meterW, meterWSF, true)
+	}
+	if err != nil {
+		return Reading{}, fmt.Errorf("unable to read the grid: %v", err)
+	}
+	if regs.Battery != nil {
+		r.BatteryWatts, err = m.readRegister(ctx, regs.Battery)
+		if err != nil {
+			return Reading{}, fmt.Errorf("unable to read the battery: %v", err)
+		}
+	}
+	switch {
+	case regs.BatterySOC != nil:
+		r.BatterySOC, err = m.readRegister(ctx, regs.BatterySOC)
+		r.HasSOC = err == nil
+	case m.cfg.InverterUnitID != 0:
+		// the storage model is optional, so it isn't an error if it isn't there
+		r.BatterySOC, r.HasSOC, err = m.readModelValue(ctx, m.cfg.InverterUnitID, []uint16{storageModel}, storageChaState, storageChaStateSF, false)
+		if errors.Is(err, errNoModel) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return Reading{}, fmt.Errorf("unable to read the battery charge: %v", err)
+	}
+	if regs.Load != nil {
+		r.LoadWatts, err = m.readRegister(ctx, regs.Load)
+		if err != nil {
+			return Reading{}, fmt.Errorf("unable to read the load: %v", err)
+		}
+	} else {
+		fillLoad(&r)
+	}
+	return r, nil
+}
+
+// readRegister reads a single value from a register
+func (m *Modbus) readRegister(ctx context.Context, reg *Register) (float64, error) {
+	words := uint16(1)
+	switch reg.Type {
+	case "int32", "uint32", "float32":
+		words = 2
+	case "", "int16", "uint16":
+	default:
+		return 0, fmt.Errorf("unknown register type %q", reg.Type)
+	}
+	read := m.client.ReadHoldingRegisters
+	if reg.Input {
+		read = m.client.ReadInputRegisters
+	}
+	values, err := read(ctx, reg.UnitID, reg.Address, words)
+	if err != nil {
+		return 0, err
+	}
+	var v float64
+	switch reg.Type {
+	case "", "int16":
+		v = float64(int16(values[0]))
+	case "uint16":
+		v = float64(values[0])
+	default:
+		hi, lo := values[0], values[1]
+		if reg.Swap {
+			hi, lo = lo, hi
+		}
+		u := uint32(hi)<<16 | uint32(lo)
+		switch reg.Type {
+		case "int32":
+			v = float64(int32(u))
+		case "uint32":
+			v = float64(u)
+		case "float32":
+			v = float64(math.Float32frombits(u))
+		}
+	}
+	if reg.Scale != 0 {
+		v *= reg.Scale
+	}
+	if reg.ScaleReg != nil {
+		sf, err := read(ctx, reg.UnitID, *reg.ScaleReg, 1)
+		if err != nil {
+			return 0, err
+		}
+		v *= math.Pow(10, float64(int16(sf[0])))
+	}
+	return v, nil
+}
+
+// errNoModel is returned when a device doesn't have any of the models asked for
+var errNoModel = errors.New("no matching sunspec model")
+
+// readModelValue reads a value and its scale factor from the first of the models the unit has, it returns false if
+// the device doesn't implement the value
+func (m *Modbus) readModelValue(ctx context.Context, unitID byte, models []uint16, offset, sfOffset uint16, signed bool) (float64, bool, error) {
+	found, err := m.sunspecModels(ctx, unitID)
+	if err != nil {
+		return 0, false, err
+	}
+	for _, model := range models {
+		start, ok := found[model]
+		if !ok {
+			continue
+		}
+		values, err := m.client.ReadHoldingRegisters(ctx, unitID, start, sfOffset+1)
+		if err != nil {
+			// the device may have been reconfigured, so look for the models again next time
+			delete(m.models, unitID)
+			return 0, false, err
+		}
+		raw, sf := values[offset], int16(values[sfOffset])
+		if sf == sunspecScaleUnknown || (signed && raw == 0x8000) || (!signed && raw == 0xffff) {
+			return 0, false, nil
+		}
+		v := float64(raw)
+		if signed {
+			v = float64(int16(raw))
+		}
+		return v * math.Pow(10, float64(sf)), true, nil
+	}
+	return 0, false, fmt.Errorf("unit %d: %w", unitID, errNoModel)
+}
+
+// sunspecModels finds the address of each model on a unit, the result is kept until a read fails
+func (m *Modbus) sunspecModels(ctx context.Context, unitID byte) (map[uint16]uint16, error) {
+	if models, ok := m.models[unitID]; ok {
+		return models, nil
+	}
+	bases := sunspecBaseAddresses
+	if m.cfg.BaseAddress != nil {
+		bases = []uint16{*m.cfg.BaseAddress}
+	}
+	var lastErr error
+	for _, base := range bases {
+		marker, err := m.client.ReadHoldingRegisters(ctx, unitID, base, 2)
+		if err != nil {
+			lastErr = err
+			var exception *modbus.Exception
+			if errors.As(err, &exception) {
+				continue
+			}
+			return nil, err
+		}
+		if marker[0] != sunspecMarker1 || marker[1] != sunspecMarker2 {
+			lastErr = fmt.Errorf("no sunspec marker at %d", base)
+			continue
+		}
+		models := map[uint16]uint16{}
+		address := base + 2
+		for i := 0; i < maxModelsPerDevice; i++ {
+			header, err := m.client.ReadHoldingRegisters(ctx, unitID, address, 2)
+			if err != nil {
+				return nil, err
+			}
+			if header[0] == sunspecEnd {
+				break
+			}
+			if _, ok := models[header[0]]; !ok {
+				models[header[0]] = address
+			}
+			address += 2 + header[1]
+		}
+		m.models[unitID] = models
+		return models, nil
+	}
+	return nil, fmt.Errorf("unit %d is not a sunspec device: %v", unitID, lastErr)
+}
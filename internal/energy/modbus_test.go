@@ -0,0 +1,342 @@
+package energy
+
+import (
+	"context"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shreddedbacon/twcmanager/internal/modbus"
+)
+
+// the units the inverter and the meter answer as
+const (
+	inverterUnit = 1
+	meterUnit    = 2
+)
+
+// sunspecModel is a model served by the test device
+type sunspecModel struct {
+	id     uint16
+	length uint16
+	set    map[uint16]uint16 // register values, keyed by the offset from the model id
+}
+
+// commonModel is the common model every sunspec device starts with, it isn't read
+var commonModel = sunspecModel{id: 1, length: 66}
+
+func inverter(id uint16, watts, sf int16) sunspecModel {
+	return sunspecModel{id: id, length: 50, set: map[uint16]uint16{inverterW: uint16(watts), inverterWSF: uint16(sf)}}
+}
+
+func meter(id uint16, watts, sf int16) sunspecModel {
+	return sunspecModel{id: id, length: 105, set: map[uint16]uint16{meterW: uint16(watts), meterWSF: uint16(sf)}}
+}
+
+func storage(chaState uint16, sf int16) sunspecModel {
+	return sunspecModel{id: storageModel, length: 24, set: map[uint16]uint16{storageChaState: chaState, storageChaStateSF: uint16(sf)}}
+}
+
+// serveSunSpec sets the registers of a sunspec device with the models on a unit, starting at base
+func serveSunSpec(s *modbus.Server, unitID byte, base uint16, models ...sunspecModel) {
+	registers := []uint16{sunspecMarker1, sunspecMarker2}
+	for _, model := range models {
+		body := make([]uint16, 2+model.length)
+		body[0], body[1] = model.id, model.length
+		for offset, v := range model.set {
+			body[offset] = v
+		}
+		registers = append(registers, body...)
+	}
+	registers = append(registers, sunspecEnd, 0)
+	s.SetHolding(unitID, base, registers...)
+}
+
+// startServer runs a modbus server until the test ends, and returns its address
+func startServer(t *testing.T) (*modbus.Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := modbus.NewServer()
+	go s.Serve(l)
+	return s, l.Addr().String()
+}
+
+// read reads the source once, with a timeout so a broken server fails the test rather than hanging it
+func read(t *testing.T, source Source) (Reading, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return source.Read(ctx)
+}
+
+// equal is true if the values are the same to within rounding from the scale factors
+func equal(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestModbusSunSpec(t *testing.T) {
+	tests := []struct {
+		name       string
+		base       uint16
+		baseCfg    *uint16
+		inverter   []sunspecModel
+		meter      []sunspecModel // nil when there isn't a meter
+		solar      float64
+		grid       float64
+		load       float64
+		batterySOC float64
+		hasSOC     bool
+	}{
+		{
+			name:     "single phase inverter",
+			base:     40000,
+			inverter: []sunspecModel{commonModel, inverter(101, 1500, 0)},
+			solar:    1500,
+			load:     1500,
+		},
+		{
+			name:     "three phase inverter with a scale factor",
+			base:     40000,
+			inverter: []sunspecModel{commonModel, inverter(103, 523, 1)},
+			meter:    []sunspecModel{commonModel, meter(203, -1230, 0)},
+			solar:    5230,
+			grid:     -1230,
+			load:     4000,
+		},
+		{
+			name:     "split phase inverter with a negative scale factor",
+			base:     40000,
+			inverter: []sunspecModel{commonModel, inverter(102, 31415, -2)},
+			meter:    []sunspecModel{commonModel, meter(201, 1000, 0)},
+			solar:    314.15,
+			grid:     1000,
+			load:     1314.15,
+		},
+		{
+			name:     "split phase meter",
+			base:     40000,
+			inverter: []sunspecModel{commonModel, inverter(101, 2000, 0)},
+			meter:    []sunspecModel{commonModel, meter(202, 4567, -1)},
+			solar:    2000,
+			grid:     456.7,
+			load:     2456.7,
+		},
+		{
+			name:     "delta meter",
+			base:     40000,
+			inverter: []sunspecModel{commonModel, inverter(101, 3000, 0)},
+			meter:    []sunspecModel{commonModel, meter(204, -25, 2)},
+			solar:    3000,
+			grid:     -2500,
+			load:     500,
+		},
+		{
+			name:     "inverter and meter on the same unit",
+			base:     40000,
+			inverter: []sunspecModel{commonModel, inverter(101, 2500, 0), meter(203, 500, 0)},
+			solar:    2500,
+			grid:     500,
+			load:     3000,
+		},
+		{
+			name:     "watts not implemented at night",
+			base:     40000,
+			inverter: []sunspecModel{commonModel, {id: 103, length: 50, set: map[uint16]uint16{inverterW: 0x8000, inverterWSF: 0}}},
+			meter:    []sunspecModel{commonModel, meter(203, 800, 0)},
+			grid:     800,
+			load:     800,
+		},
+		{
+			name:     "scale factor not implemented",
+			base:     40000,
+			inverter: []sunspecModel{commonModel, inverter(101, 1500, 0)},
+			meter:    []sunspecModel{commonModel, {id: 201, length: 105, set: map[uint16]uint16{meterW: 600, meterWSF: 0x8000}}},
+			solar:    1500,
+			load:     1500,
+		},
+		{
+			name:       "battery charge",
+			base:       40000,
+			inverter:   []sunspecModel{commonModel, inverter(103, 4000, 0), storage(8750, -2)},
+			solar:      4000,
+			load:       4000,
+			batterySOC: 87.5,
+			hasSOC:     true,
+		},
+		{
+			name:     "battery charge not implemented",
+			base:     40000,
+			inverter: []sunspecModel{commonModel, inverter(103, 4000, 0), storage(0xffff, 0)},
+			solar:    4000,
+			load:     4000,
+		},
+		{
+			name:     "models starting at 0",
+			base:     0,
+			inverter: []sunspecModel{commonModel, inverter(101, 1200, 0)},
+			solar:    1200,
+			load:     1200,
+		},
+		{
+			name:     "models starting at 50000",
+			base:     50000,
+			inverter: []sunspecModel{commonModel, inverter(101, 1200, 0)},
+			solar:    1200,
+			load:     1200,
+		},
+		{
+			name:     "configured base address",
+			base:     1000,
+			baseCfg:  func() *uint16 { b := uint16(1000); return &b }(),
+			inverter: []sunspecModel{commonModel, inverter(101, 1200, 0)},
+			solar:    1200,
+			load:     1200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, address := startServer(t)
+			serveSunSpec(s, inverterUnit, tt.base, tt.inverter...)
+			cfg := ModbusConfig{Address: address, InverterUnitID: inverterUnit, BaseAddress: tt.baseCfg}
+			if tt.meter != nil {
+				serveSunSpec(s, meterUnit, tt.base, tt.meter...)
+				cfg.MeterUnitID = meterUnit
+			} else if tt.grid != 0 {
+				// the meter model is on the inverter's unit
+				cfg.MeterUnitID = inverterUnit
+			}
+			source, err := NewModbus(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the second read uses the models found by the first
+			for i := 0; i < 2; i++ {
+				r, err := read(t, source)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !equal(r.SolarWatts, tt.solar) || !equal(r.GridWatts, tt.grid) || !equal(r.LoadWatts, tt.load) || !equal(r.BatterySOC, tt.batterySOC) {
+					t.Fatalf("read solar %vW, grid %vW, load %vW and %v%% charge, want %vW, %vW, %vW and %v%%",
+						r.SolarWatts, r.GridWatts, r.LoadWatts, r.BatterySOC, tt.solar, tt.grid, tt.load, tt.batterySOC)
+				}
+				if r.HasSOC != tt.hasSOC {
+					t.Fatalf("the battery charge was reported %v, want %v", r.HasSOC, tt.hasSOC)
+				}
+			}
+		})
+	}
+}
+
+func TestModbusSunSpecErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		setup     func(s *modbus.Server)
+		meter     bool
+		isNoModel bool
+	}{
+		{
+			name: "unit doesn't answer",
+			setup: func(s *modbus.Server) {
+				serveSunSpec(s, 9, 40000, commonModel, inverter(101, 1000, 0))
+			},
+		},
+		{
+			name: "not a sunspec device",
+			setup: func(s *modbus.Server) {
+				s.SetHolding(inverterUnit, 40000, 0x1234, 0x5678)
+			},
+		},
+		{
+			name: "no inverter model",
+			setup: func(s *modbus.Server) {
+				serveSunSpec(s, inverterUnit, 40000, commonModel, meter(203, 100, 0))
+			},
+			isNoModel: true,
+		},
+		{
+			name: "no meter model",
+			setup: func(s *modbus.Server) {
+				serveSunSpec(s, inverterUnit, 40000, commonModel, inverter(101, 1000, 0))
+				serveSunSpec(s, meterUnit, 40000, commonModel, inverter(101, 1000, 0))
+			},
+			meter:     true,
+			isNoModel: true,
+		},
+		{
+			name: "model cut short",
+			setup: func(s *modbus.Server) {
+				s.SetHolding(inverterUnit, 40000, sunspecMarker1, sunspecMarker2, 101, 50, 0, 0)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, address := startServer(t)
+			tt.setup(s)
+			cfg := ModbusConfig{Address: address, InverterUnitID: inverterUnit}
+			if tt.meter {
+				cfg.MeterUnitID = meterUnit
+			}
+			source, err := NewModbus(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := read(t, source)
+			if err == nil {
+				t.Fatalf("read %+v, want an error", r)
+			}
+			// the source adds what it was reading to the error, without wrapping it
+			if strings.Contains(err.Error(), errNoModel.Error()) != tt.isNoModel {
+				t.Fatalf("read returned %v, want a missing model error %v", err, tt.isNoModel)
+			}
+		})
+	}
+}
+
+func TestModbusRegisters(t *testing.T) {
+	s, address := startServer(t)
+	// the solar is a swapped int32, the grid a float32 input register on a meter, the battery a 16 bit value with
+	// its sign flipped, the charge has a sunspec scale factor and the load is unsigned
+	s.SetHolding(inverterUnit, 100, 0x86a0, 0x0001)
+	s.SetInput(meterUnit, 200, 0xc4a2, 0x8000)
+	s.SetHolding(inverterUnit, 300, uint16(0xffff-99))
+	s.SetHolding(inverterUnit, 400, 655, uint16(0xffff))
+	s.SetHolding(inverterUnit, 500, 60000)
+	scaleReg := uint16(401)
+	source, err := NewModbus(ModbusConfig{
+		Address: address,
+		Registers: Registers{
+			Solar:      &Register{UnitID: inverterUnit, Address: 100, Type: "int32", Swap: true, Scale: 0.01},
+			Grid:       &Register{UnitID: meterUnit, Address: 200, Input: true, Type: "float32"},
+			Battery:    &Register{UnitID: inverterUnit, Address: 300, Scale: -1},
+			BatterySOC: &Register{UnitID: inverterUnit, Address: 400, Type: "uint16", ScaleReg: &scaleReg},
+			Load:       &Register{UnitID: inverterUnit, Address: 500, Type: "uint16"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := read(t, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Reading{SolarWatts: 1000, GridWatts: -1300, BatteryWatts: 100, BatterySOC: 65.5, HasSOC: true, LoadWatts: 60000}
+	if !equal(r.SolarWatts, want.SolarWatts) || !equal(r.GridWatts, want.GridWatts) || !equal(r.BatteryWatts, want.BatteryWatts) ||
+		!equal(r.BatterySOC, want.BatterySOC) || r.HasSOC != want.HasSOC || !equal(r.LoadWatts, want.LoadWatts) {
+		t.Fatalf("read %+v, want %+v", r, want)
+	}
+
+	// a register that isn't there is an error
+	source, _ = NewModbus(ModbusConfig{
+		Address:   address,
+		Registers: Registers{Solar: &Register{UnitID: inverterUnit, Address: 900}},
+	})
+	if r, err := read(t, source); err == nil {
+		t.Fatalf("read %+v from a missing register", r)
+	}
+}
@@ -0,0 +1,174 @@
+package energy
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shreddedbacon/twcmanager/internal/mqtt"
+)
+
+// Topic is where a single value is published, the payload is either a plain number or a JSON document with the
+// value at the path
+type Topic struct {
+	Topic string `yaml:"topic"`
+	Path  string `yaml:"path"` // see Paths, a leading - negates a plain number too
+}
+
+// Topics are where each value is published, a topic left empty means the value isn't reported
+type Topics struct {
+	Solar      Topic `yaml:"solar"`
+	Load       Topic `yaml:"load"` // if empty it is worked out from the solar, grid and battery
+	Grid       Topic `yaml:"grid"`
+	Battery    Topic `yaml:"battery"`
+	BatterySOC Topic `yaml:"batterySOC"`
+}
+
+// MQTT is a source that keeps the latest values published to a set of topics
+type MQTT struct {
+	topics Topics
+	maxAge time.Duration
+	mu     sync.Mutex
+	values map[string]float64 // keyed by the field, several fields can be read from one topic
+	times  map[string]time.Time
+	errs   map[string]error
+}
+
+// mqttField is a value of the reading and where it is published
+type mqttField struct {
+	name  string
+	topic Topic
+}
+
+// NewMQTT subscribes to the topics on the client, a reading is only returned once every topic has been published
+// to within the max age
+func NewMQTT(client *mqtt.Client, topics Topics, maxAge time.Duration) (*MQTT, error) {
+	m, err := newMQTT(topics, maxAge)
+	if err != nil {
+		return nil, err
+	}
+	for topic, handler := range m.handlers() {
+		if err := client.Subscribe(topic, handler); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// newMQTT returns the source without subscribing to anything
+func newMQTT(topics Topics, maxAge time.Duration) (*MQTT, error) {
+	if topics.Solar.Topic == "" {
+		return nil, fmt.Errorf("the solar topic is required")
+	}
+	return &MQTT{
+		topics: topics,
+		maxAge: maxAge,
+		values: map[string]float64{},
+		times:  map[string]time.Time{},
+		errs:   map[string]error{},
+	}, nil
+}
+
+// fields returns the fields that are set
+func (m *MQTT) fields() []mqttField {
+	fields := []mqttField{}
+	for _, f := range []mqttField{
+		{"solar", m.topics.Solar},
+		{"load", m.topics.Load},
+		{"grid", m.topics.Grid},
+		{"battery", m.topics.Battery},
+		{"batterySOC", m.topics.BatterySOC},
+	} {
+		if f.topic.Topic != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// handlers returns a handler for each topic, the client keeps one handler for a topic, so a topic that carries
+// several fields, such as a JSON document with the solar and load at different paths, has one handler for all of them
+func (m *MQTT) handlers() map[string]mqtt.Handler {
+	byTopic := map[string][]mqttField{}
+	for _, f := range m.fields() {
+		byTopic[f.topic.Topic] = append(byTopic[f.topic.Topic], f)
+	}
+	handlers := map[string]mqtt.Handler{}
+	for topic, fields := range byTopic {
+		handlers[topic] = m.receive(fields)
+	}
+	return handlers
+}
+
+// receive returns the handler that keeps the latest value of each field published to a topic
+func (m *MQTT) receive(fields []mqttField) mqtt.Handler {
+	return func(topic string, payload []byte) {
+		now := time.Now()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for _, f := range fields {
+			value, err := parsePayload(payload, f.topic.Path)
+			m.errs[f.name] = err
+			if err != nil {
+				continue
+			}
+			m.values[f.name] = value
+			m.times[f.name] = now
+		}
+	}
+}
+
+// parsePayload reads a plain number, or the number at the path of a JSON document
+func parsePayload(payload []byte, path string) (float64, error) {
+	if strings.TrimPrefix(path, "-") == "" {
+		f, err := strconv.ParseFloat(strings.TrimSpace(string(payload)), 64)
+		if err != nil {
+			return 0, fmt.Errorf("payload is not a number")
+		}
+		if path == "-" {
+			f = -f
+		}
+		return f, nil
+	}
+	v, err := decode(payload)
+	if err != nil {
+		return 0, err
+	}
+	return lookup(v, path)
+}
+
+// Read returns the latest values, it is an error if any of them haven't been published recently
+func (m *MQTT) Read(ctx context.Context) (Reading, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	r := Reading{Time: now}
+	values := map[string]*float64{
+		"solar":      &r.SolarWatts,
+		"load":       &r.LoadWatts,
+		"grid":       &r.GridWatts,
+		"battery":    &r.BatteryWatts,
+		"batterySOC": &r.BatterySOC,
+	}
+	for _, f := range m.fields() {
+		if err := m.errs[f.name]; err != nil {
+			return Reading{}, fmt.Errorf("%s %s: %v", f.name, f.topic.Topic, err)
+		}
+		t, ok := m.times[f.name]
+		if !ok || now.Sub(t) > m.maxAge {
+			return Reading{}, fmt.Errorf("nothing published to %s recently", f.topic.Topic)
+		}
+		*values[f.name] = m.values[f.name]
+		if t.Before(r.Time) {
+			r.Time = t
+		}
+	}
+	r.HasSOC = m.topics.BatterySOC.Topic != ""
+	if m.topics.Load.Topic == "" {
+		fillLoad(&r)
+	}
+	return r, nil
+}
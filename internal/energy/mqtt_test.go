@@ -0,0 +1,99 @@
+package energy
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMQTT(t *testing.T) {
+	type publish struct {
+		topic   string
+		payload string
+	}
+	tests := []struct {
+		name     string
+		topics   Topics
+		publish  []publish
+		handlers int // the topics subscribed to
+		want     Reading
+		wantErr  string
+	}{
+		{
+			name: "a topic for each value",
+			topics: Topics{
+				Solar: Topic{Topic: "solar"},
+				Grid:  Topic{Topic: "grid", Path: "-"},
+			},
+			publish:  []publish{{"solar", "3000"}, {"grid", "500"}},
+			handlers: 2,
+			// the load is worked out from the solar and grid
+			want: Reading{SolarWatts: 3000, GridWatts: -500, LoadWatts: 2500},
+		},
+		{
+			name: "several values on one topic",
+			topics: Topics{
+				Solar:      Topic{Topic: "sma/inverter", Path: "PACTot"},
+				Load:       Topic{Topic: "sma/inverter", Path: "Load"},
+				BatterySOC: Topic{Topic: "sma/inverter", Path: "Battery.SOC"},
+				Grid:       Topic{Topic: "sma/meter/grid"},
+			},
+			publish: []publish{
+				{"sma/inverter", `{"PACTot": 4000, "Load": 1200, "Battery": {"SOC": 80}}`},
+				{"sma/meter/grid", "-2800"},
+			},
+			handlers: 2,
+			want:     Reading{SolarWatts: 4000, LoadWatts: 1200, GridWatts: -2800, BatterySOC: 80, HasSOC: true},
+		},
+		{
+			name: "a value missing from a shared topic",
+			topics: Topics{
+				Solar: Topic{Topic: "sma/inverter", Path: "PACTot"},
+				Load:  Topic{Topic: "sma/inverter", Path: "Load"},
+			},
+			publish:  []publish{{"sma/inverter", `{"PACTot": 4000}`}},
+			handlers: 1,
+			wantErr:  "load sma/inverter",
+		},
+		{
+			name: "nothing published",
+			topics: Topics{
+				Solar: Topic{Topic: "solar"},
+				Grid:  Topic{Topic: "grid"},
+			},
+			publish:  []publish{{"solar", "3000"}},
+			handlers: 2,
+			wantErr:  "nothing published to grid recently",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newMQTT(tt.topics, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			handlers := m.handlers()
+			if len(handlers) != tt.handlers {
+				t.Fatalf("subscribed to %d topics, want %d", len(handlers), tt.handlers)
+			}
+			for _, p := range tt.publish {
+				handlers[p.topic](p.topic, []byte(p.payload))
+			}
+			got, err := m.Read(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("returned %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got.Time = time.Time{}
+			if got != tt.want {
+				t.Fatalf("read %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
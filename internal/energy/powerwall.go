@@ -0,0 +1,67 @@
+package energy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	powerwall "github.com/shreddedbacon/fake-powerwall/api"
+)
+
+// Powerwall is a source that reads the local API of a Tesla Powerwall, or of fake-powerwall
+type Powerwall struct {
+	Address string // eg https://powerwall.local
+	client  *http.Client
+}
+
+// NewPowerwall creates a source that reads the powerwall at the address
+func NewPowerwall(address string) *Powerwall {
+	return &Powerwall{
+		Address: strings.TrimSuffix(address, "/"),
+		// the powerwall uses a self signed certificate
+		client: newHTTPClient(true),
+	}
+}
+
+// Read fetches the meter aggregates, and the battery percentage if the powerwall reports it
+func (p *Powerwall) Read(ctx context.Context) (Reading, error) {
+	b, err := get(ctx, p.client, p.Address+"/api/meters/aggregates", nil)
+	if err != nil {
+		return Reading{}, err
+	}
+	d := powerwall.MetersAggregates{}
+	if err := json.Unmarshal(b, &d); err != nil {
+		return Reading{}, fmt.Errorf("unable to decode the meter aggregates: %v", err)
+	}
+	r := Reading{Time: time.Now()}
+	if d.Solar != nil {
+		r.SolarWatts = d.Solar.InstantPower
+	}
+	if d.Load != nil {
+		r.LoadWatts = d.Load.InstantPower
+	}
+	if d.Site != nil {
+		r.GridWatts = d.Site.InstantPower
+	}
+	if d.Battery != nil {
+		r.BatteryWatts = d.Battery.InstantPower
+	}
+	// not every powerwall, or fake-powerwall, reports the battery percentage, so carry on without it
+	b, err = get(ctx, p.client, p.Address+"/api/system_status/soe", nil)
+	if err == nil {
+		soe := powerwall.SystemSOE{}
+		if json.Unmarshal(b, &soe) == nil {
+			r.BatterySOC = soe.Percentage
+			r.HasSOC = true
+		}
+	}
+	return r, nil
+}
+
+// Aggregates returns the raw meter aggregates from the powerwall
+func (p *Powerwall) Aggregates(ctx context.Context) ([]byte, error) {
+	return get(ctx, p.client, p.Address+"/api/meters/aggregates", nil)
+}
@@ -0,0 +1,143 @@
+// Package energy reads the solar generation, house load, grid and battery power from whatever the site has, so
+// the controller can charge from the solar that would otherwise be exported
+package energy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reading is the power flowing around the site at a point in time, in watts
+type Reading struct {
+	SolarWatts   float64   `json:"solarWatts" yaml:"solarWatts"`
+	LoadWatts    float64   `json:"loadWatts" yaml:"loadWatts"`       // the house load, including the chargers
+	GridWatts    float64   `json:"gridWatts" yaml:"gridWatts"`       // positive when importing, negative when exporting
+	BatteryWatts float64   `json:"batteryWatts" yaml:"batteryWatts"` // positive when discharging, negative when charging
+	BatterySOC   float64   `json:"batterySOC" yaml:"batterySOC"`     // percent, only set if HasSOC is
+	HasSOC       bool      `json:"hasSOC" yaml:"hasSOC"`             // false if the source doesn't report the battery charge
+	Time         time.Time `json:"time" yaml:"-"`
+}
+
+// Source is something that can report the power flowing around the site
+type Source interface {
+	// Read returns the latest reading, it should give up when the context is done
+	Read(ctx context.Context) (Reading, error)
+}
+
+// Paths are where each value is found in a JSON document, paths are dot separated object keys and array indexes
+// (eg production.1.wNow), a leading - negates the value, an empty path means the value isn't reported
+type Paths struct {
+	Solar      string `yaml:"solar"`
+	Load       string `yaml:"load"` // if empty it is worked out from the solar, grid and battery
+	Grid       string `yaml:"grid"`
+	Battery    string `yaml:"battery"`
+	BatterySOC string `yaml:"batterySOC"`
+}
+
+// Static is a source that always reports the same values, for testing or for sites without anything to read from
+type Static struct {
+	Reading Reading
+}
+
+// Read returns the configured values
+func (s *Static) Read(ctx context.Context) (Reading, error) {
+	r := s.Reading
+	r.Time = time.Now()
+	return r, nil
+}
+
+// fillLoad works out the house load from the other values when it isn't reported
+func fillLoad(r *Reading) {
+	r.LoadWatts = r.SolarWatts + r.GridWatts + r.BatteryWatts
+	if r.LoadWatts < 0 {
+		r.LoadWatts = 0
+	}
+}
+
+// decode unmarshals a JSON document keeping numbers as they are written
+func decode(b []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// lookup finds the number at a path in a decoded JSON document, numbers written as strings are accepted too
+func lookup(v interface{}, path string) (float64, error) {
+	sign := 1.0
+	if strings.HasPrefix(path, "-") {
+		sign = -1
+		path = path[1:]
+	}
+	if path != "" {
+		for _, key := range strings.Split(path, ".") {
+			switch node := v.(type) {
+			case map[string]interface{}:
+				next, ok := node[key]
+				if !ok {
+					return 0, fmt.Errorf("%s not found", path)
+				}
+				v = next
+			case []interface{}:
+				i, err := strconv.Atoi(key)
+				if err != nil || i < 0 || i >= len(node) {
+					return 0, fmt.Errorf("%s not found", path)
+				}
+				v = node[i]
+			default:
+				return 0, fmt.Errorf("%s not found", path)
+			}
+		}
+	}
+	var s string
+	switch n := v.(type) {
+	case json.Number:
+		s = n.String()
+	case string:
+		s = strings.TrimSpace(n)
+	default:
+		return 0, fmt.Errorf("%s is not a number", path)
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a number", path)
+	}
+	return f * sign, nil
+}
+
+// readPaths fills a reading from a JSON document
+func readPaths(v interface{}, paths Paths) (Reading, error) {
+	r := Reading{Time: time.Now()}
+	for _, field := range []struct {
+		path  string
+		value *float64
+	}{
+		{paths.Solar, &r.SolarWatts},
+		{paths.Load, &r.LoadWatts},
+		{paths.Grid, &r.GridWatts},
+		{paths.Battery, &r.BatteryWatts},
+		{paths.BatterySOC, &r.BatterySOC},
+	} {
+		if field.path == "" {
+			continue
+		}
+		f, err := lookup(v, field.path)
+		if err != nil {
+			return Reading{}, err
+		}
+		*field.value = f
+	}
+	r.HasSOC = paths.BatterySOC != ""
+	if paths.Load == "" {
+		fillLoad(&r)
+	}
+	return r, nil
+}
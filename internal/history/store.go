@@ -0,0 +1,223 @@
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var sessionsBucket = []byte("sessions")
+
+// Session is a single charging session, from a car being plugged in to a TWC until it is unplugged
+type Session struct {
+	ID          uint64      `json:"id"`
+	TWCID       string      `json:"twcID"`
+	VIN         string      `json:"vin"`
+	VehicleName string      `json:"vehicleName"`
+	Start       time.Time   `json:"start"`
+	End         time.Time   `json:"end"` // zero while the session is still open
+	Updated     time.Time   `json:"updated"`
+	StartKWH    uint32      `json:"startKWH"` // the lifetime kWh of the TWC when the session started
+	KWH         uint32      `json:"kWh"`      // the energy delivered during the session
+	PeakAmps    float64     `json:"peakAmps"`
+	AverageAmps float64     `json:"averageAmps"`       // averaged over the time the car was drawing current
+	SolarShare  float64     `json:"solarShare"`        // the fraction of the current that was covered by solar, 0 to 1
+	Tariffs     []TariffKWH `json:"tariffs,omitempty"` // the energy delivered at each price, sessions saved before it was kept don't have it
+
+	// running totals used to work out the averages
+	Samples        int     `json:"samples"`
+	AmpsTotal      float64 `json:"ampsTotal"`
+	SolarAmpsTotal float64 `json:"solarAmpsTotal"`
+}
+
+// TariffKWH is the energy delivered in a tariff window, or outside the windows if the window is empty, and the price
+// per kWh at the time
+type TariffKWH struct {
+	Window      string  `json:"window"`
+	PricePerKWH float64 `json:"pricePerKWH"`
+	KWH         uint32  `json:"kWh"`
+}
+
+// Open returns true if the session hasn't been closed yet
+func (s *Session) Open() bool {
+	return s.End.IsZero()
+}
+
+// Sample adds a reading of the current the car is drawing, and how much of it is covered by solar
+func (s *Session) Sample(amps, solarAmps float64) {
+	s.Updated = time.Now()
+	if amps <= 0 {
+		return
+	}
+	if solarAmps > amps {
+		solarAmps = amps
+	}
+	if amps > s.PeakAmps {
+		s.PeakAmps = amps
+	}
+	s.Samples++
+	s.AmpsTotal += amps
+	s.SolarAmpsTotal += solarAmps
+	s.AverageAmps = s.AmpsTotal / float64(s.Samples)
+	s.SolarShare = s.SolarAmpsTotal / s.AmpsTotal
+}
+
+// Meter records the lifetime kWh reported by the TWC, the first reading is taken as the start of the session, the
+// energy delivered since the last reading is put down to the tariff window it is read in
+func (s *Session) Meter(lifetimeKWH uint32, window string, pricePerKWH float64) {
+	s.Updated = time.Now()
+	if s.StartKWH == 0 {
+		s.StartKWH = lifetimeKWH
+	}
+	if lifetimeKWH <= s.StartKWH || lifetimeKWH-s.StartKWH <= s.KWH {
+		return
+	}
+	delivered := lifetimeKWH - s.StartKWH - s.KWH
+	s.KWH += delivered
+	for i, t := range s.Tariffs {
+		if t.Window == window && t.PricePerKWH == pricePerKWH {
+			s.Tariffs[i].KWH += delivered
+			return
+		}
+	}
+	s.Tariffs = append(s.Tariffs, TariffKWH{Window: window, PricePerKWH: pricePerKWH, KWH: delivered})
+}
+
+// Cost returns what the energy delivered cost, the energy that isn't put down to a tariff window is charged at the
+// price given
+func (s *Session) Cost(pricePerKWH float64) float64 {
+	cost := float64(0)
+	kwh := s.KWH
+	for _, t := range s.Tariffs {
+		cost += float64(t.KWH) * t.PricePerKWH
+		if t.KWH < kwh {
+			kwh -= t.KWH
+		} else {
+			kwh = 0
+		}
+	}
+	return cost + float64(kwh)*pricePerKWH
+}
+
+// Store keeps the charging sessions in a bolt database on disk
+type Store struct {
+	db *bolt.DB
+}
+
+// OpenStore opens, or creates, the session store at the given path, any sessions left open when the controller
+// last stopped are closed at the time they were last updated
+func OpenStore(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{db: db}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(sessionsBucket)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			session := Session{}
+			if err := json.Unmarshal(v, &session); err != nil {
+				return err
+			}
+			if !session.Open() {
+				return nil
+			}
+			session.End = session.Updated
+			d, err := json.Marshal(session)
+			if err != nil {
+				return err
+			}
+			return b.Put(k, d)
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to open session store: %v", err)
+	}
+	return s, nil
+}
+
+// Close closes the store
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Save writes the session to the store, a new session is given the next ID
+func (s *Store) Save(session *Session) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sessionsBucket)
+		if session.ID == 0 {
+			id, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			session.ID = id
+		}
+		d, err := json.Marshal(session)
+		if err != nil {
+			return err
+		}
+		return b.Put(key(session.ID), d)
+	})
+}
+
+// List returns a page of sessions, newest first, and the total number of sessions in the store
+func (s *Store) List(offset, limit int) ([]Session, int, error) {
+	sessions := []Session{}
+	total := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sessionsBucket)
+		total = b.Stats().KeyN
+		c := b.Cursor()
+		i := 0
+		for k, v := c.Last(); k != nil && len(sessions) < limit; k, v = c.Prev() {
+			if i < offset {
+				i++
+				continue
+			}
+			session := Session{}
+			if err := json.Unmarshal(v, &session); err != nil {
+				return err
+			}
+			sessions = append(sessions, session)
+		}
+		return nil
+	})
+	return sessions, total, err
+}
+
+// Between returns the sessions that started from the first time up to, but not including, the second, oldest first
+func (s *Store) Between(from, to time.Time) ([]Session, error) {
+	sessions := []Session{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).ForEach(func(k, v []byte) error {
+			session := Session{}
+			if err := json.Unmarshal(v, &session); err != nil {
+				return err
+			}
+			if session.Start.Before(from) || !session.Start.Before(to) {
+				return nil
+			}
+			sessions = append(sessions, session)
+			return nil
+		})
+	})
+	return sessions, err
+}
+
+func key(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
@@ -0,0 +1,82 @@
+package history
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSessionMeter(t *testing.T) {
+	type reading struct {
+		lifetimeKWH uint32
+		window      string
+		price       float64
+	}
+	tests := []struct {
+		name     string
+		readings []reading
+		kwh      uint32
+		tariffs  []TariffKWH
+		cost     float64 // at 0.30 for anything outside the tariffs
+	}{
+		{
+			name:     "the first reading starts the session",
+			readings: []reading{{1000, "", 0.30}},
+		},
+		{
+			name:     "outside the windows",
+			readings: []reading{{1000, "", 0.30}, {1004, "", 0.30}},
+			kwh:      4,
+			tariffs:  []TariffKWH{{Window: "", PricePerKWH: 0.30, KWH: 4}},
+			cost:     1.20,
+		},
+		{
+			name: "across two windows",
+			readings: []reading{
+				{1000, "", 0.30},
+				{1002, "", 0.30},
+				{1003, "off-peak", 0.12},
+				{1010, "off-peak", 0.12},
+				{1011, "", 0.30},
+			},
+			kwh: 11,
+			tariffs: []TariffKWH{
+				{Window: "", PricePerKWH: 0.30, KWH: 3},
+				{Window: "off-peak", PricePerKWH: 0.12, KWH: 8},
+			},
+			cost: 3*0.30 + 8*0.12,
+		},
+		{
+			name:     "a reading that goes backwards isn't counted",
+			readings: []reading{{1000, "", 0.30}, {1004, "", 0.30}, {1002, "off-peak", 0.12}, {1005, "off-peak", 0.12}},
+			kwh:      5,
+			tariffs: []TariffKWH{
+				{Window: "", PricePerKWH: 0.30, KWH: 4},
+				{Window: "off-peak", PricePerKWH: 0.12, KWH: 1},
+			},
+			cost: 4*0.30 + 0.12,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Session{}
+			for _, r := range tt.readings {
+				s.Meter(r.lifetimeKWH, r.window, r.price)
+			}
+			if s.KWH != tt.kwh || !reflect.DeepEqual(s.Tariffs, tt.tariffs) {
+				t.Fatalf("metered %d kWh in %+v, want %d in %+v", s.KWH, s.Tariffs, tt.kwh, tt.tariffs)
+			}
+			if cost := s.Cost(0.30); math.Abs(cost-tt.cost) > 1e-9 {
+				t.Fatalf("cost %v, want %v", cost, tt.cost)
+			}
+		})
+	}
+}
+
+func TestSessionCostWithoutTariffs(t *testing.T) {
+	// a session saved before the tariffs were kept is charged at the price given
+	s := &Session{KWH: 10}
+	if cost := s.Cost(0.25); math.Abs(cost-2.5) > 1e-9 {
+		t.Fatalf("cost %v, want 2.5", cost)
+	}
+}
@@ -0,0 +1,180 @@
+// Package modbus is a small Modbus TCP client, it only reads registers, which is all that is needed to read
+// inverters and energy meters
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// function codes
+const (
+	FuncReadHoldingRegisters = 0x03
+	FuncReadInputRegisters   = 0x04
+)
+
+// exception codes
+const (
+	ExceptionIllegalFunction    = 0x01
+	ExceptionIllegalDataAddress = 0x02
+	ExceptionIllegalDataValue   = 0x03
+	ExceptionGatewayTargetFail  = 0x0B
+)
+
+// MaxRegisters is the most registers that can be read in a single request
+const MaxRegisters = 125
+
+// Exception is returned when the device replies with an exception
+type Exception struct {
+	Function byte
+	Code     byte
+}
+
+func (e *Exception) Error() string {
+	switch e.Code {
+	case ExceptionIllegalFunction:
+		return fmt.Sprintf("modbus exception: illegal function 0x%02x", e.Function)
+	case ExceptionIllegalDataAddress:
+		return "modbus exception: illegal data address"
+	case ExceptionIllegalDataValue:
+		return "modbus exception: illegal data value"
+	case ExceptionGatewayTargetFail:
+		return "modbus exception: gateway target device failed to respond"
+	}
+	return fmt.Sprintf("modbus exception: code 0x%02x", e.Code)
+}
+
+// Client is a connection to a Modbus TCP device, it connects when it is first used and again after any error,
+// requests from different goroutines are sent one at a time
+type Client struct {
+	address string
+	timeout time.Duration
+	mu      sync.Mutex
+	conn    net.Conn
+	txID    uint16
+}
+
+// NewClient creates a client for the device at the address, eg 192.168.1.60:502
+func NewClient(address string, timeout time.Duration) *Client {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &Client{address: address, timeout: timeout}
+}
+
+// ReadHoldingRegisters reads quantity holding registers starting at address
+func (c *Client) ReadHoldingRegisters(ctx context.Context, unitID byte, address, quantity uint16) ([]uint16, error) {
+	return c.read(ctx, unitID, FuncReadHoldingRegisters, address, quantity)
+}
+
+// ReadInputRegisters reads quantity input registers starting at address
+func (c *Client) ReadInputRegisters(ctx context.Context, unitID byte, address, quantity uint16) ([]uint16, error) {
+	return c.read(ctx, unitID, FuncReadInputRegisters, address, quantity)
+}
+
+// Close closes the connection, the next request connects again
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// read sends a read request and waits for the reply
+func (c *Client) read(ctx context.Context, unitID, function byte, address, quantity uint16) ([]uint16, error) {
+	if quantity == 0 || quantity > MaxRegisters {
+		return nil, fmt.Errorf("can only read 1 to %d registers at a time", MaxRegisters)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		d := net.Dialer{Timeout: c.timeout}
+		conn, err := d.DialContext(ctx, "tcp", c.address)
+		if err != nil {
+			return nil, err
+		}
+		c.conn = conn
+	}
+	c.txID++
+	pdu := []byte{function, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(pdu[1:], address)
+	binary.BigEndian.PutUint16(pdu[3:], quantity)
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.conn.SetDeadline(deadline)
+	reply, err := c.exchange(unitID, pdu)
+	if err != nil {
+		// the connection is in an unknown state, so start again next time
+		c.conn.Close()
+		c.conn = nil
+		return nil, err
+	}
+	if reply[0] == function|0x80 {
+		if len(reply) < 2 {
+			return nil, fmt.Errorf("short exception reply")
+		}
+		return nil, &Exception{Function: function, Code: reply[1]}
+	}
+	if reply[0] != function || len(reply) < 2 || int(reply[1]) != int(quantity)*2 || len(reply) != 2+int(quantity)*2 {
+		return nil, fmt.Errorf("unexpected reply to function 0x%02x", function)
+	}
+	values := make([]uint16, quantity)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint16(reply[2+i*2:])
+	}
+	return values, nil
+}
+
+// exchange writes a request with the MBAP header and reads the matching reply, the lock must be held
+func (c *Client) exchange(unitID byte, pdu []byte) ([]byte, error) {
+	req := make([]byte, 7+len(pdu))
+	binary.BigEndian.PutUint16(req[0:], c.txID)
+	binary.BigEndian.PutUint16(req[4:], uint16(len(pdu)+1))
+	req[6] = unitID
+	copy(req[7:], pdu)
+	if _, err := c.conn.Write(req); err != nil {
+		return nil, err
+	}
+	for {
+		txID, replyUnit, reply, err := readADU(c.conn)
+		if err != nil {
+			return nil, err
+		}
+		// skip replies to earlier requests that timed out
+		if txID != c.txID || replyUnit != unitID {
+			continue
+		}
+		return reply, nil
+	}
+}
+
+// readADU reads a single application data unit, returning the transaction id, the unit id and the pdu
+func readADU(r io.Reader) (uint16, byte, []byte, error) {
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, 0, nil, err
+	}
+	if binary.BigEndian.Uint16(header[2:]) != 0 {
+		return 0, 0, nil, fmt.Errorf("not a modbus tcp frame")
+	}
+	length := int(binary.BigEndian.Uint16(header[4:]))
+	if length < 2 || length > 254 {
+		return 0, 0, nil, fmt.Errorf("bad modbus tcp frame length %d", length)
+	}
+	pdu := make([]byte, length-1)
+	if _, err := io.ReadFull(r, pdu); err != nil {
+		return 0, 0, nil, err
+	}
+	return binary.BigEndian.Uint16(header[0:]), header[6], pdu, nil
+}
@@ -0,0 +1,115 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+)
+
+// Server is a stand-in for a Modbus TCP device, it serves registers from memory so the energy sources can be
+// tried without an inverter or meter
+type Server struct {
+	mu      sync.Mutex
+	holding map[byte]map[uint16]uint16 // keyed by unit id, then address
+	input   map[byte]map[uint16]uint16
+}
+
+// NewServer creates a server with no registers
+func NewServer() *Server {
+	return &Server{
+		holding: map[byte]map[uint16]uint16{},
+		input:   map[byte]map[uint16]uint16{},
+	}
+}
+
+// SetHolding sets holding registers for a unit starting at address, the unit answers requests once it has any
+// registers
+func (s *Server) SetHolding(unitID byte, address uint16, values ...uint16) {
+	s.set(s.holding, unitID, address, values)
+}
+
+// SetInput sets input registers for a unit starting at address
+func (s *Server) SetInput(unitID byte, address uint16, values ...uint16) {
+	s.set(s.input, unitID, address, values)
+}
+
+func (s *Server) set(registers map[byte]map[uint16]uint16, unitID byte, address uint16, values []uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if registers[unitID] == nil {
+		registers[unitID] = map[uint16]uint16{}
+	}
+	for i, v := range values {
+		registers[unitID][address+uint16(i)] = v
+	}
+}
+
+// Serve answers requests on the listener until it is closed
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn answers requests on a single connection until it fails
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		txID, unitID, pdu, err := readADU(conn)
+		if err != nil {
+			return
+		}
+		reply := s.handle(unitID, pdu)
+		adu := make([]byte, 7+len(reply))
+		binary.BigEndian.PutUint16(adu[0:], txID)
+		binary.BigEndian.PutUint16(adu[4:], uint16(len(reply)+1))
+		adu[6] = unitID
+		copy(adu[7:], reply)
+		if _, err := conn.Write(adu); err != nil {
+			return
+		}
+	}
+}
+
+// handle returns the reply pdu for a request pdu
+func (s *Server) handle(unitID byte, pdu []byte) []byte {
+	function := pdu[0]
+	exception := func(code byte) []byte {
+		return []byte{function | 0x80, code}
+	}
+	var registers map[byte]map[uint16]uint16
+	switch function {
+	case FuncReadHoldingRegisters:
+		registers = s.holding
+	case FuncReadInputRegisters:
+		registers = s.input
+	default:
+		return exception(ExceptionIllegalFunction)
+	}
+	if len(pdu) != 5 {
+		return exception(ExceptionIllegalDataValue)
+	}
+	address := binary.BigEndian.Uint16(pdu[1:])
+	quantity := binary.BigEndian.Uint16(pdu[3:])
+	if quantity == 0 || quantity > MaxRegisters {
+		return exception(ExceptionIllegalDataValue)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.holding[unitID] == nil && s.input[unitID] == nil {
+		return exception(ExceptionGatewayTargetFail)
+	}
+	reply := []byte{function, byte(quantity * 2)}
+	for i := uint16(0); i < quantity; i++ {
+		v, ok := registers[unitID][address+i]
+		if !ok {
+			return exception(ExceptionIllegalDataAddress)
+		}
+		reply = append(reply, byte(v>>8), byte(v))
+	}
+	return reply
+}
@@ -0,0 +1,83 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClientAndServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s := NewServer()
+	s.SetHolding(1, 40000, 0x5375, 0x6e53, 1, 66)
+	s.SetInput(1, 30000, 0x8000, 0xffff)
+	go s.Serve(l)
+	c := NewClient(l.Addr().String(), time.Second)
+	defer c.Close()
+	ctx := context.Background()
+
+	values, err := c.ReadHoldingRegisters(ctx, 1, 40000, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 4 || values[0] != 0x5375 || values[3] != 66 {
+		t.Fatalf("read %X from the holding registers", values)
+	}
+	values, err = c.ReadInputRegisters(ctx, 1, 30000, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values[0] != 0x8000 || values[1] != 0xffff {
+		t.Fatalf("read %X from the input registers", values)
+	}
+
+	tests := []struct {
+		name     string
+		read     func() ([]uint16, error)
+		function byte
+		code     byte
+	}{
+		{
+			name:     "past the end of the registers",
+			read:     func() ([]uint16, error) { return c.ReadHoldingRegisters(ctx, 1, 40002, 3) },
+			function: FuncReadHoldingRegisters,
+			code:     ExceptionIllegalDataAddress,
+		},
+		{
+			name:     "holding registers read as input registers",
+			read:     func() ([]uint16, error) { return c.ReadInputRegisters(ctx, 1, 40000, 1) },
+			function: FuncReadInputRegisters,
+			code:     ExceptionIllegalDataAddress,
+		},
+		{
+			name:     "unit without any registers",
+			read:     func() ([]uint16, error) { return c.ReadHoldingRegisters(ctx, 2, 40000, 1) },
+			function: FuncReadHoldingRegisters,
+			code:     ExceptionGatewayTargetFail,
+		},
+	}
+	for _, tt := range tests {
+		values, err := tt.read()
+		var exception *Exception
+		if !errors.As(err, &exception) {
+			t.Fatalf("%s: read %X, %v, want an exception", tt.name, values, err)
+		}
+		if exception.Function != tt.function || exception.Code != tt.code {
+			t.Fatalf("%s: got exception %d for function %d, want %d for %d", tt.name, exception.Code, exception.Function, tt.code, tt.function)
+		}
+	}
+
+	// an exception doesn't close the connection, and a request that is too large isn't sent
+	if _, err := c.ReadHoldingRegisters(ctx, 1, 40000, MaxRegisters+1); err == nil {
+		t.Fatal("read more than the most registers allowed")
+	}
+	if _, err := c.ReadHoldingRegisters(ctx, 1, 40000, 1); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,522 @@
+// Package mqtt is a small MQTT 3.1.1 client, it only does what the controller needs, publishing at QoS 0 and
+// subscribing to command topics
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// control packet types
+const (
+	packetConnect    = 1
+	packetConnAck    = 2
+	packetPublish    = 3
+	packetPubAck     = 4
+	packetSubscribe  = 8
+	packetSubAck     = 9
+	packetPingReq    = 12
+	packetPingResp   = 13
+	packetDisconnect = 14
+)
+
+// how long to wait before trying to connect again, it doubles after each failure up to the maximum
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 2 * time.Minute
+)
+
+// maxPacketSize is the largest packet accepted from the broker, the controller only subscribes to short commands
+// and readings, so anything bigger is skipped rather than held in memory
+const maxPacketSize = 64 * 1024
+
+// receiveQueue is how many received messages can wait for their handlers before more are dropped
+const receiveQueue = 64
+
+// ErrNotConnected is returned when publishing while the client isn't connected to the broker
+var ErrNotConnected = errors.New("not connected to the broker")
+
+// errPacketTooLarge is returned by readPacket for a packet over maxPacketSize, the packet has been read past so the
+// connection can carry on
+var errPacketTooLarge = errors.New("packet too large")
+
+// Message is a message published to a topic
+type Message struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+// Handler is called for each message received on a subscribed topic
+type Handler func(topic string, payload []byte)
+
+// Options configures the connection to the broker
+type Options struct {
+	Broker    string // tcp://host:1883, or ssl://host:8883 for TLS
+	ClientID  string
+	Username  string
+	Password  string
+	TLSConfig *tls.Config // used for ssl:// brokers, nil uses the defaults
+	KeepAlive time.Duration
+	Will      *Message // published by the broker if the client goes away without disconnecting
+	OnConnect func()   // called each time the client connects, after the subscriptions have been made
+	Logf      func(format string, args ...interface{})
+}
+
+// Client is a connection to an MQTT broker that reconnects until it is stopped
+type Client struct {
+	opts Options
+
+	mu            sync.Mutex // guards conn and subscriptions
+	conn          net.Conn
+	subscriptions map[string]Handler
+
+	writeMu  sync.Mutex // serialises writes to the connection
+	packetID uint16     // only used while holding writeMu
+
+	received chan Message // messages waiting for their handlers, so a slow handler doesn't hold up the connection
+}
+
+// NewClient creates a client, it doesn't connect until Run is called
+func NewClient(opts Options) (*Client, error) {
+	if _, _, err := brokerAddress(opts.Broker); err != nil {
+		return nil, err
+	}
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = 30 * time.Second
+	}
+	if opts.Logf == nil {
+		opts.Logf = func(string, ...interface{}) {}
+	}
+	return &Client{
+		opts:          opts,
+		subscriptions: map[string]Handler{},
+		received:      make(chan Message, receiveQueue),
+	}, nil
+}
+
+// brokerAddress splits the broker URL into the address to dial and whether to use TLS
+func brokerAddress(broker string) (string, bool, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid broker %q: %v", broker, err)
+	}
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		if u.Port() == "" {
+			return net.JoinHostPort(u.Hostname(), "1883"), false, nil
+		}
+		return u.Host, false, nil
+	case "ssl", "tls", "mqtts":
+		if u.Port() == "" {
+			return net.JoinHostPort(u.Hostname(), "8883"), true, nil
+		}
+		return u.Host, true, nil
+	}
+	return "", false, fmt.Errorf("invalid broker %q: the scheme must be tcp or ssl", broker)
+}
+
+// Subscribe registers a handler for a topic filter, which may use the + and # wildcards, the subscription is
+// made straight away if connected and again every time the client reconnects
+func (c *Client) Subscribe(filter string, handler Handler) error {
+	c.mu.Lock()
+	c.subscriptions[filter] = handler
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return c.subscribe(conn, []string{filter})
+}
+
+// Publish sends a message at QoS 0
+func (c *Client) Publish(topic string, payload []byte, retain bool) error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	return c.write(conn, encodePublish(Message{Topic: topic, Payload: payload, Retain: retain}))
+}
+
+// Connected returns true while the client has a connection to the broker
+func (c *Client) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
+// Run connects to the broker and handles incoming messages, reconnecting if the connection drops, until the
+// context is cancelled, when it disconnects cleanly so the will isn't published
+func (c *Client) Run(ctx context.Context) {
+	go c.dispatch(ctx)
+	delay := minReconnectDelay
+	for {
+		conn, r, err := c.connect(ctx)
+		if err == nil {
+			delay = minReconnectDelay
+			err = c.serve(ctx, conn, r)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		c.opts.Logf("Disconnected from %s, reconnecting in %s: %v", c.opts.Broker, delay, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// connect dials the broker, sends the connect packet and waits for it to be accepted
+func (c *Client) connect(ctx context.Context) (net.Conn, *bufio.Reader, error) {
+	addr, useTLS, _ := brokerAddress(c.opts.Broker)
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if useTLS {
+		cfg := &tls.Config{}
+		if c.opts.TLSConfig != nil {
+			cfg = c.opts.TLSConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tlsConn := tls.Client(conn, cfg)
+		_ = tlsConn.SetDeadline(time.Now().Add(10 * time.Second))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		conn = tlsConn
+	}
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write(encodeConnect(c.opts)); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	r := bufio.NewReader(conn)
+	packetType, body, err := readPacket(r)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if packetType != packetConnAck || len(body) != 2 {
+		conn.Close()
+		return nil, nil, fmt.Errorf("expected connack, got packet type %d", packetType)
+	}
+	if body[1] != 0 {
+		conn.Close()
+		return nil, nil, fmt.Errorf("connection refused: %s", connAckReason(body[1]))
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, r, nil
+}
+
+func connAckReason(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "client identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad username or password"
+	case 5:
+		return "not authorised"
+	}
+	return fmt.Sprintf("return code %d", code)
+}
+
+// serve subscribes to every topic, then reads from the connection until it fails or the context is cancelled
+func (c *Client) serve(ctx context.Context, conn net.Conn, r *bufio.Reader) error {
+	c.mu.Lock()
+	filters := make([]string, 0, len(c.subscriptions))
+	for filter := range c.subscriptions {
+		filters = append(filters, filter)
+	}
+	c.conn = conn
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+		conn.Close()
+	}()
+	if len(filters) > 0 {
+		if err := c.subscribe(conn, filters); err != nil {
+			return err
+		}
+	}
+	c.opts.Logf("Connected to %s", c.opts.Broker)
+	if c.opts.OnConnect != nil {
+		go c.opts.OnConnect()
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// ping the broker so it knows we're still here, and disconnect cleanly when asked to stop
+		ticker := time.NewTicker(c.opts.KeepAlive / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				_ = c.write(conn, []byte{packetDisconnect << 4, 0})
+				conn.Close()
+				return
+			case <-ticker.C:
+				if err := c.write(conn, []byte{packetPingReq << 4, 0}); err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		// the broker answers every ping, so nothing arriving for this long means the connection is dead
+		_ = conn.SetReadDeadline(time.Now().Add(c.opts.KeepAlive * 3 / 2))
+		packetType, body, err := readPacket(r)
+		if err == errPacketTooLarge {
+			c.opts.Logf("Skipped a packet from the broker larger than %d bytes", maxPacketSize)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		switch packetType {
+		case packetPublish:
+			c.receive(conn, body)
+		case packetSubAck:
+			if len(body) > 2 {
+				for _, rc := range body[2:] {
+					if rc == 0x80 {
+						c.opts.Logf("The broker refused a subscription")
+					}
+				}
+			}
+		}
+	}
+}
+
+// receive handles a publish packet from the broker
+func (c *Client) receive(conn net.Conn, body []byte) {
+	// the flags aren't part of the body, but a QoS above 0 adds a packet ID after the topic
+	topic, rest, err := readString(body[1:])
+	if err != nil {
+		return
+	}
+	qos := (body[0] >> 1) & 0x03
+	if qos > 0 {
+		if len(rest) < 2 {
+			return
+		}
+		_ = c.write(conn, []byte{packetPubAck << 4, 2, rest[0], rest[1]})
+		rest = rest[2:]
+	}
+	select {
+	case c.received <- Message{Topic: topic, Payload: rest}:
+	default:
+		c.opts.Logf("Dropped a message to %s, the handlers are too far behind", topic)
+	}
+}
+
+// dispatch calls the handlers for each received message in the order they arrived, until the context is cancelled
+func (c *Client) dispatch(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-c.received:
+			c.mu.Lock()
+			handlers := []Handler{}
+			for filter, handler := range c.subscriptions {
+				if Match(filter, msg.Topic) {
+					handlers = append(handlers, handler)
+				}
+			}
+			c.mu.Unlock()
+			for _, handler := range handlers {
+				handler(msg.Topic, msg.Payload)
+			}
+		}
+	}
+}
+
+// subscribe sends a subscribe packet for the filters at QoS 0
+func (c *Client) subscribe(conn net.Conn, filters []string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.packetID++
+	if c.packetID == 0 {
+		c.packetID = 1
+	}
+	var payload []byte
+	payload = append(payload, byte(c.packetID>>8), byte(c.packetID))
+	for _, filter := range filters {
+		payload = appendString(payload, filter)
+		payload = append(payload, 0)
+	}
+	_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := conn.Write(encodePacket(packetSubscribe<<4|0x02, payload))
+	return err
+}
+
+// write sends a packet, writes from different goroutines are serialised
+func (c *Client) write(conn net.Conn, packet []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := conn.Write(packet)
+	return err
+}
+
+// Match returns true if the topic matches the filter, + matches a single level and # matches everything below
+func Match(filter, topic string) bool {
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) {
+			return false
+		}
+		if level != "+" && level != t[i] {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
+
+func encodeConnect(opts Options) []byte {
+	var flags byte = 0x02 // clean session
+	var payload []byte
+	payload = appendString(payload, opts.ClientID)
+	if opts.Will != nil {
+		flags |= 0x04
+		if opts.Will.Retain {
+			flags |= 0x20
+		}
+		payload = appendString(payload, opts.Will.Topic)
+		payload = appendBytes(payload, opts.Will.Payload)
+	}
+	if opts.Username != "" {
+		flags |= 0x80
+		payload = appendString(payload, opts.Username)
+		if opts.Password != "" {
+			flags |= 0x40
+			payload = appendString(payload, opts.Password)
+		}
+	}
+	keepAlive := uint16(opts.KeepAlive / time.Second)
+	var header []byte
+	header = appendString(header, "MQTT")
+	header = append(header, 4, flags, byte(keepAlive>>8), byte(keepAlive))
+	return encodePacket(packetConnect<<4, append(header, payload...))
+}
+
+func encodePublish(msg Message) []byte {
+	var first byte = packetPublish << 4
+	if msg.Retain {
+		first |= 0x01
+	}
+	return encodePacket(first, append(appendString(nil, msg.Topic), msg.Payload...))
+}
+
+// encodePacket adds the fixed header, the remaining length is encoded 7 bits at a time
+func encodePacket(first byte, body []byte) []byte {
+	packet := []byte{first}
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if length == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+// readPacket reads a single packet, the body returned for a publish packet starts with the fixed header flags
+// as they are needed to find the QoS, a packet over maxPacketSize is read past and errPacketTooLarge returned
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length := 0
+	for shift := uint(0); ; shift += 7 {
+		if shift > 21 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if length > maxPacketSize {
+		if _, err := r.Discard(length); err != nil {
+			return 0, nil, err
+		}
+		return 0, nil, errPacketTooLarge
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	packetType := first >> 4
+	if packetType == packetPublish {
+		body = append([]byte{first & 0x0f}, body...)
+	}
+	return packetType, body, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	return appendBytes(b, []byte(s))
+}
+
+func appendBytes(b []byte, data []byte) []byte {
+	b = append(b, byte(len(data)>>8), byte(len(data)))
+	return append(b, data...)
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errors.New("string too short")
+	}
+	n := int(b[0])<<8 | int(b[1])
+	if len(b) < 2+n {
+		return "", nil, errors.New("string too short")
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
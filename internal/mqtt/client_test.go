@@ -0,0 +1,320 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{"twcmanager/command/maxamps", "twcmanager/command/maxamps", true},
+		{"twcmanager/command/maxamps", "twcmanager/command/maxwatts", false},
+		{"twcmanager/command/+", "twcmanager/command/maxamps", true},
+		{"twcmanager/command/+", "twcmanager/command", false},
+		{"twcmanager/command/+", "twcmanager/command/maxamps/extra", false},
+		{"twcmanager/secondary/+/command/+", "twcmanager/secondary/1001/command/charging", true},
+		{"twcmanager/#", "twcmanager/secondary/1001/command/charging", true},
+		{"twcmanager/#", "other/secondary", false},
+		{"#", "anything/at/all", true},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestReadPacket(t *testing.T) {
+	small := encodePublish(Message{Topic: "a/b", Payload: []byte("16")})
+	large := encodePublish(Message{Topic: "a/b", Payload: bytes.Repeat([]byte("x"), maxPacketSize+1)})
+	r := bufio.NewReader(bytes.NewReader(append(append(append([]byte{}, small...), large...), small...)))
+	for i, want := range []error{nil, errPacketTooLarge, nil} {
+		packetType, body, err := readPacket(r)
+		if err != want {
+			t.Fatalf("packet %d returned %v, want %v", i, err, want)
+		}
+		if err != nil {
+			continue
+		}
+		if packetType != packetPublish {
+			t.Fatalf("packet %d is type %d, want a publish", i, packetType)
+		}
+		topic, payload, err := readString(body[1:])
+		if err != nil || topic != "a/b" || string(payload) != "16" {
+			t.Fatalf("packet %d is %q %q %v", i, topic, payload, err)
+		}
+	}
+	// a remaining length that never ends
+	r = bufio.NewReader(bytes.NewReader([]byte{packetPublish << 4, 0xff, 0xff, 0xff, 0xff, 0x01}))
+	if _, _, err := readPacket(r); err == nil {
+		t.Fatal("a malformed remaining length was read")
+	}
+}
+
+// testBroker is just enough of a broker to connect one client and send it packets
+type testBroker struct {
+	listener net.Listener
+	conn     chan net.Conn
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &testBroker{listener: l, conn: make(chan net.Conn, 1)}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { conn.Close() })
+		r := bufio.NewReader(conn)
+		if packetType, _, err := readPacket(r); err != nil || packetType != packetConnect {
+			conn.Close()
+			return
+		}
+		_, _ = conn.Write([]byte{packetConnAck << 4, 2, 0, 0})
+		b.conn <- conn
+	}()
+	return b
+}
+
+// runClient runs a client until the test ends
+func runClient(t *testing.T, opts Options) *Client {
+	if opts.ClientID == "" {
+		opts.ClientID = strings.Replace(t.Name(), "/", "-", -1)
+	}
+	c, err := NewClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return c
+}
+
+// waitFor fails the test if cond isn't true before the timeout
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out after %v waiting for %s", timeout, what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSlowHandlerDoesntHoldUpTheConnection(t *testing.T) {
+	b := newTestBroker(t)
+	release := make(chan struct{})
+	defer close(release)
+	handled := make(chan string, 10)
+	c := runClient(t, Options{Broker: "tcp://" + b.listener.Addr().String()})
+	_ = c.Subscribe("slow", func(topic string, payload []byte) {
+		handled <- topic
+		<-release
+	})
+	var conn net.Conn
+	select {
+	case conn = <-b.conn:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the client didn't connect")
+	}
+	r := bufio.NewReader(conn)
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if packetType, _, err := readPacket(r); err != nil || packetType != packetSubscribe {
+		t.Fatalf("expected a subscribe, got %d %v", packetType, err)
+	}
+	_, _ = conn.Write(encodePublish(Message{Topic: "slow", Payload: []byte("1")}))
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler wasn't called")
+	}
+	// the handler is still running, but a QoS 1 publish is still read and acknowledged
+	qos1 := encodePacket(packetPublish<<4|0x02, append(appendString(nil, "other"), 0x12, 0x34, '2'))
+	_, _ = conn.Write(qos1)
+	packetType, body, err := readPacket(r)
+	if err != nil || packetType != packetPubAck || !bytes.Equal(body, []byte{0x12, 0x34}) {
+		t.Fatalf("expected the publish to be acknowledged, got %d %X %v", packetType, body, err)
+	}
+	// a packet over the limit is skipped, and the connection carries on
+	_, _ = conn.Write(encodePublish(Message{Topic: "slow", Payload: bytes.Repeat([]byte("x"), maxPacketSize)}))
+	_, _ = conn.Write(qos1)
+	packetType, _, err = readPacket(r)
+	if err != nil || packetType != packetPubAck {
+		t.Fatalf("expected the publish after the large one to be acknowledged, got %d %v", packetType, err)
+	}
+	if !c.Connected() {
+		t.Fatal("the client disconnected")
+	}
+}
+
+// startMosquitto runs a mosquitto broker on a free port for the test, the test is skipped if mosquitto isn't
+// installed, MQTT_TEST_BROKER can be set to use a broker that is already running instead
+func startMosquitto(t *testing.T) (broker string, restart func()) {
+	if broker := os.Getenv("MQTT_TEST_BROKER"); broker != "" {
+		return broker, nil
+	}
+	path, err := exec.LookPath("mosquitto")
+	if err != nil {
+		t.Skip("mosquitto isn't installed")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	_, port, _ := net.SplitHostPort(addr)
+	dir, err := ioutil.TempDir("", "mosquitto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	conf := filepath.Join(dir, "mosquitto.conf")
+	err = ioutil.WriteFile(conf, []byte(fmt.Sprintf("listener %s 127.0.0.1\nallow_anonymous true\n", port)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cmd *exec.Cmd
+	start := func() {
+		cmd = exec.Command(path, "-c", conf)
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, 5*time.Second, "mosquitto to start", func() bool {
+			conn, err := net.Dial("tcp", addr)
+			if err == nil {
+				conn.Close()
+			}
+			return err == nil
+		})
+	}
+	stop := func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}
+	start()
+	t.Cleanup(stop)
+	return "tcp://" + addr, func() {
+		stop()
+		start()
+	}
+}
+
+// received collects what a handler is given
+type received chan Message
+
+func (r received) handler(topic string, payload []byte) {
+	r <- Message{Topic: topic, Payload: append([]byte{}, payload...)}
+}
+
+// next returns the next message the handler was given
+func (r received) next(t *testing.T) Message {
+	t.Helper()
+	select {
+	case msg := <-r:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+	}
+	return Message{}
+}
+
+func TestMosquittoPublishSubscribe(t *testing.T) {
+	broker, _ := startMosquitto(t)
+	sub := runClient(t, Options{Broker: broker, ClientID: "sub"})
+	pub := runClient(t, Options{Broker: broker, ClientID: "pub"})
+	waitFor(t, 5*time.Second, "the clients to connect", func() bool { return sub.Connected() && pub.Connected() })
+	if err := pub.Publish("twcmanager/test/state", []byte("retained"), true); err != nil {
+		t.Fatal(err)
+	}
+	got := make(received, 10)
+	// retained messages are sent as soon as the subscription is made
+	if err := sub.Subscribe("twcmanager/test/+", got.handler); err != nil {
+		t.Fatal(err)
+	}
+	if msg := got.next(t); msg.Topic != "twcmanager/test/state" || string(msg.Payload) != "retained" {
+		t.Fatalf("got %s %q, want the retained state", msg.Topic, msg.Payload)
+	}
+	for _, payload := range []string{"6", "16", "32"} {
+		if err := pub.Publish("twcmanager/test/maxamps", []byte(payload), false); err != nil {
+			t.Fatal(err)
+		}
+		if msg := got.next(t); msg.Topic != "twcmanager/test/maxamps" || string(msg.Payload) != payload {
+			t.Fatalf("got %s %q, want %q", msg.Topic, msg.Payload, payload)
+		}
+	}
+	// clear the retained message so it doesn't turn up in the other tests
+	_ = pub.Publish("twcmanager/test/state", nil, true)
+}
+
+func TestMosquittoSkipsLargeMessages(t *testing.T) {
+	broker, _ := startMosquitto(t)
+	sub := runClient(t, Options{Broker: broker, ClientID: "sub"})
+	pub := runClient(t, Options{Broker: broker, ClientID: "pub"})
+	got := make(received, 10)
+	_ = sub.Subscribe("twcmanager/large", got.handler)
+	waitFor(t, 5*time.Second, "the clients to connect", func() bool { return sub.Connected() && pub.Connected() })
+	// the subscription is made once connected, give the broker a moment to take it
+	time.Sleep(200 * time.Millisecond)
+	_ = pub.Publish("twcmanager/large", bytes.Repeat([]byte("x"), maxPacketSize+1), false)
+	_ = pub.Publish("twcmanager/large", []byte("small"), false)
+	if msg := got.next(t); string(msg.Payload) != "small" {
+		t.Fatalf("got a message of %d bytes, want the small one", len(msg.Payload))
+	}
+	if !sub.Connected() {
+		t.Fatal("the large message disconnected the client")
+	}
+}
+
+func TestMosquittoReconnects(t *testing.T) {
+	broker, restart := startMosquitto(t)
+	if restart == nil {
+		t.Skip("can't restart a broker that is already running")
+	}
+	connects := make(chan struct{}, 10)
+	sub := runClient(t, Options{Broker: broker, ClientID: "sub", OnConnect: func() { connects <- struct{}{} }})
+	got := make(received, 10)
+	_ = sub.Subscribe("twcmanager/reconnect", got.handler)
+	<-connects
+	restart()
+	select {
+	case <-connects:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the client didn't reconnect")
+	}
+	pub := runClient(t, Options{Broker: broker, ClientID: "pub"})
+	waitFor(t, 5*time.Second, "the publisher to connect", pub.Connected)
+	// the subscription is made again on the new connection
+	_ = pub.Publish("twcmanager/reconnect", []byte("again"), false)
+	if msg := got.next(t); string(msg.Payload) != "again" {
+		t.Fatalf("got %q after reconnecting", msg.Payload)
+	}
+}
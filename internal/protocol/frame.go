@@ -0,0 +1,126 @@
+package protocol
+
+import (
+	"fmt"
+)
+
+// Command is the two byte code at the start of every message on the bus
+type Command uint16
+
+// Known commands, messages starting with FB/FC are sent by the primary, FD are sent by a secondary
+const (
+	PrimaryLinkReady1   Command = 0xFCE1
+	PrimaryLinkReady2   Command = 0xFBE2
+	PrimaryLinkReadyAlt Command = 0xFCE2 // seen from TWCs that are set to primary mode
+	PrimaryHeartbeat    Command = 0xFBE0
+	StartCharging       Command = 0xFCB1
+	StopCharging        Command = 0xFCB2
+	PollKWH             Command = 0xFBEB
+	PollVINStart        Command = 0xFBEE
+	PollVINMiddle       Command = 0xFBEF
+	PollVINEnd          Command = 0xFBF1
+	PollPlugState       Command = 0xFBB4
+	PollFirmwareVersion Command = 0xFB1B
+	PollSerialNumber    Command = 0xFB19
+	PollModel           Command = 0xFB1A
+
+	SecondaryLinkReady Command = 0xFDE2
+	SecondaryHeartbeat Command = 0xFDE0
+	KWH                Command = 0xFDEB
+	VINStart           Command = 0xFDEE
+	VINMiddle          Command = 0xFDEF
+	VINEnd             Command = 0xFDF1
+	PlugState          Command = 0xFDB4
+	FirmwareVersion    Command = 0xFD1B
+	SerialNumber       Command = 0xFD19
+	Model              Command = 0xFD1A
+)
+
+// message lengths without the checksum
+const (
+	lengthV1   = 13
+	lengthV2   = 15
+	lengthLong = 19
+)
+
+// Bytes returns the command as it appears on the bus
+func (c Command) Bytes() []byte {
+	return []byte{byte(c >> 8), byte(c)}
+}
+
+func (c Command) String() string {
+	return fmt.Sprintf("%04X", uint16(c))
+}
+
+// addressed returns true if the command has a receiver ID after the sender ID
+func (c Command) addressed() bool {
+	switch c {
+	case PrimaryHeartbeat, SecondaryHeartbeat, StartCharging, StopCharging,
+		PollKWH, PollVINStart, PollVINMiddle, PollVINEnd, PollPlugState,
+		PollFirmwareVersion, PollSerialNumber, PollModel:
+		return true
+	}
+	return false
+}
+
+// long returns true if the command is always sent as a long message regardless of protocol version
+func (c Command) long() bool {
+	switch c {
+	case KWH, VINStart, VINMiddle, VINEnd, FirmwareVersion, SerialNumber, Model:
+		return true
+	}
+	return false
+}
+
+// Frame is a single decoded message, without the checksum or escaping
+type Frame struct {
+	Command         Command
+	Sender          []byte
+	Receiver        []byte // only set for commands that are addressed to a specific TWC
+	Payload         []byte
+	ProtocolVersion int
+}
+
+// Encode builds the message, padded out to the length used by the given protocol version
+func (f Frame) Encode(protocolVersion int) []byte {
+	msg := append(f.Command.Bytes(), f.Sender...)
+	if f.Command.addressed() {
+		msg = append(msg, f.Receiver...)
+	}
+	msg = append(msg, f.Payload...)
+	length := lengthV2
+	if f.Command.long() {
+		length = lengthLong
+	} else if protocolVersion == 1 {
+		length = lengthV1
+	}
+	for len(msg) < length {
+		msg = append(msg, 0x00)
+	}
+	return msg
+}
+
+// Decode parses a message that has had the checksum verified and removed
+func Decode(msg []byte) (Frame, error) {
+	if len(msg) < 4 {
+		return Frame{}, fmt.Errorf("message too short: %X", msg)
+	}
+	f := Frame{
+		Command:         Command(uint16(msg[0])<<8 | uint16(msg[1])),
+		Sender:          msg[2:4],
+		ProtocolVersion: 2,
+	}
+	if len(msg) == lengthV1 {
+		f.ProtocolVersion = 1
+	}
+	if f.Command.addressed() {
+		if len(msg) < 6 {
+			return Frame{}, fmt.Errorf("message too short for command %s: %X", f.Command, msg)
+		}
+		f.Receiver = msg[4:6]
+		f.Payload = msg[6:]
+	} else {
+		f.Payload = msg[4:]
+	}
+	return f, nil
+}
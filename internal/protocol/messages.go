@@ -0,0 +1,252 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// LinkReady is sent by a secondary that wants to be linked to a primary
+type LinkReady struct {
+	Sign    byte
+	MaxAmps uint16 // the maximum the TWC can supply, in hundredths of an amp
+}
+
+// ParseLinkReady parses a secondary linkready message
+func ParseLinkReady(f Frame) (LinkReady, error) {
+	if err := expect(f, SecondaryLinkReady, 3); err != nil {
+		return LinkReady{}, err
+	}
+	return LinkReady{
+		Sign:    f.Payload[0],
+		MaxAmps: uint16(f.Payload[1])<<8 | uint16(f.Payload[2]),
+	}, nil
+}
+
+// NewLinkReady builds a secondary linkready message
+func NewLinkReady(sender []byte, lr LinkReady) Frame {
+	return Frame{
+		Command: SecondaryLinkReady,
+		Sender:  sender,
+		Payload: []byte{lr.Sign, byte(lr.MaxAmps >> 8), byte(lr.MaxAmps)},
+	}
+}
+
+// NewPrimaryLinkReady builds the linkready messages the primary sends when looking for secondaries
+func NewPrimaryLinkReady(cmd Command, sender []byte, sign byte) Frame {
+	return Frame{
+		Command: cmd,
+		Sender:  sender,
+		Payload: []byte{sign},
+	}
+}
+
+// PrimaryHeartbeatData is sent by the primary to each secondary, the command is 0x05 or 0x09 when setting the charge rate
+type PrimaryHeartbeatData struct {
+	Command byte
+	Amps    uint16 // in hundredths of an amp
+}
+
+// ParsePrimaryHeartbeat parses a heartbeat sent by the primary
+func ParsePrimaryHeartbeat(f Frame) (PrimaryHeartbeatData, error) {
+	if err := expect(f, PrimaryHeartbeat, 3); err != nil {
+		return PrimaryHeartbeatData{}, err
+	}
+	return PrimaryHeartbeatData{
+		Command: f.Payload[0],
+		Amps:    uint16(f.Payload[1])<<8 | uint16(f.Payload[2]),
+	}, nil
+}
+
+// NewPrimaryHeartbeat builds a heartbeat from the primary to a secondary
+func NewPrimaryHeartbeat(sender, receiver []byte, hb PrimaryHeartbeatData) Frame {
+	return Frame{
+		Command:  PrimaryHeartbeat,
+		Sender:   sender,
+		Receiver: receiver,
+		Payload:  []byte{hb.Command, byte(hb.Amps >> 8), byte(hb.Amps)},
+	}
+}
+
+// SecondaryHeartbeatData is sent by a secondary in reply to the primary heartbeat
+type SecondaryHeartbeatData struct {
+	State      byte
+	AmpsMax    uint16 // in hundredths of an amp
+	AmpsActual uint16 // in hundredths of an amp
+}
+
+// ParseSecondaryHeartbeat parses a heartbeat sent by a secondary
+func ParseSecondaryHeartbeat(f Frame) (SecondaryHeartbeatData, error) {
+	if err := expect(f, SecondaryHeartbeat, 5); err != nil {
+		return SecondaryHeartbeatData{}, err
+	}
+	return SecondaryHeartbeatData{
+		State:      f.Payload[0],
+		AmpsMax:    uint16(f.Payload[1])<<8 | uint16(f.Payload[2]),
+		AmpsActual: uint16(f.Payload[3])<<8 | uint16(f.Payload[4]),
+	}, nil
+}
+
+// NewSecondaryHeartbeat builds a heartbeat from a secondary to the primary
+func NewSecondaryHeartbeat(sender, receiver []byte, hb SecondaryHeartbeatData) Frame {
+	return Frame{
+		Command:  SecondaryHeartbeat,
+		Sender:   sender,
+		Receiver: receiver,
+		Payload: []byte{
+			hb.State,
+			byte(hb.AmpsMax >> 8), byte(hb.AmpsMax),
+			byte(hb.AmpsActual >> 8), byte(hb.AmpsActual),
+		},
+	}
+}
+
+// KWHData is the lifetime energy and per phase voltage and current of a secondary
+type KWHData struct {
+	LifetimeKWH uint32
+	Volts       [3]uint16
+	HalfAmps    [3]byte // the current on each phase in half amps
+}
+
+// Amps returns the current on the given phase (0-2) in whole amps
+func (k KWHData) Amps(phase int) int {
+	return int(k.HalfAmps[phase]) / 2
+}
+
+// ParseKWH parses the kWh and voltage report from a secondary
+func ParseKWH(f Frame) (KWHData, error) {
+	if err := expect(f, KWH, 13); err != nil {
+		return KWHData{}, err
+	}
+	p := f.Payload
+	return KWHData{
+		LifetimeKWH: uint32(p[0])<<24 | uint32(p[1])<<16 | uint32(p[2])<<8 | uint32(p[3]),
+		Volts: [3]uint16{
+			uint16(p[4])<<8 | uint16(p[5]),
+			uint16(p[6])<<8 | uint16(p[7]),
+			uint16(p[8])<<8 | uint16(p[9]),
+		},
+		HalfAmps: [3]byte{p[10], p[11], p[12]},
+	}, nil
+}
+
+// NewKWH builds the kWh and voltage report from a secondary
+func NewKWH(sender []byte, k KWHData) Frame {
+	return Frame{
+		Command: KWH,
+		Sender:  sender,
+		Payload: []byte{
+			byte(k.LifetimeKWH >> 24), byte(k.LifetimeKWH >> 16), byte(k.LifetimeKWH >> 8), byte(k.LifetimeKWH),
+			byte(k.Volts[0] >> 8), byte(k.Volts[0]),
+			byte(k.Volts[1] >> 8), byte(k.Volts[1]),
+			byte(k.Volts[2] >> 8), byte(k.Volts[2]),
+			k.HalfAmps[0], k.HalfAmps[1], k.HalfAmps[2],
+		},
+	}
+}
+
+// vinPartLength is the number of characters of the VIN in each of the start, middle and end messages
+var vinPartLength = map[Command]int{
+	VINStart:  7,
+	VINMiddle: 7,
+	VINEnd:    3,
+}
+
+// ParseVINPart parses one of the VIN start, middle or end messages, a car that is not plugged in returns an empty string
+func ParseVINPart(f Frame) (string, error) {
+	length, ok := vinPartLength[f.Command]
+	if !ok {
+		return "", fmt.Errorf("command %s is not a vin message", f.Command)
+	}
+	if err := expect(f, f.Command, length); err != nil {
+		return "", err
+	}
+	return trimString(f.Payload[:length]), nil
+}
+
+// NewVINPart builds one of the VIN start, middle or end messages
+func NewVINPart(cmd Command, sender []byte, part string) Frame {
+	payload := make([]byte, vinPartLength[cmd])
+	copy(payload, part)
+	return Frame{
+		Command: cmd,
+		Sender:  sender,
+		Payload: payload,
+	}
+}
+
+// ParsePlugState parses the plug state, 0 is unplugged, 1 is charging, 3 is plugged in but not charging
+func ParsePlugState(f Frame) (byte, error) {
+	if err := expect(f, PlugState, 1); err != nil {
+		return 0, err
+	}
+	return f.Payload[0], nil
+}
+
+// NewPlugState builds the plug state message
+func NewPlugState(sender []byte, state byte) Frame {
+	return Frame{
+		Command: PlugState,
+		Sender:  sender,
+		Payload: []byte{state},
+	}
+}
+
+// ParseFirmwareVersion parses the firmware version of a secondary
+func ParseFirmwareVersion(f Frame) ([4]byte, error) {
+	if err := expect(f, FirmwareVersion, 4); err != nil {
+		return [4]byte{}, err
+	}
+	return [4]byte{f.Payload[0], f.Payload[1], f.Payload[2], f.Payload[3]}, nil
+}
+
+// NewFirmwareVersion builds the firmware version message
+func NewFirmwareVersion(sender []byte, version [4]byte) Frame {
+	return Frame{
+		Command: FirmwareVersion,
+		Sender:  sender,
+		Payload: version[:],
+	}
+}
+
+// ParseString parses the serial number or model messages, which are ascii padded with zeros
+func ParseString(f Frame) (string, error) {
+	if f.Command != SerialNumber && f.Command != Model {
+		return "", fmt.Errorf("command %s is not a serial number or model message", f.Command)
+	}
+	return trimString(f.Payload), nil
+}
+
+// NewString builds the serial number or model messages
+func NewString(cmd Command, sender []byte, s string) Frame {
+	return Frame{
+		Command: cmd,
+		Sender:  sender,
+		Payload: []byte(s),
+	}
+}
+
+// NewPoll builds a message from the primary requesting information from a secondary, or telling it to start or stop charging
+func NewPoll(cmd Command, sender, receiver []byte) Frame {
+	return Frame{
+		Command:  cmd,
+		Sender:   sender,
+		Receiver: receiver,
+	}
+}
+
+func expect(f Frame, cmd Command, payloadLength int) error {
+	if f.Command != cmd {
+		return fmt.Errorf("expected command %s, got %s", cmd, f.Command)
+	}
+	if len(f.Payload) < payloadLength {
+		return fmt.Errorf("payload too short for command %s: %X", cmd, f.Payload)
+	}
+	return nil
+}
+
+func trimString(b []byte) string {
+	if i := bytes.IndexByte(b, 0x00); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
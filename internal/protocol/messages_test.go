@@ -0,0 +1,344 @@
+package protocol
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var (
+	primaryID   = []byte{0x77, 0x77}
+	secondaryID = []byte{0x10, 0x01}
+)
+
+// pad returns the bytes padded with zeros to n
+func pad(n int, b ...byte) []byte {
+	msg := make([]byte, n)
+	copy(msg, b)
+	return msg
+}
+
+func TestFrameEncode(t *testing.T) {
+	tests := []struct {
+		name            string
+		frame           Frame
+		protocolVersion int
+		want            []byte
+	}{
+		{
+			name:            "addressed protocol 1",
+			frame:           NewPrimaryHeartbeat(primaryID, secondaryID, PrimaryHeartbeatData{Command: 0x09, Amps: 3200}),
+			protocolVersion: 1,
+			want:            pad(lengthV1, 0xFB, 0xE0, 0x77, 0x77, 0x10, 0x01, 0x09, 0x0C, 0x80),
+		},
+		{
+			name:            "addressed protocol 2",
+			frame:           NewPrimaryHeartbeat(primaryID, secondaryID, PrimaryHeartbeatData{Command: 0x05, Amps: 600}),
+			protocolVersion: 2,
+			want:            pad(lengthV2, 0xFB, 0xE0, 0x77, 0x77, 0x10, 0x01, 0x05, 0x02, 0x58),
+		},
+		{
+			name:            "broadcast",
+			frame:           NewPrimaryLinkReady(PrimaryLinkReady1, primaryID, 0x77),
+			protocolVersion: 2,
+			want:            pad(lengthV2, 0xFC, 0xE1, 0x77, 0x77, 0x77),
+		},
+		{
+			name: "a broadcast leaves the receiver out",
+			frame: Frame{
+				Command:  SecondaryLinkReady,
+				Sender:   secondaryID,
+				Receiver: primaryID,
+				Payload:  []byte{0x77, 0x0C, 0x80},
+			},
+			protocolVersion: 1,
+			want:            pad(lengthV1, 0xFD, 0xE2, 0x10, 0x01, 0x77, 0x0C, 0x80),
+		},
+		{
+			name:            "a poll has no payload",
+			frame:           NewPoll(PollPlugState, primaryID, secondaryID),
+			protocolVersion: 2,
+			want:            pad(lengthV2, 0xFB, 0xB4, 0x77, 0x77, 0x10, 0x01),
+		},
+		{
+			name:            "long whatever the protocol version",
+			frame:           NewVINPart(VINStart, secondaryID, "5YJ3E7E"),
+			protocolVersion: 1,
+			want:            pad(lengthLong, 0xFD, 0xEE, 0x10, 0x01, '5', 'Y', 'J', '3', 'E', '7', 'E'),
+		},
+		{
+			name: "kWh",
+			frame: NewKWH(secondaryID, KWHData{
+				LifetimeKWH: 0x01020304,
+				Volts:       [3]uint16{240, 0, 0},
+				HalfAmps:    [3]byte{64, 0, 0},
+			}),
+			protocolVersion: 2,
+			want:            pad(lengthLong, 0xFD, 0xEB, 0x10, 0x01, 0x01, 0x02, 0x03, 0x04, 0x00, 0xF0, 0x00, 0x00, 0x00, 0x00, 64),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.frame.Encode(tt.protocolVersion)
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("Encode(%d) = %X, want %X", tt.protocolVersion, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     []byte
+		want    Frame
+		wantErr string
+	}{
+		{
+			name: "addressed protocol 1",
+			msg:  pad(lengthV1, 0xFD, 0xE0, 0x10, 0x01, 0x77, 0x77, 0x01, 0x0C, 0x80, 0x06, 0x40),
+			want: Frame{
+				Command:         SecondaryHeartbeat,
+				Sender:          secondaryID,
+				Receiver:        primaryID,
+				Payload:         pad(lengthV1-6, 0x01, 0x0C, 0x80, 0x06, 0x40),
+				ProtocolVersion: 1,
+			},
+		},
+		{
+			name: "addressed protocol 2",
+			msg:  pad(lengthV2, 0xFB, 0xE0, 0x77, 0x77, 0x10, 0x01, 0x09, 0x0C, 0x80),
+			want: Frame{
+				Command:         PrimaryHeartbeat,
+				Sender:          primaryID,
+				Receiver:        secondaryID,
+				Payload:         pad(lengthV2-6, 0x09, 0x0C, 0x80),
+				ProtocolVersion: 2,
+			},
+		},
+		{
+			name: "broadcast",
+			msg:  pad(lengthV2, 0xFD, 0xE2, 0x10, 0x01, 0x77, 0x0C, 0x80),
+			want: Frame{
+				Command:         SecondaryLinkReady,
+				Sender:          secondaryID,
+				Payload:         pad(lengthV2-4, 0x77, 0x0C, 0x80),
+				ProtocolVersion: 2,
+			},
+		},
+		{
+			name: "long",
+			msg:  pad(lengthLong, 0xFD, 0xF1, 0x10, 0x01, '0', '0', '1'),
+			want: Frame{
+				Command:         VINEnd,
+				Sender:          secondaryID,
+				Payload:         pad(lengthLong-4, '0', '0', '1'),
+				ProtocolVersion: 2,
+			},
+		},
+		{
+			name:    "too short",
+			msg:     []byte{0xFD, 0xE2, 0x10},
+			wantErr: "message too short",
+		},
+		{
+			name:    "too short for the receiver",
+			msg:     []byte{0xFB, 0xE0, 0x77, 0x77, 0x10},
+			wantErr: "message too short for command FBE0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode(tt.msg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Decode(%X) returned %v, want an error containing %q", tt.msg, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Decode(%X) = %+v, want %+v", tt.msg, got, tt.want)
+			}
+		})
+	}
+}
+
+// roundTrip encodes the frame and decodes it again, as it would be sent and received
+func roundTrip(t *testing.T, f Frame) Frame {
+	t.Helper()
+	decoded, err := Decode(f.Encode(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func TestMessagesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame Frame
+		parse func(f Frame) (interface{}, error)
+		want  interface{}
+	}{
+		{
+			name:  "linkready",
+			frame: NewLinkReady(secondaryID, LinkReady{Sign: 0x77, MaxAmps: 8000}),
+			parse: func(f Frame) (interface{}, error) { return ParseLinkReady(f) },
+			want:  LinkReady{Sign: 0x77, MaxAmps: 8000},
+		},
+		{
+			name:  "primary heartbeat",
+			frame: NewPrimaryHeartbeat(primaryID, secondaryID, PrimaryHeartbeatData{Command: 0x09, Amps: 1600}),
+			parse: func(f Frame) (interface{}, error) { return ParsePrimaryHeartbeat(f) },
+			want:  PrimaryHeartbeatData{Command: 0x09, Amps: 1600},
+		},
+		{
+			name:  "secondary heartbeat",
+			frame: NewSecondaryHeartbeat(secondaryID, primaryID, SecondaryHeartbeatData{State: 0x01, AmpsMax: 3200, AmpsActual: 1550}),
+			parse: func(f Frame) (interface{}, error) { return ParseSecondaryHeartbeat(f) },
+			want:  SecondaryHeartbeatData{State: 0x01, AmpsMax: 3200, AmpsActual: 1550},
+		},
+		{
+			name:  "kWh",
+			frame: NewKWH(secondaryID, KWHData{LifetimeKWH: 12345, Volts: [3]uint16{230, 231, 229}, HalfAmps: [3]byte{32, 33, 31}}),
+			parse: func(f Frame) (interface{}, error) { return ParseKWH(f) },
+			want:  KWHData{LifetimeKWH: 12345, Volts: [3]uint16{230, 231, 229}, HalfAmps: [3]byte{32, 33, 31}},
+		},
+		{
+			name:  "vin start",
+			frame: NewVINPart(VINStart, secondaryID, "5YJ3E7E"),
+			parse: func(f Frame) (interface{}, error) { return ParseVINPart(f) },
+			want:  "5YJ3E7E",
+		},
+		{
+			name:  "vin end",
+			frame: NewVINPart(VINEnd, secondaryID, "001"),
+			parse: func(f Frame) (interface{}, error) { return ParseVINPart(f) },
+			want:  "001",
+		},
+		{
+			name:  "no car plugged in has an empty vin",
+			frame: NewVINPart(VINMiddle, secondaryID, ""),
+			parse: func(f Frame) (interface{}, error) { return ParseVINPart(f) },
+			want:  "",
+		},
+		{
+			name:  "plug state",
+			frame: NewPlugState(secondaryID, 3),
+			parse: func(f Frame) (interface{}, error) { return ParsePlugState(f) },
+			want:  byte(3),
+		},
+		{
+			name:  "firmware version",
+			frame: NewFirmwareVersion(secondaryID, [4]byte{4, 5, 3, 0}),
+			parse: func(f Frame) (interface{}, error) { return ParseFirmwareVersion(f) },
+			want:  [4]byte{4, 5, 3, 0},
+		},
+		{
+			name:  "serial number",
+			frame: NewString(SerialNumber, secondaryID, "A19K0001"),
+			parse: func(f Frame) (interface{}, error) { return ParseString(f) },
+			want:  "A19K0001",
+		},
+		{
+			name:  "model",
+			frame: NewString(Model, secondaryID, "1041175"),
+			parse: func(f Frame) (interface{}, error) { return ParseString(f) },
+			want:  "1041175",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.parse(roundTrip(t, tt.frame))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parsed %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKWHAmps(t *testing.T) {
+	k := KWHData{HalfAmps: [3]byte{64, 33, 0}}
+	for phase, want := range []int{32, 16, 0} {
+		if got := k.Amps(phase); got != want {
+			t.Fatalf("phase %d is %dA, want %dA", phase+1, got, want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	short := func(cmd Command, payload ...byte) Frame {
+		return Frame{Command: cmd, Sender: secondaryID, Payload: payload}
+	}
+	tests := []struct {
+		name    string
+		parse   func() error
+		wantErr string
+	}{
+		{
+			name:    "linkready too short",
+			parse:   func() error { _, err := ParseLinkReady(short(SecondaryLinkReady, 0x77, 0x0C)); return err },
+			wantErr: "payload too short",
+		},
+		{
+			name:    "linkready with the wrong command",
+			parse:   func() error { _, err := ParseLinkReady(short(SecondaryHeartbeat, 0x77, 0x0C, 0x80)); return err },
+			wantErr: "expected command FDE2, got FDE0",
+		},
+		{
+			name:    "primary heartbeat too short",
+			parse:   func() error { _, err := ParsePrimaryHeartbeat(short(PrimaryHeartbeat, 0x09)); return err },
+			wantErr: "payload too short",
+		},
+		{
+			name:    "secondary heartbeat too short",
+			parse:   func() error { _, err := ParseSecondaryHeartbeat(short(SecondaryHeartbeat, 1, 2, 3, 4)); return err },
+			wantErr: "payload too short",
+		},
+		{
+			name:    "kWh too short",
+			parse:   func() error { _, err := ParseKWH(short(KWH, pad(12)...)); return err },
+			wantErr: "payload too short",
+		},
+		{
+			name:    "vin too short",
+			parse:   func() error { _, err := ParseVINPart(short(VINStart, '5', 'Y', 'J')); return err },
+			wantErr: "payload too short",
+		},
+		{
+			name:    "not a vin",
+			parse:   func() error { _, err := ParseVINPart(short(PlugState, 1, 2, 3, 4, 5, 6, 7)); return err },
+			wantErr: "is not a vin message",
+		},
+		{
+			name:    "plug state too short",
+			parse:   func() error { _, err := ParsePlugState(short(PlugState)); return err },
+			wantErr: "payload too short",
+		},
+		{
+			name:    "firmware version too short",
+			parse:   func() error { _, err := ParseFirmwareVersion(short(FirmwareVersion, 4, 5, 3)); return err },
+			wantErr: "payload too short",
+		},
+		{
+			name:    "not a serial number or model",
+			parse:   func() error { _, err := ParseString(short(FirmwareVersion, 'A')); return err },
+			wantErr: "is not a serial number or model message",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.parse()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("returned %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+// bytes used to delimit and escape messages on the bus
+const (
+	frameEnd        = 0xC0
+	frameEscape     = 0xDB
+	frameEscapedEnd = 0xDC
+	frameEscapedEsc = 0xDD
+)
+
+// ErrChecksum is returned when the checksum of a received message does not match
+var ErrChecksum = errors.New("checksum does not match")
+
+// ErrLength is returned when a received message isn't one of the known lengths
+var ErrLength = errors.New("unexpected message length")
+
+// Checksum calculates the checksum of a message, which is the sum of every byte after the first
+func Checksum(msg []byte) byte {
+	checksum := 0
+	for b := 1; b < len(msg); b++ {
+		checksum = checksum + int(msg[b])
+	}
+	return byte(checksum & 0xFF)
+}
+
+// Escape replaces every C0 with DB DC and every DB with DB DD
+func Escape(msg []byte) []byte {
+	escaped := make([]byte, 0, len(msg))
+	for _, b := range msg {
+		switch b {
+		case frameEnd:
+			escaped = append(escaped, frameEscape, frameEscapedEnd)
+		case frameEscape:
+			escaped = append(escaped, frameEscape, frameEscapedEsc)
+		default:
+			escaped = append(escaped, b)
+		}
+	}
+	return escaped
+}
+
+// Unescape reverses Escape, it returns an error if the message contains an invalid escape sequence
+func Unescape(msg []byte) ([]byte, error) {
+	unescaped := make([]byte, 0, len(msg))
+	for i := 0; i < len(msg); i++ {
+		b := msg[i]
+		if b == frameEnd {
+			return nil, fmt.Errorf("unescaped delimiter at byte %d: %X", i, msg)
+		}
+		if b != frameEscape {
+			unescaped = append(unescaped, b)
+			continue
+		}
+		i++
+		if i == len(msg) {
+			return nil, fmt.Errorf("message ends with an escape byte: %X", msg)
+		}
+		switch msg[i] {
+		case frameEscapedEnd:
+			unescaped = append(unescaped, frameEnd)
+		case frameEscapedEsc:
+			unescaped = append(unescaped, frameEscape)
+		default:
+			return nil, fmt.Errorf("invalid escape sequence at byte %d: %X", i-1, msg)
+		}
+	}
+	return unescaped, nil
+}
+
+// EncodeFrame adds the checksum to the message, escapes it and wraps it in C0 delimiters ready to be sent
+func EncodeFrame(msg []byte) []byte {
+	body := Escape(append(append([]byte{}, msg...), Checksum(msg)))
+	return append(append([]byte{frameEnd}, body...), frameEnd)
+}
+
+// DecodeFrame unescapes the bytes found between two C0 delimiters, checks the length and the checksum,
+// and returns the message with the checksum removed
+func DecodeFrame(body []byte) ([]byte, error) {
+	msg, err := Unescape(body)
+	if err != nil {
+		return nil, err
+	}
+	switch len(msg) - 1 {
+	case lengthV1, lengthV2, lengthLong:
+	default:
+		return nil, fmt.Errorf("%w %d: %X", ErrLength, len(msg), msg)
+	}
+	if Checksum(msg[:len(msg)-1]) != msg[len(msg)-1] {
+		return nil, fmt.Errorf("%w, expected %X: %X", ErrChecksum, Checksum(msg[:len(msg)-1]), msg)
+	}
+	return msg[:len(msg)-1], nil
+}
+
+// FrameReader splits a stream of bytes from the bus into frames, anything received outside of a pair of
+// delimiters is discarded
+type FrameReader struct {
+	buf     []byte
+	inFrame bool
+	ignored uint64
+}
+
+// Feed adds bytes read from the bus and returns the body of any frames that have been completed,
+// the bodies are still escaped and should be passed to DecodeFrame
+func (fr *FrameReader) Feed(data []byte) [][]byte {
+	var frames [][]byte
+	for _, b := range data {
+		if b != frameEnd {
+			if fr.inFrame {
+				fr.buf = append(fr.buf, b)
+			} else {
+				fr.ignored++
+			}
+			continue
+		}
+		// a delimiter both ends the current frame and starts the next one, so back to back
+		// delimiters are just an empty frame which is skipped
+		if fr.inFrame && len(fr.buf) > 0 {
+			frames = append(frames, fr.buf)
+		}
+		fr.buf = nil
+		fr.inFrame = true
+	}
+	return frames
+}
+
+// Reject counts the bytes of a frame that DecodeFrame couldn't decode as ignored, along with its delimiter
+func (fr *FrameReader) Reject(body []byte) {
+	fr.ignored += uint64(len(body)) + 1
+}
+
+// Ignored returns the number of bytes that have been discarded, either because they weren't inside a frame or
+// because the frame they were in was rejected
+func (fr *FrameReader) Ignored() uint64 {
+	return fr.ignored
+}
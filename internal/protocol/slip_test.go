@@ -0,0 +1,369 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// message returns a message of n bytes without the checksum, starting with the heartbeat command and the given
+// bytes, padded with zeros
+func message(n int, start ...byte) []byte {
+	msg := make([]byte, n)
+	copy(msg, append([]byte{0xFB, 0xE0}, start...))
+	return msg
+}
+
+// withChecksum returns the message with its checksum on the end
+func withChecksum(msg []byte) []byte {
+	return append(append([]byte{}, msg...), Checksum(msg))
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+		want []byte
+	}{
+		{"nothing to escape", []byte{0x01, 0x02}, []byte{0x01, 0x02}},
+		{"delimiter", []byte{0x01, 0xC0, 0x02}, []byte{0x01, 0xDB, 0xDC, 0x02}},
+		{"escape", []byte{0x01, 0xDB, 0x02}, []byte{0x01, 0xDB, 0xDD, 0x02}},
+		{"escape then delimiter", []byte{0xDB, 0xC0}, []byte{0xDB, 0xDD, 0xDB, 0xDC}},
+		{"escaped sequences aren't escaped twice", []byte{0xDB, 0xDC}, []byte{0xDB, 0xDD, 0xDC}},
+		{"empty", []byte{}, []byte{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Escape(tt.msg)
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("Escape(%X) = %X, want %X", tt.msg, got, tt.want)
+			}
+			back, err := Unescape(got)
+			if err != nil {
+				t.Fatalf("Unescape(%X) returned %v", got, err)
+			}
+			if !bytes.Equal(back, tt.msg) {
+				t.Fatalf("Unescape(%X) = %X, want %X", got, back, tt.msg)
+			}
+		})
+	}
+}
+
+func TestUnescapeInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{"trailing lone escape", []byte{0x01, 0x02, 0xDB}},
+		{"only an escape", []byte{0xDB}},
+		{"escape followed by a plain byte", []byte{0x01, 0xDB, 0x02}},
+		{"escape followed by an escape", []byte{0xDB, 0xDB, 0xDD}},
+		{"escape followed by a delimiter", []byte{0xDB, 0xC0}},
+		{"unescaped delimiter", []byte{0x01, 0xC0, 0x02}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if msg, err := Unescape(tt.body); err == nil {
+				t.Fatalf("Unescape(%X) = %X, want an error", tt.body, msg)
+			}
+		})
+	}
+}
+
+func TestDecodeFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    []byte
+		want    []byte
+		wantErr error
+	}{
+		{
+			name: "v1 length",
+			body: Escape(withChecksum(message(lengthV1, 0x77, 0x77))),
+			want: message(lengthV1, 0x77, 0x77),
+		},
+		{
+			name: "v2 length",
+			body: Escape(withChecksum(message(lengthV2, 0x77, 0x77))),
+			want: message(lengthV2, 0x77, 0x77),
+		},
+		{
+			name: "long length",
+			body: Escape(withChecksum(message(lengthLong, 0x77, 0x77))),
+			want: message(lengthLong, 0x77, 0x77),
+		},
+		{
+			name: "delimiter inside the body",
+			body: Escape(withChecksum(message(lengthV2, 0xC0, 0x01))),
+			want: message(lengthV2, 0xC0, 0x01),
+		},
+		{
+			name: "escape inside the body",
+			body: Escape(withChecksum(message(lengthV2, 0x01, 0xDB))),
+			want: message(lengthV2, 0x01, 0xDB),
+		},
+		{
+			name: "escaped delimiter written out",
+			body: append([]byte{0xFB, 0xE0, 0xDB, 0xDC}, withChecksum(message(lengthV1, 0xC0))[3:]...),
+			want: message(lengthV1, 0xC0),
+		},
+		{
+			name: "escaped escape written out",
+			body: append([]byte{0xFB, 0xE0, 0xDB, 0xDD}, withChecksum(message(lengthV1, 0xDB))[3:]...),
+			want: message(lengthV1, 0xDB),
+		},
+		{
+			name: "checksum that needs escaping",
+			// the first byte isn't summed, E0 + E0 = 0x1C0, so the checksum is C0
+			body: Escape(withChecksum(message(lengthV1, 0xE0))),
+			want: message(lengthV1, 0xE0),
+		},
+		{
+			name:    "too short",
+			body:    Escape(withChecksum(message(lengthV1 - 1))),
+			wantErr: ErrLength,
+		},
+		{
+			name:    "between the known lengths",
+			body:    Escape(withChecksum(message(lengthV1 + 1))),
+			wantErr: ErrLength,
+		},
+		{
+			name:    "too long",
+			body:    Escape(withChecksum(message(lengthLong + 1))),
+			wantErr: ErrLength,
+		},
+		{
+			name:    "only a checksum",
+			body:    []byte{0x00},
+			wantErr: ErrLength,
+		},
+		{
+			name:    "wrong checksum",
+			body:    append(Escape(message(lengthV2, 0x77, 0x77)), 0x00),
+			wantErr: ErrChecksum,
+		},
+		{
+			name:    "checksum off by one",
+			body:    append(Escape(message(lengthV1, 0x01)), Checksum(message(lengthV1, 0x01))+1),
+			wantErr: ErrChecksum,
+		},
+		{
+			name:    "checksum includes the first byte",
+			body:    append(Escape(message(lengthV1, 0x01)), Checksum(message(lengthV1, 0x01))+0xFB),
+			wantErr: ErrChecksum,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeFrame(tt.body)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("DecodeFrame(%X) returned %X, %v, want %v", tt.body, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeFrame(%X) returned %v", tt.body, err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("DecodeFrame(%X) = %X, want %X", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeFrameInvalidEscape(t *testing.T) {
+	for _, body := range [][]byte{
+		append(Escape(withChecksum(message(lengthV1))), 0xDB),
+		append([]byte{0xFB, 0xE0, 0xDB, 0x01}, withChecksum(message(lengthV1))[3:]...),
+	} {
+		if msg, err := DecodeFrame(body); err == nil {
+			t.Fatalf("DecodeFrame(%X) = %X, want an error", body, msg)
+		}
+	}
+}
+
+func TestEncodeFrame(t *testing.T) {
+	msg := message(lengthV2, 0xC0, 0xDB)
+	frame := EncodeFrame(msg)
+	if frame[0] != frameEnd || frame[len(frame)-1] != frameEnd {
+		t.Fatalf("EncodeFrame(%X) = %X, want it wrapped in C0", msg, frame)
+	}
+	if bytes.IndexByte(frame[1:len(frame)-1], frameEnd) >= 0 {
+		t.Fatalf("EncodeFrame(%X) = %X, has a C0 inside the frame", msg, frame)
+	}
+	got, err := DecodeFrame(frame[1 : len(frame)-1])
+	if err != nil {
+		t.Fatalf("DecodeFrame(%X) returned %v", frame, err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("DecodeFrame(EncodeFrame(%X)) = %X", msg, got)
+	}
+}
+
+func TestFrameReader(t *testing.T) {
+	first := EncodeFrame(message(lengthV1, 0x01))
+	second := EncodeFrame(message(lengthV2, 0xC0, 0xDB))
+	corrupt := withChecksum(message(lengthV1, 0x01))
+	corrupt[len(corrupt)-1]++
+	badChecksum := append(append([]byte{0xC0}, Escape(corrupt)...), 0xC0)
+	badLength := append(append([]byte{0xC0}, Escape(withChecksum(message(lengthV1-2)))...), 0xC0)
+	badEscape := []byte{0xC0, 0xDB, 0x01, 0xC0}
+	tests := []struct {
+		name    string
+		feeds   [][]byte
+		want    [][]byte
+		ignored uint64
+	}{
+		{
+			name:  "one frame",
+			feeds: [][]byte{first},
+			want:  [][]byte{first[1 : len(first)-1]},
+		},
+		{
+			name:  "frames sharing a delimiter",
+			feeds: [][]byte{append(first, second[1:]...)},
+			want:  [][]byte{first[1 : len(first)-1], second[1 : len(second)-1]},
+		},
+		{
+			name:  "back to back delimiters",
+			feeds: [][]byte{{0xC0, 0xC0, 0xC0}, first, {0xC0, 0xC0}},
+			want:  [][]byte{first[1 : len(first)-1]},
+		},
+		{
+			name:  "split across reads",
+			feeds: [][]byte{first[:3], first[3:7], first[7:]},
+			want:  [][]byte{first[1 : len(first)-1]},
+		},
+		{
+			name:    "noise before the first delimiter",
+			feeds:   [][]byte{{0x01, 0x02, 0x03}, first},
+			want:    [][]byte{first[1 : len(first)-1]},
+			ignored: 3,
+		},
+		{
+			name:  "unfinished frame",
+			feeds: [][]byte{first, second[:len(second)-1]},
+			want:  [][]byte{first[1 : len(first)-1]},
+		},
+		{
+			// a rejected frame's body and one of its delimiters are ignored, the other is shared with the next frame
+			name:    "rejected frames between good ones",
+			feeds:   [][]byte{first, badChecksum, second, badLength, badEscape, first},
+			want:    [][]byte{first[1 : len(first)-1], second[1 : len(second)-1], first[1 : len(first)-1]},
+			ignored: uint64(len(badChecksum) - 1 + len(badLength) - 1 + len(badEscape) - 1),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fr := &FrameReader{}
+			var got [][]byte
+			// frames that don't decode are rejected, the same as the bus does
+			for _, feed := range tt.feeds {
+				for _, body := range fr.Feed(feed) {
+					if _, err := DecodeFrame(body); err != nil {
+						fr.Reject(body)
+						continue
+					}
+					got = append(got, body)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d frames %X, want %d %X", len(got), got, len(tt.want), tt.want)
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], tt.want[i]) {
+					t.Fatalf("frame %d is %X, want %X", i, got[i], tt.want[i])
+				}
+			}
+			if fr.Ignored() != tt.ignored {
+				t.Fatalf("ignored %d bytes, want %d", fr.Ignored(), tt.ignored)
+			}
+		})
+	}
+}
+
+// specials are the bytes that need escaping or are part of an escape, random messages are made mostly of them so
+// the escaping is exercised
+var specials = []byte{frameEnd, 0xDB, 0xDC, 0xDD}
+
+// randomBytes returns n random bytes, half of them from the specials
+func randomBytes(r *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		if r.Intn(2) == 0 {
+			b[i] = specials[r.Intn(len(specials))]
+		} else {
+			b[i] = byte(r.Intn(256))
+		}
+	}
+	return b
+}
+
+// randomBodies returns the frame bodies DecodeFrame is tested with, valid frames of each length and the same frames
+// with random bytes changed, dropped or added, the seed is fixed so a failure can be repeated
+func randomBodies() [][]byte {
+	r := rand.New(rand.NewSource(1))
+	bodies := [][]byte{{0xDB}, {0xC0, 0xC0}}
+	lengths := []int{lengthV1, lengthV2, lengthLong}
+	for i := 0; i < 3000; i++ {
+		body := Escape(withChecksum(randomBytes(r, lengths[r.Intn(len(lengths))])))
+		bodies = append(bodies, body)
+		changed := append([]byte{}, body...)
+		switch r.Intn(3) {
+		case 0:
+			changed[r.Intn(len(changed))] = specials[r.Intn(len(specials))]
+		case 1:
+			changed = changed[:r.Intn(len(changed))]
+		case 2:
+			at := r.Intn(len(changed) + 1)
+			changed = append(changed[:at], append(randomBytes(r, 1+r.Intn(3)), changed[at:]...)...)
+		}
+		bodies = append(bodies, changed)
+	}
+	return bodies
+}
+
+func TestDecodeFrameRandom(t *testing.T) {
+	decoded := 0
+	for _, body := range randomBodies() {
+		msg, err := DecodeFrame(body)
+		if err != nil {
+			continue
+		}
+		decoded++
+		switch len(msg) {
+		case lengthV1, lengthV2, lengthLong:
+		default:
+			t.Fatalf("DecodeFrame(%X) returned a message of %d bytes", body, len(msg))
+		}
+		// there is only one way to escape a message, so encoding it again gives back the same body
+		frame := EncodeFrame(msg)
+		if !bytes.Equal(frame[1:len(frame)-1], body) {
+			t.Fatalf("EncodeFrame(DecodeFrame(%X)) = %X", body, frame)
+		}
+	}
+	if decoded == 0 {
+		t.Fatal("none of the frames decoded")
+	}
+}
+
+func TestEscapeRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 3000; i++ {
+		msg := randomBytes(r, r.Intn(40))
+		escaped := Escape(msg)
+		if bytes.IndexByte(escaped, frameEnd) >= 0 {
+			t.Fatalf("Escape(%X) = %X, has a C0", msg, escaped)
+		}
+		got, err := Unescape(escaped)
+		if err != nil {
+			t.Fatalf("Unescape(Escape(%X)) returned %v", msg, err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatalf("Unescape(Escape(%X)) = %X", msg, got)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// magic marks the start of a secrets file, and the version of its format
+var magic = []byte("TWCSECRETS1")
+
+// KeySize is the length of the key, a key is given and kept base64 encoded
+const KeySize = 32
+
+// Store keeps secrets, such as api tokens, in a file encrypted with AES-256-GCM
+type Store struct {
+	path string
+	aead cipher.AEAD
+}
+
+// NewStore returns a store that keeps its secrets in the file at path, encrypted with the key
+func NewStore(path string, key []byte) (*Store, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("the key must be %d bytes, not %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{path: path, aead: aead}, nil
+}
+
+// Load decrypts the file into v, v is left alone if the file doesn't exist yet
+func (s *Store) Load(v interface{}) error {
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(b, magic) {
+		return fmt.Errorf("%s is not a secrets file", s.path)
+	}
+	b = b[len(magic):]
+	size := s.aead.NonceSize()
+	if len(b) < size {
+		return fmt.Errorf("%s is too short", s.path)
+	}
+	plain, err := s.aead.Open(nil, b[:size], b[size:], magic)
+	if err != nil {
+		return fmt.Errorf("unable to decrypt %s, the key may have changed: %v", s.path, err)
+	}
+	return json.Unmarshal(plain, v)
+}
+
+// Save encrypts v to the file, replacing what was there so a failed write doesn't lose the old secrets
+func (s *Store) Save(v interface{}) error {
+	plain, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	b := append(append([]byte{}, magic...), nonce...)
+	b = s.aead.Seal(b, nonce, plain, magic)
+	return writeFile(s.path, b)
+}
+
+// LoadKey returns the key to encrypt the secrets with, the key is used if it is given, otherwise it is read from the
+// keyfile, which is created with a random key if it doesn't exist. Either way the key is 32 random bytes base64
+// encoded, a passphrase isn't accepted as it would be too easy to guess
+func LoadKey(key, keyfile string) ([]byte, error) {
+	if key != "" {
+		b, err := decodeKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("TWC_SECRETS_KEY %v", err)
+		}
+		return b, nil
+	}
+	b, err := ioutil.ReadFile(keyfile)
+	if err == nil {
+		b, err := decodeKey(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("the keyfile %s %v", keyfile, err)
+		}
+		return b, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	random := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, random); err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(random)
+	if err := writeFile(keyfile, []byte(encoded+"\n")); err != nil {
+		return nil, fmt.Errorf("unable to create the keyfile %s: %v", keyfile, err)
+	}
+	return random, nil
+}
+
+// decodeKey decodes a base64 key, the error says what is wrong with it
+func decodeKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, fmt.Errorf("is empty")
+	}
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("isn't base64, generate a key with: head -c %d /dev/urandom | base64", KeySize)
+	}
+	if len(b) != KeySize {
+		return nil, fmt.Errorf("is %d bytes, it must be %d random bytes base64 encoded: head -c %d /dev/urandom | base64", len(b), KeySize, KeySize)
+	}
+	return b, nil
+}
+
+// writeFile writes the file readable only by its owner, through a temporary file so it is never half written
+func writeFile(path string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+strings.TrimPrefix(filepath.Base(path), ".")+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
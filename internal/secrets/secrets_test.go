@@ -0,0 +1,127 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestLoadKey(t *testing.T) {
+	key := make([]byte, KeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	encoded := base64.StdEncoding.EncodeToString(key)
+	tests := []struct {
+		name    string
+		env     string
+		keyfile string // written to the keyfile if not empty
+		want    []byte
+		wantErr string
+	}{
+		{name: "from the environment", env: encoded, want: key},
+		{name: "the environment over the keyfile", env: encoded, keyfile: "not a key", want: key},
+		{name: "from the keyfile", keyfile: encoded + "\n", want: key},
+		{name: "a passphrase", env: "correct horse battery staple", wantErr: "isn't base64"},
+		{name: "too short", env: base64.StdEncoding.EncodeToString(key[:16]), wantErr: "is 16 bytes"},
+		{name: "a passphrase in the keyfile", keyfile: "hunter2", wantErr: "isn't base64"},
+		{name: "an empty keyfile", keyfile: "\n", wantErr: "is empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyfile := filepath.Join(tempDir(t), "secrets.key")
+			if tt.keyfile != "" {
+				if err := ioutil.WriteFile(keyfile, []byte(tt.keyfile), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := LoadKey(tt.env, keyfile)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("returned %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(tt.want) {
+				t.Fatalf("returned %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadKeyCreatesKeyfile(t *testing.T) {
+	keyfile := filepath.Join(tempDir(t), "secrets.key")
+	key, err := LoadKey("", keyfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != KeySize {
+		t.Fatalf("created a %d byte key, want %d", len(key), KeySize)
+	}
+	info, err := os.Stat(keyfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("the keyfile is %v, want readable only by its owner", info.Mode().Perm())
+	}
+	again, err := LoadKey("", keyfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(key) {
+		t.Fatalf("read %x from the keyfile, want the key it was created with %x", again, key)
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir := tempDir(t)
+	path := filepath.Join(dir, "secrets.enc")
+	key, err := LoadKey("", filepath.Join(dir, "secrets.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewStore(path, key[:16]); err == nil {
+		t.Fatal("made a store with a 16 byte key")
+	}
+	store, err := NewStore(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]string
+	if err := store.Load(&got); err != nil || got != nil {
+		t.Fatalf("loaded %v, %v before anything was saved", got, err)
+	}
+	want := map[string]string{"token": "secret"}
+	if err := store.Save(want); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(b), "secret") {
+		t.Fatal("the secret was saved in the clear")
+	}
+	if err := store.Load(&got); err != nil || got["token"] != "secret" {
+		t.Fatalf("loaded %v, %v, want %v", got, err, want)
+	}
+
+	other := make([]byte, KeySize)
+	store, _ = NewStore(path, other)
+	if err := store.Load(&got); err == nil {
+		t.Fatal("decrypted the secrets with another key")
+	}
+}
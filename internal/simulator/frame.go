@@ -0,0 +1,15 @@
+package simulator
+
+import (
+	"github.com/shreddedbacon/twcmanager/internal/protocol"
+)
+
+// encodeFrame adds the checksum, escapes any special bytes and wraps the message in C0 delimiters,
+// optionally corrupting the checksum to test how the primary copes with it
+func encodeFrame(msg []byte, badChecksum bool) []byte {
+	if !badChecksum {
+		return protocol.EncodeFrame(msg)
+	}
+	body := protocol.Escape(append(append([]byte{}, msg...), protocol.Checksum(msg)+1))
+	return append(append([]byte{0xC0}, body...), 0xC0)
+}
@@ -0,0 +1,390 @@
+package simulator
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shreddedbacon/twcmanager/internal/protocol"
+	"github.com/shreddedbacon/twcmanager/internal/transport"
+)
+
+// Options configures the fault injection of the simulator
+type Options struct {
+	DropRate        float64       // fraction of outgoing frames that are never sent
+	BadChecksumRate float64       // fraction of outgoing frames sent with a corrupted checksum
+	SilentAfter     time.Duration // stop responding entirely once this long has passed since Run was called, 0 disables
+	Voltage         uint16        // the voltage reported on each phase, defaults to 240
+	Phases          int           // number of phases reported, defaults to 1
+}
+
+// TWC is a simulated Tesla Wall Connector running in secondary mode
+type TWC struct {
+	ID              []byte
+	Sign            byte
+	MaxAmps         uint16 // the maximum the TWC supports, in hundredths of an amp
+	ProtocolVersion int
+	FirmwareVersion [4]byte
+	SerialNumber    string
+	Model           string
+
+	linked      bool
+	silent      bool
+	primaryID   []byte
+	offeredAmps uint16 // in hundredths of an amp, as sent in the primary heartbeat
+	actualAmps  uint16
+	allowCharge bool
+	plugged     bool
+	vin         string
+	carMaxAmps  uint16
+	lifetimeWh  float64
+}
+
+// State is a snapshot of a simulated TWC
+type State struct {
+	ID          []byte
+	Linked      bool
+	Silent      bool
+	OfferedAmps uint16
+	ActualAmps  uint16
+	Plugged     bool
+	Charging    bool
+	VIN         string
+	LifetimeKWH uint32
+}
+
+// Simulator emulates one or more TWCs on the other end of a transport
+type Simulator struct {
+	port transport.Transport
+	opts Options
+
+	mu      sync.Mutex
+	twcs    []*TWC
+	started time.Time
+	rand    *rand.Rand
+	reader  protocol.FrameReader
+}
+
+// New creates a simulator that talks to the primary over the given transport
+func New(port transport.Transport, opts Options) *Simulator {
+	if opts.Voltage == 0 {
+		opts.Voltage = 240
+	}
+	if opts.Phases != 3 {
+		opts.Phases = 1
+	}
+	return &Simulator{
+		port: port,
+		opts: opts,
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Open creates an in-memory transport with a simulator attached to the other end, the path is of the form
+// sim://<count> where count is the number of TWCs to simulate, the first TWC has a car plugged in
+func Open(path string) (transport.Transport, *Simulator, error) {
+	count := 1
+	if c := strings.TrimPrefix(path, "sim://"); c != "" {
+		var err error
+		count, err = strconv.Atoi(c)
+		if err != nil || count < 1 {
+			return nil, nil, fmt.Errorf("not a valid simulator path: %s", path)
+		}
+	}
+	primaryEnd, simEnd := transport.Pipe()
+	s := New(simEnd, Options{})
+	for i := 0; i < count; i++ {
+		s.AddTWC([]byte{0x10, byte(0x01 + i)}, 3200)
+	}
+	_ = s.PlugIn([]byte{0x10, 0x01}, "5YJ3E7EB0KF000001")
+	go s.Run(nil)
+	return primaryEnd, s, nil
+}
+
+// AddTWC adds a TWC to the simulator, maxAmps is in hundredths of an amp
+func (s *Simulator) AddTWC(id []byte, maxAmps uint16) *TWC {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	twc := &TWC{
+		ID:              append([]byte{}, id...),
+		Sign:            0x77,
+		MaxAmps:         maxAmps,
+		ProtocolVersion: 2,
+		FirmwareVersion: [4]byte{4, 5, 3, 0},
+		SerialNumber:    fmt.Sprintf("A%010X", id),
+		Model:           "1001234-02-D",
+		allowCharge:     true,
+		carMaxAmps:      3200,
+	}
+	s.twcs = append(s.twcs, twc)
+	return twc
+}
+
+// PlugIn simulates a car with the given VIN being plugged in to a TWC
+func (s *Simulator) PlugIn(id []byte, vin string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	twc, ok := s.find(id)
+	if !ok {
+		return fmt.Errorf("unknown twc %x", id)
+	}
+	twc.plugged = true
+	twc.vin = vin
+	return nil
+}
+
+// Unplug simulates the car being unplugged from a TWC
+func (s *Simulator) Unplug(id []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	twc, ok := s.find(id)
+	if !ok {
+		return fmt.Errorf("unknown twc %x", id)
+	}
+	twc.plugged = false
+	twc.vin = ""
+	twc.actualAmps = 0
+	return nil
+}
+
+// SetCarMaxAmps limits how much the car will draw, in hundredths of an amp, to simulate a car tapering off
+func (s *Simulator) SetCarMaxAmps(id []byte, amps uint16) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	twc, ok := s.find(id)
+	if !ok {
+		return fmt.Errorf("unknown twc %x", id)
+	}
+	twc.carMaxAmps = amps
+	return nil
+}
+
+// Silence stops a TWC responding to anything, the primary should remove it after 26 seconds
+func (s *Simulator) Silence(id []byte, silent bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	twc, ok := s.find(id)
+	if !ok {
+		return fmt.Errorf("unknown twc %x", id)
+	}
+	twc.silent = silent
+	return nil
+}
+
+// State returns a snapshot of a simulated TWC
+func (s *Simulator) State(id []byte) (State, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	twc, ok := s.find(id)
+	if !ok {
+		return State{}, false
+	}
+	return State{
+		ID:          twc.ID,
+		Linked:      twc.linked,
+		Silent:      twc.silent,
+		OfferedAmps: twc.offeredAmps,
+		ActualAmps:  twc.actualAmps,
+		Plugged:     twc.plugged,
+		Charging:    twc.actualAmps > 0,
+		VIN:         twc.vin,
+		LifetimeKWH: uint32(twc.lifetimeWh / 1000),
+	}, true
+}
+
+func (s *Simulator) find(id []byte) (*TWC, bool) {
+	for _, twc := range s.twcs {
+		if bytes.Equal(twc.ID, id) {
+			return twc, true
+		}
+	}
+	return nil, false
+}
+
+// Run runs the simulator until the stop channel is closed or the transport is closed
+func (s *Simulator) Run(stop <-chan struct{}) {
+	s.mu.Lock()
+	s.started = time.Now()
+	s.mu.Unlock()
+
+	go s.tick(stop)
+	buf := make([]byte, 64)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		_ = s.port.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+		n, err := s.port.Read(buf)
+		if n > 0 {
+			for _, body := range s.reader.Feed(buf[:n]) {
+				if msg, err := protocol.DecodeFrame(body); err == nil {
+					s.handle(msg)
+				}
+			}
+		}
+		if err != nil && err != transport.ErrTimeout {
+			return
+		}
+	}
+}
+
+// tick sends linkready messages for any TWCs that are not linked yet, and updates the charging simulation
+func (s *Simulator) tick(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			elapsed := now.Sub(last).Hours()
+			last = now
+			for _, twc := range s.twcs {
+				s.simulateCharging(twc, elapsed)
+				if !twc.linked {
+					s.send(twc, s.linkReady(twc))
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *Simulator) simulateCharging(twc *TWC, elapsedHours float64) {
+	if !twc.plugged || !twc.allowCharge || twc.offeredAmps < 500 {
+		twc.actualAmps = 0
+		return
+	}
+	twc.actualAmps = twc.offeredAmps
+	if twc.actualAmps > twc.carMaxAmps {
+		twc.actualAmps = twc.carMaxAmps
+	}
+	if twc.actualAmps > twc.MaxAmps {
+		twc.actualAmps = twc.MaxAmps
+	}
+	watts := float64(twc.actualAmps) / 100 * float64(s.opts.Voltage) * float64(s.opts.Phases)
+	twc.lifetimeWh = twc.lifetimeWh + watts*elapsedHours
+}
+
+// send writes a message from the TWC to the bus, applying any fault injection
+func (s *Simulator) send(twc *TWC, f protocol.Frame) {
+	if twc.silent || (s.opts.SilentAfter > 0 && time.Since(s.started) > s.opts.SilentAfter) {
+		return
+	}
+	if s.opts.DropRate > 0 && s.rand.Float64() < s.opts.DropRate {
+		return
+	}
+	badChecksum := s.opts.BadChecksumRate > 0 && s.rand.Float64() < s.opts.BadChecksumRate
+	_, _ = s.port.Write(encodeFrame(f.Encode(twc.ProtocolVersion), badChecksum))
+}
+
+func (s *Simulator) handle(msg []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := protocol.Decode(msg)
+	if err != nil {
+		return
+	}
+	switch f.Command {
+	case protocol.PrimaryLinkReady1, protocol.PrimaryLinkReady2:
+		// the primary is looking for secondaries
+		for _, twc := range s.twcs {
+			if !twc.linked {
+				s.send(twc, s.linkReady(twc))
+			}
+		}
+		return
+	}
+	twc, ok := s.find(f.Receiver)
+	if !ok {
+		return
+	}
+	switch f.Command {
+	case protocol.PrimaryHeartbeat:
+		twc.linked = true
+		twc.primaryID = append([]byte{}, f.Sender...)
+		if hb, err := protocol.ParsePrimaryHeartbeat(f); err == nil && (hb.Command == 0x05 || hb.Command == 0x09) {
+			twc.offeredAmps = hb.Amps
+		}
+		s.send(twc, s.heartbeat(twc))
+	case protocol.StartCharging:
+		twc.allowCharge = true
+	case protocol.StopCharging:
+		twc.allowCharge = false
+		twc.actualAmps = 0
+	case protocol.PollKWH:
+		s.send(twc, s.kwh(twc))
+	case protocol.PollVINStart:
+		s.send(twc, s.vinPart(twc, protocol.VINStart, 0, 7))
+	case protocol.PollVINMiddle:
+		s.send(twc, s.vinPart(twc, protocol.VINMiddle, 7, 14))
+	case protocol.PollVINEnd:
+		s.send(twc, s.vinPart(twc, protocol.VINEnd, 14, 17))
+	case protocol.PollPlugState:
+		s.send(twc, s.plugState(twc))
+	case protocol.PollFirmwareVersion:
+		s.send(twc, protocol.NewFirmwareVersion(twc.ID, twc.FirmwareVersion))
+	case protocol.PollSerialNumber:
+		s.send(twc, protocol.NewString(protocol.SerialNumber, twc.ID, twc.SerialNumber))
+	case protocol.PollModel:
+		s.send(twc, protocol.NewString(protocol.Model, twc.ID, twc.Model))
+	}
+}
+
+func (s *Simulator) linkReady(twc *TWC) protocol.Frame {
+	return protocol.NewLinkReady(twc.ID, protocol.LinkReady{Sign: twc.Sign, MaxAmps: twc.MaxAmps})
+}
+
+// heartbeat builds the secondary heartbeat, state 0 is ready, 1 is charging and 4 is plugged in but not charging
+func (s *Simulator) heartbeat(twc *TWC) protocol.Frame {
+	state := byte(0x00)
+	if twc.plugged {
+		state = 0x04
+		if twc.actualAmps > 0 {
+			state = 0x01
+		}
+	}
+	return protocol.NewSecondaryHeartbeat(twc.ID, twc.primaryID, protocol.SecondaryHeartbeatData{
+		State:      state,
+		AmpsMax:    twc.offeredAmps,
+		AmpsActual: twc.actualAmps,
+	})
+}
+
+// kwh builds the lifetime kWh and voltage report, amps are reported in half amps
+func (s *Simulator) kwh(twc *TWC) protocol.Frame {
+	data := protocol.KWHData{LifetimeKWH: uint32(twc.lifetimeWh / 1000)}
+	for phase := 0; phase < s.opts.Phases; phase++ {
+		data.Volts[phase] = s.opts.Voltage
+		data.HalfAmps[phase] = byte(twc.actualAmps / 50)
+	}
+	return protocol.NewKWH(twc.ID, data)
+}
+
+func (s *Simulator) vinPart(twc *TWC, cmd protocol.Command, from, to int) protocol.Frame {
+	part := ""
+	if len(twc.vin) >= to {
+		part = twc.vin[from:to]
+	}
+	return protocol.NewVINPart(cmd, twc.ID, part)
+}
+
+// plugState builds the plug state, 0 is unplugged, 1 is plugged in and charging, 3 is plugged in and not charging
+func (s *Simulator) plugState(twc *TWC) protocol.Frame {
+	state := byte(0x00)
+	if twc.plugged {
+		state = 0x03
+		if twc.actualAmps > 0 {
+			state = 0x01
+		}
+	}
+	return protocol.NewPlugState(twc.ID, state)
+}
@@ -0,0 +1,114 @@
+package transport
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// Pipe creates a pair of connected in-memory transports, anything written to one end can be read from the other.
+// Unlike net.Pipe writes are buffered and never block, which matches how bytes are put on to a serial line.
+func Pipe() (Transport, Transport) {
+	a := newPipeBuffer()
+	b := newPipeBuffer()
+	return &pipeEnd{rx: a, tx: b}, &pipeEnd{rx: b, tx: a}
+}
+
+type pipeBuffer struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	notify chan struct{}
+	closed bool
+}
+
+func newPipeBuffer() *pipeBuffer {
+	return &pipeBuffer{
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (pb *pipeBuffer) signal() {
+	select {
+	case pb.notify <- struct{}{}:
+	default:
+	}
+}
+
+type pipeEnd struct {
+	rx *pipeBuffer
+	tx *pipeBuffer
+
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+// Read reads buffered data from the other end of the pipe, waiting until the read deadline if there is nothing buffered
+func (pe *pipeEnd) Read(b []byte) (int, error) {
+	pe.mu.Lock()
+	deadline := pe.readDeadline
+	pe.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		pe.rx.mu.Lock()
+		if pe.rx.buf.Len() > 0 {
+			n, _ := pe.rx.buf.Read(b)
+			if pe.rx.buf.Len() > 0 {
+				pe.rx.signal()
+			}
+			pe.rx.mu.Unlock()
+			return n, nil
+		}
+		closed := pe.rx.closed
+		pe.rx.mu.Unlock()
+		if closed {
+			return 0, io.EOF
+		}
+		select {
+		case <-pe.rx.notify:
+		case <-timeout:
+			return 0, ErrTimeout
+		}
+	}
+}
+
+// Write buffers the data for the other end of the pipe to read
+func (pe *pipeEnd) Write(b []byte) (int, error) {
+	pe.tx.mu.Lock()
+	defer pe.tx.mu.Unlock()
+	if pe.tx.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n, _ := pe.tx.buf.Write(b)
+	pe.tx.signal()
+	return n, nil
+}
+
+// Close closes both directions of the pipe
+func (pe *pipeEnd) Close() error {
+	for _, pb := range []*pipeBuffer{pe.rx, pe.tx} {
+		pb.mu.Lock()
+		pb.closed = true
+		pb.signal()
+		pb.mu.Unlock()
+	}
+	return nil
+}
+
+// SetReadDeadline sets the time that reads will stop waiting for data
+func (pe *pipeEnd) SetReadDeadline(t time.Time) error {
+	pe.mu.Lock()
+	pe.readDeadline = t
+	pe.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline is a no-op, writes to the pipe never block
+func (pe *pipeEnd) SetWriteDeadline(t time.Time) error {
+	return nil
+}
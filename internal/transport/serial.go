@@ -0,0 +1,69 @@
+package transport
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// Serial wraps a tarm serial port so it can be used as a transport
+// the serial port only supports a fixed read timeout, so deadlines are emulated by retrying reads until the deadline passes
+type Serial struct {
+	port io.ReadWriteCloser // a *serial.Port
+
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+// OpenSerial opens the serial port with the given configuration
+func OpenSerial(config *serial.Config) (*Serial, error) {
+	port, err := serial.OpenPort(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Serial{
+		port: port,
+	}, nil
+}
+
+// Read reads from the serial port, if a read deadline is set it will keep reading until data arrives or the deadline
+// passes, then returns ErrTimeout
+func (s *Serial) Read(b []byte) (int, error) {
+	s.mu.Lock()
+	deadline := s.readDeadline
+	s.mu.Unlock()
+	for {
+		n, err := s.port.Read(b)
+		if n > 0 || err != nil || deadline.IsZero() {
+			return n, err
+		}
+		if time.Now().After(deadline) {
+			return 0, ErrTimeout
+		}
+	}
+}
+
+// Write writes to the serial port
+func (s *Serial) Write(b []byte) (int, error) {
+	return s.port.Write(b)
+}
+
+// Close closes the serial port
+func (s *Serial) Close() error {
+	return s.port.Close()
+}
+
+// SetReadDeadline sets the time that reads will stop waiting for data
+func (s *Serial) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.readDeadline = t
+	s.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline is a no-op, writes to the serial port do not block
+func (s *Serial) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,49 @@
+package transport
+
+import (
+	"testing"
+	"time"
+)
+
+// quietPort is a serial port that nothing is sent to, like tarm it returns no data and no error at each of its
+// fixed read timeouts
+type quietPort struct {
+	data []byte
+}
+
+func (q *quietPort) Read(b []byte) (int, error) {
+	time.Sleep(10 * time.Millisecond)
+	n := copy(b, q.data)
+	q.data = q.data[n:]
+	return n, nil
+}
+
+func (q *quietPort) Write(b []byte) (int, error) { return len(b), nil }
+func (q *quietPort) Close() error                { return nil }
+
+func TestSerialReadDeadline(t *testing.T) {
+	s := &Serial{port: &quietPort{}}
+	buf := make([]byte, 8)
+	_ = s.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	start := time.Now()
+	n, err := s.Read(buf)
+	if n != 0 || err != ErrTimeout {
+		t.Fatalf("read %d bytes and %v after the deadline, want ErrTimeout", n, err)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Fatalf("gave up after %v, before the deadline", waited)
+	}
+
+	// data that arrives before the deadline is returned
+	s = &Serial{port: &quietPort{data: []byte{0xc0, 0xfd}}}
+	_ = s.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := s.Read(buf); n != 2 || err != nil {
+		t.Fatalf("read %d bytes and %v, want the 2 bytes sent", n, err)
+	}
+
+	// without a deadline each of the port's own timeouts is returned
+	_ = s.SetReadDeadline(time.Time{})
+	if n, err := s.Read(buf); n != 0 || err != nil {
+		t.Fatalf("read %d bytes and %v without a deadline", n, err)
+	}
+}
@@ -0,0 +1,154 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// the waits between attempts to connect to a bridge again, doubling each time up to the most
+const (
+	minRedialBackoff = 500 * time.Millisecond
+	maxRedialBackoff = 30 * time.Second
+)
+
+// errClosed is returned by a transport that has been closed
+var errClosed = errors.New("transport closed")
+
+// TCP is a connection to an RS485 to ethernet bridge, the bridges drop connections when they restart or the
+// network blips, so a read or write that fails closes the connection and the next one dials the bridge again,
+// backing off while it can't be reached
+type TCP struct {
+	address string
+	timeout time.Duration
+
+	mu            sync.Mutex
+	conn          net.Conn // nil until the bridge is dialled again
+	readDeadline  time.Time
+	writeDeadline time.Time
+	backoff       time.Duration
+	nextDial      time.Time // when the bridge may be dialled again
+	closed        bool
+}
+
+// DialTCP connects to a raw TCP socket, typically an RS485 to ethernet bridge
+func DialTCP(address string, timeout time.Duration) (*TCP, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %s: %v", address, err)
+	}
+	return &TCP{address: address, timeout: timeout, conn: conn}, nil
+}
+
+// connect returns the connection to the bridge, dialling it again if the last one failed and the backoff has passed
+func (t *TCP) connect() (net.Conn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, errClosed
+	}
+	if t.conn != nil {
+		return t.conn, nil
+	}
+	if time.Now().Before(t.nextDial) {
+		return nil, fmt.Errorf("unable to connect to %s, trying again in %v", t.address, time.Until(t.nextDial).Round(time.Millisecond))
+	}
+	conn, err := net.DialTimeout("tcp", t.address, t.timeout)
+	if err != nil {
+		t.backoff *= 2
+		if t.backoff < minRedialBackoff {
+			t.backoff = minRedialBackoff
+		} else if t.backoff > maxRedialBackoff {
+			t.backoff = maxRedialBackoff
+		}
+		t.nextDial = time.Now().Add(t.backoff)
+		return nil, fmt.Errorf("unable to connect to %s: %v", t.address, err)
+	}
+	t.backoff = 0
+	_ = conn.SetReadDeadline(t.readDeadline)
+	_ = conn.SetWriteDeadline(t.writeDeadline)
+	t.conn = conn
+	return conn, nil
+}
+
+// drop closes the connection after it failed, unless it has already been replaced
+func (t *TCP) drop(conn net.Conn, err error) {
+	if isTimeout(err) {
+		return
+	}
+	t.mu.Lock()
+	if t.conn == conn {
+		t.conn = nil
+	}
+	t.mu.Unlock()
+	conn.Close()
+}
+
+// Read reads from the bridge, if the connection fails it is dialled again on the next read or write
+func (t *TCP) Read(b []byte) (int, error) {
+	conn, err := t.connect()
+	if err != nil {
+		return 0, err
+	}
+	n, err := conn.Read(b)
+	if err != nil {
+		t.drop(conn, err)
+	}
+	return n, err
+}
+
+// Write writes to the bridge, if the connection fails it is dialled again on the next read or write
+func (t *TCP) Write(b []byte) (int, error) {
+	conn, err := t.connect()
+	if err != nil {
+		return 0, err
+	}
+	n, err := conn.Write(b)
+	if err != nil {
+		t.drop(conn, err)
+	}
+	return n, err
+}
+
+// Close closes the connection, it isn't dialled again
+func (t *TCP) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
+
+// SetReadDeadline sets the time that reads will stop waiting for data, it carries over to a new connection
+func (t *TCP) SetReadDeadline(deadline time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.readDeadline = deadline
+	if t.conn == nil {
+		return nil
+	}
+	return t.conn.SetReadDeadline(deadline)
+}
+
+// SetWriteDeadline sets the time that writes will give up, it carries over to a new connection
+func (t *TCP) SetWriteDeadline(deadline time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.writeDeadline = deadline
+	if t.conn == nil {
+		return nil
+	}
+	return t.conn.SetWriteDeadline(deadline)
+}
+
+// isTimeout returns true if the error is from a deadline passing, the connection is still good after one
+func isTimeout(err error) bool {
+	var t interface{ Timeout() bool }
+	return errors.As(err, &t) && t.Timeout()
+}
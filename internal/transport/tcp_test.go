@@ -0,0 +1,185 @@
+package transport
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// bridge accepts the connections a test makes to it
+type bridge struct {
+	listener net.Listener
+	conns    chan net.Conn
+}
+
+func newBridge(t *testing.T, address string) *bridge {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &bridge{listener: l, conns: make(chan net.Conn, 10)}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			b.conns <- conn
+		}
+	}()
+	return b
+}
+
+// accept returns the next connection made to the bridge
+func (b *bridge) accept(t *testing.T) net.Conn {
+	t.Helper()
+	select {
+	case conn := <-b.conns:
+		return conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("the transport didn't connect")
+	}
+	return nil
+}
+
+// expect reads from the connection and fails the test if it isn't what was written
+func expect(t *testing.T, conn net.Conn, want string) {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != want {
+		t.Fatalf("read %q, %v, want %q", got, err, want)
+	}
+}
+
+// readUntilError reads until the transport fails
+func readUntilError(t *testing.T, tcp *TCP) error {
+	t.Helper()
+	buf := make([]byte, 16)
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		_ = tcp.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, err := tcp.Read(buf); err != nil && !isTimeout(err) {
+			return err
+		}
+	}
+	t.Fatal("the transport didn't fail")
+	return nil
+}
+
+func TestTCPRedials(t *testing.T) {
+	b := newBridge(t, "127.0.0.1:0")
+	tcp, err := DialTCP(b.listener.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	conn := b.accept(t)
+	if _, err := tcp.Write([]byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, conn, "one")
+	_, _ = conn.Write([]byte("two"))
+	_ = tcp.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(tcp, buf); err != nil || string(buf) != "two" {
+		t.Fatalf("read %q, %v, want two", buf, err)
+	}
+
+	// the bridge drops the connection, a read notices and the next write dials it again
+	conn.Close()
+	readUntilError(t, tcp)
+	if _, err := tcp.Write([]byte("three")); err != nil {
+		t.Fatal(err)
+	}
+	conn = b.accept(t)
+	expect(t, conn, "three")
+	// the read deadline carries over to the new connection
+	_ = tcp.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := tcp.Read(buf); !isTimeout(err) {
+		t.Fatalf("read returned %v, want a timeout", err)
+	}
+}
+
+func TestTCPBacksOff(t *testing.T) {
+	b := newBridge(t, "127.0.0.1:0")
+	address := b.listener.Addr().String()
+	tcp, err := DialTCP(address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	conn := b.accept(t)
+
+	// the bridge goes away, the first attempt to dial it fails and the next waits for the backoff
+	b.listener.Close()
+	conn.Close()
+	readUntilError(t, tcp)
+	if _, err := tcp.Write([]byte("lost")); err == nil {
+		t.Fatal("wrote to a bridge that isn't there")
+	}
+	tcp.mu.Lock()
+	backoff, nextDial := tcp.backoff, tcp.nextDial
+	tcp.mu.Unlock()
+	if backoff != minRedialBackoff || time.Until(nextDial) <= 0 {
+		t.Fatalf("backing off for %v until %v, want %v", backoff, nextDial, minRedialBackoff)
+	}
+	if _, err := tcp.Write([]byte("lost")); err == nil {
+		t.Fatal("wrote to a bridge that isn't there")
+	}
+	tcp.mu.Lock()
+	backoff = tcp.backoff
+	tcp.mu.Unlock()
+	if backoff != minRedialBackoff {
+		t.Fatalf("dialled again before the backoff passed, backing off for %v", backoff)
+	}
+
+	// once the bridge is back the transport connects to it after the backoff
+	b = newBridge(t, address)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := tcp.Write([]byte("back")); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the transport didn't connect again")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	expect(t, b.accept(t), "back")
+	tcp.mu.Lock()
+	backoff = tcp.backoff
+	tcp.mu.Unlock()
+	if backoff != 0 {
+		t.Fatalf("still backing off for %v once connected", backoff)
+	}
+}
+
+func TestTCPClose(t *testing.T) {
+	b := newBridge(t, "127.0.0.1:0")
+	tcp, err := DialTCP(b.listener.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.accept(t)
+	if err := tcp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tcp.Write([]byte("closed")); err != errClosed {
+		t.Fatalf("write after closing returned %v, want %v", err, errClosed)
+	}
+	select {
+	case <-b.conns:
+		t.Fatal("the transport dialled again after it was closed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// nothing listening
+	b.listener.Close()
+	if _, err := DialTCP(b.listener.Addr().String(), time.Second); err == nil {
+		t.Fatal("connected to a bridge that isn't there")
+	}
+}
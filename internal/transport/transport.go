@@ -0,0 +1,49 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// Transport is the connection to the RS485 bus that the controller talks over
+type Transport interface {
+	io.Reader
+	io.Writer
+	io.Closer
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// ErrTimeout is returned when a read deadline passes before any data arrives
+var ErrTimeout error = timeoutError{}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// Open opens a transport for the given device path, a path of `tcp://host:port` will connect to an RS485 to ethernet bridge
+// otherwise the path is treated as a serial device
+func Open(devicePath string, baudRate int, readTimeout time.Duration) (Transport, error) {
+	if strings.HasPrefix(devicePath, "tcp://") {
+		t, err := DialTCP(strings.TrimPrefix(devicePath, "tcp://"), readTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+	if strings.Contains(devicePath, "://") {
+		return nil, fmt.Errorf("unsupported transport: %s", devicePath)
+	}
+	return OpenSerial(&serial.Config{
+		Name:        devicePath,
+		Baud:        baudRate,
+		ReadTimeout: readTimeout,
+		Size:        8,
+	})
+}
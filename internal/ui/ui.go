@@ -3,6 +3,7 @@
  //Package ui generated by go-bindata.// sources:
 // internal/ui/templates/accounts.html
 // internal/ui/templates/base.html
+// internal/ui/templates/history.html
 // internal/ui/templates/home.html
 // internal/ui/templates/powerwall.html
 // internal/ui/templates/settings.html
@@ -84,7 +85,7 @@ func (fi bindataFileInfo) Sys() interface{} {
 	return nil
 }
 
-var _templatesAccountsHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xa4\x55\x4d\x8f\xdb\x36\x10\xbd\xe7\x57\x0c\x08\xf4\x16\xad\x92\x16\xe8\xa1\x90\x85\x1a\x6d\x5a\xec\x25\xd8\xc3\x16\x3d\x53\xe2\x58\x62\x4b\x71\x84\xe1\x68\x1d\xc3\xf5\x7f\x2f\x48\x7d\x58\xb2\x37\x8b\x14\xbd\x48\x24\x67\xf8\xe6\x43\xef\x8d\xce\x67\x83\x07\xeb\x11\x54\x4d\x5e\xd0\x8b\xba\x5c\xde\x15\xc6\xbe\x40\xed\x74\x08\xbb\x74\xac\xad\x47\x56\xe5\x3b\x80\x22\xf4\xda\xcf\xa6\x80\xb5\x58\xf2\x99\x58\x71\xa8\xca\xf3\x19\x1e\x9e\x74\x83\x9f\x75\x87\x70\xb9\x14\x79\xf4\x4d\x97\x5a\x4e\x2f\xd1\x95\x43\xb0\x66\xa7\x7a\xa6\xbf\xe2\x65\x7f\x20\x35\xa3\x8d\xd6\xf4\xcc\x82\xb0\xed\xd1\x4c\xbb\x96\x5e\x90\xa1\x1e\x82\x50\x97\xa5\x23\x05\x41\x4e\x0e\x77\xea\x68\x8d\xb4\x3f\x7d\xfc\xf0\xe1\xbb\x94\x5e\x0c\xd2\xa2\x36\x0b\x26\x7e\x91\xec\xd8\x5a\x41\xa8\x9a\x6c\x44\x98\x1c\xa3\x2b\xcf\xcb\x74\xaf\xfc\x23\x20\x17\xb9\xb4\xdb\xd3\x4f\x5f\x7a\xcb\x18\xee\x0d\xeb\x93\x22\x9f\xc1\xe2\x29\x6a\x33\x67\x53\x91\x39\xcd\x4e\xe7\x33\x6b\xdf\xe0\xd8\xa5\x5f\xb5\xe8\x87\x67\x0c\x4e\xef\x9f\x1e\x9f\xe9\x6f\xf4\xe1\x72\x79\x3d\x33\x93\x5a\x1b\xb3\xf3\x73\x6b\xc5\xdc\x3b\x24\x90\x87\x29\x5d\xf8\x07\x7e\x47\x79\xb6\x5f\xf1\x2f\xaa\x41\x84\x96\x2f\x59\x89\x87\x4a\xfc\xd4\xa1\x8c\xb5\xb1\x43\x58\x9f\x7c\xaf\x40\x4e\x3d\xee\x54\x18\xaa\xce\x8a\x2a\x7f\x23\x6e\x50\x8a\x7c\xc4\x29\xd7\x11\xae\xbd\x88\x25\xa3\x0b\xf8\xd5\xc2\xa0\x26\x17\x59\xb2\x53\x3f\xa8\xf2\x33\xc1\xbe\xae\x69\xf0\x12\x60\x6f\x0c\x9a\x37\x40\xbd\x99\x30\x8b\x7c\x69\x71\x91\x27\x6e\xa4\x65\x35\x32\x6e\xc5\x63\xa6\xe3\x4c\x91\x0d\xbb\x5d\x16\xba\xec\xc7\x2b\x29\xd6\x46\xcd\x46\xad\xf2\xbd\x31\x65\x31\xf0\x95\x57\x99\xb3\x4d\x2b\x2b\xff\x1b\xb5\x18\x1d\xda\x8a\xe2\xbd\x1b\xdd\xec\x8d\x99\x0b\xbf\x6a\x66\x81\x68\x79\xb3\x3d\x10\x77\x49\x42\x12\xa9\xa3\x07\x69\x15\xe8\x04\xb7\x53\xb9\xee\x6d\xfe\xf2\x31\x4f\xa6\xde\xe6\xa3\xb5\x43\x69\x29\x6a\x8e\xc2\x36\xbb\x6d\x45\x11\x38\x6b\x98\x86\xfe\xc6\x09\xa0\x70\xba\x42\x07\x07\xe2\x9d\x1a\x26\x16\xaa\x72\xe1\x63\x0e\x9f\x3a\x6d\x5d\xfc\x66\x8c\x21\x14\x79\x72\xbf\x03\xb1\xbe\x1f\x64\x62\x51\xd4\xa5\xda\x44\x8e\x63\x86\xc9\x29\x88\x90\xab\x30\xa9\xd6\xeb\xae\x77\xba\xc6\x96\x9c\xc1\x29\x97\x9f\x31\xc6\x7e\xa8\x57\xba\x9e\x09\x63\xec\xcb\xff\x2e\xb7\xd7\x21\x1c\x29\xd2\xe0\x69\x5a\x7d\x4b\x79\xcb\xad\xb7\x4a\xbc\x3a\xa5\x89\xb8\x04\xfa\x2f\x55\x74\x64\xb4\xcb\x0e\x44\x32\xcd\xe7\x8d\xe3\xa4\xf1\x8d\x70\x5f\x57\xbc\x2a\xf7\x83\xb4\xe8\xc5\xd6\x5a\x70\x51\xf5\x5b\xa9\xdc\x6d\x63\x8d\x57\xb5\x2e\xc6\xf5\xf2\x5b\x85\xa7\x1d\xb2\x40\x7a\x46\xb1\x90\x37\x9a\xef\x95\x06\x4c\xf1\x17\x90\xdc\xd6\x32\xed\xcb\xe7\x16\xe1\x4f\xed\x1c\xfc\x42\xde\x63\x2d\xc4\x30\x35\xdf\x21\x83\x21\x0c\xe0\x49\x20\x08\x31\xc2\x89\x06\x86\xb9\xfd\xa0\xfd\xe9\xd8\x22\xe3\x03\x3c\x0a\x0c\x01\x03\x48\x8b\x90\xe6\x34\xec\x9f\x1e\x41\x08\xf4\xaa\x55\xab\x0e\x25\x1c\x3d\xea\x18\xb4\x4f\xb3\xcd\x61\x1d\xd7\xeb\x2b\x36\x7e\x91\x34\xa9\x8b\xbc\xbf\xc9\xda\x86\xd1\x04\x76\x4c\xf0\xc8\x56\x04\x7d\x0a\xea\x4f\xb1\x86\x83\x6d\x06\x1e\x41\x0e\xd6\x61\x78\x9f\x22\xd9\x30\xd6\x62\xc0\x7a\xe8\xb0\x23\x3e\xdd\xa1\x3f\x1e\x62\x86\xc0\x18\x44\xb3\xa4\xaa\xae\x3d\x79\x9f\x6c\x47\xeb\x1c\x78\x8c\xff\x5d\x02\xc6\x75\x9d\x29\x7e\xd4\x5b\x88\xba\x18\x6f\x6b\x8e\x7e\x41\xa8\x87\xba\xd5\xdc\x58\xdf\xac\xda\x51\x13\x33\xd6\xe2\x36\x99\xbc\xc6\x8b\x69\x31\xbd\xa6\xd9\xfe\x6f\x00\x00\x00\xff\xff\x6b\xcf\x18\xd0\x97\x08\x00\x00")
+var _templatesAccountsHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\xff\xa4\x56\x4d\x6f\xdb\x46\x13\xbe\xe7\x57\x0c\x08\xbc\xb7\x50\x4c\x5e\x14\x3d\x14\x94\x50\x23\x75\x0a\xa3\x45\x60\x24\x2e\x82\xf6\x12\x2c\xb9\x43\x71\xeb\xe5\x0e\x31\x3b\x94\x22\x28\xfa\xef\xc5\x2e\x49\x79\xf5\x61\x23\x45\x7d\xb0\xf6\xe3\x99\xef\x67\x66\xb9\xdf\x6b\x6c\x8c\x43\xc8\x6a\x72\x82\x4e\xb2\xc3\xe1\x55\xa9\xcd\x06\x6a\xab\xbc\x5f\xc6\x63\x65\x1c\x72\xb6\x7a\x05\x50\xfa\x5e\xb9\xf9\xca\x63\x2d\x86\x5c\x2e\x46\x2c\x66\xab\xfd\x1e\x16\xf7\x6a\x8d\x1f\x54\x87\x70\x38\x94\x45\xc0\x46\xa1\x96\xe3\x8f\xa8\xca\x22\x18\xbd\xcc\x7a\xa6\xbf\x83\xb0\x6b\x28\x9b\xb5\x8d\xb7\xf1\x7f\xee\x85\x4d\x8f\x7a\xda\xb5\xb4\x41\x86\x7a\xf0\x42\x5d\x1e\x8f\x32\xf0\xb2\xb3\xb8\xcc\xb6\x46\x4b\xfb\xd3\xdb\x37\x6f\xfe\x17\xdd\x0b\x46\x5a\x54\xfa\xa8\x13\xbf\x4a\xbe\x6d\x8d\x20\x54\xeb\x7c\xd4\x30\x01\x03\x94\xe7\x65\x94\x5b\xfd\xe1\x91\xcb\x42\xda\xd3\xd3\xdb\xaf\xbd\x61\xf4\x97\x17\xbf\x2b\x2f\xf0\x11\x1b\x46\xdf\xa2\xbe\xbc\x4f\x4f\xca\x62\x36\x16\x4e\x51\xe9\x69\x23\x15\xe9\xdd\x0c\xda\xef\x59\xb9\x35\x8e\x59\xfc\x45\x89\x5a\x3c\xa0\xb7\xea\xe6\xfe\xee\x81\x1e\xd1\xf9\xc3\xe1\xba\xe7\x3a\xa6\x3e\x78\xef\xe6\xd4\x8b\xbe\x04\x44\x25\x8b\x29\x1c\xf8\x06\xbf\xa2\x3c\x98\x67\xf0\xc7\x4d\xf0\x0a\x4c\x03\x8b\x63\xa0\x8b\x3b\xff\x17\x32\xc1\xe1\xf0\x01\x37\xc8\xfb\x3d\xa0\xf5\x41\xcb\x7e\x9f\xa0\xe0\x1b\xbc\x27\xee\xd4\x6c\x22\xc0\x9c\x86\xc3\xe1\x59\xcd\xb7\xcc\xc4\xc1\x99\x8a\x57\x27\x34\x8b\x45\xd4\x21\x33\x3c\x92\xec\x5c\x60\x24\xda\xa5\x85\x17\xc3\x2a\x1b\xe2\x0e\x54\x64\xf0\x32\x2b\x54\x6f\x8a\xcd\xdb\x42\x42\xc2\x7b\x53\x34\xc4\x6b\x94\x0c\x3a\x94\x96\x02\x61\xc9\x4b\x96\x8a\x03\x94\xc6\xf5\x83\x80\xec\x7a\x5c\x66\xad\xd1\x1a\x5d\x06\xa1\x00\xcb\x6c\x98\x4a\x91\xc1\x46\xd9\x01\x97\xd9\x59\x7d\xce\x35\x55\x83\x08\x1d\xe3\xad\xc4\x41\x25\x6e\xa2\x6b\xce\x4a\x9b\xc1\xa7\x27\xff\xcf\x26\xab\x7e\xa8\x3a\x23\xd9\xea\x7d\xf4\xb6\x2c\x46\x3d\x27\x51\x86\x48\xba\xd5\xd5\x94\x3c\x71\x32\x94\x22\x14\xf1\x59\x82\x41\x4d\x36\x24\x79\x99\xfd\x90\xad\x3e\x10\xdc\xd4\x35\x0d\x4e\x3c\xdc\x68\x8d\xfa\x05\xa5\x4e\x4f\x3a\xcb\xe2\x48\xf5\xb2\x88\x3d\x1c\x97\x55\x04\xa7\xf3\x86\x69\x3b\xb7\xf2\xc9\x14\xb2\xb9\xef\xf2\x1f\x9f\x9a\x37\xbd\x54\xac\xb3\xc4\xdf\xb3\xab\x3c\x18\x7e\xea\xff\xdc\x9a\x75\x7b\x5a\xcc\x13\xba\x69\xe5\xdb\x8a\x82\xdc\xd9\x7c\xbb\xd1\x7a\x0e\xfc\x69\xb6\xcd\x7f\xd3\x8c\x3b\x65\x57\x18\x75\x91\x51\x6a\x90\x36\x7b\x96\x6c\xe3\xed\x4b\x54\x4b\x22\x0a\x8a\xf3\x35\xd3\xd0\x9f\x81\x00\x4a\xab\x2a\xb4\xd0\x10\x27\x14\x5c\x1d\x79\x57\xc0\x6d\xa7\x8c\x0d\x35\x63\xf4\xbe\x2c\x22\xfc\x42\x49\x4a\xeb\xd0\x7a\xd9\x89\xe5\xf0\x1c\x30\xd9\x4b\xa6\x1b\x9d\xee\x7a\xab\x6a\x6c\xc9\x6a\x9c\x7c\xf9\x19\x83\xed\x45\x9d\xcc\xdf\x99\x30\xda\x6c\xfe\x73\xb8\xbd\xf2\x7e\x4b\x81\x06\xf7\xd3\xea\x7b\xc2\x3b\x4a\xbd\x14\xe2\x13\xc8\xe8\x64\xf7\xaf\xa2\xe8\x48\x2b\x9b\x37\x44\x32\xbd\xa3\xd7\xda\xff\xa4\xa7\xaf\x0f\x83\x6c\x75\x33\x48\x8b\x4e\x4c\xad\x04\xaf\x35\xfc\x85\x2b\x17\xdb\x74\x20\x24\x97\xe9\xf2\x7b\x1b\x4f\x59\x64\x81\xf8\x3f\x34\x0b\x39\xad\xf8\xb2\xd3\x80\x29\x3c\xd5\x11\x96\xb6\x69\xbf\x7a\x68\x11\x3e\x2b\x6b\xe1\x1d\x39\x87\xb5\x10\xc3\x94\x7c\x8b\x0c\x9a\xd0\x83\x23\x01\x2f\xc4\x08\x3b\x1a\x18\xe6\xf4\x83\x72\xbb\x6d\x8b\x8c\x0b\xb8\x13\x18\x3c\x7a\x90\x16\x21\xbe\x97\x70\x73\x7f\x07\x42\xa0\x92\x54\x25\x19\x8a\x7a\xd4\xd8\xc7\xa0\x5c\x9c\x6d\x16\xeb\xb0\x4e\x45\x4c\xa8\x48\x7c\x31\xcb\xa2\x3f\xf3\xda\xf8\xf1\x0a\xcc\xe8\xe0\x96\x8d\x08\x06\x7c\xf4\xa2\x26\xd7\x98\xf5\xc0\xa3\x92\xc6\x58\x7c\x0d\x46\x02\xf8\x11\x7b\x01\xe3\x40\x81\xc7\x5e\xb1\x12\x04\x8f\x35\xa3\xf8\x08\x03\x69\x55\xc0\x25\xde\xa2\xab\x79\xd7\x0b\x6a\xd8\x1a\x69\x41\xc1\x23\xee\xa0\x61\xea\xc6\x78\x3f\xbf\xfb\xf2\xe9\xf6\xdd\xc7\xdb\x87\x4f\x5f\x7e\xbb\xfd\x13\xd0\x6d\x0c\x93\xeb\xd0\x09\x6c\x14\x9b\xf8\x49\x45\x1c\xb1\x8f\xb8\x0b\x36\x2e\xc3\x89\xdf\x16\xa0\x18\x81\x8f\xaf\xb7\x1a\x84\x3a\x15\x12\x61\xed\x0e\x14\x6c\x11\x1f\xa1\xc2\x26\x14\x42\x5a\xdc\x01\xc6\xcf\x88\xd7\x60\x1a\x50\xb3\x1c\x34\xca\xd8\xb1\x10\x18\xdf\x66\xe3\xc1\xb7\xb4\x75\x49\x3c\xaa\xa2\x0d\xc6\xb4\xef\x68\x80\xad\xb1\x16\x1c\xa2\x06\x21\x60\x4c\x2b\x16\xd5\x84\xc9\x91\x3a\x7c\x8d\xaf\xd3\x62\xfa\x99\xde\x9c\x7f\x06\x00\x92\xbc\xd3\x8e\xd7\x0a\x00\x00")
 
 func templatesAccountsHtmlBytes() ([]byte, error) {
 	return bindataRead(
@@ -124,6 +125,26 @@ func templatesBaseHtml() (*asset, error) {
 	return a, nil
 }
 
+var _templatesHistoryHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\xff\xb4\x57\x5d\x6f\xdb\xb8\x12\x7d\xef\xaf\x18\x10\xe8\x5b\x15\xb5\xf7\xe1\x5e\xe0\x42\xd6\x22\x48\x53\x6c\x77\x01\x37\x68\x82\xe4\x99\x16\x47\x12\x1b\x8a\x23\x90\x63\xb9\x81\xaa\xff\xbe\x20\x25\x39\xb2\xf3\xd5\x76\xdb\x3c\x58\xfc\x98\x39\x3c\x43\x1e\xce\x30\x7d\xaf\xb0\xd4\x16\x41\x14\x64\x19\x2d\x8b\x61\x78\x95\x29\xdd\x41\x61\xa4\xf7\xab\x38\x2c\xb5\x45\x27\xf2\x57\x00\x99\x6f\xa5\x9d\xa7\x3c\x16\xac\xc9\x26\xac\xd9\xa0\xc8\xfb\x1e\x4e\x2e\x64\x85\x6b\xd9\x20\x0c\x43\x96\x06\xdb\xe8\x54\xbb\xf8\x61\xb9\x31\x08\x5a\x05\x4f\xef\x35\x59\x2f\x66\xa8\x71\x2a\xfe\x26\x9e\x9d\x6e\x51\x4d\xbd\x9a\x3a\x74\x50\x6c\x3d\x53\x93\xc4\x21\x01\x9e\xef\x0c\xae\xc4\x4e\x2b\xae\xff\xff\xee\xed\xdb\xd7\x91\x5b\x58\xa1\x46\xa9\xf6\x98\xf8\x95\x93\x5d\xad\x19\x61\x53\x25\x23\xc2\x64\x18\x4c\xdd\xdc\x8c\x7e\xf9\x25\x4b\xc7\xa8\xb2\x94\xeb\x83\x89\x19\x4d\x25\x96\x2c\x82\x4a\x1a\x35\xd2\x48\x0a\x34\x46\xe4\xe7\x56\x3d\xe2\x95\xdf\x48\x63\xe0\x8c\xac\xc5\x82\xc9\xfd\x30\xea\x35\xd6\xba\x30\xf8\x10\xf7\xdc\xa2\xab\xee\x5e\xc6\x33\xd5\x01\xde\x05\xca\x5b\x48\xe1\xb4\x43\x27\x2b\xfc\x61\xf7\x4b\x32\xf2\x20\x88\x2c\x9d\xf7\x2f\x8c\xa2\x54\x53\x87\x37\xa4\xee\x66\xa3\xbe\x77\xd2\x56\x08\x27\x97\xd3\x71\x0f\xc3\xe3\xbb\xaf\xa2\x76\xe2\x09\xc0\x37\xf8\x40\xae\x91\x7c\xa5\x27\x15\xb1\x3a\x30\x7d\x69\xe7\xfa\x1e\x74\x09\x27\x9f\x5a\xb4\x30\x0c\x67\xb5\x74\x95\xb6\x55\xdf\x03\x1a\x1f\x00\xc3\x4a\xe7\x56\x1d\xaf\x13\x0c\xac\x7a\x6c\xc1\x3c\x93\x50\x3b\x2c\x57\x22\xd5\xb6\xa4\x34\x00\x5c\xdd\x9c\x7d\x7c\x0f\xc3\xb0\xd7\xef\x86\x2d\x6c\xd8\x26\xbe\x89\x9f\x49\xae\xff\x11\xf9\xd2\x3a\x4b\x65\xfe\xb3\xf1\x4c\x82\x98\xee\x56\xdf\x3f\x32\xb2\x08\xf0\xfa\xe3\xfa\xf9\x98\x82\xd1\xdf\x37\x7f\xc2\x30\xc0\xed\x4d\xfd\x32\xa9\x23\x3d\xc4\xab\x8e\xf2\xf6\xb4\x69\x3d\x7c\x83\xcf\xb4\xb5\xea\x83\x21\xc9\x30\x0c\xa7\x90\x42\x98\x9f\xb4\xf6\xa8\xc9\x4f\x2d\x18\x45\x78\x59\x4b\x87\xf0\x0d\x2e\xd0\x15\x68\x03\xd8\xeb\x25\xd8\xbd\x2e\x83\xfc\xc2\x8e\x3c\xa9\x39\x28\xc8\x84\x0c\xb5\x12\xff\x13\xf9\x9a\x60\x96\x0a\xcc\x6a\x85\xcf\x58\x90\x1b\x6f\xf7\x53\x0b\x58\x35\xe1\x67\xe9\x5e\xfa\x59\x1a\x99\xc7\xa6\x95\xdd\x74\x33\xb6\x66\x8e\xb1\x95\x95\xb6\x32\x64\x4e\xf8\xb2\xf5\xac\xcb\xbb\x64\xca\xbd\x49\x08\x69\xca\xb4\xd1\xc9\xe8\x85\x13\x26\x9a\xb1\x81\x51\x10\x96\x18\x4e\x2e\x1c\x76\x21\xe3\xc2\x30\x28\xed\xc3\xa2\x6a\x7f\xe6\x62\x11\xac\x3c\x40\x31\xda\xde\x8a\x59\xd2\xb5\xf6\x4c\xee\xee\x8f\x30\xb3\xea\xfb\x03\x4c\x91\xaf\x71\x87\x2e\xc8\x76\x1f\xbd\xd1\xcf\x92\x93\x05\xeb\x0e\x45\x7e\x50\x27\xf6\xab\x2e\xf3\xf0\x5c\x2d\xee\x2b\xc5\x8b\xe0\x8b\xc8\xd7\xf8\x95\x7f\x75\xe4\x0b\x4c\x91\x7f\x32\xea\x89\xc8\xb3\x74\x6b\xc6\x63\x9e\x0e\x37\xdb\x8c\xe5\x6d\x51\x34\x1d\xed\xe6\x92\x74\x50\x4a\x4d\xe2\x9b\xe4\xbf\xf7\xe7\xbb\x9c\x94\x4e\x2d\x99\x1f\x4d\x25\x41\x5d\xf7\xfb\x97\x18\x5d\xd5\xbc\xb0\x3f\x2a\xcd\x4a\xfa\x7a\x43\xc1\xef\xa8\x48\x9f\x7f\x6d\xc9\xf1\x5e\xe3\xf7\x45\x7a\xfe\x9b\x8a\xf5\xbe\x5b\x92\x6b\x62\xcd\xc6\xe8\x29\xe2\x11\x93\x5d\x89\x54\xb6\x3a\xed\xde\xa5\x73\x2d\x4f\x67\x83\x06\xb9\x26\xb5\x12\x15\x1e\x12\x3c\x0c\x2a\xe0\x26\x95\xa3\x6d\x7b\x64\x04\x90\x19\xb9\x41\x03\x25\xb9\x95\x28\x5d\x10\xcb\x07\x47\x4d\x96\xc6\xe1\x07\xc6\xda\xb6\x5b\x06\xbe\x6b\x31\x84\xcd\x28\x0e\x56\x08\x37\xcb\x91\x11\x60\x65\x83\x13\x5c\x0c\x67\x04\x3e\xa4\x97\x2a\xdd\xfd\x6b\xc6\x4c\x22\xbf\xa2\x5f\xc2\x96\x69\xe4\x1a\x20\x7f\x03\xd3\x4e\x5b\x91\x5f\x7f\x5c\x7f\x0f\xd7\xf0\x98\x7a\x96\x6b\x00\x8b\x64\x63\xa3\x35\xb2\xc0\x9a\xc2\x25\x5a\x89\x53\x63\xa0\x1b\xab\x95\xff\x2d\x81\x94\xb1\x92\x8b\x7c\xac\xe8\x4f\x85\xe3\xd1\x60\xc1\xcf\xaa\x63\xc4\x19\xf5\x31\x61\x1e\xa1\x00\x64\xd4\xc6\xdc\xdd\x49\xb3\xc5\x95\x28\x7c\x27\xf2\xb3\xcb\xeb\x2c\x1d\xc7\x5f\x74\xf8\xe2\xc9\x8a\xfc\xaf\xcb\x4f\xeb\xa7\x5c\xb2\x74\xa4\xfa\x43\x5b\xd5\x90\x92\x26\x29\x89\x96\x05\x64\x6f\xb8\xd9\x32\x93\x9d\xce\xd2\x6f\x37\x8d\xe6\x07\x6f\x97\x39\x33\xbf\xa7\x9d\x35\x24\x55\x96\x8e\x5e\x2f\xd0\xc8\xd2\xb0\x55\xf9\xab\x47\x0d\x16\x9d\x65\xf3\x7b\x93\xa2\x34\xe8\x18\xe2\x6f\x48\x64\x64\x95\x74\x0f\xb3\x20\x38\x0a\xff\x0e\x44\xb3\x65\x0a\x6d\xf7\xa1\x00\xd7\x08\x73\xa2\x02\xae\x25\x83\x1f\xdf\xfb\xb0\x41\xde\x21\x5a\xe0\x1d\x41\xb8\x90\xfe\x0d\xec\x34\xd7\xd1\x03\xe3\x63\x1b\xa4\x55\x50\x90\x67\xa0\x12\x50\x16\xf5\x8c\x14\x27\x82\x1d\x13\x4b\xe3\x83\x16\xc7\xf9\x49\xec\x27\x53\x65\x98\xff\xae\x6a\x1c\x71\xb6\x1e\x7d\x74\x6c\x9d\x2e\x10\x5a\x74\xe1\x15\x16\xe0\x23\x9a\x74\xba\x2c\x61\xa7\xad\xa2\xdd\x92\xc7\x4e\x7a\x50\x68\x74\x87\x0e\x15\x68\xfb\x06\x42\x12\x5b\xfa\x68\x1b\x7b\x05\xd9\x52\x57\x50\xea\xc0\x21\x6d\x9f\x3b\x8c\xa9\x31\x7d\xe6\x17\xcd\x3f\x03\x00\x30\x8f\x94\x51\x16\x0e\x00\x00")
+
+func templatesHistoryHtmlBytes() ([]byte, error) {
+	return bindataRead(
+		_templatesHistoryHtml,
+		"templates/history.html",
+	)
+}
+
+func templatesHistoryHtml() (*asset, error) {
+	bytes, err := templatesHistoryHtmlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "templates/history.html", size: 0, mode: os.FileMode(0), modTime: time.Unix(0, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
 var _templatesHomeHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x84\x54\x4f\x8f\xab\x36\x10\xbf\xe7\x53\x58\x7e\x3d\xb4\x07\x0f\xc6\x06\x1b\x9e\x20\x52\x5f\x5b\x69\x2f\xfb\xd4\x43\x95\xbb\x13\x4c\xa0\x6b\x20\x02\x87\xec\x26\xe2\xbb\x57\x36\x90\x97\x46\x5b\xd5\x52\x26\xd8\xf3\xfb\xcd\x3f\x8f\xe7\x76\x2b\x74\x59\xb7\x1a\xe1\x56\x8d\x7b\xd5\xe3\x69\xda\x64\xad\x1a\xd1\xc1\xa8\x61\xc8\x71\x59\xbf\xeb\x82\xd8\xee\x84\x66\xfd\xf2\x47\x0e\xe7\xc1\x76\xcd\xba\xd3\xef\x27\xd5\x16\x64\xb8\x1f\x14\xaa\x7f\x43\xfb\xe3\x02\xc3\xdb\x0d\x42\x99\x5a\x6d\x2e\x98\x7d\xef\x38\x0b\x02\x55\xbd\x2e\x73\x1c\xe0\x6d\x36\x8c\x47\xf4\xde\x98\x76\xc8\x71\x65\xed\xe9\x6b\x10\x5c\x2e\x17\xb8\x70\xe8\xfa\x63\xc0\x28\xa5\xc1\x30\x1e\x31\xaa\x8b\x1c\xdb\x62\x20\x97\xae\x2f\x1a\xd5\xbf\xe1\x0d\xf2\x6b\xac\xf5\xe5\x5b\xf7\x9e\x63\x8a\x28\xe2\x11\x43\x3c\xf6\xfe\xdd\xca\x4e\xca\x56\x6b\x18\x8e\x5c\x1f\xba\x96\x94\xb5\x31\x84\x9c\xfa\xba\x51\xfd\xc7\x6a\x06\xa1\x22\xc7\xaf\x14\x41\xa8\x52\x90\xc8\xfd\x28\xa2\x54\x22\x01\x29\xaf\x42\x0a\x29\x37\x10\x0b\x60\x6c\x64\x12\xe2\xa4\x12\x90\xb0\x9d\x04\x96\x1a\x10\x8c\x00\x63\x1e\x14\xa9\x14\x12\x86\xbc\xf0\x7c\xe2\xf8\x3b\xa0\xf2\x85\xee\x20\xbc\xbe\x32\x9e\x80\x08\x81\x8a\x8a\x08\x48\x46\x1e\x41\x12\x57\x3c\x84\xd0\x31\x45\x82\xbc\x70\xcc\x18\xd2\xc8\x41\x44\x45\x38\x05\x6e\x80\x32\xc2\x24\xa4\xe9\xb5\x21\x31\x03\xce\x91\x53\x1e\xb8\xf3\x1e\x22\x01\x42\x10\xee\xbc\x4a\xe0\xa9\xe3\x25\x86\xf0\x04\x9c\xa7\x91\x51\x88\x67\x27\x72\x94\xc0\x78\x45\x58\x04\x91\x50\x21\x07\x81\xbc\x70\x0e\x49\x02\x32\x44\xb2\xe2\x29\x24\xe9\x2e\xe4\x90\x84\x2f\xa1\x60\xc0\x77\x02\x52\x56\x31\x0e\x69\x72\x6d\xc2\x50\x40\x28\x91\x8b\x44\x56\x02\xa4\xd8\x31\x0a\x89\xac\x58\x04\x71\x32\x86\x11\x50\xe6\x8e\x63\x6f\x40\xfa\x18\x68\x4a\x80\x46\xd7\xd7\x24\x06\x1e\x23\x59\x31\xa1\x52\x88\x5d\x8d\xe3\xa5\xc8\x40\x63\x22\x5f\x64\x02\xdc\x15\x21\x44\x5e\xac\x1a\x24\xaf\x0d\x75\x61\xca\x95\x9a\x22\x2f\x3e\xa1\x72\xe4\xc5\x13\x35\x82\x30\xfa\x5f\xaa\xbb\x34\xc1\x1e\xa9\xaf\x9c\x26\x10\x09\x24\x5d\x4e\x33\xdd\xdd\x4f\x9c\xac\x20\x41\x64\x45\x22\x0a\x61\xf8\x89\x69\x24\xaf\x3f\x7a\xcb\xb5\x5c\x8e\xbf\x94\x65\x89\xb7\x59\xe0\xba\x72\x6e\xd1\xcc\xf5\xf6\x36\x0b\x94\x7f\x31\xfb\xb3\xb5\x5d\xfb\xf4\x6c\x6c\x77\x3c\x1a\xdd\x63\x64\x3f\x4e\x3a\xc7\x33\x06\xa3\x42\x59\xb5\xe8\x72\x7c\xe8\x8c\x51\xa7\x41\xaf\xc7\xaa\x3f\x6a\x9b\xe3\x2f\xb3\x89\xe1\x8f\x77\xd5\x9c\x8c\xfe\x5d\x97\xea\x6c\xec\x1c\x95\xea\x6b\x45\x0e\x5d\x6b\xfb\xce\xdc\x9d\x3d\x23\x67\xd4\xfc\xda\x75\x91\xe3\x52\x19\xe7\xc4\x9f\x1a\xb5\xd7\x26\xc7\x7f\xf9\x10\xdc\x1c\xa8\x8f\xca\xd6\x5d\xbb\x3c\xbe\x6c\x38\xa9\xff\x48\xc5\xbf\x42\x57\x07\x07\xf1\x89\x07\x73\x56\xdb\x8d\xdb\x14\xf5\x7d\x1a\xad\x79\xdd\xa7\xd0\xba\xff\xfb\x3c\xd8\xba\xfc\xf0\x09\xe8\xd6\x92\xa6\x20\x07\xdd\x5a\x57\x26\x37\x27\x3e\xcf\x66\x09\xec\x6c\x9e\xc2\x72\xe3\xef\xc7\xe7\xe3\x0c\x73\xeb\x76\x43\xbd\x6a\x8f\x1a\xc1\xb7\x5e\xab\xe2\xb7\xfe\xdc\xec\x87\x69\xba\x6b\x7f\x52\x07\x5b\x8f\x1a\x7d\xcd\x11\xfc\xea\x3f\x1f\x95\xad\x6a\x66\xd5\x77\x35\x7e\x57\xcd\xbf\x74\x07\x6f\xca\x69\x31\xbe\x9f\x67\xa6\x7e\x08\x8f\xd4\x56\x37\x2e\x84\x15\x3c\x4d\x7e\xb7\xf8\x9c\x26\xec\x6b\xb6\x50\x1f\x27\x2e\x31\x75\xfb\x86\xd6\x8f\xa7\xb1\x7b\xbb\xc1\x9f\xca\x56\x8e\xbe\xc4\x38\x4d\xf3\x8d\xdd\x8d\xb9\xb5\x58\x1b\x7a\xd2\xb5\xe6\xc3\x61\x51\x5d\x3e\x38\xff\xf9\x70\xee\x7b\xdd\xda\x5f\x6e\x37\xdd\x16\xd3\xb4\xdc\xa8\x6f\xe7\x35\x9d\xc0\xd4\x0f\x95\xd4\x6d\x81\x96\x54\xb3\xe0\x6c\xe6\xcb\x2f\xea\x71\xbb\xc9\x82\x56\x8d\xdb\xcd\x62\xe9\x9f\x00\x00\x00\xff\xff\x82\xd0\x2a\x78\xa7\x06\x00\x00")
 
 func templatesHomeHtmlBytes() ([]byte, error) {
@@ -144,7 +165,7 @@ func templatesHomeHtml() (*asset, error) {
 	return a, nil
 }
 
-var _templatesPowerwallHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xcc\x58\x5f\x6f\x1b\x37\x0c\x7f\xef\xa7\x20\x0e\x28\xd6\x01\x71\x9c\x0c\xeb\x1e\x0a\x3b\x40\xb6\xb5\x40\x80\xae\x2b\x96\x0d\xc3\x1e\x79\x27\xfa\x4e\x9b\x4e\x3c\x48\x3c\x3b\x86\xe7\xef\x3e\x48\xf7\xdf\x7f\x9a\x14\x4b\xb1\xbd\xc4\x3a\x92\x22\x7f\xfc\x91\x14\xa4\xec\x76\x8a\x56\xda\x12\x24\x19\x5b\x21\x2b\xc9\x7e\xff\x62\xa1\xf4\x1a\x32\x83\xde\x2f\xa3\x18\xb5\x25\x97\xdc\xbc\x00\x58\xf8\x0a\x6d\xa7\xf2\x94\x89\x66\x3b\x13\x2d\x86\x92\x9b\xdd\x0e\x2e\x3f\x62\x4e\x1f\xb0\x24\xd8\xef\x17\xf3\x60\x1b\x37\x15\x2e\xfc\xec\x76\xa0\x57\x8d\xc9\x8f\x28\x78\xf9\xd6\x62\x6a\xe8\x23\x6f\xc8\x6d\xd0\x18\xd8\xef\x83\xad\x04\x21\x68\xb5\x4c\x2a\xc7\x7f\x86\x08\x76\xc5\x49\x17\xb2\xd1\xc6\xbf\x33\x2f\x4e\x57\xa4\xda\xaf\x82\xd7\xe4\x20\xab\xbd\x70\x39\x8b\xa2\x04\xbc\x6c\x0d\x2d\x93\x8d\x56\x52\xbc\xb9\xbe\xba\x7a\x19\x73\x08\x41\x0a\x42\xd5\xfb\xa4\x07\x99\x6d\x0a\x2d\x04\x69\x3e\x6b\x3c\xb4\x86\xc1\xd4\x75\xcb\xb8\xef\xe6\x5e\x0b\x2d\xe6\x52\x4c\xa5\xef\x19\xd5\xb1\xf4\x9e\x0d\xba\x63\xf1\xf7\x28\x42\x6e\x7b\x56\x01\x2f\x8f\x55\x6f\x1f\x2a\x76\xa2\x6d\x0e\x47\x4e\x17\xf3\x0e\x63\x90\x12\xaa\x2e\xc9\x94\xd5\xf6\x4c\x1e\xaa\xa9\x56\xc7\xfd\x9d\x5d\xf1\x65\xc8\xec\xf2\xce\x7a\x41\x2b\x51\x03\x7f\xc3\x2f\x5c\x5b\xf5\xce\x30\x0a\xec\xf7\xf0\x3b\x8a\xf8\xc5\x5c\xd4\x63\xae\x02\x1d\xcf\xe4\x2a\xa6\xfb\x4c\xbe\x5a\x7e\xff\xbd\xb7\xd6\x51\x74\xfa\x91\x5c\x46\x56\x30\xa7\x23\x57\x2f\x4f\xba\x89\x43\xf0\x28\xf3\x77\x3e\xba\xf9\x40\x39\x8a\x5e\x87\x81\xfa\x83\xfc\x6e\x07\x64\x7c\xf8\xf8\xc0\x61\x6d\x55\x1c\xb4\x21\xc8\xa4\x17\xba\xfa\x2f\xe6\x71\x1e\xe2\x32\xed\x46\xb1\xd9\x1b\x44\xa3\x61\x77\xbc\xe9\x46\x64\x72\x04\x98\x99\x2f\x67\xdf\x0d\x43\x31\x56\xa2\x53\xc9\x28\xc7\x15\xbb\x32\x8e\x6f\x38\x38\x1c\x1b\x43\xce\x93\x84\xce\xf5\x09\x60\x3c\x30\x96\xc9\x1c\x2b\x3d\x5f\x5f\xcf\xab\x8e\x86\xc1\xa4\x24\x29\x38\x4c\x3f\x7b\x19\xf9\x3d\x0e\x3a\x0b\xe9\x0d\x13\x3b\x33\x3a\x2f\xa6\x3b\xa6\x7b\x02\xb0\x59\xee\xb8\xae\x0e\x8c\x00\x16\x06\x53\x32\xb0\x62\xb7\x4c\x68\x7a\x2a\x25\x37\xcd\x31\x05\xc3\x39\xf5\x13\x5b\x2d\xec\xb4\xcd\x17\xf3\xb8\xf1\xc8\xdd\x18\x69\x03\xae\x25\xa3\x3b\x9f\xb2\x82\xb2\xbf\x52\x7e\x38\x42\x02\xb0\xd0\xb6\xaa\x05\x64\x5b\xd1\x32\xe9\xed\x4e\xbb\x9b\x45\xdb\x24\xd2\x7d\x88\x1b\x2c\x96\x74\x2c\x3e\x8a\xf7\x94\x73\x39\xc2\x20\xd5\x77\xcd\x09\xd4\x0d\x83\xa7\x51\x46\x5d\xf2\x49\x7a\xd5\x39\x2a\xe7\x4a\xaf\x0f\x6a\x7a\x42\xf4\xf9\x65\xc6\x5a\xf8\x5e\xd0\xc9\xbd\x70\x75\x67\x85\xdc\x1a\x4d\x72\x73\x5b\x0b\x43\x94\xcf\x83\x02\xd8\x42\xa7\x84\x1f\x02\x0b\xff\xa7\x92\x9f\xce\xe1\x44\x85\x9b\x56\x38\x6d\x7e\x54\xfe\xdb\x53\x66\xcf\xdb\x04\x67\xc8\xff\x8f\x5a\xa1\x1a\x9a\x71\x68\xfa\x5b\xa5\x1c\x79\x0f\xaf\xde\x73\x86\xe6\xeb\x73\x98\xc6\x95\x0b\xf7\x88\x64\x12\xbb\xcd\xbd\x9b\xc5\x21\x50\x73\xbf\x39\x3f\x94\x95\xc1\x8c\x0a\x36\x8a\xdc\x32\x29\x44\xaa\x37\xf3\xe1\xac\xbc\x34\x01\x52\x02\x6b\x34\x35\x2d\x93\xee\xda\x15\x8b\x37\x9e\xda\xc3\xb3\xf0\x79\xc9\x8a\xd3\x30\xd4\x2e\x7e\x0e\xb3\xf2\xaa\xd4\xb6\x16\xf2\x4f\x22\xce\xd6\x65\x4a\xee\x69\xd4\x4d\xc3\x4e\x79\x9c\xea\x3e\x4d\xea\xeb\x47\xf8\x9b\xf8\xfa\xa2\x64\xfe\xbc\x5a\x79\x92\xb6\xf7\xa0\xf9\x82\x57\xf1\x16\xf2\xac\xec\xb5\x71\x06\xca\x3a\xc1\x84\x97\xab\x63\xde\xce\xf2\xd4\x62\xfd\xf2\xe4\xdc\x96\x95\x3f\x24\x28\xc8\xbe\x00\x3f\x31\xd4\x21\x47\x8d\xf0\x89\x3c\x7d\x1e\x19\x25\x2b\x34\xb3\x15\xb3\xb4\x2f\xac\x89\x61\x5a\x8b\xb0\x6d\x13\xf1\x75\x5a\xea\xe1\x84\x49\xc5\x42\x2a\xb6\x7f\xb0\xfc\x56\x29\x0c\x4f\x93\x66\xcf\x23\x20\x0e\x04\x8b\x79\xa0\x65\xb8\x44\xf6\xca\xf1\xf2\xa9\x37\x42\x34\xe4\x04\xe2\xdf\x99\xa7\x8c\xad\x42\x77\x7c\x51\x03\xc7\xe1\x6d\x16\xcd\xc6\xf7\xc7\xea\xe6\x6e\x05\xfd\x48\x43\xd9\x5f\xb6\x40\x7b\x68\x6e\x0f\xea\x02\xa4\x20\x18\x2e\x98\xb0\xd1\xc6\x40\x45\x4e\xb3\xd2\x19\x1a\xb3\x85\x8a\x8d\x89\x56\x83\x2b\x61\xc8\x49\x82\x70\x44\x84\x0f\xcf\x0b\xf0\x82\x52\xfb\xcb\x11\x1f\xd5\x21\xa4\x2d\xd7\x50\xe0\x9a\xa0\x79\x2f\x2b\xc0\xc6\x35\x70\xd3\x90\xda\xc2\x26\xcc\x2c\xb0\x03\x2c\x2b\x7f\x1a\x63\xed\x09\xa4\xd0\xbe\xdb\x85\x86\x6d\x0e\x1b\x2d\x45\x34\x8f\x68\x46\xe8\x52\x0a\x89\xe7\x64\xc9\xa1\x84\xa7\x2e\x43\x86\x26\xab\x0d\x0a\x41\xc1\x1b\x28\xd1\x6e\x63\xb8\xa8\x2a\xd0\xe5\x04\x28\xe7\x13\x19\xf9\xfe\x35\xc0\xc8\xd0\x42\x4a\x01\xd6\xaa\x36\xe1\x1e\xb0\xe5\xda\x75\xa4\x6c\xbd\x50\x09\x8a\xc9\xdb\xaf\xa4\x47\x01\x64\xb9\xce\x8b\x26\xfb\x8b\x90\x6e\xa0\x06\x1d\x41\x81\x55\xb5\x0d\x40\x30\xcb\xa8\x12\x40\xc8\xc8\x09\x6a\x3b\x8a\x8a\x25\xd7\x56\x80\x57\x80\x4a\xe9\xf0\x1a\x40\xd3\x12\xd9\x24\x9b\xb1\xf5\x75\x49\x0a\x56\x8e\xcb\x48\x4a\xee\xb4\x7a\x52\x46\xef\xd8\x01\x3d\x60\x59\x19\xba\x38\xc8\x25\xe4\xd9\x27\x80\x50\xe2\x83\x2e\xeb\x12\xbe\xbd\xba\xba\x6a\x1e\x7c\x17\x90\xd6\x72\x36\x93\xeb\xde\xae\x41\x39\x0a\xaa\x1c\x6e\x6c\x0f\x76\xa4\x08\xb0\x63\x13\xd8\xe8\x36\xe3\xda\x28\x08\x35\x1f\x9c\x3d\xbd\x4e\x9b\xb8\xbb\x24\xb4\x20\x05\x36\x40\x1b\x59\x4a\xd0\xfc\x4f\xa4\xaf\x7f\x80\xdd\x67\xc8\x2b\x78\x3d\xc4\x83\xbb\x09\x2b\x1b\xf4\xc0\xd6\x6c\x3b\x6a\xa6\x99\x5d\x8f\xc8\x89\x69\x1c\x36\x74\x93\x11\x6e\x47\xb1\x63\x27\x7f\xd3\x6f\x3c\x95\xdf\xa9\xd3\xa5\x5d\xb4\x3f\xbb\x1d\x59\xb5\xdf\xff\x13\x00\x00\xff\xff\x0d\x8e\x10\x46\x9b\x12\x00\x00")
+var _templatesPowerwallHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\xff\xe4\x5b\xdb\x6f\xdb\x38\x97\x7f\xef\x5f\x71\x20\x60\xb0\x1d\x20\xbe\x74\x30\xdd\x87\xc2\x31\x90\x76\xd2\x99\x62\x27\x4d\x50\x67\x50\xec\x23\x2d\x1e\x59\x9c\x4a\xa4\x96\xa4\xec\x1a\x59\xff\xef\x1f\x78\x48\x49\x94\x2f\x89\xd3\xb1\x3b\x83\xef\xcb\x43\x22\x1d\x92\xe7\xf2\x3b\x17\x5e\xc4\x3c\x3c\x70\xcc\x84\x44\x48\x52\x25\x2d\x4a\x9b\x6c\x36\x2f\x26\x5c\x2c\x21\x2d\x98\x31\x97\x44\x66\x42\xa2\x4e\xa6\x2f\x00\x26\xa6\x62\xb2\x69\x32\x98\x5a\xa1\xe4\xc0\x0a\x5b\x60\x32\x7d\x78\x80\xe1\x1d\x5b\xe0\x47\x56\x22\x6c\x36\x93\x91\xeb\x4b\x83\x72\xed\xfe\x3c\x3c\x80\xc8\x60\x81\x30\xbc\x67\x5a\x64\xd9\xf0\x86\x7d\xbd\x2a\x2b\x03\x63\xd8\x6c\x5c\xb7\x48\x2a\x2b\x50\x5b\xa0\xdf\x03\x83\xa9\x92\x9c\xe9\x35\xcc\x17\x83\xb4\x36\x56\x95\x83\x42\x2c\x72\x9b\x80\x56\x05\x86\xce\xa4\x5e\x23\xa4\x91\xf0\x59\x48\xae\x56\xb0\xd9\x7c\x90\x60\x73\x74\xad\x3b\x4d\x60\x89\x00\x2b\x22\x3c\x3c\x00\x16\xc6\xe9\x7f\x5b\x5b\x23\x38\xd2\xb8\x5e\x17\xe3\xfa\x48\x0e\x9b\xcd\x45\x23\xb2\x61\x7a\xa7\x45\x8a\x77\xa8\xff\xe7\xf3\x6f\x8e\x73\xd4\xf2\xae\xd6\x1a\x65\xba\x76\xe4\x0a\x35\x7c\xf9\x9c\x5f\x10\xeb\x94\x69\x03\xa9\x03\x35\x67\x7a\x81\xc0\x2c\xd4\x15\x58\x15\x0f\x6e\x80\xda\x6c\xae\x20\xd3\xaa\x74\x03\x49\xf4\x42\x0b\x3e\x74\xd0\x8d\xb8\x58\x06\x8c\xbd\x6a\x2d\xde\xe4\x92\x5f\x98\x65\xc3\x6b\xc9\xe6\x05\xde\xa9\x15\xea\x15\x2b\x8a\x5e\xa7\x6b\x89\x7a\xb1\xbe\xd6\x5a\xe9\xc7\x9d\xc1\x99\x5c\xa0\xde\x42\xfe\x0f\xe2\x0c\x56\x81\x46\xc6\x5b\xa4\x3d\xd3\x99\xaa\x75\x8a\xf7\xeb\xca\x81\x0a\x48\x34\x30\x44\x7c\x13\x75\x6b\x64\xef\x35\x65\x62\x49\x80\xe0\x97\x49\xa5\xd5\x9f\x2e\xee\x64\xa6\x92\x46\x41\xdf\x4a\xbf\x07\xc6\x6a\x51\x21\x0f\x6f\xb9\x5a\xa2\x86\x10\x34\x44\x4a\xc0\xd8\xb5\xd3\x7d\x25\xb8\xcd\xdf\xbc\x1a\x8f\x7f\x08\xa1\x33\xb1\xb9\xd3\xbe\xe1\x89\x5f\xed\x60\x95\x0b\x8b\x5d\xd8\x85\x8e\xae\xab\x6e\x1e\x69\xdc\x74\x26\x2c\x4e\x46\x36\xef\x53\x7f\x57\x8c\xef\x52\x67\xaa\x60\x7a\x97\xfc\x96\x59\x8b\x7a\x7d\xb0\x01\x7e\xd8\x6d\xba\xfe\x5a\x29\x6d\x85\x5c\xc0\x0e\xd3\xc9\xa8\xd1\xd1\x51\x91\xf1\xf0\x62\xe7\x8a\xaf\x0f\xd8\xc1\xa7\x9d\x3f\x86\xbf\x6a\xc1\x3f\x33\x6b\x0d\xfc\x3f\x7c\x52\xb5\xe4\xef\x0b\xc5\xac\xf3\x21\x51\x27\x23\xcb\x0f\x8e\x75\x86\x7f\xeb\x58\xb2\xe4\x5b\x07\x07\xac\x9e\x3b\xbc\x4b\x81\xe1\x6f\xcc\xcc\x6e\xdf\xc1\x66\xb3\xcb\xd5\xd1\xb7\x79\xfe\xd0\x15\x8c\x3f\xe4\x17\xa9\x56\xb2\x8d\xdb\xa7\x24\xc5\x00\x7f\x30\xc4\xf1\x23\x2e\x98\x15\x4b\xc7\xed\x7f\xd1\x74\xac\x3f\xaa\xbd\x5c\x7b\x3e\x6e\xfc\x3a\x19\x51\x9c\xd3\xe3\x3c\x2a\xbc\x1e\xd8\x5f\x30\x15\x46\x28\x69\x42\x62\xc5\xf5\x9c\x33\x93\xcf\x15\xd3\x7c\xb0\x55\xd9\x3f\x61\x8a\xd2\x42\x3b\x36\x2a\xed\x5d\x62\x1a\xc7\x9e\x37\x5d\xfe\x41\xb9\x79\x2f\xca\x3d\xb9\x79\xb5\x64\xa2\x70\x42\xf7\x24\x68\xa9\x94\xcd\x71\x4f\xea\x5e\x11\x2c\x7b\xe8\x65\x65\x76\xa9\x9f\x90\x99\x7e\xef\xa3\x92\xf2\xe1\x01\xb4\xab\xb2\xfb\x3d\x76\x30\x6d\x9d\x99\xc3\xdf\x55\xca\x8a\xe1\x7b\xa5\x4b\x66\x21\x79\xf5\xfa\xcd\xf8\xe7\x37\xe3\xd7\xc9\xa1\x68\x1c\x3e\x3b\xcf\x1a\x6c\x9e\x3d\xd0\x43\x77\x50\x91\x30\xbf\xed\x6f\xf4\x40\x1e\x0c\xfe\xfe\x5c\xf1\x74\x32\xc4\x33\x64\xfb\x1c\x4f\x78\x5a\xad\x9a\xb8\xeb\xad\x84\x8a\x81\x29\x07\xff\xdd\x45\x5a\xdc\xc8\x34\x4f\x22\xcd\x33\xa5\x4b\x4a\x0b\xb7\x7e\xd2\xaa\x28\x50\x1b\xb4\xae\x54\x9b\x04\x18\x61\x71\x99\x8c\x58\x25\x46\xcb\x57\xa3\xaa\x99\x93\xbb\x2e\x25\xda\x5c\xb9\xe9\x4e\x19\x1b\xf1\xdd\x15\x3a\x70\xa6\xee\xac\x8c\xe2\x11\xfd\x31\x4e\xb1\xc1\x42\xab\xba\xda\xea\x04\x30\x29\xd8\x1c\x0b\xc8\x94\xbe\x4c\xb0\xbf\x58\x48\xa6\x7e\xf5\x00\x2d\x05\x6e\x94\x14\x56\x69\x21\x17\x93\x11\x0d\xdc\x61\x17\x6b\xea\x95\x0b\x60\x34\x49\x9f\xe6\x98\x7e\x99\xab\xaf\x3b\x9a\x00\x4c\x84\xac\x6a\x0b\x76\x5d\xe1\x65\xd2\xf6\xdb\xcf\x6e\x40\x7d\x13\x82\x7b\x5b\x6f\x90\xac\xc4\x5d\xf2\x8e\xbc\x63\x96\x4b\xa4\x06\xf2\x36\x6a\xf6\x68\xed\x11\xdc\xaf\x25\xb5\x25\x8f\xc2\xcb\x0f\x41\xd9\x2c\x8a\x1e\x27\x3d\xdf\xcd\xac\xb6\x6a\x66\x99\xb6\x33\xab\xaa\x0f\xd2\xa2\x5e\xb2\x22\x99\x5e\xd5\x56\x01\xd1\x47\xae\x01\x94\x84\xa6\x11\xde\x39\x14\xfe\x49\x2e\xdf\x6f\xc3\x1e\x0f\xfb\x50\xd8\xdf\x7d\xc7\xfd\x57\xfb\xba\x9d\x36\x08\x0e\x80\xff\xdd\x43\x61\xea\x17\x24\xe0\x57\xea\x87\xe4\xc6\xde\x71\x13\x70\xd2\xe3\x1f\xec\x4b\x60\xc9\x8a\x1a\x2f\x93\x03\xeb\xff\x84\x76\x08\x4a\x16\xeb\x73\x44\x73\xd5\xe5\x53\x97\xb7\x57\x9c\x6b\x34\x06\x5e\xd2\xdc\xf8\xe3\x5f\x34\xcf\xc7\x50\x27\xc8\xef\x49\x0e\xd7\x95\xaa\x60\x29\xe6\xaa\xe0\xa8\x2f\x93\xdc\xda\xea\xcd\xa8\x2b\xf7\xc3\xc2\xa9\xd4\xc3\xac\x8d\xbf\xb8\xf0\x24\x67\x05\x8b\x12\xba\x0b\x3f\x7a\xed\xd2\xfd\x65\x29\x64\x6d\xd1\x1c\x05\x9c\xac\xcb\x39\xea\xe3\xa0\xeb\x8b\xed\xe3\xd8\x6f\x7b\x1c\xd4\xd7\x4f\xe0\xd7\xe3\x75\x2e\x30\x69\xf1\x7b\xa3\x38\x26\x7e\x7b\x07\xee\xf9\x10\x62\x06\x0b\x4c\xed\x63\x20\x75\xec\xba\xa5\xb5\xe7\xbe\x5b\x6d\x54\x45\x0b\xab\x80\x81\xa9\x75\x55\xd4\xa6\xa9\x67\x12\x23\x48\x66\x0d\x1b\x48\xdc\xc1\x81\x5b\x15\x7a\x55\xe2\x72\x36\xf3\x0c\xe0\x25\x09\x85\xc2\xa5\x8e\xcd\x11\x72\x55\x1b\x84\x42\x31\xfe\xe3\x64\xe4\x65\x3e\xa9\x8c\x97\xe2\x35\xc1\xff\x7b\xae\x26\x6e\x7f\x04\x2f\x9d\x9b\x49\x01\xd7\x11\x4a\xb4\xa8\x81\x59\xa2\x18\xb4\x95\x12\xd2\x1e\x54\x68\x32\xf2\x5c\xcf\xe1\x71\xa7\xce\x2c\x28\x90\x78\x5d\x9b\x57\x78\x49\xeb\xe0\x53\x65\x4c\x4f\x12\xc5\x43\x9f\xd2\xcb\x86\xf1\x6e\xb6\xec\xcb\x8e\x5f\x23\x0e\x67\x2d\x30\xb7\x59\x66\xd0\x86\x7a\x0c\xfe\xed\xc4\xf8\xc4\x72\xba\x32\xd2\x10\xbe\x05\x9d\xbb\x8e\xc1\x77\x00\xc7\x6d\x7d\xb6\x01\x72\xb4\x33\xe0\x43\xa2\xb6\x31\xf2\xc4\x23\x71\x7a\x1e\x18\xa5\xe2\xac\x18\x64\x4a\xd9\x70\x7e\xdc\xeb\x38\xaf\xad\x55\x32\x18\x62\xea\x79\x29\xba\x59\x77\x6e\x25\xcc\xad\x6c\x37\xf7\x7f\x54\x9c\xb9\x23\x36\x3f\xe6\x09\x25\xb6\x08\x93\x91\x83\x65\xfa\x62\x4f\x63\xd8\x14\x1e\xbd\x9b\x9b\xfb\x93\xa0\x4a\x15\x22\x5d\xef\x6e\xe4\xb6\x9a\x4f\xbb\x89\x3b\xea\xa8\xa6\x3d\x45\x6c\x4e\x68\xba\x9f\x70\x0c\x7f\x82\x79\x4e\xc8\xd9\xed\xbb\xa4\x3b\x98\x84\xb7\x98\x29\x8d\xf0\xce\x1d\x61\x0b\xb9\x80\xf7\x5a\x95\xcd\x91\xe4\x49\x62\x38\x96\x1b\x4d\x89\x81\x50\x0a\xe9\x62\x16\x4a\xf6\xf5\x32\x79\x35\x1e\x3f\xb1\x62\x18\xef\x5f\x31\x04\x73\xee\xc8\x79\xc3\x59\x27\xe0\x6c\x35\x40\xe3\x12\xa5\xfd\x45\x18\x7f\xf6\x9f\x4c\x3f\xe2\x12\xb5\x87\x11\x3d\x88\xf7\x39\x42\xeb\xd3\x7f\xce\x9e\x6b\x47\xf5\xa6\xd6\x6c\xd3\x8f\xd8\x68\xf7\x71\xbf\xdb\xe2\x70\xda\x2d\xd7\x2e\xe4\x7f\xd3\xc6\xdb\xa9\x21\xe4\x82\xb6\x7f\xc9\xf4\xda\xbf\x6d\x25\xd0\xf3\x7c\x4f\xde\x39\x20\x79\x7b\x7f\x23\x4a\x7c\x34\xdd\x7a\xda\x91\xc3\x7b\x94\x3d\x4e\x7d\x3a\xa1\xae\x23\x0e\xbb\x19\xf5\xcd\x3a\x5e\x4b\xde\xd3\xd0\xbd\xff\x15\xfd\xae\x25\xdf\xa7\xdd\xb9\x03\xa1\xa9\xa9\x41\x0b\xe8\x6a\xeb\xbd\x02\x3a\x83\xb9\xb2\x27\xaa\xa5\x7d\x89\x31\x76\xe7\xac\xa7\xd7\xb1\x88\x73\x55\xd4\x60\x07\x2d\x2f\x3b\x2c\xe9\xf5\xe8\x94\xfa\x36\x34\xbd\xc8\x18\xcc\x40\x09\x58\x9e\x0e\x41\x6f\xcd\x66\xf3\x1f\xbe\x12\xa3\x05\x40\xeb\x8b\xed\x85\x58\xbf\xf5\x6f\x58\x87\xf9\x53\x80\xf7\xaa\x28\xd4\x8a\x8e\xc8\xcf\xb4\x1e\x63\x65\x55\x60\x77\x78\x33\xa3\xbb\x12\x06\xde\xa2\x5d\x21\x4a\xf8\x84\x8c\xbb\x0f\x0a\xa7\x5a\x86\xf5\xc5\x79\x47\x6c\xd1\xfe\x4a\xc0\x13\x6a\xef\xbc\xcc\xe1\xac\xc7\xf8\x6c\x27\x37\xf4\x41\x4b\xc8\x45\x12\xbe\xfb\x51\xc5\x40\xe7\x78\xb8\xcd\xe0\x9a\xa5\x79\x83\xe2\xa9\x40\x6c\x25\x7a\xfc\xba\xd7\x7e\xdd\x4d\xc0\x58\xac\x2e\x93\xf1\x70\xfc\xfa\x14\x70\xb6\xd6\x9d\x0b\x49\x37\xb3\xfb\xcd\xac\xfb\xed\x27\x2e\xa6\x2d\x8c\xfc\x04\xf6\x16\x0b\xb5\x3a\xed\x42\xe6\x18\xb4\x5b\xad\x3c\xda\xdd\xeb\xa1\x40\xdd\xc2\xb6\x87\xe8\x8d\x90\x6e\xf0\x1d\xea\xfb\xcf\x34\x8b\x1d\x81\x7b\x23\xf1\x88\x45\xcf\x51\xf6\xa8\x2a\x36\x47\x55\xdf\xd9\x1a\x2f\xf0\xbb\xae\x91\x4a\x21\x6f\x65\x28\x6d\xc9\xf4\x46\x48\x51\xd6\x25\x04\x02\xdc\x4a\x18\xb9\x83\x93\xef\x1e\x5a\x3d\xb5\xc8\x1d\x7d\xca\x91\x2e\x39\x26\x79\x6f\x22\xc6\xa7\x89\x23\xa7\x6a\x96\xed\x68\x9f\x65\xe7\x52\x3f\xcb\x1e\xd1\xff\x7c\xa1\xc3\xbe\xce\x2c\xfa\x1c\x99\xde\x28\x63\xa1\xa9\x4d\xef\x72\xba\x64\xf1\x76\x7d\x8e\x1a\x1f\x8b\xf5\xd8\xc6\x84\x93\x4d\x91\x37\x1d\xd7\x7f\xb7\x15\x61\xfc\x78\xec\x25\x8c\x6f\xbe\x35\x4a\x8c\xaa\xe9\x87\x0c\xda\x4f\x50\x50\xb6\xf7\x1b\x40\x18\xf0\x1f\xec\x79\xb8\xb3\xd9\xde\xe9\x80\x95\x28\x0a\xa8\x50\x0b\xc5\x45\xca\x8a\x62\x0d\x95\x2a\x0a\xea\xd5\xb1\xb2\x0a\x16\x68\xdb\x5b\x9b\xfe\xc7\x7f\x5f\x31\x96\xd9\xda\x0c\x23\x3c\xaa\x9e\x4a\xb7\x36\x47\xdd\xbf\x35\x69\x2e\xc0\xd4\x69\x0e\xcc\x7f\x98\xa1\x6f\x88\x70\x75\xf7\x01\x54\x06\x4c\xc2\xb5\xac\x72\x66\x10\x94\x86\xd9\xcd\x15\x08\xb9\x44\x6d\x51\x5f\x40\xca\x24\xcc\xe9\xab\x09\xd4\x15\xac\x84\xcd\x63\xb7\xa4\x8a\xe3\x14\xa3\x2f\xb6\x93\x11\x91\x40\xc8\xc6\xe6\x4c\x2c\x2e\xb6\x2c\x63\xe1\xe3\xaa\x30\xe0\x3e\xea\x42\x6d\x90\xc3\x7c\xbd\xd5\xcb\xeb\x7d\xd8\xc8\x0f\x32\x7c\xdd\x51\x1c\xb7\x01\x66\xfc\xcf\xda\x58\x6f\xaa\x3f\xb3\xd1\xc6\x49\xc8\xd5\x0a\x32\xa6\xb7\x3f\x0d\x09\xd3\xde\x90\xf5\xe4\xe6\x23\x11\x20\x4b\x63\x83\xe9\x50\xe9\x02\x8c\x02\x61\x41\x22\x72\x03\x4c\xf6\x81\x06\x9b\x33\x0b\x1a\xdd\x1d\x4b\xd3\x72\x1c\xc2\x55\xc7\x54\x65\x30\x76\x46\x1b\x60\xc1\xeb\xde\xad\x34\x72\xa5\xea\x82\xc3\x3c\x76\x3a\xd2\x85\x4d\x17\x47\x83\xd7\xe3\x31\x7c\x41\xac\x0c\xb8\x27\xbf\xab\xc3\xe6\x3e\xe7\x05\x11\x99\xdb\x3d\xc4\xed\x56\xc1\x1c\x41\x94\x9e\xc9\x61\x40\xdd\x36\x37\x9c\x45\x43\x73\xa7\x88\x8c\x3c\xc2\xc6\x30\x6e\x08\xf7\xf1\xed\xe4\x39\xc2\x17\xac\x9c\xc1\x59\x67\x66\x64\x58\x2d\xad\x28\xe2\xf1\x20\x4c\xf0\x17\x07\xab\x80\xb9\x24\x49\x51\x5a\xb6\xc0\x0b\xcf\x8a\xfc\x44\x07\x9e\x5c\x33\xb7\x49\xea\x3c\x17\x78\x5c\x80\xd2\x1e\x04\xcf\xc4\xf3\x8b\xa4\x6e\x0f\x08\x57\xa7\x19\xf8\x12\xed\xdc\xb3\x22\xe0\x42\x10\x87\xad\x79\xd0\x56\x58\xa7\x24\x57\x2b\xb9\xad\xe1\xe3\xc8\x7a\x17\x67\xcd\xde\xae\x43\xd8\xad\xc6\xa0\x62\xc6\x38\x6a\x5a\xa8\x9a\x87\x68\xa4\x55\xa7\x23\x32\xc9\xa9\x57\xe5\x5e\x9a\xeb\xdf\xc3\x76\x9b\xd6\x40\x6d\xd9\x17\x94\x71\xd8\x2c\x9d\x71\x19\xae\xc0\x84\x39\x94\x18\x85\xbb\x78\x17\xc0\x60\xe5\x77\x2c\x2e\x1e\x87\x3f\xc1\x42\x2c\xd1\x50\xc4\x83\xc4\x15\xdd\xb9\x20\xe9\x90\x89\xcc\xe6\xae\x17\xf9\x90\xc5\x5e\x26\x1d\x41\xc9\x34\x06\x98\xba\x05\x31\xc0\xdc\x34\xa3\x89\x2b\xd1\x69\x00\x11\x1b\xb3\x68\xb8\x6b\x5c\x43\xe6\x12\x62\xee\x96\xfc\xa1\xb3\xaa\xa8\xef\x45\xe8\xcc\xd6\xa0\x24\x28\x4d\x11\x95\x39\x3f\xdb\x48\x6e\x81\xcc\x50\xc1\x84\x32\xac\xf6\x82\xe1\x43\xf8\x1d\xd9\x32\xa0\x67\x10\x98\x85\x31\xd9\x67\x02\x48\x94\xd7\x20\x9a\x5d\xa3\x13\xe6\x3d\x65\xa2\xf4\x64\xc6\x3b\xff\x91\x92\x94\xc1\x5a\xd5\x90\xb3\x25\x82\xff\x0f\x09\x0e\xcc\x97\x34\xa7\xb1\x41\x0b\x42\x86\xe0\x52\x3a\x58\xb6\x6f\x6e\xa8\x8d\x03\x44\x98\x66\x14\x2b\x94\x5c\x50\xf5\xdd\x9b\x47\x73\x74\xb6\x2d\x5c\x8e\x32\x1b\xa2\x9e\x15\x69\x5d\x30\x8b\x54\xf3\x4a\x26\xd7\x24\xae\x4b\x08\x60\xf6\xb0\x21\x2f\x3a\xde\xf7\xb9\x68\x23\xac\x36\x98\xd5\x05\x08\x32\x53\x37\x93\xd1\xda\x58\x2c\x81\x2b\x34\xf2\xbf\x6c\xab\x05\xa0\x54\xf5\x22\xf7\xd6\x53\x4e\x3a\x68\x98\x46\xc8\x59\x55\xad\x29\x79\xd2\xd4\xa5\x34\x83\x14\xb5\x65\x22\x8e\x5d\x56\xaa\xda\x57\x4a\xc6\xb9\x70\x67\x24\xac\xf0\xac\x82\xb1\xa9\x92\xa6\x2e\x91\xf7\x0b\xf7\x51\x16\xbd\x57\x1a\xf0\x2b\x9d\x13\x5c\x6c\xd9\xe2\xec\x6c\x0d\x60\x6e\x4b\x4d\x61\xf4\xf3\xb8\x29\xa6\x17\x30\xaf\xed\x41\x4b\x5e\xb5\xfd\xbc\x96\x91\x50\x57\xad\x64\xff\xff\x30\xfc\x8f\x53\x9b\x82\x40\x12\xdb\x94\xca\xbf\xc1\x98\xd9\xf1\x7e\xf2\x93\x47\x89\x4c\xfa\x12\xed\x38\x36\x13\x0a\x34\xff\x6e\x11\xfc\x6f\x55\x64\xa1\xca\xe0\x75\x27\x0f\x3e\xf4\x50\x59\xb1\x30\x51\x07\x68\xfa\x96\xbd\x8a\xc0\x21\x33\xb6\x03\xda\x5b\xc4\xd6\x91\x6c\x8a\xe4\x9f\xda\x81\xfb\xec\xdb\xb7\xaa\x0b\x0f\xe1\xcf\xc3\x03\x4a\xbe\xd9\xfc\x6b\x00\x59\xd2\x76\x41\x8d\x34\x00\x00")
 
 func templatesPowerwallHtmlBytes() ([]byte, error) {
 	return bindataRead(
@@ -184,7 +205,7 @@ func templatesSettingsHtml() (*asset, error) {
 	return a, nil
 }
 
-var _templatesStatsHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xec\x57\xdf\x6f\xdb\xb6\x13\x7f\xcf\x5f\x71\x20\xf0\x85\x93\x2f\x26\x2b\x4e\xbb\x60\xd8\x24\x03\x9e\xb3\x0d\x05\xb6\xa2\x68\x5a\xe4\x99\x12\xcf\x16\x07\x8a\x14\xc8\x93\x13\xc3\xf5\xff\x3e\x90\x92\x1c\x49\x76\xdc\x75\xe8\x80\x3e\xcc\x0f\x16\xc5\xfb\xc1\xe3\xf1\x3e\x9f\xa3\x76\x3b\x81\x2b\xa9\x11\x58\x6e\x34\xa1\x26\xb6\xdf\x5f\x24\x42\x6e\x20\x57\xdc\xb9\x34\x4c\x73\xa9\xd1\xb2\xf9\x05\x40\xe2\x2a\xae\x3b\x91\xc3\x9c\xa4\xd1\x11\x49\x52\xc8\xe6\xbb\x1d\x4c\xdf\xf1\x35\xbe\xe5\x25\xc2\x7e\x9f\xc4\x5e\x37\x18\x15\x36\x3c\x88\x67\x0a\x41\x8a\x94\x55\xd6\xfc\xe9\x8d\xf5\xca\xb0\xce\x5b\x23\x0d\xff\x91\x23\x2b\x2b\x14\xed\x5b\x61\x36\x68\x21\xaf\x1d\x99\x32\x0a\x53\x0c\x1c\x6d\x15\xa6\xec\x51\x0a\x2a\x7e\x9c\x5d\x5f\xff\x2f\x84\xe7\x17\x29\x90\x8b\x83\x4f\x7c\xa2\xe8\xb1\x90\x84\x90\xad\xa3\xc6\x43\xab\xe8\x55\x6d\x37\x0c\x76\xf3\xa5\xd1\x64\x8d\x4a\x62\x2a\x86\x82\x37\x77\xc7\x73\xf7\xc4\x09\x8f\xa7\x97\x05\xb7\x6b\x84\xf7\xa7\x84\x5d\x54\x22\xd2\x46\x23\x88\x48\xad\x9b\xed\x44\x39\x2a\xc5\xe6\xcb\xda\x5a\xd4\x04\x0f\x9c\xc8\x7d\xde\xfa\x49\x0d\xac\x7f\xe7\x8e\x60\x69\xca\x72\x60\x9a\xc4\xdd\x2e\xfd\x2c\x72\xd1\xa5\x29\x33\x62\xdb\x29\xed\x76\x96\xeb\x35\xc2\xd4\x6f\xca\xdd\x71\xe2\xfb\xfd\xe9\x24\x09\x7f\xca\x72\x05\xd3\x66\x9f\x21\x09\x70\x50\x0e\x3a\x2b\x63\xcb\x70\xc8\xf4\x98\x4b\xc1\x80\x87\x22\x49\x59\xcc\x2b\x19\x6f\x66\xb1\x90\xae\x39\xc2\x12\xa9\x30\xbe\x18\x8c\x23\x36\xef\xb9\x00\x48\xa4\xae\x6a\x02\xda\x56\x98\xb2\x42\x0a\x81\x9a\x81\xe6\x25\x1e\xbc\xf6\x16\xd8\x70\x55\x63\xca\x7c\xf9\x7d\x78\x58\xbe\xb9\x83\x4f\xf0\xf3\x96\xd0\x7d\x30\xf7\x64\xa5\x5e\xc3\x7e\x3f\x76\x9f\xd5\x44\xe6\x50\xc7\x19\x69\xc8\x48\x47\xae\x0c\x0f\xe1\x73\x61\xc3\xb0\xad\x39\xcb\x85\xac\x5d\x7f\x66\x65\x34\xb1\x36\x3e\x57\x67\xa5\x24\x36\xbf\x27\x53\x25\x71\xe3\xba\xbf\x5e\x12\xfb\x94\xf4\x67\x76\x3b\x40\xe5\xbe\x34\x71\xa8\xbf\xed\xbc\xb9\x3a\xcf\xd1\xb9\x7f\x92\x38\x6e\xe9\xef\x67\x4e\x8b\x7e\xe2\x92\x98\xc4\xb0\x42\x13\x0e\x85\xc5\x55\xca\x62\xcf\x2f\xf1\xd9\xfd\x0d\xb6\x77\x7a\x5b\x6d\xe0\x37\x0d\xc1\xbd\xe4\x29\x89\xf9\xfc\x38\x14\x6f\xf1\x1e\x2b\x63\x09\x45\x83\x95\x4f\xf0\x1b\x52\x07\x9b\xf3\x06\x8b\xb2\x72\x8b\x9c\x6a\xae\x9e\xd7\xfb\x28\x35\xcd\x6e\xef\xe4\x46\x0a\xef\x20\x1e\xeb\xff\xc1\x9f\x5e\x54\x5e\x1c\x2d\xf7\x39\x42\xf2\xde\x03\x25\xb4\xcc\x14\x88\x09\xf6\x7b\x78\xf8\xbc\xab\x11\x3b\x85\xdc\xc9\x12\x3d\x4b\xbd\x7f\x6a\xd2\xe0\xdf\x47\x59\x78\xa6\x2b\x7f\xd6\x1e\x24\x2f\x32\x11\xe4\x46\xf9\x0e\x93\xb2\x5b\x36\x7f\x6b\xe0\x81\x2b\x05\x4b\xa3\x35\xe6\x64\xac\x83\x5f\x4d\xad\xc5\x19\xdf\x5a\xb4\xae\x93\xf8\x40\x86\x49\x1c\x62\x0e\xc3\xac\x69\x5a\xbd\x56\x68\xcd\x63\xd7\x65\x06\x0d\x52\x45\xae\x8c\x6e\x9f\xfb\x4a\x5f\xc8\xad\xe8\x81\x68\x2c\x8a\xfc\xc2\xcf\xad\x29\x52\x72\x5d\x0c\x31\x3d\x68\xb8\x82\xbb\x22\x33\xde\x6e\xd4\x7a\xef\x91\x60\xb1\xe1\x52\x85\x1e\xea\x0b\xe1\xb9\xfb\x1e\x3c\x15\x76\x7e\x92\x6e\x4a\xfe\xc4\xcb\xca\x1d\x13\xce\x41\x70\x8e\x71\x7a\x3b\xf2\x1e\xa3\xb5\x35\x75\x35\x52\x02\x48\x14\xcf\x50\xc1\xca\xd8\x94\xf1\x2e\x50\x1f\x27\x9b\x37\xd1\x06\xf9\x91\x55\x9f\xce\x74\x5d\x66\x68\xd9\x60\xb1\xbc\xe9\xd8\x1d\xc7\x0d\x3d\x87\xcd\x8d\xa6\x2a\xc5\x73\x2c\x8c\x12\x68\x53\xf6\xea\x86\x8d\x16\x84\x3e\x29\xbe\xb3\xb2\xe4\x76\xeb\xdb\xe1\x74\xd1\x77\x73\x82\x18\x63\x21\x37\x2f\x27\xa6\x34\x82\xab\x68\x65\x0c\xb5\xd7\xa8\x81\x62\x4b\xaa\x03\x4e\x1c\x73\x51\x77\x75\xf9\x58\x89\x70\xb3\x38\xe6\xca\xa3\x20\x8e\x5e\xfb\x5c\x3a\x10\xb6\xa5\x3e\x8e\x9a\x2b\xb4\x04\xe1\xdf\xd7\x9b\xd1\x82\xdb\xe3\x62\x05\x6b\xfc\x45\x2c\xa8\xf5\x2b\xbd\x0a\x45\x49\x05\xc2\xe1\x08\xc0\x57\x93\xaf\x01\xf0\x50\x1d\xe1\x35\x89\xab\x13\xc1\xf5\x87\xdf\x3c\xe6\x20\xdb\x76\x77\xb7\x2f\x01\xdf\xa3\x37\x39\x89\xbe\x56\xf2\x2f\xc1\x2f\x84\xca\xe6\x6d\xc4\x5f\x17\x80\x8d\xef\x21\x02\xdb\xb9\x01\x04\x6f\x5e\x5f\x5f\xbf\x08\xc2\xff\x40\xf6\xb5\x41\x06\xb5\xf3\xd7\x15\x0e\x6b\xb9\x41\x0d\xbe\xbe\xf8\x1a\x7b\xa1\xf9\xdf\x6c\xf6\xfd\xcd\x75\xd3\xea\x63\xb8\xbc\x79\x7d\xbd\x81\xff\xc3\xab\x77\x05\x77\x78\x05\x29\xcc\x6e\x17\x23\x83\x57\x3f\xbc\x3e\xd2\x9f\x0d\xf4\xcf\x63\xbb\x1d\x74\x0f\x97\x5b\x59\x91\x17\xac\x6a\x1d\x40\x01\x95\x35\x39\xa2\xb8\xbc\x82\xdd\x45\x53\x20\x16\x02\x84\x52\x10\x26\xaf\x4b\xd4\x34\xcd\x2d\x72\xc2\x5f\x14\xfa\xb7\xcb\x89\x17\x4f\xae\x7e\x0a\xea\x7e\x3c\x75\x48\x0b\x22\x2b\xb3\x9a\xf0\x72\xd2\x60\x6a\xf2\x1d\x4c\x3c\xaa\xce\x28\x36\xb0\xf4\x8a\xa3\x0f\x98\xa1\x8d\xff\x1c\x9d\x0a\xe9\x2a\xc5\xb7\x90\xc2\xa4\xb9\x7c\x4f\x1a\x95\x43\x8c\x9e\x7a\xa6\xbc\xaa\x50\x8b\x65\x21\x95\xb8\xf4\xc6\x57\x3d\x37\xa1\x30\x2f\x83\xe7\xfd\x45\x12\x77\xa9\x68\xaf\x2b\x7f\x05\x00\x00\xff\xff\x8d\x19\x08\xac\xad\x0f\x00\x00")
+var _templatesStatsHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\xff\xec\x57\xdf\x6f\xdb\xb6\x13\x7f\xcf\x5f\x71\x20\xf0\x45\x92\x2f\x26\x2b\x4e\xbb\x6e\xd8\x64\x03\x9e\xd3\x0d\x05\xd6\xa2\x68\xda\xe5\x99\x12\xcf\x16\x07\x8a\x14\xc8\x93\x13\xc3\xf5\xff\x3e\x90\x92\x1c\xfd\x70\x9c\x75\xe8\x80\x3e\xcc\x0f\x16\xc9\xfb\xc1\xe3\xe9\x3e\x1f\x9e\x76\x3b\x81\x2b\xa9\x11\x58\x66\x34\xa1\x26\xb6\xdf\x9f\x25\x42\x6e\x20\x53\xdc\xb9\x59\x58\xe6\x52\xa3\x65\xf3\x33\x80\xc4\x95\x5c\xb7\x22\x87\x19\x49\xa3\x23\x92\xa4\x90\xcd\x77\x3b\x98\xbc\xe7\x6b\x7c\xc7\x0b\x84\xfd\x3e\x89\xbd\x6e\x30\xca\x6d\x78\x10\x4f\x15\x82\x14\x33\x56\x5a\xf3\xa7\x37\xd6\x2b\xc3\x5a\x6f\xb5\x34\xfc\x47\x8e\xac\x2c\x51\x34\xb3\xdc\x6c\xd0\x42\x56\x39\x32\x45\x14\x96\x18\x38\xda\x2a\x9c\xb1\x7b\x29\x28\xff\x69\x7a\x75\xf5\xbf\x10\x9e\xdf\x24\x47\x2e\x0e\x3e\xf1\x81\xa2\xfb\x5c\x12\x42\xba\x8e\x6a\x0f\x8d\xa2\x57\xb5\xed\x30\xd8\xcd\x97\x46\x93\x35\x2a\x89\x29\xef\x0b\xde\xdc\x8c\xd6\xda\x1d\x44\xa4\x8d\x46\x10\x51\x21\xea\xd0\xa2\x0c\x95\x62\xf3\x3f\x30\x97\x99\xc2\xb1\xaf\x5b\xe2\x74\x64\x79\x99\x73\xbb\x46\xf8\x70\x4c\x38\xda\x4b\xad\x7b\x7b\x2d\x2b\x6b\x51\x13\xdc\x71\x22\xf7\xbc\xf5\x83\xea\x59\xff\xce\x1d\xc1\xd2\x14\x45\xcf\x34\x89\xdb\xec\xf8\x55\xe4\xa2\x99\x50\x6a\xc4\xb6\x55\xda\xed\x2c\xd7\x6b\x84\x89\x3f\x94\xbb\xe1\xc4\xf7\xfb\xe3\xc9\x15\xbe\x3a\xe4\x0a\x26\xf5\x39\x43\x12\xe0\xa0\x1c\x74\x56\xc6\x16\xa1\x38\xe8\x3e\x93\x82\x01\x0f\xc5\x35\x63\x31\x2f\x65\xbc\x99\xc6\x42\xba\xfa\xd5\x17\x48\xb9\xf1\x45\x64\x1c\xb1\x79\xc7\x05\x40\x22\x75\x59\x11\xd0\xb6\xc4\x19\xcb\xa5\x10\xa8\x19\x68\x5e\xe0\xc1\x6b\x67\x83\x0d\x57\x15\xce\x98\x2f\xdb\x8f\x77\xcb\x37\x37\xf0\x19\x7e\xd9\x12\xba\x8f\xe6\x96\xac\xd4\x6b\xd8\xef\x87\xee\xd3\x8a\xc8\x1c\xea\x3f\x25\x0d\x29\xe9\xc8\x15\xe1\x21\x7c\x2e\x6c\x18\x36\xb5\x6a\xb9\x90\x95\xeb\xae\xac\x8c\x26\xd6\xc4\xe7\xaa\xb4\x90\xc4\xe6\xb7\x64\xca\x24\xae\x5d\x77\xf7\x4b\x62\x9f\x92\xee\xca\x6e\x07\xa8\xdc\x97\x26\x0e\xf5\xb7\x9d\x37\x57\x65\x19\x3a\xf7\x4f\x12\xc7\x2d\xfd\xfd\xcc\x69\xd1\x4d\x5c\x12\x93\xe8\x57\x68\xc2\x21\xb7\xb8\x9a\xb1\xd8\xf3\x52\x7c\xf2\x7c\xbd\xe3\x1d\x3f\x56\x13\xf8\x35\x9b\x9f\xf2\x94\xc4\x7c\x3e\x0a\xe5\x39\x72\x69\xb0\xd4\x70\x4c\xc3\xb7\xbb\xdd\x91\x95\xa6\x5c\x82\xec\xcd\xbb\x90\xb0\xc7\xe9\x5b\x29\x84\xea\x88\x5f\x6b\xd1\x58\x85\xc1\x38\x43\x5e\xef\x03\x96\xc6\x12\x8a\x1a\xc2\x9f\xe1\x37\xa4\x16\xcd\xa7\x0d\x16\x45\xe9\x16\x19\x55\x5c\x3d\xa6\xe1\x93\xd4\x34\x7d\x75\x23\x37\x52\x78\x07\xf1\x50\xff\x2d\x7f\x78\x52\x79\xf1\x7c\xda\x06\x3c\xe9\xbd\x07\xa6\x6a\x08\x33\xf0\x25\xec\xf7\x70\xf7\xbc\xab\x07\x35\x72\xf5\x51\x16\xe8\xc9\xf3\xc3\x43\x9d\x06\x3f\x1f\x64\xe1\x91\x45\x7d\x09\xfa\x97\xf1\x24\x41\x42\x66\x94\xbf\x30\x67\xec\x07\x36\x7f\x67\xe0\x8e\x2b\x05\x4b\xa3\x35\x66\x64\xac\x83\x5f\x4d\xa5\xc5\x09\xdf\x5a\x34\xae\x93\xf8\xc0\xd1\x49\x1c\x62\x0e\xc3\x34\x28\x77\x6f\x76\x6b\xee\xdb\x4b\xb3\x77\xdf\xab\xc8\x15\xd1\xab\xc7\x6b\xb2\x2b\xe4\x56\xb0\x4e\xd8\x03\x51\xe4\x37\x7e\xbc\x69\x23\x25\xd7\x79\x9f\x6a\x7a\xfd\x83\xe0\x2e\x4f\x8d\xb7\x1b\x74\x12\xb7\x48\xb0\xd8\x70\xa9\x7c\xf4\xe0\x0b\xe1\xb1\x99\x68\x7f\x4d\x53\x31\x66\xc1\x82\x3f\xf0\xa2\x74\x63\x1e\x3c\x08\x4e\x11\x61\xe7\x44\xde\x63\xb4\xb6\xa6\x2a\x07\x4a\x00\x89\xe2\x29\x2a\x58\x19\x3b\x63\xbc\x0d\xd4\xc7\xc9\xe6\x75\xb4\x41\x3e\xb2\xea\xb2\xac\xae\x8a\x14\x2d\xeb\x6d\x96\xd5\x0d\x48\x4b\xbd\x7d\xcf\xe1\x70\x83\xa5\x52\xf1\x0c\x73\xa3\x04\xda\x19\x7b\x71\xcd\x06\x1b\x42\x97\xab\xdf\x5b\x59\x70\xbb\xf5\xb7\xf4\x64\xd1\x75\x73\x84\xaf\x63\x21\x37\x4f\x27\xa6\x30\x82\xab\x68\x65\x0c\x35\x5d\xe1\x31\xae\xef\x51\xf5\x90\x22\xdb\x4e\xec\x53\x29\x42\xc3\x33\xa6\xf0\x51\x10\xa3\x69\x97\xe2\x7b\xc2\xa6\xd4\x87\x51\x73\x85\x96\x20\xfc\xfb\x7a\x33\x5a\x70\x3b\x2e\x56\xb0\xc6\xf7\x95\x41\xad\x5b\xe9\x65\x28\x4a\xca\x11\x0e\xaf\x00\x7c\x35\xf9\x1a\x00\x0f\xd5\x01\x5e\x93\xb8\x3c\x12\x5c\x77\xf8\xcd\x63\x0e\xd2\x6d\xdb\x52\x7e\x09\xf8\xee\xbd\xc9\x51\xf4\x35\x92\x7f\x09\x7e\x21\x54\x36\x6f\x22\xfe\xba\x00\xac\x7d\xf7\x11\xd8\xac\xf5\x20\x78\xfd\xf2\xea\xea\x49\x10\xfe\x07\xb2\xaf\x0d\x32\xa8\x9c\xef\xa2\x38\xac\xe5\x06\x35\xf8\xfa\xe2\x6b\xec\x84\xe6\x7f\xd3\xe9\xf7\xd7\x57\xe1\x65\x41\x0c\x17\xd7\x2f\xaf\x36\xf0\x7f\x78\xf1\x3e\xe7\x0e\x2f\x61\x06\xd3\x57\x8b\x81\xc1\x8b\x1f\x5f\x8e\xf4\xa7\x3d\xfd\xd3\xd8\x6e\x06\xed\xc3\x65\x56\x96\xe4\x05\xab\x4a\x07\x50\x40\x69\x4d\x86\x28\x2e\x2e\x61\x77\x56\x17\x88\x85\x00\xa1\x19\x08\x93\x55\x05\x6a\x9a\x64\x16\x39\xe1\x6b\x85\x7e\x76\x71\xee\xc5\xe7\x97\x3f\x07\x75\x3f\x9e\x38\xa4\x05\x91\x95\x69\x45\x78\x71\x5e\x63\xea\xfc\x3b\x38\xf7\xa8\x3a\xa1\x58\xc3\xd2\x2b\x0e\xbe\xab\xfa\x36\xfe\xeb\x7a\x22\xa4\x2b\x15\xdf\xc2\x0c\xce\xeb\x6f\x82\xf3\x5a\xe5\x10\xa3\xa7\x9e\x09\x2f\x4b\xd4\x62\x99\x4b\x25\x2e\xbc\xf1\x65\xc7\x4d\x28\xcc\x8b\xe0\x79\x7f\x96\xc4\x6d\x2a\x9a\x76\xe5\xaf\x01\x00\xbf\x4e\x02\x87\x7c\x10\x00\x00")
 
 func templatesStatsHtmlBytes() ([]byte, error) {
 	return bindataRead(
@@ -204,7 +225,7 @@ func templatesStatsHtml() (*asset, error) {
 	return a, nil
 }
 
-var _templatesWcinfoHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xe4\x56\x51\x6f\xf2\x36\x14\x7d\xff\x7e\xc5\x95\xdf\xf9\x22\x5a\x69\x0f\x13\x54\xea\x60\x5a\xd1\xf6\x55\xa8\x65\xf0\xec\xc4\x97\xc4\xaa\x63\x47\xf6\x0d\x2b\x4a\xf3\xdf\x27\x87\xd0\x05\x9a\x68\x55\x8b\x42\x37\xf2\xc2\x8d\x8d\x7d\xee\x39\x3e\xb9\xbe\x45\x21\x70\x2d\x35\x02\x8b\x8c\x26\xd4\xc4\xca\xf2\xdb\x48\xc8\x0d\x44\x8a\x3b\x37\xae\x86\xb9\xd4\x68\xd9\xcd\x37\x00\x80\x91\xcb\xb8\xde\x4f\x3a\x8c\x48\x1a\x3d\x20\x49\x0a\xd9\x4d\x51\xc0\xf7\x39\x8f\xf1\x9e\xa7\x08\x65\x09\xb3\xe9\xcf\xe0\xc7\x1e\x89\x93\x9b\x72\xe2\xdf\x17\xab\xc9\x6c\x0a\x2f\xf0\xcb\x96\xd0\x2d\xcc\x23\x59\xa9\x63\x28\xcb\x51\xe0\xb7\xad\x11\x12\x5b\x07\xcd\x34\xb8\x15\x03\x81\xd1\x53\x9d\x46\xdb\x7c\x63\xaa\x75\x79\x68\xc4\x16\xc2\x78\x10\xe5\x8e\x4c\x3a\x50\x32\x4e\xe8\x68\xcd\x1b\x86\x82\xbb\x24\x34\x7e\xf5\x11\xd7\x89\xd1\x1a\x23\x42\x01\x4b\x4c\x64\xa4\xb0\x49\xe1\x60\xbb\x3d\x9d\xae\xdc\xd6\xc6\xa6\x83\xd8\x9a\x3c\x6b\x49\xa6\xfa\xb3\xe2\x21\x2a\x58\x1b\x3b\x66\x2e\xcf\x32\xb5\x5d\x1a\x45\x3c\x46\x76\xb3\x9c\xdd\x8f\x82\x6a\xba\x63\xa9\xd4\x59\x4e\x40\xdb\x0c\xc7\x8c\xf0\x99\xd8\x01\xaa\x3f\x5c\x6b\x14\x03\x21\x1d\x0f\x15\x8a\xd6\x4d\xfc\xb3\xe1\x2a\xc7\x31\x3b\x3c\xcd\xe5\xec\xfe\x91\xb8\x25\x28\xcb\x37\x13\x3f\xa4\x10\x0a\xdb\x66\x7e\xd5\x02\xca\xb2\x4d\xf8\x40\xc8\xcd\xd1\x19\x1e\x0e\x35\x5e\x9b\x61\xf8\x9f\x30\x4c\xc2\x6d\xec\xdd\x3e\xd3\x6b\x73\x16\xb3\x54\x19\x20\x3c\x70\xc2\x33\x9a\xe6\x01\x33\x63\x09\xc5\x6d\x9a\xb9\xdb\x88\x72\xae\xfe\xa9\x07\x7f\x4a\x4d\xc3\x9f\xa6\x72\x23\x85\xb7\x4e\xd0\xbd\xf2\x07\x7f\xee\x5c\x76\xfb\x3e\x6f\x9d\x54\xda\xdc\x5a\xd4\x04\x2b\x4e\xe4\x4e\x2a\x6e\xbb\x88\x55\x54\x83\x56\x98\xbe\xdc\xae\xfa\xe7\xbd\x30\xc4\x15\x3c\xad\xee\xfa\xe2\xfc\xfb\xea\xce\x53\x7d\x5a\xdd\x7d\xb6\x80\x1c\xcb\xd0\x7b\x41\xf0\x7c\x10\x02\x98\xec\xe8\xbb\xf3\xd4\x84\xda\xb8\x55\x32\x3d\x1c\xe2\xfe\x1b\xde\x91\x7f\x81\xdf\x90\x76\xe1\x7b\x6f\x84\x53\x92\x9f\xab\x3c\xee\x8d\xb9\x07\x3b\x1f\xd5\x3f\xb8\x23\x98\x98\x34\xed\xa3\x3a\x2d\x64\x8a\x1e\xf0\xe1\x79\x77\xc2\xfe\xfd\xc3\xac\x6d\xc7\x47\xe6\x9f\xa2\x00\xb9\x6e\x22\xef\xae\xb8\xbd\xcc\xed\x14\x3d\x13\x90\x62\xcc\xe8\xaf\x48\x0a\x06\xbc\xfa\x2a\xc7\x2c\xe0\x99\x0c\x36\xc3\xa0\xe6\xc6\x20\x45\x4a\x8c\x18\xb3\xcc\xb8\xae\x04\xde\x68\x96\x48\x21\x50\x33\xd0\x3c\xc5\x57\x84\x06\x58\xbb\x5e\x6d\x5d\x71\xab\x5e\xaf\x98\x61\x4e\x64\x5e\x6b\x4c\x48\x1a\x42\xd2\x03\xc1\x75\x8c\xb6\x0a\xeb\x12\x65\xb9\x90\xb9\x6b\x8e\xac\x8d\x26\x56\x67\xeb\xf2\x30\x95\xe4\x4b\x91\xc9\x46\xc1\x6e\xcf\x0e\x63\x04\x5e\xb6\xce\x53\x40\xe5\x3e\x2e\x38\xea\xaf\xa0\x77\xbb\x41\xff\x45\x70\x97\x47\x11\x3a\xf7\x11\xc5\xb9\xa5\xcf\x49\x5e\xb5\xd1\x17\xd6\x44\xcf\x13\xee\x10\x86\x55\xd1\x3e\xcf\x85\x59\x07\x7d\xf5\x3b\xf3\xa1\x07\xf4\xed\xdd\xf2\x7c\x5d\xad\x6f\xb8\xfb\x23\xec\xd1\xde\xdf\xc5\x7f\xe1\x06\x6f\x67\xd6\xab\x0b\x32\xeb\xd5\xa5\x99\xf5\xea\x7f\x66\xd6\xeb\x0b\x32\xeb\xf5\xa5\x99\xf5\xfa\x54\x66\xad\xc3\xfa\xa7\x28\x50\x8b\xb2\xfc\x3b\x00\x00\xff\xff\xbe\x6c\x49\xe3\x4a\x16\x00\x00")
+var _templatesWcinfoHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\xff\xe4\x5a\x4b\x6f\xdb\x3a\x16\xde\xf7\x57\x1c\x10\xb3\x6c\xe2\xa6\x05\x66\x31\x70\x04\xa4\xce\xf4\x36\x98\x26\x30\x9a\xdc\x7a\x4d\x89\xc7\x12\x11\x8a\xd4\x90\x94\x1d\xc3\xd5\x7f\xbf\x20\x45\xbf\x65\xc7\xf6\xf5\x55\x9a\xc6\x1b\x53\x7c\x9c\xc7\xc7\x43\xf2\xe3\x91\xa6\x53\x86\x43\x2e\x11\x48\xa2\xa4\x45\x69\x49\x55\xbd\xeb\x32\x3e\x82\x44\x50\x63\x2e\x7d\x35\xe5\x12\x35\x89\xde\x01\x00\x74\x4d\x41\xe5\xac\xd1\x60\x62\xb9\x92\x67\x96\x5b\x81\x24\x9a\x4e\xe1\xbc\x4f\x53\xbc\xa3\x39\x42\x55\xc1\xcd\xf5\x7f\xc0\xd5\xdd\x5b\x6a\xcd\x35\xb5\xf4\xfc\x61\xd0\xbb\xb9\x86\x9f\xf0\x79\x62\xd1\x3c\xa8\x7b\xab\xb9\x4c\xa1\xaa\xba\x1d\x27\x36\x68\xc8\x74\x28\x2c\x9b\x41\x35\x3b\x63\x98\x3c\x06\x33\x9a\xda\x97\x9a\x1a\x87\xc7\x8a\x4d\x20\x4e\xcf\x92\xd2\x58\x95\x9f\x09\x9e\x66\x76\x6d\xcc\x86\x87\x8c\x9a\x2c\x56\x6e\xf4\x9a\xaf\x3d\x25\x25\x26\x16\x19\xfc\xc0\x8c\x27\x02\x97\x5d\x58\x11\x37\x73\x67\xf9\x37\x9d\x02\x1f\x2e\x03\x13\x84\x04\xe4\x36\x85\x2c\xf9\x32\x54\x3a\x3f\x4b\xb5\x2a\x8b\x06\xe3\x01\x00\xba\x82\xc6\x28\x60\xa8\xf4\x25\x19\x2d\xe4\x92\x68\x6e\xa9\xef\xb0\x65\x30\x97\x45\x69\xc1\x4e\x0a\xbc\x24\x16\x9f\x2c\x59\xd1\xeb\xc2\x41\x2b\x41\x80\x71\x43\x63\x81\x0c\x46\x54\x94\x78\x49\x56\xe7\x79\xd5\x9d\x26\x90\x3b\x8c\x8f\x1a\x71\x41\x61\x4e\x0b\x81\x29\x8b\x42\x4c\x7e\x28\x61\x69\xea\x40\xb8\xb9\x3b\x29\x00\x8d\x42\x00\x60\x0b\x30\x37\x77\xf7\x96\x6a\x0b\x55\xb5\xd1\x70\xcb\x19\x13\xd8\xd4\xf2\x5f\xc9\x0e\x86\xd1\x0f\x79\xb7\xa3\xf3\xfa\xe3\x4b\xae\xa6\x01\x15\x02\xc2\x92\x52\xfa\xa0\xa5\x74\x74\x5c\x0c\xb9\xce\xc7\x54\xe3\x0f\xd4\x86\x2b\x49\xa2\x2f\xa1\x02\x42\x4d\x0b\xeb\xe4\xcb\xaa\x0d\x07\x4d\xf2\xf1\x0b\x02\x35\xa7\xe2\xae\xcc\x63\xb7\xab\xdf\xfb\x27\xa8\x1f\x5b\x70\xf9\x7e\x49\x7b\x3b\xfe\xe6\x8a\xa1\x20\xd1\xad\xfb\x6b\xc1\x41\xaf\x67\x6f\xcf\x7e\xe1\x45\xf9\x4d\x51\x06\x9f\xa9\xa0\x32\xe1\x32\x3d\x6c\x51\x3a\xc0\x80\x33\x4f\x11\x94\x64\x54\x4f\x0c\x5a\xcb\x65\x6a\x08\x50\xaf\xe5\x92\x74\x68\xc1\x3b\xa3\x8b\xce\xbc\x0b\x81\x1c\x6d\xa6\xd8\x25\x29\x94\xb1\x64\x8f\x49\xca\x38\x63\x28\x09\x48\x9a\xbb\x29\x1b\x27\x9c\x91\xe6\x59\xd9\xc6\x3c\xb6\x69\x39\x24\xd8\xd6\x03\xae\xd0\x5c\x69\x6e\x27\x24\xea\x87\xd2\xce\xb0\xdb\xf0\x4a\xd6\x4b\xb3\x39\xf8\x6a\x57\xe7\x2a\x3c\xc8\x8b\xa7\x9c\xcb\x4b\x72\x41\xb6\xea\x01\x00\x28\x04\x4d\x30\x53\x82\xa1\x76\x7d\x57\xf0\x9a\x4d\x45\x4f\xc9\x21\x4f\xcf\x67\xf6\xef\x00\xaa\x79\xb1\xfe\x6d\x0c\x73\xfa\x74\x95\x17\x86\x44\xb7\xf4\x89\xe7\x65\x0e\x34\x2f\xcc\xc9\x61\x9c\x69\xf1\x28\xce\x1f\x3c\x88\x1f\x0e\x00\xd1\x13\x5f\xcd\x73\xaa\x27\x3e\xd8\x06\xdc\x45\xd7\x6d\x2d\xaf\x8f\xfa\x61\xd0\x73\x08\xee\x42\x3a\x74\x6e\x1f\xe8\x24\xa3\x3a\x45\xb7\x69\x91\xa8\xe7\xcb\xe0\x36\xcc\xe7\xa1\x36\x28\x30\xb1\xbb\xd0\x5d\x12\xed\x01\x5e\x56\xb5\x13\xdc\xae\x2a\xdc\x16\x31\xc3\xcb\x28\x41\x35\x09\xa4\x59\xe2\x26\x78\xbd\xb9\x60\x20\x54\x8c\xe9\xc4\x10\xa8\xaa\xda\x40\x64\x73\x36\x14\xdd\x3b\x41\xdd\x4e\x2d\xfd\x20\x13\x66\x52\x6b\x1b\xf0\xff\xc7\xdb\x70\xe5\xdb\x9e\x37\xa2\xdb\xa9\xc7\xb6\x1b\x0d\x6e\x95\xf5\xc2\x2c\x46\x3e\x20\x0d\x5a\x88\x27\xa7\x08\x87\x65\xd9\x3e\x1e\x56\x94\x1d\x32\x1b\x76\x9c\xec\x0a\x87\xab\x85\x5c\x98\xdd\x80\x9a\x27\xc3\xf3\xce\x64\xc1\x3b\x8f\x88\x8c\xb9\xfc\xed\xa1\xb1\xbf\x3d\xe1\xde\x04\x57\xfd\x9b\x7f\x30\x42\xc2\xed\x33\x28\x0b\xdb\x4e\xb3\x90\xc2\x5f\xea\x57\x7b\x76\x3b\xc5\x56\xb9\x0d\xd7\x8e\xa6\xb8\xd4\x5b\x88\xc9\xbc\x6f\x5c\x5a\xab\x64\xd8\xc8\x4d\x19\xe7\x7c\x41\xc6\x62\x2b\x21\xb6\x32\x90\x1c\x12\xfd\x59\x30\x6a\xb1\xdb\xa9\xc7\x1c\x84\x46\xb7\xe3\x02\x75\x5f\x46\xb6\x5c\x8c\x5f\x45\x92\xc2\xed\x49\x8e\xe7\xdc\xc8\xa1\x6a\xe7\x56\xb5\x76\xdb\x0e\x27\xca\x77\x3f\x41\x2f\x76\xeb\xfe\x8e\x85\xd2\x16\x99\x0b\xe0\xab\xc4\x96\x54\x2c\x98\xe0\x9f\x5c\xda\x8b\x7f\x5f\xf3\x11\x67\xee\xee\xdd\xd9\x3e\xf2\x96\x3e\x6d\x1d\x76\xd5\xc6\xbd\x6d\x0d\xda\x52\x6b\x94\x16\x06\xd4\x5a\xd3\xc6\xc5\xcd\x95\x82\x52\xaf\x13\xaa\x0a\x06\xed\xfb\xfd\xa0\x2c\x15\xf0\x38\xf8\xda\x96\xcf\xff\x1b\x7c\x75\xae\x3e\x0e\xbe\xbe\xfa\x2b\x9d\xf3\x07\xa1\x03\xe1\x34\x32\x2f\xb3\x27\x84\xc0\xf5\xc6\xb4\x30\x89\xb3\x35\xec\xf5\xc1\x4f\xf8\x03\x6d\x5d\x6c\x27\xdb\xb2\xea\x7c\x5f\x94\x69\x6b\x9e\x3b\x65\x2f\xe7\xea\x37\x6a\x2c\xf4\x54\x9e\xb7\xb1\x3b\x3d\xf0\x1c\x9d\xc2\xef\x4f\xf5\x0c\xbb\xe7\xa3\xbd\xde\x45\x4f\x36\x52\xf7\xf5\x11\x37\x83\xb9\xd9\xc5\x79\x3a\x24\xa4\x28\xd6\x53\x20\xc1\xb7\xbd\x12\x20\xb0\x57\x12\x64\x49\xd9\xfe\xf9\x90\xaa\xda\xa5\x33\x70\xb2\x35\x16\xc6\xa8\x4c\x51\x2f\x11\xb2\x33\x4d\x19\x2f\xcd\x72\xcd\x50\x49\x4b\x56\xc9\x5c\x74\x6f\x55\xf1\x1c\x67\xdb\x24\x67\x7b\xbc\x28\xd8\x0b\x70\x94\xbf\x02\xde\x70\x0c\xe0\xa6\x4c\x12\x34\xe6\x18\xc4\xa9\xb6\x7f\x0f\xf2\x66\x76\x7f\xd0\x19\xf8\xea\x48\xf4\x35\x16\x54\xdb\x52\x23\xfc\xa1\xa8\x38\x32\x0d\x5a\x08\x2a\x53\x45\xc5\x66\x24\xba\x96\xdf\x25\xf1\xf9\x38\xc8\x48\xf4\x38\xc8\x40\x22\x32\x64\xef\xe1\x83\xab\x07\xa9\x20\xf5\xd0\x9d\x38\x7b\xe7\xd4\x79\x74\x7d\xe1\xe0\xac\xdd\x87\x9d\x09\x39\x37\xd9\xe7\x35\xf5\x6b\x39\x23\x17\x4f\x48\xf4\x0d\xe9\xc8\xcd\x13\xb5\x87\xa1\x66\x79\x8e\x3b\x31\x8b\x43\xca\x38\x9e\x3c\xef\xfd\xe7\xe3\xf2\xbe\xe1\x84\xec\x6b\x35\xe4\x02\x9d\xa4\x1d\xe9\x85\x87\x0c\xa1\xa8\x7b\x82\x1a\x82\xcd\x10\x42\x86\x04\x0a\x51\xa6\x29\x32\xe0\xd2\xc7\x93\x71\x82\x97\xa7\x05\x5c\xa4\xc5\x93\x45\xb5\xb7\xf7\x3d\x8c\x33\x9e\x64\xc0\x0d\x94\x66\xc7\x45\x91\x4b\x63\x91\xb2\xf3\x37\x9b\xcd\x80\x5f\x26\x3b\xd1\x17\x54\x1e\xf3\xf9\x84\x1b\x77\xd2\x4f\x05\xdc\x5e\xec\x89\x5c\xb8\x29\xfd\x63\xbc\xd5\x59\x7e\xde\x22\x33\x77\x8e\x5d\xa3\xe0\x23\xd4\xc8\x48\x34\x2f\xb6\x9e\x93\xf1\x8e\xcf\xd5\x87\x75\xac\x86\x30\x6f\xdb\xb2\xbe\xc3\xb8\xd9\x49\xfc\x13\xbe\x28\x9d\xd3\x23\x58\x7e\xed\xef\x3c\xf4\x86\x5a\xe5\x7e\xd3\x49\x35\xdf\x8e\x46\xb7\x14\x5b\xf7\x08\xed\xf8\x6f\x30\x70\xc0\x25\x53\xe3\xed\xd9\x54\xc1\xa3\x70\x38\x6b\xbb\xee\x03\x58\xe5\x3d\x75\xdf\x7a\xac\x35\xbd\x87\xf0\x82\x29\xc1\x3e\xea\x00\xce\x74\x0a\xff\x5a\x79\xe9\xf4\x40\x35\x1f\x0e\xcf\xeb\xdb\x75\xe2\xb6\x42\x28\x50\x3b\x10\xbb\x1d\xc1\x8f\xa3\xd1\x82\x47\x77\xca\x86\xe3\x7c\xb7\x98\x6d\xd4\xb0\x09\xba\x1d\x6a\xeb\x23\x41\x23\x70\x03\x52\xb9\x03\x5b\xbe\x07\xea\xff\x5d\x55\x4e\x19\xc2\x38\x43\x09\x74\x7e\x4e\x8c\xb9\xcd\x80\x7a\x8a\xe1\xba\x2c\x8e\x8d\xe6\xbd\xfd\xd0\x8f\x63\x5e\x1d\x61\xed\x67\xd4\x20\x5c\xf8\x2c\xc3\xcb\x64\x78\x42\xa1\xad\x04\x5d\xff\xc2\x29\x74\xcb\xee\xc7\xcb\xa5\x61\xaf\x9e\x7b\x3f\x7d\x52\x87\xc3\xab\x98\xab\x57\x9f\x91\xac\x83\xf5\xe3\x1b\x0a\xd6\x8f\x6f\x2d\x58\x3f\xfe\x66\xc1\xfa\xe9\x0d\x05\xeb\xa7\xb7\x16\xac\x9f\x4e\x15\xac\xa1\x18\xfe\xa6\x53\x94\xac\xaa\xfe\x1a\x00\x85\xff\xb9\xd3\x6f\x2f\x00\x00")
 
 func templatesWcinfoHtmlBytes() ([]byte, error) {
 	return bindataRead(
@@ -278,6 +299,7 @@ func AssetNames() []string {
 var _bindata = map[string]func() (*asset, error){
 	"templates/accounts.html":  templatesAccountsHtml,
 	"templates/base.html":      templatesBaseHtml,
+	"templates/history.html":   templatesHistoryHtml,
 	"templates/home.html":      templatesHomeHtml,
 	"templates/powerwall.html": templatesPowerwallHtml,
 	"templates/settings.html":  templatesSettingsHtml,
@@ -329,6 +351,7 @@ var _bintree = &bintree{nil, map[string]*bintree{
 	"templates": &bintree{nil, map[string]*bintree{
 		"accounts.html":  &bintree{templatesAccountsHtml, map[string]*bintree{}},
 		"base.html":      &bintree{templatesBaseHtml, map[string]*bintree{}},
+		"history.html":   &bintree{templatesHistoryHtml, map[string]*bintree{}},
 		"home.html":      &bintree{templatesHomeHtml, map[string]*bintree{}},
 		"powerwall.html": &bintree{templatesPowerwallHtml, map[string]*bintree{}},
 		"settings.html":  &bintree{templatesSettingsHtml, map[string]*bintree{}},
@@ -0,0 +1,322 @@
+package vehicle
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fakeTokenLifetime is how long the tokens the fake gives out last, the same 45 days as the owner api
+const fakeTokenLifetime = 45 * 24 * 60 * 60
+
+// Fake acts like the tesla owner api for the vehicles added to it, so the controller can be run without a car or
+// the internet, and so the failures of the real api can be tried on purpose
+type Fake struct {
+	URL string // set once the fake is started
+
+	mu        sync.Mutex
+	vehicles  []*FakeVehicle
+	tokens    int
+	failures  int // the number of commands still to fail
+	wakeAfter int // the number of wake ups a sleeping vehicle needs before it is online
+	listener  net.Listener
+}
+
+// FakeVehicle is a vehicle on the fake, and what it has been told to do
+type FakeVehicle struct {
+	Vehicle
+	ChargeState
+	Commands []string // the commands the vehicle has been sent, oldest first
+	wakes    int
+}
+
+// NewFake returns a fake with an asleep, plugged in vehicle for each VIN
+func NewFake(vins ...string) *Fake {
+	f := &Fake{wakeAfter: 1}
+	for i, vin := range vins {
+		f.vehicles = append(f.vehicles, &FakeVehicle{
+			Vehicle: Vehicle{
+				ID:          int64(1000 + i),
+				VIN:         vin,
+				DisplayName: fmt.Sprintf("Fake %d", i+1),
+				State:       "asleep",
+			},
+			ChargeState: ChargeState{
+				ChargingState:           "Stopped",
+				BatteryLevel:            50,
+				ChargeLimitSOC:          90,
+				ChargeAmps:              32,
+				ChargeCurrentRequestMax: 32,
+			},
+		})
+	}
+	return f
+}
+
+// Open returns the base url of the vehicle api at url, fake://<vin>,<vin> starts a fake with those vehicles, an
+// empty url is the tesla owner api, the fake takes ?fail=<n> to fail the first n commands and ?wakeAfter=<n> for
+// the wake ups the vehicles need
+func Open(rawurl string) (string, *Fake, error) {
+	if !strings.HasPrefix(rawurl, "fake://") {
+		return rawurl, nil, nil
+	}
+	rawurl = strings.TrimPrefix(rawurl, "fake://")
+	query := url.Values{}
+	if i := strings.Index(rawurl, "?"); i >= 0 {
+		var err error
+		query, err = url.ParseQuery(rawurl[i+1:])
+		if err != nil {
+			return "", nil, err
+		}
+		rawurl = rawurl[:i]
+	}
+	vins := []string{}
+	for _, vin := range strings.Split(rawurl, ",") {
+		if vin = strings.TrimSpace(vin); vin != "" {
+			vins = append(vins, vin)
+		}
+	}
+	f := NewFake(vins...)
+	for name, set := range map[string]func(int){"fail": f.Fail, "wakeAfter": f.WakeAfter} {
+		if v := query.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return "", nil, fmt.Errorf("%s must be a number: %v", name, err)
+			}
+			set(n)
+		}
+	}
+	if err := f.Start(); err != nil {
+		return "", nil, err
+	}
+	return f.URL, f, nil
+}
+
+// Start serves the fake on a local port
+func (f *Fake) Start() error {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.listener = l
+	f.URL = "http://" + l.Addr().String()
+	f.mu.Unlock()
+	go http.Serve(l, f)
+	return nil
+}
+
+// Close stops serving the fake
+func (f *Fake) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.listener == nil {
+		return nil
+	}
+	return f.listener.Close()
+}
+
+// Fail makes the next n commands fail the way they do when the car can't be reached
+func (f *Fake) Fail(n int) {
+	f.mu.Lock()
+	f.failures = n
+	f.mu.Unlock()
+}
+
+// WakeAfter sets how many wake ups a sleeping vehicle needs before it is online
+func (f *Fake) WakeAfter(n int) {
+	f.mu.Lock()
+	f.wakeAfter = n
+	f.mu.Unlock()
+}
+
+// Sleep puts the vehicle to sleep, it has to be woken before it takes commands again
+func (f *Fake) Sleep(vin string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if v := f.find(vin); v != nil {
+		v.State = "asleep"
+		v.wakes = 0
+	}
+}
+
+// Vehicle returns a copy of the vehicle with the VIN
+func (f *Fake) Vehicle(vin string) (FakeVehicle, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v := f.find(vin)
+	if v == nil {
+		return FakeVehicle{}, false
+	}
+	copied := *v
+	copied.Commands = append([]string{}, v.Commands...)
+	return copied, true
+}
+
+// find returns the vehicle with the VIN, the lock must be held
+func (f *Fake) find(vin string) *FakeVehicle {
+	for _, v := range f.vehicles {
+		if v.VIN == vin {
+			return v
+		}
+	}
+	return nil
+}
+
+// ServeHTTP answers the owner api requests the controller makes
+func (f *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == "/oauth/token" && r.Method == http.MethodPost {
+		f.token(w, r)
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		fakeError(w, http.StatusUnauthorized, "invalid bearer token")
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 3 || parts[0] != "api" || parts[1] != "1" || parts[2] != "vehicles" {
+		fakeError(w, http.StatusNotFound, "not_found")
+		return
+	}
+	if len(parts) == 3 && r.Method == http.MethodGet {
+		vehicles := []Vehicle{}
+		for _, v := range f.vehicles {
+			vehicles = append(vehicles, v.Vehicle)
+		}
+		fakeRespond(w, vehicles)
+		return
+	}
+	var v *FakeVehicle
+	if id, err := strconv.ParseInt(parts[3], 10, 64); err == nil {
+		for _, candidate := range f.vehicles {
+			if candidate.ID == id {
+				v = candidate
+			}
+		}
+	}
+	if v == nil {
+		fakeError(w, http.StatusNotFound, "not_found")
+		return
+	}
+	switch {
+	case len(parts) == 5 && parts[4] == "wake_up" && r.Method == http.MethodPost:
+		if !v.Online() {
+			v.wakes++
+			if v.wakes >= f.wakeAfter {
+				v.State = "online"
+			}
+		}
+		fakeRespond(w, v.Vehicle)
+	case !v.Online():
+		fakeError(w, http.StatusRequestTimeout, "vehicle unavailable: {:error=>\"vehicle unavailable:\"}")
+	case len(parts) == 6 && parts[4] == "data_request" && parts[5] == "charge_state" && r.Method == http.MethodGet:
+		fakeRespond(w, v.ChargeState)
+	case len(parts) == 6 && parts[4] == "command" && r.Method == http.MethodPost:
+		f.command(w, r, v, parts[5])
+	default:
+		fakeError(w, http.StatusNotFound, "not_found")
+	}
+}
+
+// token gives out a token for any email and password, or any refresh token, the lock must be held
+func (f *Fake) token(w http.ResponseWriter, r *http.Request) {
+	grant := map[string]string{}
+	if err := json.NewDecoder(r.Body).Decode(&grant); err != nil {
+		fakeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	switch {
+	case grant["grant_type"] == "password" && grant["email"] != "" && grant["password"] != "":
+	case grant["grant_type"] == "refresh_token" && grant["refresh_token"] != "":
+	default:
+		fakeError(w, http.StatusUnauthorized, "invalid_grant")
+		return
+	}
+	f.tokens++
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  fmt.Sprintf("fake-access-%d", f.tokens),
+		"token_type":    "bearer",
+		"expires_in":    fakeTokenLifetime,
+		"refresh_token": fmt.Sprintf("fake-refresh-%d", f.tokens),
+	})
+}
+
+// command carries out a command on the vehicle, the lock must be held
+func (f *Fake) command(w http.ResponseWriter, r *http.Request, v *FakeVehicle, name string) {
+	args := map[string]int{}
+	if r.ContentLength != 0 {
+		_ = json.NewDecoder(r.Body).Decode(&args)
+	}
+	v.Commands = append(v.Commands, name)
+	if f.failures > 0 {
+		f.failures--
+		fakeRespond(w, teslaResult{Reason: "could_not_wake_buses"})
+		return
+	}
+	result := teslaResult{Result: true}
+	charging := v.ChargingState == "Charging"
+	switch name {
+	case "charge_start":
+		if charging {
+			result = teslaResult{Reason: "is_charging"}
+			break
+		}
+		v.ChargingState = "Charging"
+		v.ChargerActualCurrent = v.ChargeAmps
+	case "charge_stop":
+		if !charging {
+			result = teslaResult{Reason: "not_charging"}
+			break
+		}
+		v.ChargingState = "Stopped"
+		v.ChargerActualCurrent = 0
+	case "set_charge_limit":
+		percent, ok := args["percent"]
+		if !ok || percent < 50 || percent > 100 {
+			result = teslaResult{Reason: "invalid"}
+			break
+		}
+		if percent == v.ChargeLimitSOC {
+			result = teslaResult{Reason: "already_set"}
+			break
+		}
+		v.ChargeLimitSOC = percent
+	case "set_charging_amps":
+		amps, ok := args["charging_amps"]
+		if !ok || amps < 0 {
+			result = teslaResult{Reason: "invalid"}
+			break
+		}
+		if amps > v.ChargeCurrentRequestMax {
+			amps = v.ChargeCurrentRequestMax
+		}
+		v.ChargeAmps = amps
+		if charging {
+			v.ChargerActualCurrent = amps
+		}
+	default:
+		result = teslaResult{Reason: "unknown command"}
+	}
+	fakeRespond(w, result)
+}
+
+// fakeRespond writes the response the way the owner api wraps it
+func fakeRespond(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"response": response})
+}
+
+// fakeError writes an error the way the owner api does
+func fakeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"response": nil, "error": message, "error_description": ""})
+}
@@ -0,0 +1,200 @@
+package vehicle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TeslaURL is the base url of the tesla owner api
+const TeslaURL = "https://owner-api.teslamotors.com"
+
+// the client id and secret of the tesla apps, the owner api only accepts these
+const (
+	teslaClientID     = "81527cff06843c8634fdc09e8ac0abefb46ac849f38fe1e431c2ef2106796384"
+	teslaClientSecret = "c7257eb71a564034f9419ee651c7d0e5f7aa6bfbd18bafb5c5c033b093bb2fa3"
+)
+
+// Tesla is the tesla owner api, or anything that acts like it such as the fake
+type Tesla struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// NewTesla returns the owner api at the base url acting for the account the access token belongs to, an empty
+// base url uses the real owner api
+func NewTesla(baseURL, accessToken string) *Tesla {
+	if baseURL == "" {
+		baseURL = TeslaURL
+	}
+	return &Tesla{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   accessToken,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// teslaError is what the owner api returns when a request fails
+type teslaError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// teslaResult is what the owner api returns for a command
+type teslaResult struct {
+	Result bool   `json:"result"`
+	Reason string `json:"reason"`
+}
+
+// RequestToken signs in to the owner api at the base url with a password or a refresh token grant, it returns the
+// token and the refresh token
+func RequestToken(ctx context.Context, baseURL string, grant map[string]string) (*Token, string, error) {
+	if baseURL == "" {
+		baseURL = TeslaURL
+	}
+	body := map[string]string{
+		"client_id":     teslaClientID,
+		"client_secret": teslaClientSecret,
+	}
+	for k, v := range grant {
+		body[k] = v
+	}
+	t := struct {
+		Token
+		RefreshToken string `json:"refresh_token"`
+	}{}
+	now := time.Now()
+	client := &Tesla{baseURL: strings.TrimSuffix(baseURL, "/"), client: &http.Client{Timeout: 30 * time.Second}}
+	if err := client.do(ctx, http.MethodPost, "/oauth/token", body, &t); err != nil {
+		return nil, "", err
+	}
+	if t.AccessToken == "" {
+		return nil, "", fmt.Errorf("the owner api didn't return a token")
+	}
+	t.Expires = now.Add(time.Duration(t.ExpiresIn) * time.Second).Unix()
+	return &t.Token, t.RefreshToken, nil
+}
+
+// Vehicles returns the vehicles on the account
+func (t *Tesla) Vehicles(ctx context.Context) ([]Vehicle, error) {
+	vehicles := []Vehicle{}
+	err := t.do(ctx, http.MethodGet, "/api/1/vehicles", nil, &struct {
+		Response *[]Vehicle `json:"response"`
+	}{&vehicles})
+	return vehicles, err
+}
+
+// Wake asks the vehicle to wake up
+func (t *Tesla) Wake(ctx context.Context, id int64) (Vehicle, error) {
+	v := Vehicle{}
+	err := t.do(ctx, http.MethodPost, t.vehiclePath(id, "wake_up"), nil, &struct {
+		Response *Vehicle `json:"response"`
+	}{&v})
+	return v, err
+}
+
+// StartCharging starts the vehicle charging
+func (t *Tesla) StartCharging(ctx context.Context, id int64) error {
+	return t.command(ctx, id, "charge_start", nil, "is_charging", "complete")
+}
+
+// StopCharging stops the vehicle charging
+func (t *Tesla) StopCharging(ctx context.Context, id int64) error {
+	return t.command(ctx, id, "charge_stop", nil, "not_charging")
+}
+
+// SetChargeLimit sets the battery percentage the vehicle charges to
+func (t *Tesla) SetChargeLimit(ctx context.Context, id int64, percent int) error {
+	return t.command(ctx, id, "set_charge_limit", map[string]int{"percent": percent}, "already_set")
+}
+
+// SetChargingAmps sets the amps the vehicle draws while charging
+func (t *Tesla) SetChargingAmps(ctx context.Context, id int64, amps int) error {
+	return t.command(ctx, id, "set_charging_amps", map[string]int{"charging_amps": amps})
+}
+
+// ChargeState returns the charging state of the vehicle
+func (t *Tesla) ChargeState(ctx context.Context, id int64) (ChargeState, error) {
+	state := ChargeState{}
+	err := t.do(ctx, http.MethodGet, t.vehiclePath(id, "data_request/charge_state"), nil, &struct {
+		Response *ChargeState `json:"response"`
+	}{&state})
+	return state, err
+}
+
+// vehiclePath returns the path of something on the vehicle
+func (t *Tesla) vehiclePath(id int64, path string) string {
+	return "/api/1/vehicles/" + strconv.FormatInt(id, 10) + "/" + path
+}
+
+// command sends a command to the vehicle, a command that fails for one of the reasons given has already been done
+func (t *Tesla) command(ctx context.Context, id int64, name string, body interface{}, done ...string) error {
+	result := teslaResult{}
+	err := t.do(ctx, http.MethodPost, t.vehiclePath(id, "command/"+name), body, &struct {
+		Response *teslaResult `json:"response"`
+	}{&result})
+	if err != nil {
+		return err
+	}
+	if result.Result {
+		return nil
+	}
+	for _, reason := range done {
+		if result.Reason == reason {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s failed: %s", name, result.Reason)
+}
+
+// do sends a request to the owner api and decodes the response into v
+func (t *Tesla) do(ctx context.Context, method, path string, body, v interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, t.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := teslaError{}
+		if json.Unmarshal(b, &e) == nil && e.Error != "" {
+			return fmt.Errorf("%s returned %s: %s", path, resp.Status, e.Error)
+		}
+		return fmt.Errorf("%s returned %s", path, resp.Status)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("unable to decode the response from %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// Package vehicle talks to the cars through their manufacturer's api, so the controller can wake them and start
+// and stop them charging cleanly instead of cutting the power at the wall connector
+package vehicle
+
+import (
+	"context"
+)
+
+// Vehicle is a car on an account
+type Vehicle struct {
+	ID          int64  `json:"id"`
+	VIN         string `json:"vin"`
+	DisplayName string `json:"display_name"`
+	State       string `json:"state"` // online, asleep or offline
+}
+
+// Online returns true if the vehicle is awake and can be sent commands
+func (v Vehicle) Online() bool {
+	return v.State == "online"
+}
+
+// ChargeState is what the vehicle reports about its charging
+type ChargeState struct {
+	ChargingState           string `json:"charging_state"` // Disconnected, Stopped, Charging or Complete
+	BatteryLevel            int    `json:"battery_level"`
+	ChargeLimitSOC          int    `json:"charge_limit_soc"`
+	ChargeAmps              int    `json:"charge_amps"`                // what the vehicle has been asked to draw
+	ChargeCurrentRequestMax int    `json:"charge_current_request_max"` // the most the vehicle can be asked to draw
+	ChargerActualCurrent    int    `json:"charger_actual_current"`     // what the vehicle is drawing
+}
+
+// Token is what an account is given when it signs in, it is sent with every request
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Expires     int64  `json:"expires"` // unix time
+}
+
+// API is what the controller needs from a vehicle api, each API acts for a single signed in account
+type API interface {
+	// Vehicles returns the vehicles on the account
+	Vehicles(ctx context.Context) ([]Vehicle, error)
+	// Wake asks the vehicle to wake up, the vehicle returned may not be online yet
+	Wake(ctx context.Context, id int64) (Vehicle, error)
+	// StartCharging starts the vehicle charging, it isn't an error if it already is
+	StartCharging(ctx context.Context, id int64) error
+	// StopCharging stops the vehicle charging, it isn't an error if it already has
+	StopCharging(ctx context.Context, id int64) error
+	// SetChargeLimit sets the battery percentage the vehicle charges to
+	SetChargeLimit(ctx context.Context, id int64, percent int) error
+	// SetChargingAmps sets the amps the vehicle draws while charging
+	SetChargingAmps(ctx context.Context, id int64, amps int) error
+	// ChargeState returns the charging state of the vehicle
+	ChargeState(ctx context.Context, id int64) (ChargeState, error)
+}
+
+// Find returns the vehicle with the VIN from the vehicles on an account
+func Find(ctx context.Context, api API, vin string) (Vehicle, bool, error) {
+	vehicles, err := api.Vehicles(ctx)
+	if err != nil {
+		return Vehicle{}, false, err
+	}
+	for _, v := range vehicles {
+		if v.VIN == vin {
+			return v, true, nil
+		}
+	}
+	return Vehicle{}, false, nil
+}
@@ -4,20 +4,30 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/shreddedbacon/twcmanager/internal/controller"
+	"github.com/shreddedbacon/twcmanager/internal/simulator"
 	"github.com/shreddedbacon/twcmanager/internal/static"
-	"github.com/tarm/serial"
+	"github.com/shreddedbacon/twcmanager/internal/transport"
 	"gopkg.in/robfig/cron.v2"
 	"gopkg.in/yaml.v2"
 )
 
+// how long to wait for the controller to leave the TWCs in a safe state when shutting down, this needs to be less
+// than the time docker waits before killing the container
+const shutdownTimeout = 8 * time.Second
+
 func main() {
 
 	configPath := "./config.yml"
@@ -36,15 +46,19 @@ func main() {
 	// Set the ID of the primary controller
 	twcConfig.ID = []byte{0x77, 0x77}
 
-	// Create the serial port configuration
-	serialConfig := &serial.Config{
-		Name:        twcConfig.SerialConfig.DevicePath,
-		Baud:        twcConfig.SerialConfig.BaudRate,
-		ReadTimeout: 750 * time.Millisecond,
-		Size:        8,
-	}
 	twcConfig.ConfigPath = configPath
-	sPort, err := newSerialPort(serialConfig)
+	// Open the connection to the RS485 bus, either a serial port or a tcp bridge
+	// or sim://<count> to run against simulated TWCs without any hardware
+	var port transport.Transport
+	if strings.HasPrefix(twcConfig.SerialConfig.DevicePath, "sim://") {
+		port, _, err = simulator.Open(twcConfig.SerialConfig.DevicePath)
+	} else {
+		port, err = transport.Open(
+			twcConfig.SerialConfig.DevicePath,
+			twcConfig.SerialConfig.BaudRate,
+			750*time.Millisecond,
+		)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -52,16 +66,33 @@ func main() {
 	// Create the primary TWC controller
 	p, err := controller.NewPrimary(
 		*twcConfig,
-		sPort.port,
+		port,
 	)
 	if err != nil {
 		log.Fatal(err)
 	}
+	// stop everything when we are asked to
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
 	// pre start the controller
 	p.PreStart()
 	// then actually run it
-	go p.Run()
-	go p.LEDLoop()
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		p.Run(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		p.LEDLoop(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		p.MQTTLoop(ctx)
+	}()
 
 	c := cron.New()
 	// Add the cron runner, every second
@@ -91,37 +122,84 @@ func main() {
 	// r.HandleFunc("/api/v1/enable/all", p.APIEnableTWC).Methods("POST")
 	r.HandleFunc("/api/v1/enable", p.APIStartCharging).Methods("POST")
 	r.HandleFunc("/api/v1/enable/{twcid}", p.APIStartCharging).Methods("GET")
+	r.HandleFunc("/api/v1/secondary", p.APISecondarySettings).Methods("POST")
 
 	// Tesla API functions
 	r.HandleFunc("/api/v1/teslapi/auth", p.TeslaAPIAuth).Methods("POST")
+	r.HandleFunc("/api/v1/teslapi/forget", p.APIForgetTeslaAccount).Methods("POST")
 
 	// Handle general API functions
 	r.HandleFunc("/api/v1/debug/{debugLevel}", p.APISetDebugLevel).Methods("POST")
 	r.HandleFunc("/api/v1/stats", p.APIGetStats).Methods("GET")
+	r.HandleFunc("/api/v1/sessions", p.APIGetSessions).Methods("GET")
+	r.HandleFunc("/api/v1/sessions/export", p.APIExportSessions).Methods("GET")
 	r.HandleFunc("/api/v1/settings", p.APISettings).Methods("GET", "POST")
 	r.HandleFunc("/api/v1/powerwallsettings", p.APIPowerwallSettings).Methods("GET", "POST")
+	r.HandleFunc("/api/v1/batterypolicy", p.APIBatteryPolicy).Methods("POST")
+	r.HandleFunc("/api/v1/solarcontrol", p.APISolarControl).Methods("GET", "POST")
+	r.HandleFunc("/api/v1/plans", p.APIPlans).Methods("GET")
+	r.HandleFunc("/api/v1/plan", p.APIPlanGoal).Methods("POST")
 	r.HandleFunc("/api/vi/send/{msg}", p.CustomMessage)
 
 	r.HandleFunc("/api/v1/pollvin", p.APIPollVIN).Methods("POST")
 	r.HandleFunc("/api/v1/pollplugstate", p.APIPollPlugState).Methods("POST")
 	r.HandleFunc("/api/v1/pollfirmware", p.APIPollFirmwareInfo).Methods("POST")
 
+	// Prometheus metrics
+	r.HandleFunc("/metrics", p.Metrics).Methods("GET")
+
 	// Template serving pages
 	r.HandleFunc("/", p.GetWallConnectors)
 	r.HandleFunc("/info/{twcid}", p.GetWallConnectorInfo)
 	r.HandleFunc("/settings", p.GetPrimarySettings)
+	r.HandleFunc("/history", p.GetSessionHistory)
 	r.HandleFunc("/powerwall", p.GetPowerwallSettings)
 	r.HandleFunc("/accounts", p.GetTeslaAPIUsers)
 
 	r.HandleFunc("/vehicleslist", p.ListTeslaAPIVehicles)
 	r.HandleFunc("/powerwall/stats", p.GetPowerwallSiteUsage)
 
-	http.Handle("/", r)
-	err = http.ListenAndServe(":8080", nil)
-	if err != nil {
-		fmt.Println(err)
+	srv := &http.Server{
+		Addr:    ":8080",
+		Handler: r,
+	}
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			fmt.Println(err)
+		}
+		// if the webservice can't run, shut down the same as if we were asked to
+		signals <- syscall.SIGTERM
+	}()
+
+	sig := <-signals
+	log.Printf("Received %v, shutting down", sig)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer shutdownCancel()
+
+	// stop taking api calls first so nothing else gets sent to the TWCs
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println(err)
+	}
+	// Stop doesn't wait for a cron that is already running, Shutdown stops anything it sends from reaching the TWCs
+	c.Stop()
+	cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		wg.Wait()
+		done <- p.Shutdown(shutdownCtx)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	case <-shutdownCtx.Done():
+		log.Println("Timed out shutting down")
+		os.Exit(1)
 	}
-
 }
 
 func faviconHandler(w http.ResponseWriter, r *http.Request) {
@@ -129,18 +207,3 @@ func faviconHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "public, max-age=7776000")
 	fmt.Fprintf(w, "data:image/x-icon;base64,AAABAAIAEBAAAAEAIABoBAAAJgAAACAgAAABACAAqBAAAI4EAAAoAAAAEAAAACAAAAABACAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzBQAAMwiAADMAAAAzAAAAMwAAADMAAAAzAAAAMwATk7bAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMxAAADMTgAAzAAAAMwAAADMAAAAzAAAAMwAAADMAE5O2wAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMbgAAzHwAAMwAAADMAAAAzAAAAMwAAADMAAAAzABOTtsAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzJwAAMyoAADMAAAAzAAAAMwAAADMAAAAzAAAAMwATk7bAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMzIAADM1gAAzAAAAMwAAADMAAAAzAAAAMwAAADMAE5O2wAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwEAADM9AAAzPoAAMwIAADMAAAAzAAAAMwAAADMAAAAzABOTtsAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMJgAAzP8AAMz/AADMMAAAzAAAAMwAAADMAAAAzAAAAMwATk7bAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzFIAAMz/AADM/wAAzF4AAMwAAADMAAAAzAAAAMwAAADMAE5O2wAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMyAAADM/wAAzP8AAMyKAADMAAAAzAAAAMwAAADMAAAAzABOTtsAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMrgAAzP8AAMz/AADMuAAAzAAAAMwAAADMAAAAzAAAAMwATk7bAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzNoAAMz/AADM/wAAzOQAAMwCAADMAAAAzAAAAMwAAADMAE5O2wAAAMwAAADMBAAAzFoAAMwCAADMAAAAzAwAAMz8AADM/wAAzP8AAMz/AADMFAAAzAAAAMwCAADMWAAAzAZOTtsAAADMDAAAzL4AAMz/AADMrAAAzGwAAMx8AADM/wAAzOAAAMzYAADM/wAAzIIAAMxsAADMpgAAzP8AAMzITk7bEAAAzFIAAMyAAADMwAAAzPoAAMz/AADM/wAAzPQAAMwwAADMKAAAzPAAAMz/AADM/wAAzPoAAMzCAADMgkND2VgAAMyUAADM7AAAzMIAAMyIAADMggAAzH4AAMxGAADMAgAAzAIAAMxAAADMfgAAzIAAAMyGAADMvgAAzO4mJtOeAADMAAAAzAgAAMxCAADMgAAAzLIAAMzWAADM7gAAzPgAAMz4AADM8AAAzNgAAMy0AADMggAAzEYAAMwITk7bAP//AAD//wAA//8AAP5/AAD+fwAA/n8AAP5/AAD+fwAA/D8AAPw/AAD8PwAA/D8AAIwRAACBgQAAB+AAAOAHAAAoAAAAIAAAAEAAAAABACAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMEAAAzCoAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAJ2d6wAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMw8AADMWAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAnZ3rAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzGoAAMyEAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzACdnesAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMmAAAzLIAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAJ2d6wAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMzGAADM3gAAzAIAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAnZ3rAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMBAAAzPAAAMz8AADMEAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzACdnesAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwiAADM/wAAzP8AAMw6AADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAJ2d6wAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzE4AAMz/AADM/wAAzGgAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAnZ3rAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMfAAAzP8AAMz/AADMlAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzACdnesAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMyqAADM/wAAzP8AAMzCAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAJ2d6wAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzNYAAMz/AADM/wAAzOwAAMwCAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAnZ3rAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwMAADM+gAAzP8AAMz/AADM/wAAzB4AAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzACdnesAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzDQAAMz/AADM/wAAzP8AAMz/AADMSgAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAJ2d6wAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMYgAAzP8AAMz/AADM/wAAzP8AAMx4AADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAnZ3rAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMyOAADM/wAAzP8AAMz/AADM/wAAzKQAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzACdnesAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzLwAAMz/AADM/wAAzP8AAMz/AADM0gAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAJ2d6wAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwCAADM6AAAzP8AAMz/AADM/wAAzP8AAMz4AADMBgAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAnZ3rAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzBgAAMz/AADM/wAAzP8AAMz/AADM/wAAzP8AAMwuAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzACdnesAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMRgAAzP8AAMz/AADM/wAAzP8AAMz/AADM/wAAzFoAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAJ2d6wAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMxyAADM/wAAzP8AAMz/AADM/wAAzP8AAMz/AADMiAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAnZ3rAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzKAAAMz/AADM/wAAzP8AAMz/AADM/wAAzP8AAMy0AADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzACdnesAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMzgAAzP8AAMz/AADM/wAAzP8AAMz/AADM/wAAzOAAAMwCAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwAAADMAJ2d6wAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAgAAMwAAADMAAAAzAAAAMwAAADMAAAAzAYAAMz2AADM/wAAzP8AAMz/AADM/wAAzP8AAMz/AADM/wAAzBIAAMwAAADMAAAAzAAAAMwAAADMAAAAzAgAAMwAAADMAAAAzAAAAMwAnZ3rAAAAzAAAAMwAAADMAAAAzA4AAMyMAADM1AAAzAQAAMwAAADMAAAAzAAAAMwAAADMKgAAzP8AAMz/AADM/wAAzP8AAMz/AADM/wAAzP8AAMz/AADMPgAAzAAAAMwAAADMAAAAzAAAAMwCAADMwAAAzJoAAMwWAADMAAAAzACdnesAAADMAAAAzAAAAMwwAADM3gAAzP8AAMz/AADMmAAAzBoAAMwCAADMAAAAzAAAAMxYAADM/wAAzP8AAMz/AADM/wAAzPoAAMz/AADM/wAAzP8AAMxqAADMAAAAzAAAAMwCAADMFgAAzIYAAMz/AADM/wAAzOgAAMxAAADMAJ2d6wAAAMwAAADMLAAAzO4AAMz/AADM/wAAzP8AAMz/AADM/wAAzOIAAMzKAADMwAAAzNoAAMz/AADM/wAAzP8AAMyCAADMaAAAzP8AAMz/AADM/wAAzOAAAMzAAADMygAAzOAAAMz8AADM/wAAzP8AAMz/AADM/wAAzPYAAMw+nZ3rAAAAzAIAAMwoAADMnAAAzPIAAMz/AADM/wAAzP8AAMz/AADM/wAAzP8AAMz/AADM/wAAzP8AAMz/AADMrgAAzAIAAMwCAADMlgAAzP8AAMz/AADM/wAAzP8AAMz/AADM/wAAzP8AAMz/AADM/wAAzP8AAMz2AADMpgAAzDKdnesCAADMXgAAzMIAAMxcAADMGAAAzFgAAMymAADM6AAAzP8AAMz/AADM/wAAzP8AAMz/AADM/wAAzNIAAMwOAADMAAAAzAAAAMwGAADMwAAAzP8AAMz/AADM/wAAzP8AAMz/AADM/wAAzO4AAMyuAADMYAAAzBoAAMxSAADMtm9v4ngAAMzSAADM/wAAzP8AAMzyAADMqgAAzF4AAMwcAADMKgAAzF4AAMyKAADMrgAAzMoAAMzSAADMIgAAzAAAAMwAAADMAAAAzAAAAMwWAADMygAAzMwAAMyyAADMjgAAzGIAAMwuAADMGAAAzFYAAMygAADM7gAAzP8AAMz/CQnN6AAAzBYAAMxuAADMxAAAzPwAAMz/AADM/wAAzP8AAMzaAADMpgAAzHgAAMxQAADMMgAAzBgAAMwKAADMBAAAzAIAAMwAAADMBAAAzAoAAMwWAADMLgAAzE4AAMx0AADMoAAAzNQAAMz/AADM/wAAzP8AAMz/AADMzAAAzHaPj+gcAADMAAAAzAAAAMwCAADMGgAAzGIAAMymAADM5AAAzP8AAMz/AADM/wAAzP8AAMz/AADM/wAAzPwAAMz2AADM9AAAzPQAAMz2AADM+gAAzP8AAMz/AADM/wAAzP8AAMz/AADM/wAAzOgAAMysAADMagAAzCAAAMwCAADMAJ2d6wAAAMwAAADMAAAAzAAAAMwAAADMAAAAzAAAAMwCAADMHgAAzE4AAMx6AADMngAAzL4AAMzWAADM6gAAzPYAAMz/AADM/wAAzPgAAMzqAADM2AAAzMAAAMyiAADMfgAAzFIAAMwiAADMAgAAzAAAAMwAAADMAAAAzAAAAMwAnZ3rAP////////////9////+f////n////5////+f////n////4////8P////D////w////8P////D////gf///4H///+B////gf///4H///+A////AP///wD///8A//8/APz+HwD4fAAIADwAGAA7gDwB0Hh+HgwH/+A/gAAB//wAP/\n")
 }
-
-// SerialPort .
-type SerialPort struct {
-	port *serial.Port
-}
-
-func newSerialPort(config *serial.Config) (*SerialPort, error) {
-	port, err := serial.OpenPort(config)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return &SerialPort{
-		port: port,
-	}, nil
-}